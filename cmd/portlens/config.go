@@ -1,114 +1,545 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"net"
 	"os"
+	"reflect"
+	"strings"
+	"time"
 
+	"github.com/hwang-fu/portlens/internal/capture"
 	yamlconfig "github.com/hwang-fu/portlens/internal/config"
+	"github.com/hwang-fu/portlens/internal/filter"
+	"github.com/hwang-fu/portlens/internal/flow"
+	"github.com/hwang-fu/portlens/internal/otlp"
+	"github.com/hwang-fu/portlens/internal/output"
+	"github.com/hwang-fu/portlens/internal/rotate"
 )
 
-// config holds all runtime configuration from flags.
+// config holds all runtime configuration from flags. Every setting is
+// tagged with the flag that sets it, which is also its key in the config
+// file.
 type config struct {
-	interfaceName string
-	protocol      string
-	port          int
-	ip            string
-	direction     string
-	process       string
-	pid           int
-	stateful      bool
-	verbosity     int    // 0=minimal, 1=normal, 2=detailed, 3=verbose
-	outputFile    string // output file path (empty = stdout)
-	debug         bool   // enable debug logging
-	logFile       string // log file path (empty = stderr)
-	configFile    string // config file path
-	stats         bool   // show performance statistics
-	graceful      bool   // enable graceful shutdown with summary
+	interfaceName     string        `flag:"interface"` // comma-separated names or globs, or "any"
+	cooked            bool          `flag:"cooked"`    // capture in cooked (SLL2) mode; implied by -i any
+	workers           int           `flag:"workers"`   // number of PACKET_FANOUT capture workers
+	fanout            string        `flag:"fanout"`    // fanout mode: hash, lb, or cpu
+	promisc           bool          `flag:"promisc"`   // put interfaces into promiscuous mode
+	snaplen           int           `flag:"snaplen"`   // bytes captured per packet (0 = whole packet)
+	duration          time.Duration `flag:"duration"`  // stop after this long (0 = no limit)
+	count             uint64        `flag:"count"`     // stop after this many packets (0 = no limit)
+	maxBytes          byteSize      `flag:"max-bytes"` // stop after this many wire bytes (0 = no limit)
+	stopOn            string        `flag:"stop-on"`   // stop at the first packet matching this filter
+	protocol          string        `flag:"protocol"`
+	port              int           `flag:"port"`
+	ip                string        `flag:"ip"`
+	vlan              int           `flag:"vlan"` // 802.1Q VLAN ID (0 = all)
+	direction         string        `flag:"direction"`
+	process           string        `flag:"process"`
+	pid               int           `flag:"pid"`
+	stateful          bool          `flag:"stateful"`
+	verbosity         int           `flag:"verbosity"`           // 0=minimal, 1=normal, 2=detailed, 3=verbose
+	outputFile        string        `flag:"output"`              // output file path (empty = stdout)
+	format            string        `flag:"format"`              // output format: json, ndjson, text, ecs, otlp, zeek, or zeek-json
+	color             string        `flag:"color"`               // colorize text output: auto, always, or never
+	pcapFile          string        `flag:"pcap"`                // pcap file path (empty = no pcap output)
+	rotateSize        byteSize      `flag:"rotate-size"`         // start a new output file past this size (0 = never)
+	rotateInterval    time.Duration `flag:"rotate-interval"`     // start a new output file this often (0 = never)
+	maxFiles          int           `flag:"max-files"`           // keep at most this many output files (0 = all)
+	compress          string        `flag:"compress"`            // compress finished output files: none, gzip, or zstd
+	debug             bool          `flag:"debug"`               // enable debug logging
+	logFile           string        `flag:"log-file"`            // log file path (empty = stderr)
+	configFile        string        `flag:"config"`              // config file path
+	metricsListen     string        `flag:"metrics-listen"`      // serve Prometheus metrics on this address (empty = off)
+	flowCollector     string        `flag:"flow-collector"`      // send flow records to this host:port (empty = off)
+	flowProtocol      string        `flag:"flow-protocol"`       // flow export protocol: ipfix, netflow9, or netflow5
+	flowActiveTimeout time.Duration `flag:"flow-active-timeout"` // export long-lived connections this often
+	otlpEndpoint      string        `flag:"otlp-endpoint"`       // send records to this OTLP/HTTP collector (empty = off)
+	otlpHeaders       string        `flag:"otlp-headers"`        // key=value,... headers for OTLP requests
+	sqlitePath        string        `flag:"sqlite"`              // keep a connection history in this SQLite database (empty = off)
+	streamSocket      string        `flag:"stream-socket"`       // stream records to subscribers on this Unix socket (empty = off)
+	streamListen      string        `flag:"stream-listen"`       // stream records to subscribers over HTTP on this address (empty = off)
+	controlSocket     string        `flag:"control-socket"`      // answer portlens ctl on this Unix socket (empty = off)
+	snapshotSize      byteSize      `flag:"snapshot-size"`       // keep this many bytes of recent matching packets for snapshots (0 = off)
+	stats             bool          `flag:"stats"`               // show performance statistics
+	statsInterval     time.Duration `flag:"stats-interval"`      // how often --stats prints a snapshot and --sqlite records one
+	graceful          bool          `flag:"graceful"`            // enable graceful shutdown with summary
+	timeFormat        string        `flag:"time-format"`         // rfc3339, rfc3339nano, epoch, or relative
+	hwTimestamps      bool          `flag:"hw-timestamps"`       // request NIC hardware timestamps
+
+	sources map[string]string // Where each setting came from, by flag: default, file, env, flag or "implied by ..."
 }
 
+// flagAliases maps the shorthand flags to the settings they set.
+var flagAliases = map[string]string{"i": "interface", "p": "port", "v": "verbosity", "o": "output", "c": "config"}
+
+// settingNames returns the flag of every setting, in the order of config.
+func settingNames() []string {
+	t := reflect.TypeFor[config]()
+	var names []string
+	for i := range t.NumField() {
+		if name := t.Field(i).Tag.Get("flag"); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// envName returns the environment variable of a setting, e.g.
+// PORTLENS_STATS_INTERVAL for --stats-interval.
+func envName(setting string) string {
+	return "PORTLENS_" + strings.ToUpper(strings.ReplaceAll(setting, "-", "_"))
+}
+
+// cfgArgs are the command line flags, kept for reloading the config.
+var cfgArgs []string
+
+// errNoInterface is the error of a configuration without an interface.
+var errNoInterface = errors.New("--interface (-i) is required")
+
+// parseFlags loads the configuration into cfg, exiting on errors.
 func parseFlags() {
-	// Check for config file flag first (manual parse)
-	configPath := yamlconfig.DefaultPath()
-	for i, arg := range os.Args[1:] {
-		if arg == "-c" || arg == "--config" {
-			if i+1 < len(os.Args)-1 {
-				configPath = os.Args[i+2]
-			}
+	cfgArgs = os.Args[1:]
+	c, fs, err := loadConfig(cfgArgs, flag.ExitOnError)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	if fs.Lookup("version").Value.String() == "true" {
+		fmt.Println("portlens", version)
+		os.Exit(0)
+	}
+
+	warnings, err := c.validate()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		if errors.Is(err, errNoInterface) {
+			fmt.Fprintln(os.Stderr, "usage: portlens -i <interface> [--protocol tcp|udp|arp|all]")
+			fmt.Fprintln(os.Stderr, "example: sudo portlens -i lo")
+		}
+		os.Exit(1)
+	}
+	for _, w := range warnings {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", w)
+	}
+	cfg = *c
+}
+
+// loadConfig builds a configuration from the defaults, the config file,
+// PORTLENS_* environment variables and the flags in args, each overriding
+// the one before, and notes where each setting came from. It doesn't
+// validate it. The returned flag set has parsed args.
+func loadConfig(args []string, errorHandling flag.ErrorHandling) (*config, *flag.FlagSet, error) {
+	c, fileCfg, err := configFromFile(configPath(args))
+	if err != nil {
+		return nil, nil, err
+	}
+	c.sources = make(map[string]string)
+	for _, name := range settingNames() {
+		c.sources[name] = "default"
+		if fileCfg.IsSet(name) {
+			c.sources[name] = "file"
+		}
+	}
+	if os.Getenv(envName("config")) != "" {
+		c.sources["config"] = "env"
+	}
+
+	fs := c.flagSet(errorHandling)
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+	fs.Visit(func(f *flag.Flag) {
+		name := f.Name
+		if long, ok := flagAliases[name]; ok {
+			name = long
+		}
+		c.sources[name] = "flag"
+	})
+
+	// Environment variables stand in for the flags not given
+	for _, name := range settingNames() {
+		value, ok := os.LookupEnv(envName(name))
+		if !ok || name == "config" || c.sources[name] == "flag" {
+			continue
+		}
+		if err := fs.Set(name, value); err != nil {
+			return nil, nil, fmt.Errorf("invalid value %q for %s: %w", value, envName(name), err)
 		}
+		c.sources[name] = "env"
 	}
+	return c, fs, nil
+}
 
+// configPath finds the config file named in args (-c or --config) or
+// PORTLENS_CONFIG, or returns the default one. The flags can't be parsed
+// before the file's values, their defaults, are known.
+func configPath(args []string) string {
+	path := yamlconfig.DefaultPath()
+	if env := os.Getenv(envName("config")); env != "" {
+		path = env
+	}
+	for i, arg := range args {
+		if (arg == "-c" || arg == "--config") && i+1 < len(args) {
+			path = args[i+1]
+		}
+	}
+	return path
+}
+
+// configFromFile returns the configuration of the config file at path,
+// with defaults for what it leaves out, and the file itself.
+func configFromFile(configPath string) (*config, *yamlconfig.YamlConfig, error) {
 	// Load config file (if exists)
 	fileCfg, err := yamlconfig.Load(configPath)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "load config: %v\n", err)
-		os.Exit(1)
+		return nil, nil, fmt.Errorf("load config: %w", err)
 	}
 
 	// Set defaults from file config
-	cfg.configFile = configPath
-	cfg.interfaceName = fileCfg.Interface
-	cfg.protocol = fileCfg.Protocol
-	cfg.port = fileCfg.Port
-	cfg.ip = fileCfg.IP
-	cfg.direction = fileCfg.Direction
-	cfg.process = fileCfg.Process
-	cfg.pid = fileCfg.PID
-	cfg.stateful = fileCfg.Stateful
-	cfg.verbosity = fileCfg.Verbosity
-	cfg.outputFile = fileCfg.Output
-	cfg.debug = fileCfg.Debug
-	cfg.logFile = fileCfg.LogFile
-	cfg.stats = fileCfg.Stats
-	cfg.graceful = fileCfg.Graceful
-
-	// Default verbosity if not set
-	if cfg.verbosity == 0 {
-		cfg.verbosity = 2
+	c := &config{}
+	c.configFile = configPath
+	c.interfaceName = fileCfg.Interface
+	c.cooked = fileCfg.Cooked
+	c.workers = 1
+	if fileCfg.Workers != nil {
+		c.workers = *fileCfg.Workers
+	}
+	c.fanout = fileCfg.Fanout
+	c.promisc = fileCfg.Promisc
+	c.snaplen = fileCfg.Snaplen
+	c.count = fileCfg.Count
+	c.stopOn = fileCfg.StopOn
+	if fileCfg.Duration != "" {
+		if c.duration, err = time.ParseDuration(fileCfg.Duration); err != nil {
+			return nil, nil, fmt.Errorf("load config: duration: %w", err)
+		}
+	}
+	if fileCfg.MaxBytes != "" {
+		if err := c.maxBytes.Set(fileCfg.MaxBytes); err != nil {
+			return nil, nil, fmt.Errorf("load config: max-bytes: %w", err)
+		}
+	}
+	c.protocol = fileCfg.Protocol
+	c.port = fileCfg.Port
+	c.ip = fileCfg.IP
+	c.vlan = fileCfg.VLAN
+	c.direction = fileCfg.Direction
+	c.process = fileCfg.Process
+	c.pid = fileCfg.PID
+	c.stateful = fileCfg.Stateful
+	c.verbosity = 2
+	if fileCfg.Verbosity != nil {
+		c.verbosity = *fileCfg.Verbosity
+	}
+	c.outputFile = fileCfg.Output
+	c.format = fileCfg.Format
+	c.color = fileCfg.Color
+	c.pcapFile = fileCfg.Pcap
+	c.maxFiles = fileCfg.MaxFiles
+	c.compress = fileCfg.Compress
+	if fileCfg.RotateSize != "" {
+		if err := c.rotateSize.Set(fileCfg.RotateSize); err != nil {
+			return nil, nil, fmt.Errorf("load config: rotate-size: %w", err)
+		}
+	}
+	if fileCfg.RotateInterval != "" {
+		if c.rotateInterval, err = time.ParseDuration(fileCfg.RotateInterval); err != nil {
+			return nil, nil, fmt.Errorf("load config: rotate-interval: %w", err)
+		}
+	}
+	c.debug = fileCfg.Debug
+	c.logFile = fileCfg.LogFile
+	c.stats = fileCfg.Stats
+	c.statsInterval = 5 * time.Second
+	if fileCfg.StatsInterval != "" {
+		if c.statsInterval, err = time.ParseDuration(fileCfg.StatsInterval); err != nil {
+			return nil, nil, fmt.Errorf("load config: stats-interval: %w", err)
+		}
 	}
+	c.metricsListen = fileCfg.MetricsListen
+	c.flowCollector = fileCfg.FlowCollector
+	c.flowProtocol = fileCfg.FlowProtocol
+	if c.flowProtocol == "" {
+		c.flowProtocol = flow.ProtocolIPFIX
+	}
+	c.flowActiveTimeout = time.Minute
+	if fileCfg.FlowActiveTimeout != "" {
+		if c.flowActiveTimeout, err = time.ParseDuration(fileCfg.FlowActiveTimeout); err != nil {
+			return nil, nil, fmt.Errorf("load config: flow-active-timeout: %w", err)
+		}
+	}
+	c.otlpEndpoint = fileCfg.OTLPEndpoint
+	c.otlpHeaders = fileCfg.OTLPHeaders
+	c.sqlitePath = fileCfg.SQLite
+	c.streamSocket = fileCfg.StreamSocket
+	c.streamListen = fileCfg.StreamListen
+	c.controlSocket = fileCfg.ControlSocket
+	if fileCfg.SnapshotSize != "" {
+		if err := c.snapshotSize.Set(fileCfg.SnapshotSize); err != nil {
+			return nil, nil, fmt.Errorf("load config: snapshot-size: %w", err)
+		}
+	}
+	c.graceful = fileCfg.Graceful
+	c.timeFormat = fileCfg.TimeFormat
+	c.hwTimestamps = fileCfg.HWTimestamps
+
 	// Default protocol if not set
-	if cfg.protocol == "" {
-		cfg.protocol = "all"
+	if c.protocol == "" {
+		c.protocol = "all"
+	}
+	// Default fanout mode if not set
+	if c.fanout == "" {
+		c.fanout = "hash"
+	}
+	// Default output format if not set
+	if c.format == "" {
+		c.format = "json"
+	}
+	// Default to color only on a terminal
+	if c.color == "" {
+		c.color = "auto"
+	}
+	// Default time format if not set
+	if c.timeFormat == "" {
+		c.timeFormat = "rfc3339"
 	}
 	// Default direction if not set
-	if cfg.direction == "" {
-		cfg.direction = "all"
-	}
-
-	flag.StringVar(&cfg.interfaceName, "interface", cfg.interfaceName, "network interface to capture on")
-	flag.StringVar(&cfg.interfaceName, "i", cfg.interfaceName, "network interface (shorthand)")
-	flag.StringVar(&cfg.protocol, "protocol", cfg.protocol, "protocol to capture: tcp, udp, or all")
-	flag.IntVar(&cfg.port, "port", cfg.port, "filter by port number (0 = all ports)")
-	flag.IntVar(&cfg.port, "p", cfg.port, "filter by port (shorthand)")
-	flag.StringVar(&cfg.ip, "ip", cfg.ip, "filter by IP address (empty = all IPs)")
-	flag.StringVar(&cfg.direction, "direction", cfg.direction, "filter by direction: in, out, or all")
-	flag.StringVar(&cfg.process, "process", cfg.process, "filter by process name")
-	flag.IntVar(&cfg.pid, "pid", cfg.pid, "filter by process ID")
-	flag.BoolVar(&cfg.stateful, "stateful", cfg.stateful, "enable connection state tracking")
-	flag.IntVar(&cfg.verbosity, "verbosity", cfg.verbosity, "output verbosity: 0=minimal, 1=normal, 2=detailed, 3=verbose")
-	flag.IntVar(&cfg.verbosity, "v", cfg.verbosity, "verbosity level (shorthand)")
-	flag.StringVar(&cfg.outputFile, "output", cfg.outputFile, "write output to file (default: stdout)")
-	flag.StringVar(&cfg.outputFile, "o", cfg.outputFile, "output file (shorthand)")
-	flag.BoolVar(&cfg.debug, "debug", cfg.debug, "enable debug logging")
-	flag.StringVar(&cfg.logFile, "log-file", cfg.logFile, "write logs to file (default: stderr)")
-	flag.StringVar(&cfg.configFile, "config", cfg.configFile, "config file path")
-	flag.StringVar(&cfg.configFile, "c", cfg.configFile, "config file (shorthand)")
-	flag.BoolVar(&cfg.stats, "stats", cfg.stats, "show performance statistics")
-	flag.BoolVar(&cfg.graceful, "graceful", cfg.graceful, "enable graceful shutdown with summary")
-
-	showVersion := flag.Bool("version", false, "show version and exit")
-
-	flag.Parse()
-
-	if *showVersion {
-		fmt.Println("portlens", version)
-		os.Exit(0)
+	if c.direction == "" {
+		c.direction = "all"
 	}
+	return c, fileCfg, nil
+}
 
-	if cfg.interfaceName == "" {
-		fmt.Fprintln(os.Stderr, "error: --interface (-i) is required")
-		fmt.Fprintln(os.Stderr, "usage: portlens -i <interface> [--protocol tcp|udp|all]")
-		fmt.Fprintln(os.Stderr, "example: sudo portlens -i lo")
-		os.Exit(1)
+// flagSet returns the command line flags, which set c's fields and
+// default to their current values.
+func (c *config) flagSet(errorHandling flag.ErrorHandling) *flag.FlagSet {
+	fs := flag.NewFlagSet(os.Args[0], errorHandling)
+	fs.StringVar(&c.interfaceName, "interface", c.interfaceName, "network interfaces to capture on: comma-separated names or globs, or any")
+	fs.StringVar(&c.interfaceName, "i", c.interfaceName, "network interface (shorthand)")
+	fs.BoolVar(&c.cooked, "cooked", c.cooked, "capture in Linux cooked mode (SLL2); implied by -i any")
+	fs.IntVar(&c.workers, "workers", c.workers, "number of capture workers (PACKET_FANOUT) on a single interface")
+	fs.StringVar(&c.fanout, "fanout", c.fanout, "fanout mode for --workers > 1: hash, lb, or cpu")
+	fs.BoolVar(&c.promisc, "promisc", c.promisc, "put interfaces into promiscuous mode")
+	fs.IntVar(&c.snaplen, "snaplen", c.snaplen, "capture at most this many bytes per packet (0 = whole packet)")
+	fs.DurationVar(&c.duration, "duration", c.duration, "stop capturing after this long, e.g. 30s (0 = no limit)")
+	fs.Uint64Var(&c.count, "count", c.count, "stop after this many matching packets (0 = no limit)")
+	fs.Var(&c.maxBytes, "max-bytes", "stop after this many bytes of matching packets, e.g. 10M (0 = no limit)")
+	fs.StringVar(&c.stopOn, "stop-on", c.stopOn, "stop at the first packet matching this filter expression")
+	fs.StringVar(&c.protocol, "protocol", c.protocol, "protocol to capture: tcp, udp, arp, or all")
+	fs.IntVar(&c.port, "port", c.port, "filter by port number (0 = all ports)")
+	fs.IntVar(&c.port, "p", c.port, "filter by port (shorthand)")
+	fs.StringVar(&c.ip, "ip", c.ip, "filter by IP address (empty = all IPs)")
+	fs.IntVar(&c.vlan, "vlan", c.vlan, "filter by 802.1Q VLAN ID (0 = all VLANs)")
+	fs.StringVar(&c.direction, "direction", c.direction, "filter by direction: in, out, or all")
+	fs.StringVar(&c.process, "process", c.process, "filter by process name")
+	fs.IntVar(&c.pid, "pid", c.pid, "filter by process ID")
+	fs.BoolVar(&c.stateful, "stateful", c.stateful, "enable connection state tracking")
+	fs.IntVar(&c.verbosity, "verbosity", c.verbosity, "output verbosity: 0=minimal, 1=normal, 2=detailed, 3=verbose")
+	fs.IntVar(&c.verbosity, "v", c.verbosity, "verbosity level (shorthand)")
+	fs.StringVar(&c.outputFile, "output", c.outputFile, "write output to file (default: stdout)")
+	fs.StringVar(&c.outputFile, "o", c.outputFile, "output file (shorthand)")
+	fs.StringVar(&c.format, "format", c.format, "output format: json (pretty-printed), ndjson (one record per line), text, ecs, otlp, zeek, or zeek-json (Zeek logs in the --output directory)")
+	fs.StringVar(&c.color, "color", c.color, "colorize text output: auto (on a terminal), always, or never")
+	fs.StringVar(&c.pcapFile, "pcap", c.pcapFile, "also write matching packets to a pcap file")
+	fs.Var(&c.rotateSize, "rotate-size", "start a new output file once it reaches this size, e.g. 100M (0 = never)")
+	fs.DurationVar(&c.rotateInterval, "rotate-interval", c.rotateInterval, "start a new output file this often, e.g. 1h (0 = never)")
+	fs.IntVar(&c.maxFiles, "max-files", c.maxFiles, "keep at most this many output files, deleting the oldest (0 = all)")
+	fs.StringVar(&c.compress, "compress", c.compress, "compress finished output files: none, gzip, or zstd")
+	fs.BoolVar(&c.debug, "debug", c.debug, "enable debug logging")
+	fs.StringVar(&c.logFile, "log-file", c.logFile, "write logs to file (default: stderr)")
+	fs.StringVar(&c.configFile, "config", c.configFile, "config file path")
+	fs.StringVar(&c.configFile, "c", c.configFile, "config file (shorthand)")
+	fs.BoolVar(&c.stats, "stats", c.stats, "show performance statistics")
+	fs.DurationVar(&c.statsInterval, "stats-interval", c.statsInterval, "how often --stats prints statistics and starts a new top-talkers interval, and --sqlite records them")
+	fs.StringVar(&c.metricsListen, "metrics-listen", c.metricsListen, "serve Prometheus metrics at /metrics on this address, e.g. :9100")
+	fs.StringVar(&c.flowCollector, "flow-collector", c.flowCollector, "send connections as flow records to this collector (host:port, UDP); needs --stateful")
+	fs.StringVar(&c.flowProtocol, "flow-protocol", c.flowProtocol, "flow export protocol: ipfix, netflow9, or netflow5")
+	fs.DurationVar(&c.flowActiveTimeout, "flow-active-timeout", c.flowActiveTimeout, "export open connections this often")
+	fs.StringVar(&c.otlpEndpoint, "otlp-endpoint", c.otlpEndpoint, "also send records as OpenTelemetry logs to this OTLP/HTTP collector, e.g. http://localhost:4318")
+	fs.StringVar(&c.otlpHeaders, "otlp-headers", c.otlpHeaders, "headers for --otlp-endpoint requests: key=value,...")
+	fs.StringVar(&c.streamSocket, "stream-socket", c.streamSocket, "stream NDJSON records to subscribers on this Unix socket")
+	fs.StringVar(&c.streamListen, "stream-listen", c.streamListen, "stream records to subscribers over HTTP (NDJSON, SSE or WebSocket) at /stream on this address, e.g. localhost:9200")
+	fs.StringVar(&c.controlSocket, "control-socket", c.controlSocket, "answer portlens ctl requests on this Unix socket, e.g. /run/portlens/control.sock")
+	fs.Var(&c.snapshotSize, "snapshot-size", "keep this much of the latest matching packets in memory for portlens ctl snapshot, e.g. 64M (0 = off)")
+	fs.StringVar(&c.sqlitePath, "sqlite", c.sqlitePath, "keep a queryable history of connections, DNS answers, processes and stats in this SQLite database")
+	fs.BoolVar(&c.graceful, "graceful", c.graceful, "enable graceful shutdown with summary")
+
+	fs.StringVar(&c.timeFormat, "time-format", c.timeFormat, "timestamp format: rfc3339, rfc3339nano, epoch, or relative")
+	fs.BoolVar(&c.hwTimestamps, "hw-timestamps", c.hwTimestamps, "use NIC hardware timestamps where supported")
+	// Handled by parseFlags; reloads ignore it
+	fs.Bool("version", false, "show version and exit")
+	return fs
+}
+
+// validate checks the configuration and fills in the settings implied by
+// others. It returns warnings about settings that are valid but unlikely
+// to do what was meant.
+func (c *config) validate() (warnings []string, err error) {
+	if c.interfaceName == "" {
+		return nil, errNoInterface
+	}
+
+	// Settings implied by others come first, so the checks below see them
+	if isZeekFormat(c.format) {
+		// conn.log is written from tracked connections
+		c.imply(&c.stateful, "stateful", "--format "+c.format)
+	}
+	if c.sqlitePath != "" {
+		// The history's connections are written from tracked connections
+		c.imply(&c.stateful, "stateful", "--sqlite")
+	}
+	// The "any" pseudo-interface mixes link types, so it always runs cooked
+	if c.interfaceName == capture.AnyInterface {
+		c.imply(&c.cooked, "cooked", "-i any")
+	}
+	// The live view needs packet records and connection state
+	if topMode {
+		c.imply(&c.stateful, "stateful", "portlens top")
+		if c.verbosity < 2 {
+			c.verbosity = 2
+			c.sources["verbosity"] = "implied by portlens top"
+		}
+	}
+
+	if c.workers < 1 {
+		return nil, errors.New("--workers must be at least 1")
+	}
+	if c.workers > 1 && (c.interfaceName == capture.AnyInterface || strings.Contains(c.interfaceName, ",")) {
+		return nil, errSeveralInterfaces
+	}
+
+	if _, err := capture.ParseFanoutMode(c.fanout); err != nil {
+		return nil, fmt.Errorf("--fanout: %w", err)
+	}
+
+	if c.snaplen < 0 {
+		return nil, errors.New("--snaplen must not be negative")
+	}
+
+	if c.duration < 0 {
+		return nil, errors.New("--duration must not be negative")
+	}
+
+	if c.stopOn != "" {
+		if _, err := filter.Compile(c.stopOn); err != nil {
+			return nil, fmt.Errorf("--stop-on: %w", err)
+		}
+	}
+
+	// The filters' errors start with their name
+	if err := configFilters(c).validate(); err != nil {
+		return nil, fmt.Errorf("--%w", err)
+	}
+
+	if c.verbosity < 0 || c.verbosity > 3 {
+		return nil, fmt.Errorf("--verbosity must be between 0 and 3, not %d", c.verbosity)
+	}
+
+	switch c.format {
+	case "json", "ndjson", "text", "ecs", "otlp", "zeek", "zeek-json":
+	default:
+		return nil, fmt.Errorf("--format must be json, ndjson, text, ecs, otlp, zeek, or zeek-json, not %q", c.format)
+	}
+
+	if c.color != "auto" && c.color != "always" && c.color != "never" {
+		return nil, fmt.Errorf("--color must be auto, always, or never, not %q", c.color)
+	}
+
+	if _, err := output.ParseTimeFormat(c.timeFormat); err != nil {
+		return nil, fmt.Errorf("--time-format: %w", err)
+	}
+
+	if c.statsInterval <= 0 {
+		return nil, errors.New("--stats-interval must be positive")
+	}
+
+	if !flow.ValidProtocol(c.flowProtocol) {
+		return nil, fmt.Errorf("--flow-protocol must be ipfix, netflow9, or netflow5, not %q", c.flowProtocol)
+	}
+
+	if c.flowActiveTimeout <= 0 {
+		return nil, errors.New("--flow-active-timeout must be positive")
+	}
+
+	// Addresses are checked here rather than failing once capture starts
+	for _, addr := range []struct{ name, value string }{
+		{"metrics-listen", c.metricsListen},
+		{"flow-collector", c.flowCollector},
+		{"stream-listen", c.streamListen},
+	} {
+		if addr.value == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(addr.value); err != nil {
+			return nil, fmt.Errorf("--%s: %w", addr.name, err)
+		}
+	}
+
+	if c.flowCollector != "" && !c.stateful {
+		return nil, errors.New("--flow-collector exports tracked connections and needs --stateful")
+	}
+
+	if c.otlpEndpoint != "" {
+		if _, err := otlp.LogsURL(c.otlpEndpoint); err != nil {
+			return nil, fmt.Errorf("--otlp-endpoint: %w", err)
+		}
+	}
+
+	if _, err := otlp.ParseHeaders(c.otlpHeaders); err != nil {
+		return nil, fmt.Errorf("--otlp-headers: %w", err)
+	}
+
+	if !rotate.ValidCompression(c.compress) {
+		return nil, fmt.Errorf("--compress must be none, gzip, or zstd, not %q", c.compress)
+	}
+
+	if c.rotateInterval < 0 || c.maxFiles < 0 {
+		return nil, errors.New("--rotate-interval and --max-files must not be negative")
+	}
+
+	rotating := c.rotateSize != 0 || c.rotateInterval != 0 || c.maxFiles != 0 ||
+		(c.compress != "" && c.compress != rotate.CompressNone)
+	if rotating && c.outputFile == "" && c.pcapFile == "" {
+		return nil, errors.New("output rotation and compression need --output or --pcap")
+	}
+
+	if c.snapshotSize != 0 && c.controlSocket == "" {
+		return nil, errors.New("--snapshot-size keeps packets for portlens ctl snapshot and needs --control-socket")
+	}
+
+	// ARP packets have neither ports nor owning processes
+	if c.protocol == "arp" {
+		for _, f := range []struct {
+			name string
+			set  bool
+		}{{"port", c.port != 0}, {"process", c.process != ""}, {"pid", c.pid != 0}} {
+			if f.set {
+				return nil, fmt.Errorf("--%s never matches --protocol arp", f.name)
+			}
+		}
+	}
+
+	if c.verbosity < 2 && !c.stateful {
+		warnings = append(warnings, fmt.Sprintf("--verbosity %d reports connection events only, and there are none without --stateful", c.verbosity))
+	}
+	if c.color != "auto" && c.format != "text" {
+		warnings = append(warnings, "--color only applies to --format text")
+	}
+	if c.sources["fanout"] != "default" && c.workers == 1 {
+		warnings = append(warnings, "--fanout only applies to --workers above 1")
+	}
+	return warnings, nil
+}
+
+// imply turns on a setting that another one needs, noting why.
+func (c *config) imply(setting *bool, name, by string) {
+	if !*setting {
+		*setting = true
+		c.sources[name] = "implied by " + by
 	}
 }
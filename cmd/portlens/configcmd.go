@@ -0,0 +1,99 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v3"
+)
+
+// runConfig implements "portlens config check|print": it loads the
+// configuration a capture given the same flags would run with, from the
+// config file, PORTLENS_* environment variables and the flags, and checks
+// or prints it.
+func runConfig(args []string) int {
+	usage := func() {
+		fmt.Fprintln(os.Stderr, "usage: portlens config check [capture flags]")
+		fmt.Fprintln(os.Stderr, "       portlens config print [--effective] [capture flags]")
+		fmt.Fprintln(os.Stderr, "check validates the configuration as a capture would; print shows the settings")
+		fmt.Fprintln(os.Stderr, "that are set and where they came from, and --effective every setting, defaults included.")
+	}
+	if len(args) == 0 || args[0] != "check" && args[0] != "print" {
+		usage()
+		return 1
+	}
+	command := args[0]
+
+	// --effective is taken out before the capture flags are parsed
+	var flags []string
+	effective := false
+	for _, arg := range args[1:] {
+		if command == "print" && (arg == "--effective" || arg == "-effective") {
+			effective = true
+			continue
+		}
+		flags = append(flags, arg)
+	}
+
+	c, fs, err := loadConfig(flags, flag.ExitOnError)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	warnings, err := c.validate()
+	if command == "print" {
+		printConfig(os.Stdout, c, fs, effective)
+	}
+	for _, w := range warnings {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", w)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	if command == "check" {
+		fmt.Printf("config OK (%s)\n", describeConfigFile(c.configFile))
+	}
+	return 0
+}
+
+// describeConfigFile names the config file, and says if there is none.
+func describeConfigFile(path string) string {
+	if _, err := os.Stat(path); err != nil {
+		return "no config file at " + path
+	}
+	return "config file " + path
+}
+
+// printConfig writes the settings of c as a config file, each commented
+// with where its value came from. Unless effective, settings left at
+// their default are left out.
+func printConfig(w io.Writer, c *config, fs *flag.FlagSet, effective bool) {
+	fmt.Fprintf(w, "# %s\n", describeConfigFile(c.configFile))
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	v := reflect.ValueOf(c).Elem()
+	for i := range v.NumField() {
+		name := v.Type().Field(i).Tag.Get("flag")
+		source := c.sources[name]
+		if name == "" || name == "config" || source == "default" && !effective {
+			continue
+		}
+		// Strings are quoted as YAML needs; other values print as their
+		// flags take them
+		value := fs.Lookup(name).Value.String()
+		if v.Field(i).Kind() == reflect.String {
+			data, _ := yaml.Marshal(value)
+			value = strings.TrimSuffix(string(data), "\n")
+		}
+		if source == "env" {
+			source += " " + envName(name)
+		}
+		fmt.Fprintf(tw, "%s: %s\t# %s\n", name, value, source)
+	}
+	tw.Flush()
+}
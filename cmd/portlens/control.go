@@ -0,0 +1,396 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/hwang-fu/portlens/internal/capture"
+	"github.com/hwang-fu/portlens/internal/control"
+	"github.com/hwang-fu/portlens/internal/output"
+	"github.com/hwang-fu/portlens/internal/rotate"
+	"github.com/hwang-fu/portlens/internal/sdnotify"
+	"github.com/hwang-fu/portlens/internal/stats"
+	"github.com/hwang-fu/portlens/internal/stream"
+	"github.com/hwang-fu/portlens/internal/tracker"
+)
+
+// daemon is the running capture as the control commands see it.
+type daemon struct {
+	started   time.Time
+	tracker   *tracker.Tracker       // nil without --stateful
+	neighbors *tracker.NeighborTable // nil when ARP is filtered out
+	stats     *stats.StatsRecorder
+	outputs   []*rotate.Writer   // Output, pcap and Zeek log files
+	snapshots *output.PacketRing // nil without --snapshot-size
+	linkType  uint32             // Of snapshots
+	stop      context.CancelCauseFunc
+	reloader  *reloader
+}
+
+// setupControl starts answering portlens ctl on --control-socket. It
+// returns the server for the caller to close, or nil.
+func setupControl(d *daemon) *control.Server {
+	if cfg.controlSocket == "" {
+		return nil
+	}
+	srv := control.NewServer()
+	srv.Handle("status", d.status)
+	srv.Handle("stats", d.statistics)
+	srv.Handle("connections", d.connections)
+	srv.Handle("jobs", d.jobs)
+	srv.Handle("start", d.startJob)
+	srv.Handle("stop", d.stopJob)
+	srv.Handle("filter", d.filter)
+	srv.Handle("pause", func(json.RawMessage) (any, error) { return d.pause(true), nil })
+	srv.Handle("resume", func(json.RawMessage) (any, error) { return d.pause(false), nil })
+	srv.Handle("snapshot", d.snapshot)
+	srv.Handle("rotate", d.rotate)
+	srv.Handle("reload", d.reload)
+	srv.Handle("shutdown", func(json.RawMessage) (any, error) {
+		log.Printf("control: shutdown requested")
+		d.stop(stopControl)
+		return nil, nil
+	})
+	if err := srv.Serve(cfg.controlSocket); err != nil {
+		log.Fatalf("%v", err)
+	}
+	return srv
+}
+
+// statusResult answers "status".
+type statusResult struct {
+	Version     string                  `json:"version"`
+	PID         int                     `json:"pid"`
+	Started     time.Time               `json:"started"`
+	Uptime      string                  `json:"uptime"`
+	Interfaces  []string                `json:"interfaces"`
+	Paused      bool                    `json:"paused"`
+	Jobs        []jobStatus             `json:"jobs"`
+	Connections *int                    `json:"connections,omitempty"` // Tracked, with --stateful
+	Outputs     []string                `json:"outputs,omitempty"`
+	Snapshot    *snapshotStatus         `json:"snapshot,omitempty"`
+	Subscribers []stream.SubscriberInfo `json:"subscribers,omitempty"`
+}
+
+type snapshotStatus struct {
+	Packets  int `json:"packets"`
+	Bytes    int `json:"bytes"`
+	MaxBytes int `json:"max_bytes"`
+}
+
+func (d *daemon) status(json.RawMessage) (any, error) {
+	res := statusResult{
+		Version:    version,
+		PID:        os.Getpid(),
+		Started:    d.started,
+		Uptime:     time.Since(d.started).Round(time.Second).String(),
+		Interfaces: captureJobs.interfaces(),
+		Paused:     capturePaused.Load(),
+		Jobs:       listJobs(),
+	}
+	if d.tracker != nil {
+		n := d.tracker.ActiveConnections()
+		res.Connections = &n
+	}
+	for _, w := range d.outputs {
+		res.Outputs = append(res.Outputs, w.Name())
+	}
+	if d.snapshots != nil {
+		packets, size := d.snapshots.Len()
+		res.Snapshot = &snapshotStatus{Packets: packets, Bytes: size, MaxBytes: int(cfg.snapshotSize)}
+	}
+	if streamHub != nil {
+		res.Subscribers = streamHub.Subscribers()
+	}
+	return res, nil
+}
+
+// statistics answers "stats" with what --stats prints.
+func (d *daemon) statistics(json.RawMessage) (any, error) {
+	updateHealthStats(d.stats, d.tracker, d.neighbors)
+	return d.stats.Snapshot(), nil
+}
+
+// trackedConnection is a row of "connections".
+type trackedConnection struct {
+	output.ConnectionInfo
+	Started string `json:"started"`
+	PID     int    `json:"pid,omitempty"`
+	Process string `json:"process,omitempty"`
+	History string `json:"history,omitempty"`
+}
+
+// connections answers "connections" with the tracker's table, oldest
+// first.
+func (d *daemon) connections(json.RawMessage) (any, error) {
+	if d.tracker == nil {
+		return nil, errors.New("connection tracking is off; start portlens with --stateful")
+	}
+	conns := d.tracker.Connections()
+	slices.SortFunc(conns, func(a, b tracker.Connection) int {
+		return cmp.Or(a.StartTime.Compare(b.StartTime), cmp.Compare(a.Key.String(), b.Key.String()))
+	})
+	rows := make([]trackedConnection, 0, len(conns))
+	for i := range conns {
+		c := &conns[i]
+		rows = append(rows, trackedConnection{
+			ConnectionInfo: connectionInfo(c),
+			Started:        output.FormatTime(c.StartTime),
+			PID:            c.PID,
+			Process:        c.Process,
+			History:        c.History,
+		})
+	}
+	return rows, nil
+}
+
+// jobStatus describes a capture job.
+type jobStatus struct {
+	ID         int            `json:"id"`
+	Interfaces []string       `json:"interfaces"`
+	Started    time.Time      `json:"started"`
+	Filters    *packetFilters `json:"filters"`
+	Packets    uint64         `json:"packets"` // Seen by the kernel, including drops
+	Drops      uint64         `json:"drops"`
+}
+
+func newJobStatus(job *captureJob) jobStatus {
+	st := jobStatus{
+		ID:         job.id,
+		Interfaces: job.interfaces,
+		Started:    job.started,
+		Filters:    job.filters.Load(),
+	}
+	for _, s := range job.socketStats() {
+		st.Packets += s.Packets
+		st.Drops += s.Drops + s.FreezeQueueDrops
+	}
+	return st
+}
+
+// listJobs describes the running capture jobs.
+func listJobs() []jobStatus {
+	jobs := []jobStatus{}
+	for _, job := range captureJobs.list() {
+		jobs = append(jobs, newJobStatus(job))
+	}
+	return jobs
+}
+
+// jobs answers "jobs" with the running capture jobs.
+func (d *daemon) jobs(json.RawMessage) (any, error) {
+	return listJobs(), nil
+}
+
+// startArgs is the args of "start": the interfaces to capture on, as -i
+// takes them, and the job's filters. Filters left out match everything.
+type startArgs struct {
+	Interface string `json:"interface"`
+	filterChange
+}
+
+// startJob answers "start": it starts a capture job and describes it.
+func (d *daemon) startJob(args json.RawMessage) (any, error) {
+	var start startArgs
+	if err := control.DecodeArgs(args, &start); err != nil {
+		return nil, err
+	}
+	filters := start.apply(packetFilters{Protocol: "all", Direction: "all"})
+	if err := filters.validate(); err != nil {
+		return nil, err
+	}
+	interfaces, err := capture.ResolveInterfaces(start.Interface)
+	if err != nil {
+		return nil, err
+	}
+	job, err := captureJobs.start(interfaces, &filters)
+	if err != nil {
+		return nil, fmt.Errorf("start capture: %w", err)
+	}
+	logJob(job, "started")
+	notifySystemd(sdnotify.Status(d.statusLine()))
+	return newJobStatus(job), nil
+}
+
+// stopArgs is the args of "stop".
+type stopArgs struct {
+	Job int `json:"job"`
+}
+
+// stopJob answers "stop": it stops a capture job and describes it.
+func (d *daemon) stopJob(args json.RawMessage) (any, error) {
+	var stop stopArgs
+	if err := control.DecodeArgs(args, &stop); err != nil {
+		return nil, err
+	}
+	job := captureJobs.get(stop.Job)
+	if job == nil {
+		return nil, fmt.Errorf("no capture job %d", stop.Job)
+	}
+	st := newJobStatus(job)
+	if _, err := captureJobs.stop(stop.Job); err != nil {
+		return nil, err
+	}
+	logJob(job, "stopped")
+	notifySystemd(sdnotify.Status(d.statusLine()))
+	return st, nil
+}
+
+// logJob logs that job started or stopped.
+func logJob(job *captureJob, what string) {
+	filters, _ := json.Marshal(job.filters.Load())
+	log.Printf("control: capture job %d on %s %s, filters %s", job.id, strings.Join(job.interfaces, ", "), what, filters)
+}
+
+// filterChange is the filters to change. Filters left out keep their
+// value; 0 and "" switch a filter off, and "all" the protocol and
+// direction filters.
+type filterChange struct {
+	Protocol  *string `json:"protocol"`
+	Port      *int    `json:"port"`
+	IP        *string `json:"ip"`
+	VLAN      *int    `json:"vlan"`
+	Direction *string `json:"direction"`
+	Process   *string `json:"process"`
+	PID       *int    `json:"pid"`
+}
+
+// apply returns f with the change made.
+func (change *filterChange) apply(f packetFilters) packetFilters {
+	set(&f.Protocol, change.Protocol)
+	set(&f.Port, change.Port)
+	set(&f.IP, change.IP)
+	set(&f.VLAN, change.VLAN)
+	set(&f.Direction, change.Direction)
+	set(&f.Process, change.Process)
+	set(&f.PID, change.PID)
+	return f
+}
+
+// filterArgs is the args of "filter": the capture job, job 1 if left out,
+// and the change.
+type filterArgs struct {
+	Job int `json:"job"`
+	filterChange
+}
+
+// filter answers "filter": it applies a change to a job's filters, if
+// any, and returns the filters in effect.
+func (d *daemon) filter(args json.RawMessage) (any, error) {
+	var change filterArgs
+	if err := control.DecodeArgs(args, &change); err != nil {
+		return nil, err
+	}
+	id := cmp.Or(change.Job, 1)
+
+	filtersMu.Lock()
+	defer filtersMu.Unlock()
+	job := captureJobs.get(id)
+	if job == nil {
+		return nil, fmt.Errorf("no capture job %d", id)
+	}
+	f := change.apply(*job.filters.Load())
+	if f == *job.filters.Load() {
+		return &f, nil
+	}
+	if err := f.validate(); err != nil {
+		return nil, err
+	}
+	job.filters.Store(&f)
+	filters, _ := json.Marshal(&f)
+	log.Printf("control: filters of capture job %d changed to %s", id, filters)
+	return &f, nil
+}
+
+// set assigns *v to *dst unless v is nil.
+func set[T any](dst *T, v *T) {
+	if v != nil {
+		*dst = *v
+	}
+}
+
+// pause pauses or resumes the capture and returns the new state.
+func (d *daemon) pause(paused bool) map[string]bool {
+	if capturePaused.Swap(paused) != paused {
+		state := "resumed"
+		if paused {
+			state = "paused"
+		}
+		log.Printf("control: capture %s", state)
+		notifySystemd(sdnotify.Status(d.statusLine()))
+	}
+	return map[string]bool{"paused": paused}
+}
+
+// statusLine describes the capture for systemctl status.
+func (d *daemon) statusLine() string {
+	if capturePaused.Load() {
+		return "paused"
+	}
+	interfaces := captureJobs.interfaces()
+	if len(interfaces) == 0 {
+		return "no capture jobs"
+	}
+	return "capturing on " + strings.Join(interfaces, ", ")
+}
+
+// snapshotResult answers "snapshot".
+type snapshotResult struct {
+	Packets int    `json:"packets"`
+	Pcap    []byte `json:"pcap"` // A pcap file, base64 in JSON
+}
+
+// snapshot answers "snapshot" with the packets in the snapshot ring as a
+// pcap file. The client writes it, so the daemon never creates files on
+// a client's behalf.
+func (d *daemon) snapshot(json.RawMessage) (any, error) {
+	if d.snapshots == nil {
+		return nil, errors.New("no packets are kept for snapshots; start portlens with --snapshot-size")
+	}
+	var buf bytes.Buffer
+	n, err := d.snapshots.WritePcap(&buf, d.linkType, cfg.snaplen)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot: %w", err)
+	}
+	return snapshotResult{Packets: n, Pcap: buf.Bytes()}, nil
+}
+
+// rotate answers "rotate": every output file starts a new segment, or is
+// reopened if it doesn't rotate. It returns the files now written.
+func (d *daemon) rotate(json.RawMessage) (any, error) {
+	if len(d.outputs) == 0 {
+		return nil, errors.New("no output files to rotate")
+	}
+	var files []string
+	var errs []error
+	for _, w := range d.outputs {
+		if err := w.Rotate(); err != nil {
+			errs = append(errs, err)
+		}
+		files = append(files, w.Name())
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	log.Printf("control: rotated outputs")
+	return map[string][]string{"files": files}, nil
+}
+
+// reload answers "reload": it reloads the config file, as SIGHUP does, and
+// returns the settings that changed.
+func (d *daemon) reload(json.RawMessage) (any, error) {
+	changed, err := d.reloader.reloadAndLog("portlens ctl")
+	if err != nil {
+		return nil, err
+	}
+	return map[string][]string{"changed": append([]string{}, changed...)}, nil
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	yamlconfig "github.com/hwang-fu/portlens/internal/config"
+	"github.com/hwang-fu/portlens/internal/control"
+)
+
+// ctlCommands describes the commands of portlens ctl, in usage order.
+var ctlCommands = []struct{ name, args, description string }{
+	{"status", "", "show what the capture is doing"},
+	{"stats", "", "show capture statistics, as --stats prints them"},
+	{"connections", "", "list the tracked connections (needs --stateful)"},
+	{"jobs", "", "list the capture jobs; the command line's is job 1"},
+	{"start", "-i <interfaces> [flags]", "start a capture job with the filters given as flags"},
+	{"stop", "<job>", "stop a capture job and close its sockets"},
+	{"filter", "[--job n] [flags]", "show a job's packet filters, or change those given as flags"},
+	{"pause", "", "discard captured packets; the sockets stay open"},
+	{"resume", "", "stop discarding captured packets"},
+	{"snapshot", "<file.pcap | ->", "write the latest matching packets (--snapshot-size) as a pcap file"},
+	{"rotate", "", "start new output, pcap and Zeek log files"},
+	{"reload", "", "reload the config file, as on SIGHUP"},
+	{"shutdown", "", "stop capturing and exit, as on SIGTERM"},
+}
+
+// runCtl implements "portlens ctl <command>": it sends a command to a
+// capture running with --control-socket and prints the result.
+func runCtl(args []string) int {
+	fs := flag.NewFlagSet("ctl", flag.ExitOnError)
+	socket := fs.String("socket", defaultControlPath(), "control socket of the capture (--control-socket)")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: portlens ctl [--socket path] <command> [args]")
+		fmt.Fprintln(os.Stderr, "commands:")
+		for _, c := range ctlCommands {
+			fmt.Fprintf(os.Stderr, "  %-30s %s\n", c.name+" "+c.args, c.description)
+		}
+		fmt.Fprintln(os.Stderr, "flags:")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 1
+	}
+	command, rest := fs.Arg(0), fs.Args()[1:]
+
+	var cmdArgs any
+	switch command {
+	case "filter", "start":
+		change, ok := parseFilterFlags(command, rest)
+		if !ok {
+			return 1
+		}
+		if len(change) > 0 {
+			cmdArgs = change
+		}
+	case "stop":
+		var job int
+		var err error
+		if len(rest) == 1 {
+			job, err = strconv.Atoi(rest[0])
+		}
+		if len(rest) != 1 || err != nil {
+			fmt.Fprintln(os.Stderr, "usage: portlens ctl stop <job>")
+			return 1
+		}
+		cmdArgs = stopArgs{Job: job}
+	case "snapshot":
+		if len(rest) != 1 {
+			fmt.Fprintln(os.Stderr, "usage: portlens ctl snapshot <file.pcap | ->")
+			return 1
+		}
+		return ctlSnapshot(*socket, rest[0])
+	default:
+		if len(rest) > 0 {
+			fmt.Fprintf(os.Stderr, "error: %s takes no arguments\n", command)
+			return 1
+		}
+	}
+
+	var result json.RawMessage
+	if err := control.Call(*socket, command, cmdArgs, &result); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	if result == nil {
+		return 0
+	}
+	out, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	fmt.Printf("%s\n", out)
+	return 0
+}
+
+// parseFilterFlags parses the flags of "ctl filter" or "ctl start" into
+// the args of the command: only the flags given. It reports false on a
+// usage error.
+func parseFilterFlags(command string, args []string) (map[string]any, bool) {
+	fs := flag.NewFlagSet("ctl "+command, flag.ContinueOnError)
+	protocol := fs.String("protocol", "", "protocol to capture: tcp, udp, arp, or all")
+	port := fs.Int("port", 0, "filter by port number (0 = all ports)")
+	ip := fs.String("ip", "", `filter by IP address ("" = all IPs)`)
+	vlan := fs.Int("vlan", 0, "filter by 802.1Q VLAN ID (0 = all VLANs)")
+	direction := fs.String("direction", "", "filter by direction: in, out, or all")
+	process := fs.String("process", "", `filter by process name ("" = all processes)`)
+	pid := fs.Int("pid", 0, "filter by process ID (0 = all processes)")
+	values := map[string]any{
+		"protocol":  protocol,
+		"port":      port,
+		"ip":        ip,
+		"vlan":      vlan,
+		"direction": direction,
+		"process":   process,
+		"pid":       pid,
+	}
+	if command == "start" {
+		iface := fs.String("interface", "", "interfaces to capture on, as -i takes them")
+		fs.StringVar(iface, "i", "", "shorthand for --interface")
+		values["interface"], values["i"] = iface, iface
+		fs.Usage = func() {
+			fmt.Fprintln(os.Stderr, "usage: portlens ctl start -i <interfaces> [flags]")
+			fmt.Fprintln(os.Stderr, "Filters not given match everything. flags:")
+			fs.PrintDefaults()
+		}
+	} else {
+		values["job"] = fs.Int("job", 1, "capture job whose filters to show or change")
+		fs.Usage = func() {
+			fmt.Fprintln(os.Stderr, "usage: portlens ctl filter [--job n] [flags]")
+			fmt.Fprintln(os.Stderr, "Filters not given keep their value. flags:")
+			fs.PrintDefaults()
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, false
+	}
+	if fs.NArg() > 0 || command == "start" && *values["interface"].(*string) == "" {
+		fs.Usage()
+		return nil, false
+	}
+
+	change := make(map[string]any)
+	fs.Visit(func(f *flag.Flag) {
+		name := f.Name
+		if name == "i" {
+			name = "interface"
+		}
+		change[name] = values[f.Name]
+	})
+	return change, true
+}
+
+// ctlSnapshot fetches a snapshot and writes it to path, or to stdout for
+// "-".
+func ctlSnapshot(socket, path string) int {
+	var snap snapshotResult
+	if err := control.Call(socket, "snapshot", nil, &snap); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	if path == "-" {
+		if _, err := os.Stdout.Write(snap.Pcap); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+	if err := os.WriteFile(path, snap.Pcap, 0o644); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "wrote %d packets to %s\n", snap.Packets, path)
+	return 0
+}
+
+// defaultControlPath is the config file's control socket, or
+// /run/portlens/control.sock.
+func defaultControlPath() string {
+	if fileCfg, err := yamlconfig.Load(yamlconfig.DefaultPath()); err == nil && fileCfg.ControlSocket != "" {
+		return fileCfg.ControlSocket
+	}
+	return "/run/portlens/control.sock"
+}
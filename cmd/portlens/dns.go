@@ -0,0 +1,56 @@
+package main
+
+import (
+	"log"
+
+	"github.com/hwang-fu/portlens/internal/history"
+	"github.com/hwang-fu/portlens/internal/parser"
+	"github.com/hwang-fu/portlens/internal/procfs"
+	"github.com/hwang-fu/portlens/internal/zeek"
+)
+
+// handleDNS decodes a DNS message carried over UDP port 53 and hands it
+// to dns.log and the history.
+func handleDNS(pc *packetContext, ipv4 *parser.IPv4Packet, udp *parser.UDPDatagram, proc *procfs.ProcessInfo) {
+	if udp.SrcPort != parser.DNSPort && udp.DstPort != parser.DNSPort {
+		return
+	}
+	msg, err := parser.ParseDNS(udp.Payload)
+	if err != nil {
+		logDebug("parse DNS error: %v", err)
+		return
+	}
+	var pid int
+	var name string
+	if proc != nil {
+		pid, name = proc.PID, proc.Name
+	}
+
+	if zeekOut != nil {
+		err := zeekOut.DNS(zeek.DNSPacket{
+			Timestamp: pc.timestamp,
+			SrcIP:     ipv4.SrcIP,
+			SrcPort:   udp.SrcPort,
+			DstIP:     ipv4.DstIP,
+			DstPort:   udp.DstPort,
+			Message:   msg,
+			PID:       pid,
+			Process:   name,
+		})
+		if err != nil {
+			log.Printf("%v", err)
+		}
+	}
+	if historyDB != nil && msg.Response {
+		historyDB.DNS(history.DNSResponse{
+			Time:       pc.timestamp,
+			ClientIP:   ipv4.DstIP,
+			ClientPort: udp.DstPort,
+			ServerIP:   ipv4.SrcIP,
+			ServerPort: udp.SrcPort,
+			Message:    msg,
+			PID:        pid,
+			Process:    name,
+		})
+	}
+}
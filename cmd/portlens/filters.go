@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/hwang-fu/portlens/internal/procfs"
+)
+
+// packetFilters are the filters a packet must pass to be reported. Every
+// capture job has its own, which can change while capturing (portlens ctl
+// filter), so every packet is checked against its job's set that was
+// current when it arrived.
+type packetFilters struct {
+	Protocol  string `json:"protocol"`          // tcp, udp, arp, or all
+	Port      int    `json:"port,omitempty"`    // 0 = all ports
+	IP        string `json:"ip,omitempty"`      // Empty = all IPs
+	VLAN      int    `json:"vlan,omitempty"`    // 802.1Q VLAN ID (0 = all)
+	Direction string `json:"direction"`         // in, out, or all
+	Process   string `json:"process,omitempty"` // Empty = all processes
+	PID       int    `json:"pid,omitempty"`     // 0 = all processes
+}
+
+// filtersMu serializes changes to the filters of capture jobs.
+var filtersMu sync.Mutex
+
+// configFilters returns the filters set by c's flags and config file.
+func configFilters(c *config) *packetFilters {
+	return &packetFilters{
+		Protocol:  c.protocol,
+		Port:      c.port,
+		IP:        c.ip,
+		VLAN:      c.vlan,
+		Direction: c.direction,
+		Process:   c.process,
+		PID:       c.pid,
+	}
+}
+
+// validate checks that every filter has a value that can match.
+func (f *packetFilters) validate() error {
+	switch f.Protocol {
+	case "tcp", "udp", "arp", "all":
+	default:
+		return fmt.Errorf("protocol must be tcp, udp, arp, or all, not %q", f.Protocol)
+	}
+	switch f.Direction {
+	case "in", "out", "all":
+	default:
+		return fmt.Errorf("direction must be in, out, or all, not %q", f.Direction)
+	}
+	if f.Port < 0 || f.Port > 65535 {
+		return fmt.Errorf("port must be between 0 and 65535, not %d", f.Port)
+	}
+	if f.VLAN < 0 || f.VLAN > 4094 {
+		return fmt.Errorf("vlan must be between 0 and 4094, not %d", f.VLAN)
+	}
+	if f.IP != "" && net.ParseIP(f.IP) == nil {
+		return fmt.Errorf("ip %q is not an IP address", f.IP)
+	}
+	if f.PID < 0 {
+		return fmt.Errorf("pid must not be negative, not %d", f.PID)
+	}
+	return nil
+}
+
+// wantProtocol reports whether the protocol filter lets proto ("tcp", "udp",
+// "arp") through.
+func (f *packetFilters) wantProtocol(proto string) bool {
+	return f.Protocol == "all" || f.Protocol == proto
+}
+
+// matchesVLAN checks if any of the packet's VLAN tags carries the
+// filtered VLAN ID.
+func (f *packetFilters) matchesVLAN(vlans []uint16) bool {
+	if f.VLAN == 0 {
+		return true
+	}
+	for _, vid := range vlans {
+		if vid == uint16(f.VLAN) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesPort checks if either port is the filtered one.
+func (f *packetFilters) matchesPort(srcPort, dstPort uint16) bool {
+	return f.Port == 0 || srcPort == uint16(f.Port) || dstPort == uint16(f.Port)
+}
+
+// matchesProcess checks if proc matches the process filters.
+// Returns true if the packet should be processed, false if it should be skipped.
+func (f *packetFilters) matchesProcess(proc *procfs.ProcessInfo) bool {
+	if f.Process != "" && (proc == nil || proc.Name != f.Process) {
+		return false
+	}
+	if f.PID != 0 && (proc == nil || proc.PID != f.PID) {
+		return false
+	}
+	return true
+}
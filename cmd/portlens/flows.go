@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/hwang-fu/portlens/internal/flow"
+	"github.com/hwang-fu/portlens/internal/tracker"
+)
+
+// flowTick is how often open connections are checked against the active
+// timeout.
+const flowTick = time.Second
+
+// flowMeter turns tracked connections into flow records for
+// --flow-collector, or is nil.
+var flowMeter *flow.Meter
+
+// flowFailing is set while sends to the collector fail, so a collector
+// that is down is logged once rather than on every export.
+var flowFailing atomic.Bool
+
+// setupFlowExport connects to the flow collector and creates flowMeter.
+// Returns nil without --flow-collector.
+func setupFlowExport(localIPs map[string]bool) *flow.Exporter {
+	if cfg.flowCollector == "" {
+		return nil
+	}
+	exp, err := flow.Dial(cfg.flowCollector, cfg.flowProtocol)
+	if err != nil {
+		log.Fatalf("flow export: %v", err)
+	}
+	isLocal := func(ip string) bool { return localIPs[ip] }
+	flowMeter = flow.NewMeter(exp.Export, cfg.flowActiveTimeout, isLocal)
+	return exp
+}
+
+// runFlowTicks exports the records of long-lived connections until ctx
+// is done.
+func runFlowTicks(ctx context.Context, connTracker *tracker.Tracker) {
+	ticker := time.NewTicker(flowTick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			flowResult(flowMeter.Tick(connTracker.Connections(), now))
+		}
+	}
+}
+
+// flowResult logs the first of a run of failed exports.
+func flowResult(err error) {
+	if err == nil {
+		if flowFailing.Swap(false) {
+			log.Printf("flow export: collector reachable again")
+		}
+		return
+	}
+	if !flowFailing.Swap(true) {
+		log.Printf("flow export: %v", err)
+	}
+}
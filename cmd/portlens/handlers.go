@@ -4,27 +4,111 @@ import (
 	"encoding/json"
 	"io"
 	"log"
+	"sync"
+	"sync/atomic"
 
 	"github.com/hwang-fu/portlens/internal/output"
 	"github.com/hwang-fu/portlens/internal/parser"
 	"github.com/hwang-fu/portlens/internal/tracker"
 )
 
-// jsonWriter handles JSON output with pretty-printing.
-type jsonWriter struct {
-	w io.Writer
+// recordSink receives every record the capture produces.
+type recordSink interface {
+	Encode(v any) error
+	Close()
 }
 
-// Encode writes a value as pretty-printed JSON.
-func (jw *jsonWriter) Encode(v any) error {
-	data, err := json.MarshalIndent(v, "", "  ")
+// activeVerbosity holds the --verbosity level, which a config reload can
+// change while capturing.
+var activeVerbosity atomic.Int32
+
+// verbosity returns the current --verbosity level.
+func verbosity() int {
+	return int(activeVerbosity.Load())
+}
+
+// encodeFunc renders one record, including its trailing newline.
+type encodeFunc func(v any) ([]byte, error)
+
+// recordWriter serializes records from every pipeline goroutine onto one
+// output. Callers encode their own records (in parallel) and a single
+// writer goroutine writes them in the order they were queued.
+type recordWriter struct {
+	w      io.Writer
+	encode atomic.Pointer[encodeFunc] // Swapped when the config is reloaded
+	lines  chan []byte
+	done   chan struct{}
+}
+
+// newRecordWriter creates a recordWriter and starts its writer goroutine.
+func newRecordWriter(w io.Writer, encode encodeFunc) *recordWriter {
+	rw := &recordWriter{
+		w:     w,
+		lines: make(chan []byte, 1024),
+		done:  make(chan struct{}),
+	}
+	rw.encode.Store(&encode)
+	go rw.run()
+	return rw
+}
+
+// setEncoder changes the output format of the records encoded from now on.
+func (rw *recordWriter) setEncoder(encode encodeFunc) {
+	rw.encode.Store(&encode)
+}
+
+// newEncoder returns the encoder for an output format. color applies to
+// the text format only.
+func newEncoder(format string, color bool) encodeFunc {
+	switch format {
+	case "ndjson":
+		return func(v any) ([]byte, error) {
+			data, err := json.Marshal(v)
+			return append(data, '\n'), err
+		}
+	case "text":
+		return (&output.TextEncoder{Color: color}).Encode
+	case "ecs":
+		return output.ECSEncoder{}.Encode
+	case "otlp":
+		return (&output.OTLPEncoder{Resource: otlpResource()}).Encode
+	}
+	return func(v any) ([]byte, error) {
+		data, err := json.MarshalIndent(v, "", "  ")
+		return append(data, '\n'), err
+	}
+}
+
+// run writes queued records until the queue is closed.
+func (rw *recordWriter) run() {
+	defer close(rw.done)
+	for line := range rw.lines {
+		if _, err := rw.w.Write(line); err != nil {
+			log.Printf("write output: %v", err)
+		}
+	}
+}
+
+// Encode renders a record in the output format and queues it for writing.
+func (rw *recordWriter) Encode(v any) error {
+	data, err := (*rw.encode.Load())(v)
 	if err != nil {
 		return err
 	}
-	_, err = jw.w.Write(append(data, '\n'))
-	return err
+	rw.lines <- data
+	return nil
+}
+
+// Close stops accepting records and waits until all queued ones are written.
+func (rw *recordWriter) Close() {
+	close(rw.lines)
+	<-rw.done
 }
 
+// eventHandlers tracks the tracker and neighbor event goroutines, so
+// shutdown can wait until their last events are written.
+var eventHandlers sync.WaitGroup
+
 // setupTracker creates a connection tracker and starts its event handler.
 // Returns nil if stateful mode is disabled.
 func setupTracker() *tracker.Tracker {
@@ -34,72 +118,215 @@ func setupTracker() *tracker.Tracker {
 
 	t := tracker.New(100)
 
+	eventHandlers.Add(1)
 	go func() {
+		defer eventHandlers.Done()
 		for event := range t.Events() {
-			eventRecord := map[string]any{
-				"event_type": event.Type,
-				"timestamp":  event.Timestamp.UTC().Format("2006-01-02T15:04:05.000Z"),
-				"connection": map[string]any{
-					"src_ip":       event.Connection.Key.SrcIP,
-					"src_port":     event.Connection.Key.SrcPort,
-					"dst_ip":       event.Connection.Key.DstIP,
-					"dst_port":     event.Connection.Key.DstPort,
-					"protocol":     event.Connection.Key.Protocol,
-					"state":        event.Connection.State.String(),
-					"duration":     event.Connection.Duration().String(),
-					"packets_sent": event.Connection.PacketsSent,
-					"packets_recv": event.Connection.PacketsReceived,
-					"bytes_sent":   event.Connection.BytesSent,
-					"bytes_recv":   event.Connection.BytesReceived,
-				},
+			if promMetrics != nil {
+				promMetrics.connEvent(event.Type)
 			}
-			jsonOut.Encode(eventRecord)
+			conn := event.Connection
+			if flowMeter != nil && event.Type == "closed" {
+				flowResult(flowMeter.Closed(conn, event.Reason))
+			}
+			if zeekOut != nil && event.Type == "closed" {
+				if err := zeekOut.Connection(conn); err != nil {
+					log.Printf("%v", err)
+				}
+			}
+			if historyDB != nil && event.Type == "closed" {
+				historyDB.Connection(conn, event.Reason)
+			}
+			recordOut.Encode(output.ConnectionRecord{
+				Type:          output.TypeConnection,
+				SchemaVersion: output.SchemaVersion,
+				EventType:     event.Type,
+				Timestamp:     output.FormatTime(event.Timestamp),
+				Time:          event.Timestamp,
+				Reason:        event.Reason,
+				Connection:    connectionInfo(conn),
+			})
 		}
 	}()
 
 	return t
 }
 
+// connectionInfo describes a tracked connection in records.
+func connectionInfo(conn *tracker.Connection) output.ConnectionInfo {
+	return output.ConnectionInfo{
+		SrcIP:       conn.Key.SrcIP,
+		SrcPort:     conn.Key.SrcPort,
+		DstIP:       conn.Key.DstIP,
+		DstPort:     conn.Key.DstPort,
+		Protocol:    conn.Key.Protocol,
+		State:       conn.State.String(),
+		Duration:    conn.Duration().String(),
+		PacketsSent: conn.PacketsSent,
+		PacketsRecv: conn.PacketsReceived,
+		BytesSent:   conn.BytesSent,
+		BytesRecv:   conn.BytesReceived,
+	}
+}
+
+// setupNeighborTable creates an ARP neighbor table and starts its event handler.
+// Returns nil if ARP is excluded by the protocol filter at startup.
+func setupNeighborTable() *tracker.NeighborTable {
+	if !configFilters(&cfg).wantProtocol("arp") {
+		return nil
+	}
+
+	t := tracker.NewNeighborTable(100, tracker.DefaultMaxNeighbors, tracker.DefaultNeighborTimeout)
+
+	eventHandlers.Add(1)
+	go func() {
+		defer eventHandlers.Done()
+		for event := range t.Events() {
+			recordOut.Encode(output.NeighborRecord{
+				Type:          output.TypeNeighbor,
+				SchemaVersion: output.SchemaVersion,
+				EventType:     event.Type,
+				Timestamp:     output.FormatTime(event.Timestamp),
+				Time:          event.Timestamp,
+				Neighbor: output.NeighborInfo{
+					IP:     event.IP,
+					MAC:    event.MAC,
+					OldMAC: event.OldMAC,
+				},
+			})
+		}
+	}()
+
+	return t
+}
+
+// handleARPPacket processes an ARP packet, updates the neighbor table
+// and outputs the record. Returns false if the packet was filtered out.
+func handleARPPacket(pc *packetContext, data []byte) bool {
+	arp, err := parser.ParseARP(data)
+	if err != nil {
+		pc.parseError("parse ARP error: %v", err)
+		return false
+	}
+
+	// The neighbor table sees every ARP packet, regardless of output filters
+	if pc.neighbors != nil {
+		pc.neighbors.ProcessARP(
+			arp.SenderIP.String(), arp.SenderMAC.String(),
+			arp.TargetIP.String(),
+			arp.IsGratuitous(), arp.IsProbe(),
+			pc.timestamp,
+		)
+	}
+
+	// ARP carries no ports or owning process
+	if pc.filters.Port != 0 || !pc.filters.matchesProcess(nil) {
+		pc.filtered()
+		return false
+	}
+
+	// IP filter
+	if ip := pc.filters.IP; ip != "" && arp.SenderIP.String() != ip && arp.TargetIP.String() != ip {
+		pc.filtered()
+		return false
+	}
+
+	// Direction filter
+	dir := getDirection(arp.SenderIP.String(), arp.TargetIP.String(), pc.localIPs)
+	if pc.filters.Direction != "all" && dir != pc.filters.Direction {
+		pc.filtered()
+		return false
+	}
+
+	record := output.ARPRecord{
+		Type:          output.TypePacket,
+		SchemaVersion: output.SchemaVersion,
+		Timestamp:     output.FormatTime(pc.timestamp),
+		Time:          pc.timestamp,
+		Interface:     pc.iface,
+		Protocol:      "ARP",
+		Operation:     arp.OperationName(),
+		SenderMAC:     arp.SenderMAC.String(),
+		SenderIP:      arp.SenderIP.String(),
+		TargetMAC:     arp.TargetMAC.String(),
+		TargetIP:      arp.TargetIP.String(),
+		Direction:     dir,
+		Length:        pc.length,
+		CapLen:        pc.caplen,
+		Gratuitous:    arp.IsGratuitous(),
+		Probe:         arp.IsProbe(),
+		VLANs:         pc.vlans,
+		Encap:         pc.encap,
+	}
+
+	// Past a --count or --max-bytes limit, packets are no longer reported
+	if !pc.admit(&record) {
+		return true
+	}
+
+	if verbosity() >= 2 {
+		recordOut.Encode(record)
+	}
+
+	pc.accept("ARP", dir, record.SenderIP, record.TargetIP, 0, nil)
+	return true
+}
+
 // handleTCPPacket processes a TCP packet and outputs the record.
 // Returns false if the packet was filtered out.
-func handleTCPPacket(ipv4 *parser.IPv4Packet, dir string, connTracker *tracker.Tracker) bool {
+func handleTCPPacket(pc *packetContext, ipv4 *parser.IPv4Packet, dir string) bool {
 	tcp, err := parser.ParseTCP(ipv4.Payload)
 	if err != nil {
-		log.Printf("parse TCP error: %v", err)
+		pc.parseError("parse TCP error: %v", err)
 		return false
 	}
 
 	// Port filter
-	if cfg.port != 0 && tcp.SrcPort != uint16(cfg.port) && tcp.DstPort != uint16(cfg.port) {
+	if !pc.filters.matchesPort(tcp.SrcPort, tcp.DstPort) {
+		pc.filtered()
 		return false
 	}
 
 	// Process lookup and filter
 	proc := lookupProcess("tcp", ipv4.SrcIP, ipv4.DstIP, tcp.SrcPort, tcp.DstPort)
-	if !matchesProcessFilter(proc) {
+	if !pc.filters.matchesProcess(proc) {
+		pc.filtered()
 		return false
 	}
 
 	// Connection tracking
-	if connTracker != nil {
-		connTracker.ProcessTCPPacket(
+	if pc.connTracker != nil {
+		conn := pc.connTracker.ProcessTCPPacket(
 			ipv4.SrcIP.String(), tcp.SrcPort,
 			ipv4.DstIP.String(), tcp.DstPort,
 			tcp.Flags,
-			len(tcp.Payload),
+			len(tcp.Payload), int(ipv4.TotalLen),
 			dir == "out",
+			pc.timestamp,
 		)
+		// Flow records, conn.log and the history carry the owning process
+		if proc != nil && (flowMeter != nil || zeekOut != nil || historyDB != nil) {
+			pc.connTracker.SetOwner(conn.Key, proc.PID, proc.Name)
+		}
 	}
 
 	// Build and output record
 	record := output.PacketRecord{
-		Timestamp: output.Now(),
-		Protocol:  "TCP",
-		SrcIP:     ipv4.SrcIP.String(),
-		SrcPort:   tcp.SrcPort,
-		DstIP:     ipv4.DstIP.String(),
-		DstPort:   tcp.DstPort,
-		Direction: dir,
+		Type:          output.TypePacket,
+		SchemaVersion: output.SchemaVersion,
+		Timestamp:     output.FormatTime(pc.timestamp),
+		Time:          pc.timestamp,
+		Interface:     pc.iface,
+		Protocol:      "TCP",
+		SrcIP:         ipv4.SrcIP.String(),
+		SrcPort:       tcp.SrcPort,
+		DstIP:         ipv4.DstIP.String(),
+		DstPort:       tcp.DstPort,
+		Direction:     dir,
+		Length:        pc.length,
+		CapLen:        pc.caplen,
+		VLANs:         pc.vlans,
+		Encap:         pc.encap,
 		TCP: &output.TCPInfo{
 			Seq:   tcp.SeqNum,
 			Ack:   tcp.AckNum,
@@ -111,46 +338,62 @@ func handleTCPPacket(ipv4 *parser.IPv4Packet, dir string, connTracker *tracker.T
 		record.ProcessName = proc.Name
 	}
 
-	if cfg.verbosity >= 3 {
+	if verbosity() >= 3 {
 		record.Payload = output.NewPayloadInfo(tcp.Payload)
 	}
 
-	if cfg.verbosity >= 2 {
-		jsonOut.Encode(record)
+	// Past a --count or --max-bytes limit, packets are no longer reported
+	if !pc.admit(&record) {
+		return true
+	}
+
+	if verbosity() >= 2 {
+		recordOut.Encode(record)
 	}
 
+	pc.accept("TCP", dir, record.SrcIP, record.DstIP, servicePort(tcp.SrcPort, tcp.DstPort), proc)
 	return true
 }
 
 // handleUDPPacket processes a UDP packet and outputs the record.
 // Returns false if the packet was filtered out.
-func handleUDPPacket(ipv4 *parser.IPv4Packet, dir string) bool {
+func handleUDPPacket(pc *packetContext, ipv4 *parser.IPv4Packet, dir string) bool {
 	udp, err := parser.ParseUDP(ipv4.Payload)
 	if err != nil {
-		log.Printf("parse UDP error: %v", err)
+		pc.parseError("parse UDP error: %v", err)
 		return false
 	}
 
 	// Port filter
-	if cfg.port != 0 && udp.SrcPort != uint16(cfg.port) && udp.DstPort != uint16(cfg.port) {
+	if !pc.filters.matchesPort(udp.SrcPort, udp.DstPort) {
+		pc.filtered()
 		return false
 	}
 
 	// Process lookup and filter
 	proc := lookupProcess("udp", ipv4.SrcIP, ipv4.DstIP, udp.SrcPort, udp.DstPort)
-	if !matchesProcessFilter(proc) {
+	if !pc.filters.matchesProcess(proc) {
+		pc.filtered()
 		return false
 	}
 
 	// Build and output record
 	record := output.PacketRecord{
-		Timestamp: output.Now(),
-		Protocol:  "UDP",
-		SrcIP:     ipv4.SrcIP.String(),
-		SrcPort:   udp.SrcPort,
-		DstIP:     ipv4.DstIP.String(),
-		DstPort:   udp.DstPort,
-		Direction: dir,
+		Type:          output.TypePacket,
+		SchemaVersion: output.SchemaVersion,
+		Timestamp:     output.FormatTime(pc.timestamp),
+		Time:          pc.timestamp,
+		Interface:     pc.iface,
+		Protocol:      "UDP",
+		SrcIP:         ipv4.SrcIP.String(),
+		SrcPort:       udp.SrcPort,
+		DstIP:         ipv4.DstIP.String(),
+		DstPort:       udp.DstPort,
+		Direction:     dir,
+		Length:        pc.length,
+		CapLen:        pc.caplen,
+		VLANs:         pc.vlans,
+		Encap:         pc.encap,
 		UDP: &output.UDPInfo{
 			Length: udp.Length,
 		},
@@ -160,12 +403,22 @@ func handleUDPPacket(ipv4 *parser.IPv4Packet, dir string) bool {
 		record.ProcessName = proc.Name
 	}
 
-	if cfg.verbosity >= 3 {
+	if verbosity() >= 3 {
 		record.Payload = output.NewPayloadInfo(udp.Payload)
 	}
 
-	if cfg.verbosity >= 2 {
-		jsonOut.Encode(record)
+	// Past a --count or --max-bytes limit, packets are no longer reported
+	if !pc.admit(&record) {
+		return true
 	}
+
+	if verbosity() >= 2 {
+		recordOut.Encode(record)
+	}
+	if zeekOut != nil || historyDB != nil {
+		handleDNS(pc, ipv4, udp, proc)
+	}
+
+	pc.accept("UDP", dir, record.SrcIP, record.DstIP, servicePort(udp.SrcPort, udp.DstPort), proc)
 	return true
 }
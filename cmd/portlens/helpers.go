@@ -1,8 +1,16 @@
 package main
 
 import (
+	"fmt"
 	"log"
+	"math"
 	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
 
 	"github.com/hwang-fu/portlens/internal/procfs"
 )
@@ -28,28 +36,62 @@ func getDirection(srcIP, dstIP string, localIPs map[string]bool) string {
 	return "unknown"
 }
 
+// processLookupTTL is how long a socket's owner is remembered.
+const processLookupTTL = 2 * time.Second
+
+// processCache caches socket owner lookups across workers.
+var processCache = procfs.NewCache(processLookupTTL)
+
 // lookupProcess finds the process owning a socket.
 func lookupProcess(protocol string, srcIP, dstIP net.IP, srcPort, dstPort uint16) *procfs.ProcessInfo {
-	inode, err := procfs.FindSocketInode(protocol, srcIP, srcPort, dstIP, dstPort)
-	if err != nil || inode == 0 {
-		return nil
+	return processCache.Lookup(protocol, srcIP, srcPort, dstIP, dstPort)
+}
+
+// servicePort picks the port identifying a flow's service: the lower of
+// the two, which is the well-known port for most client/server traffic.
+func servicePort(srcPort, dstPort uint16) uint16 {
+	return min(srcPort, dstPort)
+}
+
+// byteSize is a flag value for sizes such as 1048576, 512K, 10MiB or 2G.
+// Suffixes are binary: K = 1024 bytes.
+type byteSize uint64
+
+// Set parses a size, implementing flag.Value.
+func (b *byteSize) Set(s string) error {
+	num := strings.ToUpper(strings.TrimSpace(s))
+	if trimmed, ok := strings.CutSuffix(num, "B"); ok {
+		num = strings.TrimSuffix(trimmed, "I")
 	}
 
-	proc, err := procfs.FindProcessBySocket(inode)
-	if err != nil {
-		return nil
+	shift := 0
+	if num != "" {
+		if i := strings.IndexByte("KMGT", num[len(num)-1]); i >= 0 {
+			shift = 10 * (i + 1)
+			num = num[:len(num)-1]
+		}
 	}
-	return proc
-}
 
-// matchesProcessFilter checks if proc matches the configured process filters.
-// Returns true if the packet should be processed, false if it should be skipped.
-func matchesProcessFilter(proc *procfs.ProcessInfo) bool {
-	if cfg.process != "" && (proc == nil || proc.Name != cfg.process) {
-		return false
+	n, err := strconv.ParseUint(num, 10, 64)
+	if err != nil || n > math.MaxUint64>>shift {
+		return fmt.Errorf("invalid size %q", s)
 	}
-	if cfg.pid != 0 && (proc == nil || proc.PID != cfg.pid) {
+	*b = byteSize(n << shift)
+	return nil
+}
+
+// String implements flag.Value.
+func (b *byteSize) String() string {
+	return strconv.FormatUint(uint64(*b), 10)
+}
+
+// useColor reports whether colored output suits f: it is a terminal and
+// the NO_COLOR convention (https://no-color.org) is not in effect.
+func useColor(f *os.File) bool {
+	if os.Getenv("NO_COLOR") != "" {
 		return false
 	}
-	return true
+	var termios syscall.Termios
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), syscall.TCGETS, uintptr(unsafe.Pointer(&termios)))
+	return errno == 0
 }
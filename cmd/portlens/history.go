@@ -0,0 +1,44 @@
+package main
+
+import (
+	"log"
+	"sync/atomic"
+
+	"github.com/hwang-fu/portlens/internal/history"
+)
+
+// historyDB keeps the --sqlite connection history, or is nil.
+var historyDB *history.Store
+
+// historyFailing is set while writes to the history fail, so a full disk
+// is logged once rather than on every batch.
+var historyFailing atomic.Bool
+
+// setupHistory opens the --sqlite database and creates historyDB.
+func setupHistory() {
+	if cfg.sqlitePath == "" {
+		return
+	}
+	s, err := history.Open(cfg.sqlitePath, historyResult)
+	if err != nil {
+		log.Fatalf("history: %v", err)
+	}
+	historyDB = s
+}
+
+// historyResult logs the first of a run of failed writes.
+func historyResult(err error) {
+	if !historyFailing.Swap(true) {
+		log.Printf("%v", err)
+	}
+}
+
+// closeHistory writes what is still queued and closes the database.
+func closeHistory() {
+	if err := historyDB.Close(); err != nil {
+		log.Printf("close history: %v", err)
+	}
+	if n := historyDB.Dropped(); n > 0 {
+		log.Printf("history: %d writes dropped", n)
+	}
+}
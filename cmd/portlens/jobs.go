@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hwang-fu/portlens/internal/capture"
+)
+
+// captureJob captures on a set of interfaces with packet filters of its
+// own. The capture asked for on the command line is job 1; portlens ctl
+// starts and stops more.
+type captureJob struct {
+	id         int
+	interfaces []string
+	started    time.Time
+	sockets    []*capture.Socket
+	filters    atomic.Pointer[packetFilters]
+	loops      []atomic.Uint64    // Of each socket's capture goroutine
+	cancel     context.CancelFunc // nil until the capture runs
+	readers    sync.WaitGroup
+}
+
+// jobTable holds the capture jobs. Every job's capture goroutines feed the
+// same worker queues; packets carry the ID of the job that read them
+// (capture.Packet.Source), which picks the filters they are checked
+// against.
+type jobTable struct {
+	mu      sync.Mutex // Serializes starting and stopping jobs
+	ctx     context.Context
+	workers []chan capture.Packet
+	readers sync.WaitGroup // Of every job
+	nextID  int
+	jobs    atomic.Pointer[map[int]*captureJob] // Replaced on every change
+	retired map[string]capture.SocketStats      // Counters of stopped jobs' sockets, by interface
+}
+
+// errSeveralInterfaces rejects capturing on several interfaces with
+// several workers, whose records would not be in timestamp order.
+var errSeveralInterfaces = errors.New("--workers above 1 needs a single interface: capture several interfaces, or any, with --workers 1")
+
+// captureJobs are the running capture jobs.
+var captureJobs *jobTable
+
+// newJobTable returns an empty table feeding the given number of workers.
+func newJobTable(workers int) *jobTable {
+	t := &jobTable{
+		workers: make([]chan capture.Packet, workers),
+		retired: make(map[string]capture.SocketStats),
+	}
+	for i := range t.workers {
+		t.workers[i] = make(chan capture.Packet, 1024)
+	}
+	t.jobs.Store(&map[int]*captureJob{})
+	return t
+}
+
+// start opens the sockets of a new job and, once the capture runs, starts
+// reading them.
+func (t *jobTable) start(interfaces []string, filters *packetFilters) (*captureJob, error) {
+	if slices.Contains(interfaces, capture.AnyInterface) && !cfg.cooked {
+		return nil, fmt.Errorf("%q needs cooked mode, which is chosen at startup", capture.AnyInterface)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.workers) > 1 {
+		// Only a single worker merges several interfaces back into
+		// timestamp order
+		names := t.interfaces()
+		for _, name := range interfaces {
+			if !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+		if len(names) > 1 || slices.Contains(names, capture.AnyInterface) {
+			return nil, errSeveralInterfaces
+		}
+	}
+	if t.ctx != nil && t.ctx.Err() != nil {
+		return nil, errors.New("the capture is stopping")
+	}
+	sockets, err := openSockets(interfaces)
+	if err != nil {
+		return nil, err
+	}
+	t.nextID++
+	job := &captureJob{
+		id:         t.nextID,
+		interfaces: interfaces,
+		started:    time.Now(),
+		sockets:    sockets,
+		loops:      make([]atomic.Uint64, len(sockets)),
+	}
+	job.filters.Store(filters)
+	if t.ctx != nil {
+		t.read(job)
+	}
+	jobs := maps.Clone(*t.jobs.Load())
+	jobs[job.id] = job
+	t.jobs.Store(&jobs)
+	return job, nil
+}
+
+// read starts a capture goroutine for each of job's sockets. t.mu must be
+// held.
+func (t *jobTable) read(job *captureJob) {
+	ctx, cancel := context.WithCancel(t.ctx)
+	job.cancel = cancel
+	for i, sock := range job.sockets {
+		t.readers.Add(1)
+		job.readers.Add(1)
+		go func() {
+			defer t.readers.Done()
+			defer job.readers.Done()
+			readPackets(ctx, sock, t.workers, job.id, &job.loops[i])
+		}()
+	}
+}
+
+// run starts reading every job, and any job started later, until ctx is
+// cancelled. It returns the worker queues.
+func (t *jobTable) run(ctx context.Context) []chan capture.Packet {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.ctx = ctx
+	for _, job := range t.list() {
+		t.read(job)
+	}
+	return t.workers
+}
+
+// wait returns once ctx of run is cancelled and every capture goroutine
+// has returned, so nothing is sent to the worker queues any more.
+func (t *jobTable) wait() {
+	<-t.ctx.Done()
+	// A start holding the lock has added its readers; later ones fail
+	t.mu.Lock()
+	t.mu.Unlock()
+	t.readers.Wait()
+}
+
+// stop stops job id and closes its sockets. Its packets still queued are
+// discarded.
+func (t *jobTable) stop(id int) (*captureJob, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	job := t.get(id)
+	if job == nil {
+		return nil, fmt.Errorf("no capture job %d", id)
+	}
+	jobs := maps.Clone(*t.jobs.Load())
+	delete(jobs, id)
+	t.jobs.Store(&jobs)
+
+	if job.cancel != nil {
+		job.cancel()
+		job.readers.Wait()
+	}
+	for _, sock := range job.sockets {
+		// Keep the counters, so that the totals don't go backwards
+		if st, err := sock.Stats(); err == nil {
+			t.retired[sock.Interface()] = addSocketStats(t.retired[sock.Interface()], st)
+		}
+		sock.Close()
+	}
+	return job, nil
+}
+
+// get returns job id, or nil if there is none.
+func (t *jobTable) get(id int) *captureJob {
+	return (*t.jobs.Load())[id]
+}
+
+// list returns the jobs by ID.
+func (t *jobTable) list() []*captureJob {
+	jobs := *t.jobs.Load()
+	return slices.SortedFunc(maps.Values(jobs), func(a, b *captureJob) int { return a.id - b.id })
+}
+
+// filters returns the filters of job id, or nil once it is stopped.
+func (t *jobTable) filters(id int) *packetFilters {
+	if job := t.get(id); job != nil {
+		return job.filters.Load()
+	}
+	return nil
+}
+
+// interfaces returns the interfaces captured on, in job order.
+func (t *jobTable) interfaces() []string {
+	var names []string
+	for _, job := range t.list() {
+		for _, name := range job.interfaces {
+			if !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// readerLoops returns the loop counters of every capture goroutine.
+func (t *jobTable) readerLoops() []*atomic.Uint64 {
+	var loops []*atomic.Uint64
+	for _, job := range t.list() {
+		for i := range job.loops {
+			loops = append(loops, &job.loops[i])
+		}
+	}
+	return loops
+}
+
+// socketStats returns the kernel socket counters by interface, those of
+// stopped jobs included.
+func (t *jobTable) socketStats() map[string]capture.SocketStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	byIface := maps.Clone(t.retired)
+	for _, job := range t.list() {
+		for iface, st := range job.socketStats() {
+			byIface[iface] = addSocketStats(byIface[iface], st)
+		}
+	}
+	return byIface
+}
+
+// socketStats returns the kernel counters of job's sockets by interface.
+func (job *captureJob) socketStats() map[string]capture.SocketStats {
+	byIface := make(map[string]capture.SocketStats)
+	for _, sock := range job.sockets {
+		st, err := sock.Stats()
+		if err != nil {
+			logDebug("socket stats: %v", err)
+			continue
+		}
+		byIface[sock.Interface()] = addSocketStats(byIface[sock.Interface()], st)
+	}
+	return byIface
+}
+
+// addSocketStats returns the sum of two sets of socket counters.
+func addSocketStats(a, b capture.SocketStats) capture.SocketStats {
+	return capture.SocketStats{
+		Packets:          a.Packets + b.Packets,
+		Drops:            a.Drops + b.Drops,
+		FreezeQueueDrops: a.FreezeQueueDrops + b.FreezeQueueDrops,
+	}
+}
+
+// close closes the sockets of every job. The capture must have stopped.
+func (t *jobTable) close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, job := range t.list() {
+		for _, sock := range job.sockets {
+			sock.Close()
+		}
+	}
+}
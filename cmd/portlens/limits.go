@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/hwang-fu/portlens/internal/filter"
+)
+
+// Exit codes. A capture bounded by --count, --max-bytes or --stop-on that
+// ends because --duration ran out first exits with exitTimedOut, so
+// scripts can tell whether the condition they waited for happened.
+const (
+	exitOK       = 0
+	exitTimedOut = 3
+)
+
+// stopCause is why the capture stopped. It is the cancellation cause of
+// the capture context; the first cause wins.
+type stopCause struct {
+	reason  string // Reported as stop_reason in the summary
+	message string
+}
+
+func (c *stopCause) Error() string {
+	return c.message
+}
+
+var (
+	stopSignal   = &stopCause{"signal", "interrupted"}
+	stopDuration = &stopCause{"duration", "--duration elapsed"}
+	stopCount    = &stopCause{"count", "--count packets captured"}
+	stopMaxBytes = &stopCause{"max_bytes", "--max-bytes captured"}
+	stopFilter   = &stopCause{"stop_on", "--stop-on filter matched"}
+	stopControl  = &stopCause{"control", "stopped by portlens ctl"}
+)
+
+// captureLimits ends the capture after a number of packets or bytes, or
+// at the first packet matching the --stop-on filter. Only packets that
+// pass every filter count. It is safe for concurrent use by the workers.
+type captureLimits struct {
+	stop     context.CancelCauseFunc
+	count    uint64         // Maximum packets (0 = unlimited)
+	maxBytes uint64         // Maximum wire bytes (0 = unlimited)
+	stopOn   *filter.Filter // nil = none
+
+	packets atomic.Uint64
+	bytes   atomic.Uint64
+	reached atomic.Bool // A limit was hit; later packets are not reported
+}
+
+// newCaptureLimits returns the limits configured by the flags, or nil if
+// there are none. stop cancels the capture.
+func newCaptureLimits(stop context.CancelCauseFunc) *captureLimits {
+	if cfg.count == 0 && cfg.maxBytes == 0 && cfg.stopOn == "" {
+		return nil
+	}
+
+	l := &captureLimits{
+		stop:     stop,
+		count:    cfg.count,
+		maxBytes: uint64(cfg.maxBytes),
+	}
+	if cfg.stopOn != "" {
+		// Validated by parseFlags
+		l.stopOn, _ = filter.Compile(cfg.stopOn)
+	}
+	return l
+}
+
+// hasStopCondition reports whether the capture waits for a condition
+// other than time running out.
+func hasStopCondition() bool {
+	return cfg.count != 0 || cfg.maxBytes != 0 || cfg.stopOn != ""
+}
+
+// admit accounts for a packet of the given wire length and reports whether
+// it is still within the limits. The packet that reaches a limit or
+// matches --stop-on is admitted and stops the capture.
+func (l *captureLimits) admit(length int, rec filter.Env) bool {
+	if l.reached.Load() {
+		return false
+	}
+
+	if l.maxBytes != 0 {
+		for {
+			cur := l.bytes.Load()
+			next := cur + uint64(length)
+			if next > l.maxBytes {
+				l.trigger(stopMaxBytes)
+				return false
+			}
+			if l.bytes.CompareAndSwap(cur, next) {
+				if next == l.maxBytes {
+					l.trigger(stopMaxBytes)
+				}
+				break
+			}
+		}
+	}
+
+	if l.count != 0 {
+		n := l.packets.Add(1)
+		if n > l.count {
+			return false
+		}
+		if n == l.count {
+			l.trigger(stopCount)
+		}
+	}
+
+	if l.stopOn != nil && l.stopOn.Match(rec) {
+		l.trigger(stopFilter)
+	}
+	return true
+}
+
+// trigger stops the capture with cause, unless a limit already did.
+func (l *captureLimits) trigger(cause *stopCause) {
+	if l.reached.CompareAndSwap(false, true) {
+		l.stop(cause)
+	}
+}
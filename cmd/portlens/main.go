@@ -1,29 +1,73 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/hwang-fu/portlens/internal/capture"
-	"github.com/hwang-fu/portlens/internal/parser"
+	"github.com/hwang-fu/portlens/internal/output"
+	"github.com/hwang-fu/portlens/internal/rotate"
+	"github.com/hwang-fu/portlens/internal/sdnotify"
 	"github.com/hwang-fu/portlens/internal/stats"
+	"github.com/hwang-fu/portlens/internal/top"
+	"github.com/hwang-fu/portlens/internal/tracker"
 )
 
 var version = "dev"
 
 var (
-	cfg     config
-	jsonOut *jsonWriter
+	cfg       config
+	recordOut recordSink
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "schema":
+			os.Exit(runSchema(os.Args[2:]))
+		case "query":
+			os.Exit(runQuery(os.Args[2:]))
+		case "ctl":
+			os.Exit(runCtl(os.Args[2:]))
+		case "config":
+			os.Exit(runConfig(os.Args[2:]))
+		case "top":
+			// Capture flags follow the subcommand
+			topMode = true
+			os.Args = append(os.Args[:1], os.Args[2:]...)
+		}
+	}
+	os.Exit(run())
+}
+
+// run captures until the capture is stopped and returns the exit code.
+// Returning instead of exiting lets deferred cleanup (promiscuous mode,
+// open files) run.
+func run() int {
 	parseFlags()
+	activeVerbosity.Store(int32(cfg.verbosity))
+	if topMode {
+		setupTopMode()
+	}
 
-	// Setup log output
+	timeFormat, err := output.ParseTimeFormat(cfg.timeFormat)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	output.SetTimeFormat(timeFormat, time.Now())
+
+	// Setup log output. The live view owns the terminal, so its log lines
+	// are held back until it exits.
+	var heldLog bytes.Buffer
 	if cfg.logFile != "" {
 		f, err := os.OpenFile(cfg.logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
 		if err != nil {
@@ -31,23 +75,65 @@ func main() {
 		}
 		defer f.Close()
 		log.SetOutput(f)
+	} else if topMode {
+		log.SetOutput(&heldLog)
 	}
 
 	// Setup output destination
-	outWriter := os.Stdout
-	if cfg.outputFile != "" {
-		f, err := os.Create(cfg.outputFile)
+	var outWriter io.Writer = os.Stdout
+	var outFile *rotate.Writer
+	var topModel *top.Model
+	var zeekFiles []*rotate.Writer
+	reload := &reloader{args: cfgArgs, current: cfg}
+	switch {
+	case topMode:
+		topModel = top.NewModel()
+		recordOut = topModel
+	case isZeekFormat(cfg.format):
+		// Set up below, once the local addresses are known
+		recordOut = discardSink{}
+	default:
+		if cfg.outputFile != "" {
+			outFile, err = openOutput(cfg.outputFile, false)
+			if err != nil {
+				log.Fatalf("create output file: %v", err)
+			}
+			outWriter = outFile
+		}
+		reload.colorTerminal = outFile == nil && useColor(os.Stdout)
+		color := cfg.color == "always" || cfg.color == "auto" && reload.colorTerminal
+		reload.writer = newRecordWriter(outWriter, newEncoder(cfg.format, color))
+		recordOut = reload.writer
+	}
+	if cfg.otlpEndpoint != "" {
+		recordOut = teeSink{recordOut, newOTLPSink()}
+	}
+	if streamSrv := setupStream(); streamHub != nil {
+		recordOut = teeSink{recordOut, streamSink{}}
+		if streamSrv != nil {
+			defer streamSrv.Close()
+		}
+	}
+
+	linkType := uint32(output.LinkTypeEthernet)
+	if cfg.cooked {
+		linkType = output.LinkTypeLinuxSLL2
+	}
+	var pcapFile *rotate.Writer
+	var pcapOut *output.PcapWriter
+	if cfg.pcapFile != "" {
+		pcapFile, err = openOutput(cfg.pcapFile, true)
 		if err != nil {
-			log.Fatalf("create output file: %v", err)
+			log.Fatalf("create pcap file: %v", err)
+		}
+		pcapOut, err = output.NewPcapWriter(pcapFile, linkType, cfg.snaplen)
+		if err != nil {
+			log.Fatalf("write pcap header: %v", err)
 		}
-		defer f.Close()
-		outWriter = f
 	}
-	jsonOut = &jsonWriter{w: outWriter}
 
-	connTracker := setupTracker()
-	if connTracker != nil {
-		defer connTracker.Close()
+	if cfg.metricsListen != "" {
+		promMetrics = newExporter()
 	}
 
 	localIPs, err := capture.LocalIPs()
@@ -55,95 +141,281 @@ func main() {
 		log.Fatalf("get local IPs: %v", err)
 	}
 
-	sock, err := capture.NewSocket()
+	flowOut := setupFlowExport(localIPs)
+	if isZeekFormat(cfg.format) && !topMode {
+		zeekFiles = setupZeek(localIPs)
+	}
+	setupHistory()
+	connTracker := setupTracker()
+	neighbors := setupNeighborTable()
+
+	interfaces, err := capture.ResolveInterfaces(cfg.interfaceName)
 	if err != nil {
-		log.Fatalf("create socket: %v", err)
+		log.Fatalf("resolve interfaces: %v", err)
+	}
+
+	// The command line's capture is job 1
+	captureJobs = newJobTable(cfg.workers)
+	if _, err := captureJobs.start(interfaces, configFilters(&cfg)); err != nil {
+		log.Fatalf("open socket: %v", err)
 	}
-	defer sock.Close()
+	defer captureJobs.close()
 
-	if err := sock.Bind(cfg.interfaceName); err != nil {
-		log.Fatalf("bind: %v", err)
+	if promMetrics != nil {
+		promMetrics.watch(connTracker, processCache)
+		srv, err := promMetrics.serve(cfg.metricsListen)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		defer srv.Close()
 	}
 
-	logDebug("config: interface=%s, protocol=%s, verbosity=%d", cfg.interfaceName, cfg.protocol, cfg.verbosity)
+	logDebug("config: interface=%s, cooked=%v, workers=%d, protocol=%s, verbosity=%d", cfg.interfaceName, cfg.cooked, cfg.workers, cfg.protocol, cfg.verbosity)
+
+	ctx, cancel := notifyShutdown()
+	defer cancel(nil)
 
-	fmt.Fprintf(os.Stderr, "capturing on %s...\n", cfg.interfaceName)
+	if flowMeter != nil {
+		go runFlowTicks(ctx, connTracker)
+	}
+
+	if cfg.duration > 0 {
+		timer := time.AfterFunc(cfg.duration, func() { cancel(stopDuration) })
+		defer timer.Stop()
+	}
 
-	// Setup stats recorder
+	fmt.Fprintf(os.Stderr, "capturing on %s...\n", strings.Join(interfaces, ", "))
+
+	// Setup stats recorder; --graceful needs it for the shutdown summary,
+	// the live view for its header, the history for its stats table and
+	// portlens ctl for its stats command
 	var statsRecorder *stats.StatsRecorder
-	if cfg.stats {
+	if cfg.stats || cfg.graceful || topMode || historyDB != nil || cfg.controlSocket != "" {
 		statsRecorder = stats.NewRecorder()
+	}
+	if cfg.stats || historyDB != nil {
+		reload.statsInterval = make(chan time.Duration, 1)
 		go func() {
-			ticker := time.NewTicker(5 * time.Second)
+			ticker := time.NewTicker(cfg.statsInterval)
 			defer ticker.Stop()
-			for range ticker.C {
-				statsRecorder.WriteJSON(os.Stderr)
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case interval := <-reload.statsInterval:
+					ticker.Reset(interval)
+				case <-ticker.C:
+					updateHealthStats(statsRecorder, connTracker, neighbors)
+					if historyDB != nil {
+						historyDB.Stats(statsRecorder.Totals())
+					}
+					if cfg.stats {
+						statsRecorder.WriteInterval(os.Stderr)
+					}
+				}
 			}
 		}()
 	}
 
-	// Setup signal handling for graceful shutdown
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	pl := &pipeline{
+		localIPs:    localIPs,
+		connTracker: connTracker,
+		neighbors:   neighbors,
+		stats:       statsRecorder,
+		pcap:        pcapOut,
+		limits:      newCaptureLimits(cancel),
+		metrics:     promMetrics,
+	}
+	if cfg.snapshotSize != 0 {
+		pl.snapshots = output.NewPacketRing(int(cfg.snapshotSize))
+	}
 
-	go func() {
-		<-sigChan
-		if cfg.graceful && statsRecorder != nil {
-			fmt.Fprintln(os.Stderr, "\n--- Shutdown Summary ---")
-			statsRecorder.WriteJSON(os.Stderr)
-		}
-		os.Exit(0)
-	}()
+	// A packet crossing a bridge shows up on the bridge and on the veth.
+	// portlens ctl may start capture jobs on more interfaces.
+	if len(interfaces) > 1 || interfaces[0] == capture.AnyInterface || cfg.controlSocket != "" {
+		pl.dedup = capture.NewDeduplicator(dedupWindow)
+	}
 
-	buf := make([]byte, 65535)
-	for {
-		n, err := sock.ReadPacket(buf)
-		if err != nil {
-			log.Printf("read error: %v", err)
-			continue
-		}
+	var topDone <-chan struct{}
+	if topMode {
+		topDone = startTop(ctx, cancel, topModel, top.Options{
+			Title:   strings.Join(interfaces, ", "),
+			Stats:   statsRecorder,
+			Refresh: func() { updateHealthStats(statsRecorder, connTracker, neighbors) },
+		})
+	}
 
-		if statsRecorder != nil {
-			statsRecorder.RecordPacket(n)
+	d := &daemon{
+		started:   time.Now(),
+		tracker:   connTracker,
+		neighbors: neighbors,
+		stats:     statsRecorder,
+		snapshots: pl.snapshots,
+		linkType:  linkType,
+		stop:      cancel,
+		reloader:  reload,
+	}
+	for _, w := range append([]*rotate.Writer{outFile, pcapFile}, zeekFiles...) {
+		if w != nil {
+			d.outputs = append(d.outputs, w)
 		}
+	}
+	controlSrv := setupControl(d)
+	watchConfig(ctx, reload)
 
-		frame, err := parser.ParseEthernet(buf[:n])
-		if err != nil {
-			log.Printf("parse error: %v", err)
-			continue
-		}
+	notifySystemd(sdnotify.Ready, sdnotify.Status(d.statusLine()))
+	startWatchdog(ctx)
 
-		if frame.EtherType != parser.EtherTypeIPv4 {
-			continue
-		}
+	// Capture jobs started through portlens ctl may add interfaces
+	runCapture(ctx, pl, len(interfaces) > 1 || cfg.controlSocket != "")
 
-		ipv4, err := parser.ParseIPv4(frame.Payload)
-		if err != nil {
-			log.Printf("parse ipv4 error: %v", err)
-			continue
-		}
+	notifySystemd(sdnotify.Stopping)
+	if controlSrv != nil {
+		controlSrv.Close()
+	}
 
-		// IP filter
-		if cfg.ip != "" && ipv4.SrcIP.String() != cfg.ip && ipv4.DstIP.String() != cfg.ip {
-			continue
-		}
+	if topMode {
+		<-topDone
+		os.Stderr.Write(heldLog.Bytes())
+	}
 
-		// Direction filter
-		dir := getDirection(ipv4.SrcIP.String(), ipv4.DstIP.String(), localIPs)
-		if cfg.direction != "all" && dir != cfg.direction {
-			continue
+	cause, _ := context.Cause(ctx).(*stopCause)
+	if cause == nil {
+		cause = stopSignal
+	}
+	if cause != stopSignal {
+		fmt.Fprintf(os.Stderr, "capture stopped: %s\n", cause)
+	}
+
+	// Capture has stopped and every worker is drained. Close what is still
+	// open so its events reach the output before the writers are flushed.
+	if connTracker != nil {
+		connTracker.CloseAll(time.Now(), "shutdown")
+		connTracker.Close()
+	}
+	if neighbors != nil {
+		neighbors.Close()
+	}
+	eventHandlers.Wait()
+	if flowOut != nil {
+		flowOut.Close()
+	}
+	if zeekOut != nil {
+		closeZeek(zeekFiles)
+	}
+	if historyDB != nil {
+		updateHealthStats(statsRecorder, connTracker, neighbors)
+		historyDB.Stats(statsRecorder.Totals())
+		closeHistory()
+	}
+
+	recordOut.Close()
+	if outFile != nil {
+		if err := outFile.Close(); err != nil {
+			log.Printf("close output file: %v", err)
+		}
+	}
+	if pcapFile != nil {
+		if err := pcapFile.Close(); err != nil {
+			log.Printf("close pcap file: %v", err)
 		}
+	}
 
-		// Protocol handling
-		switch ipv4.Protocol {
-		case parser.ProtocolTCP:
-			if cfg.protocol != "udp" {
-				handleTCPPacket(ipv4, dir, connTracker)
-			}
-		case parser.ProtocolUDP:
-			if cfg.protocol != "tcp" {
-				handleUDPPacket(ipv4, dir)
-			}
+	if cfg.stats || cfg.graceful {
+		fmt.Fprintln(os.Stderr, "\n--- Shutdown Summary ---")
+		updateHealthStats(statsRecorder, connTracker, neighbors)
+		statsRecorder.SetStopReason(cause.reason)
+		statsRecorder.WriteJSON(os.Stderr)
+	}
+
+	if cause == stopDuration && hasStopCondition() {
+		return exitTimedOut
+	}
+	return exitOK
+}
+
+// openOutput opens an output file, rotated and compressed as configured.
+// header marks the first write as a file header to repeat in every segment.
+func openOutput(path string, header bool) (*rotate.Writer, error) {
+	return rotate.Open(path, rotate.Options{
+		MaxSize:  uint64(cfg.rotateSize),
+		Interval: cfg.rotateInterval,
+		MaxFiles: cfg.maxFiles,
+		Compress: cfg.compress,
+		Header:   header,
+	})
+}
+
+// notifyShutdown returns a context that is cancelled on the first SIGINT or
+// SIGTERM. A second signal exits immediately without cleaning up.
+func notifyShutdown() (context.Context, context.CancelCauseFunc) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+
+	sigChan := make(chan os.Signal, 2)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		<-sigChan
+		fmt.Fprintln(os.Stderr, "\nshutting down, press Ctrl+C again to force exit...")
+		cancel(stopSignal)
+
+		<-sigChan
+		fmt.Fprintln(os.Stderr, "forced exit")
+		os.Exit(1)
+	}()
+
+	return ctx, cancel
+}
+
+// runCapture reads packets from the sockets of every capture job and runs
+// them through the pipeline until ctx is cancelled. It returns once all
+// packets read so far have been processed.
+func runCapture(ctx context.Context, pl *pipeline, severalInterfaces bool) {
+	// Every socket gets its own capture goroutine, which hands packets to
+	// the worker owning their flow. With a single worker and several
+	// interfaces, packets are merged back into timestamp order; several
+	// workers capture a single interface and keep each flow in order.
+	workers := captureJobs.run(ctx)
+
+	var processors sync.WaitGroup
+	for i, worker := range workers {
+		var in <-chan capture.Packet = worker
+		if i == 0 && cfg.workers == 1 && severalInterfaces {
+			in = capture.MergeOrdered(worker, reorderWindow)
 		}
+		processors.Add(1)
+		go func() {
+			defer processors.Done()
+			pl.runWorker(in)
+		}()
+	}
+
+	// Once no reader can send any more, closing the queues lets the
+	// workers drain what is left and return
+	captureJobs.wait()
+	for _, worker := range workers {
+		close(worker)
+	}
+	processors.Wait()
+}
+
+// updateHealthStats copies the kernel socket counters and the tracker's
+// dropped-event counts into the stats recorder.
+func updateHealthStats(s *stats.StatsRecorder, connTracker *tracker.Tracker, neighbors *tracker.NeighborTable) {
+	var kernel stats.KernelStats
+	for _, st := range captureJobs.socketStats() {
+		kernel.Packets += st.Packets
+		kernel.Drops += st.Drops
+		kernel.FreezeQueueDrops += st.FreezeQueueDrops
+	}
+	s.SetKernelStats(kernel)
+
+	var trackerDrops, neighborDrops uint64
+	if connTracker != nil {
+		trackerDrops = connTracker.DroppedEvents()
+	}
+	if neighbors != nil {
+		neighborDrops = neighbors.DroppedEvents()
 	}
+	s.SetEventDrops(trackerDrops, neighborDrops)
 }
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/hwang-fu/portlens/internal/capture"
+	"github.com/hwang-fu/portlens/internal/metrics"
+	"github.com/hwang-fu/portlens/internal/procfs"
+	"github.com/hwang-fu/portlens/internal/tracker"
+)
+
+// Cardinality bounds of the per-process and per-port metrics. Traffic of
+// further processes or ports is counted under "other".
+const (
+	maxProcessSeries = 100
+	maxPortSeries    = 200
+)
+
+// exporter holds the metrics served on --metrics-listen.
+type exporter struct {
+	registry *metrics.Registry
+
+	packets        *metrics.CounterVec // interface, protocol, direction
+	bytes          *metrics.CounterVec // interface, protocol, direction
+	processPackets *metrics.CounterVec // process
+	processBytes   *metrics.CounterVec // process
+	portPackets    *metrics.CounterVec // protocol, port
+	portBytes      *metrics.CounterVec // protocol, port
+	connEvents     *metrics.CounterVec // event
+}
+
+// promMetrics is the exporter, or nil without --metrics-listen.
+var promMetrics *exporter
+
+// newExporter creates the exporter's packet and event counters.
+func newExporter() *exporter {
+	r := metrics.NewRegistry()
+	return &exporter{
+		registry: r,
+		packets: r.NewCounterVec("portlens_packets",
+			"Packets that passed all filters.", "interface", "protocol", "direction"),
+		bytes: r.NewCounterVec("portlens_bytes",
+			"Wire bytes of packets that passed all filters.", "interface", "protocol", "direction"),
+		processPackets: r.NewCounterVec("portlens_process_packets",
+			"Packets by owning process name.", "process").Limit(maxProcessSeries, "process"),
+		processBytes: r.NewCounterVec("portlens_process_bytes",
+			"Wire bytes by owning process name.", "process").Limit(maxProcessSeries, "process"),
+		portPackets: r.NewCounterVec("portlens_port_packets",
+			"Packets by service port (the lower of the two ports).", "protocol", "port").Limit(maxPortSeries, "port"),
+		portBytes: r.NewCounterVec("portlens_port_bytes",
+			"Wire bytes by service port (the lower of the two ports).", "protocol", "port").Limit(maxPortSeries, "port"),
+		connEvents: r.NewCounterVec("portlens_connection_events",
+			"Connection tracker events: opened, state_change, closed.", "event"),
+	}
+}
+
+// watch registers the metrics read at scrape time: kernel socket
+// counters, tracked connections by state and the process lookup cache.
+// connTracker may be nil.
+func (e *exporter) watch(connTracker *tracker.Tracker, cache *procfs.Cache) {
+	kernel := func(pick func(capture.SocketStats) uint64) func(func(float64, ...string)) {
+		return func(emit func(float64, ...string)) {
+			for iface, st := range captureJobs.socketStats() {
+				emit(float64(pick(st)), iface)
+			}
+		}
+	}
+	e.registry.NewCounterFunc("portlens_kernel_packets",
+		"Packets seen by the kernel capture socket, including drops.", []string{"interface"},
+		kernel(func(st capture.SocketStats) uint64 { return st.Packets }))
+	e.registry.NewCounterFunc("portlens_kernel_drops",
+		"Packets dropped by the kernel because the socket queue was full.", []string{"interface"},
+		kernel(func(st capture.SocketStats) uint64 { return st.Drops + st.FreezeQueueDrops }))
+
+	if connTracker != nil {
+		e.registry.NewGaugeFunc("portlens_connections",
+			"Tracked TCP connections by state.", []string{"state"},
+			func(emit func(float64, ...string)) {
+				for state, n := range connTracker.StateCounts() {
+					emit(float64(n), state.String())
+				}
+			})
+	}
+
+	e.registry.NewCounterFunc("portlens_process_lookups",
+		"Socket owner lookups by result: hit (cached) or miss (scanned /proc).", []string{"result"},
+		func(emit func(float64, ...string)) {
+			hits, misses := cache.Stats()
+			emit(float64(hits), "hit")
+			emit(float64(misses), "miss")
+		})
+	e.registry.NewGaugeFunc("portlens_process_lookup_cache_hit_ratio",
+		"Share of socket owner lookups answered from the cache.", nil,
+		func(emit func(float64, ...string)) {
+			hits, misses := cache.Stats()
+			if hits+misses > 0 {
+				emit(float64(hits) / float64(hits+misses))
+			}
+		})
+}
+
+// packet accounts a packet that passed all filters.
+func (e *exporter) packet(iface, protocol, dir string, port uint16, proc *procfs.ProcessInfo, length int) {
+	e.packets.Inc(iface, protocol, dir)
+	e.bytes.Add(float64(length), iface, protocol, dir)
+
+	name := "unknown"
+	if proc != nil && proc.Name != "" {
+		name = proc.Name
+	}
+	e.processPackets.Inc(name)
+	e.processBytes.Add(float64(length), name)
+
+	if port != 0 {
+		p := strconv.Itoa(int(port))
+		e.portPackets.Inc(protocol, p)
+		e.portBytes.Add(float64(length), protocol, p)
+	}
+}
+
+// connEvent counts a connection tracker event.
+func (e *exporter) connEvent(eventType string) {
+	e.connEvents.Inc(eventType)
+}
+
+// serve listens on addr and serves /metrics in the background. Listening
+// happens before it returns, so a bad address fails at startup.
+func (e *exporter) serve(addr string) (*http.Server, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("metrics listen: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", e.registry.Handler())
+	srv := &http.Server{Handler: mux}
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("metrics server: %v", err)
+		}
+	}()
+	return srv, nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"sync/atomic"
+
+	"github.com/hwang-fu/portlens/internal/otlp"
+	"github.com/hwang-fu/portlens/internal/output"
+)
+
+// otlpFailing is set while requests to the OTLP collector fail, so a
+// collector that is down is logged once rather than on every batch.
+var otlpFailing atomic.Bool
+
+// otlpResource describes this process in OTLP output.
+func otlpResource() otlp.Resource {
+	host, _ := os.Hostname()
+	return otlp.Resource{
+		ServiceName:    "portlens",
+		ServiceVersion: version,
+		HostName:       host,
+	}
+}
+
+// otlpSink sends records to an OTLP/HTTP collector as log records.
+type otlpSink struct {
+	exp *otlp.Exporter
+}
+
+// newOTLPSink starts exporting to --otlp-endpoint.
+func newOTLPSink() *otlpSink {
+	headers, _ := otlp.ParseHeaders(cfg.otlpHeaders) // Checked by parseFlags
+	exp, err := otlp.NewExporter(otlp.Options{
+		Endpoint: cfg.otlpEndpoint,
+		Headers:  headers,
+		Resource: otlpResource(),
+		Report:   otlpResult,
+	})
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	return &otlpSink{exp: exp}
+}
+
+// Encode queues a record for the collector. Records are dropped rather
+// than slowing the capture down when the collector can't keep up.
+func (s *otlpSink) Encode(v any) error {
+	rec, err := output.OTLPLogRecord(v)
+	if err != nil {
+		return err
+	}
+	s.exp.Export(rec)
+	return nil
+}
+
+// Close sends what is still queued.
+func (s *otlpSink) Close() {
+	s.exp.Close()
+	if n := s.exp.Dropped(); n > 0 {
+		log.Printf("otlp: %d records were not delivered", n)
+	}
+}
+
+// otlpResult logs the first of a run of failed requests.
+func otlpResult(err error) {
+	if err == nil {
+		if otlpFailing.Swap(false) {
+			log.Printf("otlp: collector reachable again")
+		}
+		return
+	}
+	if !otlpFailing.Swap(true) {
+		log.Printf("%v", err)
+	}
+}
+
+// teeSink hands every record to several sinks.
+type teeSink []recordSink
+
+func (t teeSink) Encode(v any) error {
+	var errs []error
+	for _, s := range t {
+		errs = append(errs, s.Encode(v))
+	}
+	return errors.Join(errs...)
+}
+
+func (t teeSink) Close() {
+	for _, s := range t {
+		s.Close()
+	}
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"encoding/binary"
+	"log"
+	"time"
+
+	"github.com/hwang-fu/portlens/internal/capture"
+	"github.com/hwang-fu/portlens/internal/filter"
+	"github.com/hwang-fu/portlens/internal/output"
+	"github.com/hwang-fu/portlens/internal/parser"
+	"github.com/hwang-fu/portlens/internal/procfs"
+	"github.com/hwang-fu/portlens/internal/stats"
+	"github.com/hwang-fu/portlens/internal/tracker"
+)
+
+// maxEncapDepth limits how many tunnel layers are peeled from one packet.
+const maxEncapDepth = 4
+
+// pipeline holds the state shared by every packet-processing worker.
+// Everything in it is safe for concurrent use.
+type pipeline struct {
+	localIPs    map[string]bool
+	connTracker *tracker.Tracker
+	neighbors   *tracker.NeighborTable
+	stats       *stats.StatsRecorder
+	dedup       *capture.Deduplicator
+	pcap        *output.PcapWriter
+	snapshots   *output.PacketRing // nil without --snapshot-size
+	limits      *captureLimits
+	metrics     *exporter // nil without --metrics-listen
+}
+
+// runWorker processes packets from in until it is closed.
+func (p *pipeline) runWorker(in <-chan capture.Packet) {
+	for pkt := range in {
+		p.handlePacket(pkt)
+	}
+}
+
+// handlePacket runs a single captured packet through the pipeline.
+func (p *pipeline) handlePacket(pkt capture.Packet) {
+	filters := captureJobs.filters(pkt.Source)
+	if filters == nil {
+		// Its capture job was stopped while it was queued
+		return
+	}
+	if p.stats != nil {
+		p.stats.RecordPacket(len(pkt.Data), pkt.Info.Timestamp)
+	}
+
+	if p.dedup != nil && p.dedup.Duplicate(pkt) {
+		if p.stats != nil {
+			p.stats.RecordDuplicate()
+		}
+		return
+	}
+
+	pc := &packetContext{
+		pipeline:  p,
+		filters:   filters,
+		timestamp: pkt.Info.Timestamp,
+		length:    pkt.Info.Length,
+	}
+	if len(pkt.Data) < pkt.Info.Length {
+		pc.caplen = len(pkt.Data)
+	}
+
+	processFrame(pc, pkt)
+
+	if pc.matched && (p.pcap != nil || p.snapshots != nil) {
+		p.writePcap(pkt)
+	}
+}
+
+// writePcap writes a matching packet to the pcap file and the snapshot
+// ring, with the VLAN tag the kernel stripped put back. Raw-mode packets
+// from L3 interfaces get a zeroed Ethernet header so that every packet
+// fits the file's Ethernet link type.
+func (p *pipeline) writePcap(pkt capture.Packet) {
+	data, origLen := pkt.WireFrame()
+	if !pkt.Cooked && !pkt.Info.HasEthernetHeader() {
+		hdr := make([]byte, parser.EthernetHeaderSize, parser.EthernetHeaderSize+len(data))
+		binary.BigEndian.PutUint16(hdr[12:14], pkt.Info.Protocol)
+		data = append(hdr, data...)
+		origLen += parser.EthernetHeaderSize
+	}
+
+	if p.snapshots != nil {
+		p.snapshots.Add(pkt.Info.Timestamp, data, origLen)
+	}
+	if p.pcap == nil {
+		return
+	}
+	if err := p.pcap.WritePacket(pkt.Info.Timestamp, data, origLen); err != nil {
+		log.Printf("write pcap: %v", err)
+	}
+}
+
+// parseError logs a packet that failed to decode and counts it.
+func (p *pipeline) parseError(format string, args ...any) {
+	log.Printf(format, args...)
+	if p.stats != nil {
+		p.stats.RecordParseError()
+	}
+}
+
+// filtered counts a packet rejected by a filter.
+func (p *pipeline) filtered() {
+	if p.stats != nil {
+		p.stats.RecordFiltered()
+	}
+}
+
+// packetContext carries per-packet state down the decoding pipeline.
+// Tunnel decoders append to it before handing the inner frame back to
+// processEthernet or processIPv4.
+type packetContext struct {
+	*pipeline
+
+	filters   *packetFilters     // The filters in effect when the packet arrived
+	timestamp time.Time          // Capture timestamp (kernel or NIC time)
+	length    int                // Original frame length on the wire
+	caplen    int                // Captured length if truncated by the snaplen, else 0
+	matched   bool               // A handler accepted the packet (passed all filters)
+	iface     string             // Name of the interface the packet was captured on
+	vlans     []uint16           // VLAN IDs from every Ethernet layer, outermost first
+	encap     []output.EncapInfo // Tunnel layers peeled so far, outermost first
+	dir       string             // Direction of the outermost IP header
+}
+
+// admit checks an accepted packet's record against the capture limits.
+// It returns false if the packet is past a limit and must not be reported.
+func (pc *packetContext) admit(rec filter.Env) bool {
+	if pc.limits == nil {
+		return true
+	}
+	return pc.limits.admit(pc.length, rec)
+}
+
+// accept marks the packet as matching every filter and accounts it in the
+// stats and metrics. port is the service port (see servicePort), 0 for ARP.
+func (pc *packetContext) accept(protocol, dir, srcIP, dstIP string, port uint16, proc *procfs.ProcessInfo) {
+	pc.matched = true
+	if pc.stats != nil {
+		t := stats.Traffic{
+			Interface: pc.iface,
+			Protocol:  protocol,
+			Direction: dir,
+			SrcIP:     srcIP,
+			DstIP:     dstIP,
+			Port:      port,
+			Length:    pc.length,
+		}
+		if proc != nil {
+			t.Process = proc.Name
+		}
+		pc.stats.RecordTraffic(t)
+	}
+	if pc.metrics != nil {
+		pc.metrics.packet(pc.iface, protocol, dir, port, proc, pc.length)
+	}
+	if historyDB != nil && proc != nil {
+		historyDB.Process(proc.PID, proc.Name, pc.timestamp)
+	}
+}
+
+// pushEncap records a peeled tunnel layer. The outermost layer also fixes
+// the direction used for inner packets whose addresses aren't local.
+func (pc *packetContext) pushEncap(layer output.EncapInfo) {
+	if len(pc.encap) == 0 {
+		pc.dir = getDirection(layer.SrcIP, layer.DstIP, pc.localIPs)
+	}
+	pc.encap = append(pc.encap, layer)
+}
+
+// processFrame dispatches a captured packet according to its link layer:
+// SLL2 for cooked sockets, Ethernet for Ethernet-like interfaces, and the
+// bare network layer for L3 interfaces such as tun or wireguard.
+func processFrame(pc *packetContext, pkt capture.Packet) {
+	pc.iface = capture.InterfaceName(pkt.Info.Ifindex)
+	// The kernel strips the outermost tag; inner ones stay in the frame
+	if vid, ok := pkt.Info.VLANID(); ok {
+		pc.vlans = append(pc.vlans, vid)
+	}
+
+	switch {
+	case pkt.Cooked:
+		sll, err := parser.ParseSLL2(pkt.Data)
+		if err != nil {
+			pc.parseError("parse SLL2 error: %v", err)
+			return
+		}
+		processNetwork(pc, sll.Protocol, sll.Payload)
+	case pkt.Info.HasEthernetHeader():
+		processEthernet(pc, pkt.Data)
+	default:
+		processNetwork(pc, pkt.Info.Protocol, pkt.Data)
+	}
+}
+
+// processEthernet decodes an Ethernet frame and dispatches its payload.
+func processEthernet(pc *packetContext, data []byte) {
+	frame, err := parser.ParseEthernet(data)
+	if err != nil {
+		pc.parseError("parse error: %v", err)
+		return
+	}
+
+	pc.vlans = append(pc.vlans, frame.VLANIDs()...)
+	processNetwork(pc, frame.EtherType, frame.Payload)
+}
+
+// processNetwork dispatches a network-layer packet by its EtherType.
+func processNetwork(pc *packetContext, etherType uint16, data []byte) {
+	switch etherType {
+	case parser.EtherTypeARP:
+		if pc.filters.matchesVLAN(pc.vlans) {
+			handleARPPacket(pc, data)
+		} else {
+			pc.filtered()
+		}
+	case parser.EtherTypeIPv4:
+		processIPv4(pc, data)
+	default:
+		// IPv6 and other EtherTypes aren't decoded yet
+		pc.filtered()
+	}
+}
+
+// processIPv4 decodes an IPv4 packet, peels any tunnel encapsulation and
+// hands the innermost TCP/UDP packet to its handler.
+func processIPv4(pc *packetContext, data []byte) {
+	ipv4, err := parser.ParseIPv4(data)
+	if err != nil {
+		pc.parseError("parse ipv4 error: %v", err)
+		return
+	}
+
+	if len(pc.encap) < maxEncapDepth && decapsulate(pc, ipv4) {
+		return
+	}
+
+	// VLAN filter
+	if !pc.filters.matchesVLAN(pc.vlans) {
+		pc.filtered()
+		return
+	}
+
+	// IP filter (inner addresses for tunneled traffic)
+	if ip := pc.filters.IP; ip != "" && ipv4.SrcIP.String() != ip && ipv4.DstIP.String() != ip {
+		pc.filtered()
+		return
+	}
+
+	// Direction filter. Overlay addresses are rarely local, so tunneled
+	// packets fall back to the direction of the outer header.
+	dir := getDirection(ipv4.SrcIP.String(), ipv4.DstIP.String(), pc.localIPs)
+	if dir == "unknown" && pc.dir != "" {
+		dir = pc.dir
+	}
+	if pc.filters.Direction != "all" && dir != pc.filters.Direction {
+		pc.filtered()
+		return
+	}
+
+	// Protocol handling
+	switch {
+	case ipv4.Protocol == parser.ProtocolTCP && pc.filters.wantProtocol("tcp"):
+		handleTCPPacket(pc, ipv4, dir)
+	case ipv4.Protocol == parser.ProtocolUDP && pc.filters.wantProtocol("udp"):
+		handleUDPPacket(pc, ipv4, dir)
+	default:
+		pc.filtered()
+	}
+}
+
+// decapsulate checks ipv4 for a supported tunnel (VXLAN, Geneve, GRE,
+// IP-in-IP) and, if found, runs the inner packet through the pipeline.
+// Returns false if ipv4 is not a tunnel or the tunnel header is malformed,
+// in which case the caller handles it as a regular packet.
+func decapsulate(pc *packetContext, ipv4 *parser.IPv4Packet) bool {
+	layer := output.EncapInfo{
+		SrcIP: ipv4.SrcIP.String(),
+		DstIP: ipv4.DstIP.String(),
+	}
+
+	switch ipv4.Protocol {
+	case parser.ProtocolIPIP:
+		layer.Type = "ipip"
+		pc.pushEncap(layer)
+		processIPv4(pc, ipv4.Payload)
+		return true
+
+	case parser.ProtocolGRE:
+		gre, err := parser.ParseGRE(ipv4.Payload)
+		if err != nil {
+			logDebug("parse GRE error: %v", err)
+			return false
+		}
+		layer.Type = "gre"
+		if gre.HasKey {
+			layer.Key = &gre.Key
+		}
+		return decapsulatePayload(pc, layer, gre.ProtocolType, gre.Payload)
+
+	case parser.ProtocolUDP:
+		udp, err := parser.ParseUDP(ipv4.Payload)
+		if err != nil {
+			return false
+		}
+		layer.SrcPort = udp.SrcPort
+		layer.DstPort = udp.DstPort
+
+		switch udp.DstPort {
+		case parser.VXLANPort:
+			vxlan, err := parser.ParseVXLAN(udp.Payload)
+			if err != nil {
+				logDebug("parse VXLAN error: %v", err)
+				return false
+			}
+			layer.Type = "vxlan"
+			layer.VNI = &vxlan.VNI
+			return decapsulatePayload(pc, layer, parser.EtherTypeTEB, vxlan.Payload)
+
+		case parser.GenevePort:
+			geneve, err := parser.ParseGeneve(udp.Payload)
+			if err != nil {
+				logDebug("parse Geneve error: %v", err)
+				return false
+			}
+			layer.Type = "geneve"
+			layer.VNI = &geneve.VNI
+			return decapsulatePayload(pc, layer, geneve.ProtocolType, geneve.Payload)
+		}
+	}
+
+	return false
+}
+
+// decapsulatePayload records layer and processes the inner payload according
+// to its EtherType. Returns false for inner protocols the pipeline can't parse.
+func decapsulatePayload(pc *packetContext, layer output.EncapInfo, etherType uint16, payload []byte) bool {
+	switch etherType {
+	case parser.EtherTypeTEB:
+		pc.pushEncap(layer)
+		processEthernet(pc, payload)
+		return true
+	case parser.EtherTypeIPv4, parser.EtherTypeARP:
+		pc.pushEncap(layer)
+		processNetwork(pc, etherType, payload)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	yamlconfig "github.com/hwang-fu/portlens/internal/config"
+	"github.com/hwang-fu/portlens/internal/history"
+)
+
+// runQuery implements "portlens query <name|sql>": it runs a canned query
+// or an ad-hoc SQL statement against a --sqlite history database.
+func runQuery(args []string) int {
+	fs := flag.NewFlagSet("query", flag.ExitOnError)
+	db := fs.String("db", defaultHistoryPath(), "history database written by --sqlite")
+	since := fs.String("since", "", "only activity at or after this time, e.g. 2h, yesterday 14:00, 2025-12-24 14:00")
+	until := fs.String("until", "", "only activity before this time")
+	ip := fs.String("ip", "", "only this IP, as either endpoint or a DNS answer")
+	port := fs.Int("port", 0, "only this port, as either endpoint")
+	process := fs.String("process", "", "only this process name")
+	name := fs.String("name", "", "only this DNS name and its subdomains")
+	limit := fs.Int("limit", 100, "show at most this many rows (0 = all)")
+	format := fs.String("format", "table", "output format: table, json, or csv")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: portlens query [flags] <query | sql \"SELECT ...\">")
+		fmt.Fprintln(os.Stderr, "queries:")
+		for _, q := range history.CannedQueries {
+			fmt.Fprintf(os.Stderr, "  %-12s %s\n", q.Name, q.Description)
+		}
+		fmt.Fprintln(os.Stderr, "  sql          an SQL statement; it may use :since, :until, :ip, :port, :process and :name")
+		fmt.Fprintln(os.Stderr, "flags:")
+		fs.PrintDefaults()
+	}
+	// Flags may come before or after the query
+	fs.Parse(args)
+	var pos []string
+	for fs.NArg() > 0 {
+		pos = append(pos, fs.Arg(0))
+		fs.Parse(fs.Args()[1:])
+	}
+
+	if len(pos) == 0 || pos[0] == "sql" && len(pos) != 2 || pos[0] != "sql" && len(pos) != 1 {
+		fs.Usage()
+		return 1
+	}
+	if *format != "table" && *format != "json" && *format != "csv" {
+		fmt.Fprintf(os.Stderr, "error: --format must be table, json, or csv, not %q\n", *format)
+		return 1
+	}
+
+	p := history.Params{IP: *ip, Port: *port, Process: *process, Name: *name, Limit: *limit}
+	now := time.Now()
+	var err error
+	if *since != "" {
+		if p.Since, err = history.ParseWhen(*since, now); err != nil {
+			fmt.Fprintf(os.Stderr, "error: --since: %v\n", err)
+			return 1
+		}
+	}
+	if *until != "" {
+		if p.Until, err = history.ParseWhen(*until, now); err != nil {
+			fmt.Fprintf(os.Stderr, "error: --until: %v\n", err)
+			return 1
+		}
+	}
+
+	r, err := history.OpenReader(*db)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	defer r.Close()
+
+	var res *history.Result
+	if pos[0] == "sql" {
+		res, err = r.SQL(pos[1], p)
+	} else {
+		res, err = r.Canned(strings.ToLower(pos[0]), p)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+
+	switch *format {
+	case "json":
+		err = res.WriteJSON(os.Stdout)
+	case "csv":
+		err = res.WriteCSV(os.Stdout)
+	default:
+		err = res.WriteTable(os.Stdout)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// defaultHistoryPath is the config file's sqlite database, or
+// portlens.db.
+func defaultHistoryPath() string {
+	if fileCfg, err := yamlconfig.Load(yamlconfig.DefaultPath()); err == nil && fileCfg.SQLite != "" {
+		return fileCfg.SQLite
+	}
+	return "portlens.db"
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	yamlconfig "github.com/hwang-fu/portlens/internal/config"
+)
+
+// liveSettings are the flags a config reload applies while capturing. Any
+// other change needs a restart.
+var liveSettings = map[string]bool{
+	"protocol":       true,
+	"port":           true,
+	"ip":             true,
+	"vlan":           true,
+	"direction":      true,
+	"process":        true,
+	"pid":            true,
+	"verbosity":      true,
+	"format":         true,
+	"color":          true,
+	"stats-interval": true,
+}
+
+// reloader reloads the config file into the running capture. A reload is
+// all or nothing: a config that doesn't validate, or changes a setting
+// that needs a restart, leaves the running one in place.
+type reloader struct {
+	mu            sync.Mutex
+	args          []string // Command line flags, which still override the file
+	current       config
+	writer        *recordWriter      // nil unless records are encoded (not with Zeek logs or top)
+	colorTerminal bool               // Whether --color auto colors the output
+	statsInterval chan time.Duration // nil unless statistics are collected
+}
+
+// reload loads and applies the config, and returns the flags that changed.
+func (r *reloader) reload() ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, _, err := loadConfig(r.args, flag.ContinueOnError)
+	if err != nil {
+		return nil, err
+	}
+	warnings, err := c.validate()
+	if err != nil {
+		return nil, err
+	}
+	filters := configFilters(c)
+
+	changed := changedSettings(&r.current, c)
+	var restart []string
+	for _, name := range changed {
+		if !liveSettings[name] || (name == "format" || name == "color") && !r.formatLive(c) {
+			restart = append(restart, "--"+name)
+		}
+	}
+	if len(restart) > 0 {
+		return nil, fmt.Errorf("%s changed, which needs a restart", strings.Join(restart, ", "))
+	}
+
+	// The config's filters are those of the command line's capture job
+	if job := captureJobs.get(1); job != nil && *filters != *configFilters(&r.current) {
+		filtersMu.Lock()
+		job.filters.Store(filters)
+		filtersMu.Unlock()
+	}
+	activeVerbosity.Store(int32(c.verbosity))
+	if c.format != r.current.format || c.color != r.current.color {
+		color := c.color == "always" || c.color == "auto" && r.colorTerminal
+		r.writer.setEncoder(newEncoder(c.format, color))
+	}
+	if c.statsInterval != r.current.statsInterval && r.statsInterval != nil {
+		// Only the latest interval matters
+		select {
+		case <-r.statsInterval:
+		default:
+		}
+		r.statsInterval <- c.statsInterval
+	}
+	r.current = *c
+	for _, w := range warnings {
+		log.Printf("config: warning: %s", w)
+	}
+	return changed, nil
+}
+
+// formatLive reports whether the output can switch to c's format and color
+// while capturing. Zeek logs are written to files of their own.
+func (r *reloader) formatLive(c *config) bool {
+	return r.writer != nil && !isZeekFormat(c.format)
+}
+
+// reloadAndLog reloads the config and logs the outcome; why says what
+// asked for the reload.
+func (r *reloader) reloadAndLog(why string) ([]string, error) {
+	changed, err := r.reload()
+	switch {
+	case err != nil:
+		log.Printf("config reload (%s) rejected, keeping the running config: %v", why, err)
+	case len(changed) == 0:
+		log.Printf("config reloaded (%s): no changes", why)
+	default:
+		log.Printf("config reloaded (%s): %s changed", why, strings.Join(changed, ", "))
+	}
+	return changed, err
+}
+
+// changedSettings returns the flags whose values differ between a and b.
+func changedSettings(a, b *config) []string {
+	va, vb := reflect.ValueOf(a).Elem(), reflect.ValueOf(b).Elem()
+	var changed []string
+	for i := range va.NumField() {
+		name := va.Type().Field(i).Tag.Get("flag")
+		if name != "" && !va.Field(i).Equal(vb.Field(i)) {
+			changed = append(changed, name)
+		}
+	}
+	return changed
+}
+
+// watchConfig reloads the config on SIGHUP and whenever the config file
+// changes, until ctx is cancelled.
+func watchConfig(ctx context.Context, r *reloader) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hup)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				r.reloadAndLog("SIGHUP")
+			}
+		}
+	}()
+
+	// The config file can't change without a restart
+	path := r.current.configFile
+	err := yamlconfig.Watch(ctx, path, func() {
+		r.reloadAndLog(path + " changed")
+	})
+	if err != nil {
+		logDebug("%v; reload it with SIGHUP", err)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/hwang-fu/portlens/internal/output"
+)
+
+// runSchema implements "portlens schema [type]": it prints the JSON Schema
+// of one record type, or of all of them keyed by type.
+func runSchema(args []string) int {
+	fs := flag.NewFlagSet("schema", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: portlens schema [type]")
+		fmt.Fprintf(os.Stderr, "record types: %s\n", strings.Join(output.SchemaTypes, ", "))
+	}
+	fs.Parse(args)
+
+	switch fs.NArg() {
+	case 0:
+		all := make(map[string]json.RawMessage, len(output.SchemaTypes))
+		for _, recordType := range output.SchemaTypes {
+			data, _ := output.Schema(recordType)
+			all[recordType] = data
+		}
+		data, err := json.MarshalIndent(all, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return 1
+		}
+		fmt.Println(string(data))
+	case 1:
+		data, err := output.Schema(fs.Arg(0))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return 1
+		}
+		os.Stdout.Write(data)
+	default:
+		fs.Usage()
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/hwang-fu/portlens/internal/capture"
+)
+
+const (
+	// reorderWindow is how long packets from several interfaces are held
+	// back so they can be emitted in timestamp order.
+	reorderWindow = 10 * time.Millisecond
+
+	// dedupWindow is how far apart two copies of a packet on different
+	// interfaces (bridge and veth) may be and still count as one.
+	dedupWindow = 50 * time.Millisecond
+
+	// readTimeout bounds how long a capture goroutine blocks in a read, and
+	// so how long it takes to notice that the capture was stopped.
+	readTimeout = 100 * time.Millisecond
+
+	// snapBufferSize fits the largest packet plus a synthesized SLL2 header.
+	snapBufferSize = 65535 + capture.SLL2HeaderSize
+)
+
+// openSockets opens and binds the capture sockets: one per interface, or
+// with several workers, one per worker per interface joined into a
+// PACKET_FANOUT group. On error, sockets opened so far are closed.
+func openSockets(interfaces []string) ([]*capture.Socket, error) {
+	mode, err := capture.ParseFanoutMode(cfg.fanout)
+	if err != nil {
+		return nil, err
+	}
+
+	var sockets []*capture.Socket
+	closeAll := func() {
+		for _, s := range sockets {
+			s.Close()
+		}
+	}
+
+	for _, name := range interfaces {
+		// Fanout group IDs are system-wide, so derive them from our PID
+		groupID := uint16(os.Getpid() + int(fanoutGroups.Add(1)) - 1)
+
+		for range cfg.workers {
+			sock, err := openSocket(name)
+			if err == nil && cfg.workers > 1 {
+				if err = sock.JoinFanout(groupID, mode); err != nil {
+					sock.Close()
+				}
+			}
+			if err != nil {
+				closeAll()
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			sockets = append(sockets, sock)
+		}
+	}
+
+	return sockets, nil
+}
+
+// openSocket opens a capture socket in the configured mode and binds it.
+func openSocket(name string) (*capture.Socket, error) {
+	var sock *capture.Socket
+	var err error
+	if cfg.cooked {
+		sock, err = capture.NewCookedSocket()
+	} else {
+		sock, err = capture.NewSocket()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := sock.Bind(name); err != nil {
+		sock.Close()
+		return nil, err
+	}
+
+	if err := sock.SetSnaplen(cfg.snaplen); err != nil {
+		sock.Close()
+		return nil, err
+	}
+
+	if cfg.promisc {
+		if err := sock.SetPromiscuous(); err != nil {
+			sock.Close()
+			return nil, err
+		}
+	}
+
+	if err := sock.SetReadTimeout(readTimeout); err != nil {
+		sock.Close()
+		return nil, err
+	}
+
+	// Without kernel timestamps, packets are stamped when read
+	if err := sock.EnableTimestamps(cfg.hwTimestamps); err != nil {
+		log.Printf("warning: %s: %v", name, err)
+	}
+	return sock, nil
+}
+
+// capturePaused is set while the capture is paused (portlens ctl pause).
+// Packets are still read, so the kernel doesn't count them as drops, but
+// they are discarded.
+var capturePaused atomic.Bool
+
+// fanoutGroups counts the fanout groups joined, so every interface of every
+// capture job gets its own.
+var fanoutGroups atomic.Uint32
+
+// readPackets reads packets from sock and sends a private copy of each to
+// one of the worker queues. Packets are assigned by a symmetric flow hash,
+// so every packet of a connection is handled by the same worker.
+// Packets are tagged with the capture job they belong to. Each loop is
+// counted in loops: a goroutine loops at least every readTimeout unless it
+// is stuck, which the systemd watchdog checks for. It returns once ctx is
+// cancelled.
+func readPackets(ctx context.Context, sock *capture.Socket, workers []chan capture.Packet, job int, loops *atomic.Uint64) {
+	buf := make([]byte, snapBufferSize)
+	for ctx.Err() == nil {
+		loops.Add(1)
+		n, info, err := sock.ReadPacket(buf)
+		if errors.Is(err, capture.ErrTimeout) {
+			continue
+		}
+		if err != nil {
+			log.Printf("read error: %v", err)
+			continue
+		}
+		if capturePaused.Load() {
+			continue
+		}
+
+		data := make([]byte, n)
+		copy(data, buf[:n])
+		pkt := capture.Packet{Data: data, Info: info, Cooked: sock.Cooked(), Source: job}
+
+		worker := 0
+		if len(workers) > 1 {
+			worker = int(capture.FlowHash(pkt) % uint32(len(workers)))
+		}
+		workers[worker] <- pkt
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/hwang-fu/portlens/internal/stream"
+)
+
+// streamHub serves records to --stream-socket and --stream-listen
+// subscribers, or is nil.
+var streamHub *stream.Hub
+
+// setupStream creates streamHub and starts listening. It returns the HTTP
+// server, if any, for the caller to close once the hub is closed.
+func setupStream() *http.Server {
+	if cfg.streamSocket == "" && cfg.streamListen == "" {
+		return nil
+	}
+	streamHub = stream.NewHub()
+	if cfg.streamSocket != "" {
+		if err := streamHub.ServeUnix(cfg.streamSocket); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+	if cfg.streamListen == "" {
+		return nil
+	}
+
+	ln, err := net.Listen("tcp", cfg.streamListen)
+	if err != nil {
+		log.Fatalf("stream listen: %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/stream", streamHub.Handler())
+	srv := &http.Server{Handler: mux}
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("stream server: %v", err)
+		}
+	}()
+	return srv
+}
+
+// streamSink publishes records to streamHub.
+type streamSink struct{}
+
+func (streamSink) Encode(v any) error {
+	streamHub.Publish(v)
+	return nil
+}
+
+// Close flushes and disconnects the subscribers.
+func (streamSink) Close() {
+	streamHub.Close()
+	if n := streamHub.Dropped(); n > 0 {
+		log.Printf("stream: %d records dropped for slow subscribers", n)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/hwang-fu/portlens/internal/sdnotify"
+)
+
+// notifyFailing is set once a notification to systemd failed, so a broken
+// NOTIFY_SOCKET is logged only once.
+var notifyFailing atomic.Bool
+
+// notifySystemd sends states to systemd when it runs portlens as a
+// Type=notify service, and does nothing otherwise.
+func notifySystemd(states ...string) {
+	if _, err := sdnotify.Notify(states...); err != nil && !notifyFailing.Swap(true) {
+		log.Printf("%v", err)
+	}
+}
+
+// startWatchdog feeds the systemd watchdog, if it is enabled, until ctx is
+// cancelled. It goes quiet while a capture goroutine is stuck, so systemd
+// restarts the service.
+func startWatchdog(ctx context.Context) {
+	interval, err := sdnotify.WatchdogInterval()
+	if err != nil {
+		log.Printf("%v", err)
+		return
+	}
+	if interval == 0 {
+		return
+	}
+	// Capture goroutines loop every readTimeout, so they are seen moving
+	// between two checks
+	period := max(interval/2, 2*readTimeout)
+
+	go func() {
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+		last := make(map[*atomic.Uint64]uint64)
+		stuck := false
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			// Goroutines of capture jobs started since the last check
+			// count as moving
+			moving := true
+			seen := make(map[*atomic.Uint64]uint64)
+			for _, loops := range captureJobs.readerLoops() {
+				n := loops.Load()
+				if prev, ok := last[loops]; ok && n == prev {
+					moving = false
+				}
+				seen[loops] = n
+			}
+			last = seen
+			if !moving {
+				if !stuck {
+					log.Printf("watchdog: a capture goroutine is stuck, no longer notifying systemd")
+				}
+				stuck = true
+				continue
+			}
+			stuck = false
+			notifySystemd(sdnotify.Watchdog)
+		}
+	}()
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+
+	"github.com/hwang-fu/portlens/internal/top"
+)
+
+// topMode is set by the "top" subcommand: records feed the live view
+// instead of being written out.
+var topMode bool
+
+// setupTopMode checks that the live view has a terminal to run in.
+func setupTopMode() {
+	if !top.IsTerminal(os.Stdin) || !top.IsTerminal(os.Stdout) {
+		log.Fatalf("%v", top.ErrNotTerminal)
+	}
+}
+
+// startTop shows the live view until ctx is done or the user quits, which
+// stops the capture like an interrupt. The returned channel is closed once
+// the terminal is restored.
+func startTop(ctx context.Context, stop context.CancelCauseFunc, model *top.Model, opts top.Options) <-chan struct{} {
+	done := make(chan struct{})
+	ui := top.NewUI(model, opts)
+	go func() {
+		defer close(done)
+		if err := ui.Run(ctx, os.Stdin, os.Stdout, func() { stop(stopSignal) }); err != nil {
+			log.Printf("top: %v", err)
+			stop(stopSignal)
+		}
+	}()
+	return done
+}
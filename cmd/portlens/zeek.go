@@ -0,0 +1,69 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/hwang-fu/portlens/internal/rotate"
+	"github.com/hwang-fu/portlens/internal/zeek"
+)
+
+// zeekOut writes conn.log and dns.log for --format zeek and zeek-json, or
+// is nil.
+var zeekOut *zeek.Writer
+
+// isZeekFormat reports whether format writes Zeek logs instead of records.
+func isZeekFormat(format string) bool {
+	return format == "zeek" || format == "zeek-json"
+}
+
+// setupZeek opens conn.log and dns.log in the --output directory and
+// creates zeekOut. It returns the files, for the caller to close once
+// zeekOut is closed.
+func setupZeek(localIPs map[string]bool) []*rotate.Writer {
+	dir := cfg.outputFile
+	if dir == "" {
+		dir = "."
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		log.Fatalf("create zeek log directory: %v", err)
+	}
+
+	// The TSV header is repeated at the top of every rotated file
+	var files []*rotate.Writer
+	for _, name := range []string{"conn.log", "dns.log"} {
+		f, err := openOutput(filepath.Join(dir, name), cfg.format == "zeek")
+		if err != nil {
+			log.Fatalf("create zeek log: %v", err)
+		}
+		files = append(files, f)
+	}
+
+	isLocal := func(ip string) bool { return localIPs[ip] }
+	w, err := zeek.NewWriter(files[0], files[1], cfg.format == "zeek-json", isLocal)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	zeekOut = w
+	return files
+}
+
+// closeZeek logs the DNS queries still unanswered, ends both logs and
+// closes their files.
+func closeZeek(files []*rotate.Writer) {
+	if err := zeekOut.Close(); err != nil {
+		log.Printf("close zeek logs: %v", err)
+	}
+	for _, f := range files {
+		if err := f.Close(); err != nil {
+			log.Printf("close zeek log: %v", err)
+		}
+	}
+}
+
+// discardSink drops records; Zeek logs are written on their own.
+type discardSink struct{}
+
+func (discardSink) Encode(any) error { return nil }
+func (discardSink) Close()           {}
@@ -1,62 +1,267 @@
 package capture
 
 import (
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"net"
+	"sync"
 	"syscall"
+	"time"
 )
 
+// AnyInterface is the pseudo-interface name that captures on all interfaces.
+const AnyInterface = "any"
+
+// SLL2HeaderSize is the size of the synthesized Linux cooked-mode (SLL2)
+// header that cooked sockets prepend to every packet.
+const SLL2HeaderSize = 20
+
+// ARPHRD_* link types (from <linux/if_arp.h>) that carry an Ethernet header.
+const (
+	ARPHRDEther    = 1
+	ARPHRDLoopback = 772
+)
+
+// ErrTimeout is returned by ReadPacket when the read timeout set with
+// SetReadTimeout expires before a packet arrives.
+var ErrTimeout = errors.New("read timeout")
+
 // Socket represents a raw packet capture socket.
 type Socket struct {
-	fd int
+	fd      int
+	cooked  bool
+	ifindex int  // Bound interface (0 = all)
+	promisc bool // Promiscuous membership added
+	snaplen int  // Maximum captured bytes per packet (0 = unlimited)
+
+	statsMu sync.Mutex
+	stats   SocketStats // Kernel counters accumulated by Stats
+}
+
+// PacketInfo describes a captured packet as reported by the kernel.
+type PacketInfo struct {
+	Ifindex   int       // Index of the interface the packet was seen on
+	Protocol  uint16    // EtherType of the network-layer payload
+	Hatype    uint16    // ARPHRD_* link type of the interface
+	PktType   uint8     // PACKET_HOST, PACKET_OUTGOING, ...
+	Length    int       // Original length on the wire, before snaplen truncation
+	Timestamp time.Time // When the packet arrived (kernel or NIC time if enabled)
+	HWStamp   bool      // Timestamp comes from the NIC
+
+	// The outermost 802.1Q/802.1ad tag, which the kernel strips from the
+	// frame and reports beside it. VLANTPID is 0 if the frame had none.
+	VLANTCI  uint16
+	VLANTPID uint16
+}
+
+// Packet is a captured packet handed from a capture goroutine to the
+// processing pipeline. Data is owned by the receiver.
+type Packet struct {
+	Data   []byte
+	Info   PacketInfo
+	Cooked bool // Data starts with an SLL2 header
+	Source int  // Set by the reader to tell several captures apart
+}
+
+// HasEthernetHeader reports whether a raw-mode packet starts with an
+// Ethernet header. Packets from L3 interfaces (tun, wireguard, ...) start
+// directly at the network layer.
+func (pi PacketInfo) HasEthernetHeader() bool {
+	return pi.Hatype == ARPHRDEther || pi.Hatype == ARPHRDLoopback
+}
+
+// VLANID returns the VLAN ID of the tag the kernel stripped, and false if
+// there was none.
+func (pi PacketInfo) VLANID() (uint16, bool) {
+	return pi.VLANTCI & 0x0fff, pi.VLANTPID != 0
+}
+
+// WireFrame returns the packet's data with the VLAN tag the kernel
+// stripped put back where it was on the wire, and the original length to
+// match. For cooked packets, the tag follows the SLL2 header, whose
+// protocol becomes the tag's TPID. Other packets are returned as they are.
+func (pkt Packet) WireFrame() ([]byte, int) {
+	data, origLen := pkt.Data, pkt.Info.Length
+	if pkt.Info.VLANTPID == 0 {
+		return data, origLen
+	}
+
+	var offset int
+	switch {
+	case pkt.Cooked:
+		offset = SLL2HeaderSize
+	case pkt.Info.HasEthernetHeader():
+		offset = 12 // After the MAC addresses
+	default:
+		return data, origLen
+	}
+	if len(data) < offset {
+		return data, origLen
+	}
+
+	frame := make([]byte, 0, len(data)+4)
+	if pkt.Cooked {
+		// The SLL2 protocol moves behind the tag
+		frame = binary.BigEndian.AppendUint16(frame, pkt.Info.VLANTPID)
+		frame = append(frame, data[2:offset]...)
+		frame = binary.BigEndian.AppendUint16(frame, pkt.Info.VLANTCI)
+		frame = append(frame, data[0:2]...)
+	} else {
+		frame = append(frame, data[:offset]...)
+		frame = binary.BigEndian.AppendUint16(frame, pkt.Info.VLANTPID)
+		frame = binary.BigEndian.AppendUint16(frame, pkt.Info.VLANTCI)
+	}
+	frame = append(frame, data[offset:]...)
+	return frame, origLen + 4
 }
 
 // NewSocket creates a new AF_PACKET socket for capturing raw packets.
 func NewSocket() (*Socket, error) {
+	return newSocket(syscall.SOCK_RAW)
+}
+
+// NewCookedSocket creates a new AF_PACKET socket in cooked (SOCK_DGRAM)
+// mode. The kernel strips the link-layer header and ReadPacket replaces it
+// with an SLL2 header, so packets from any interface type look the same.
+func NewCookedSocket() (*Socket, error) {
+	return newSocket(syscall.SOCK_DGRAM)
+}
+
+func newSocket(sockType int) (*Socket, error) {
 	fd, err := syscall.Socket(
 		syscall.AF_PACKET,
-		syscall.SOCK_RAW,
+		sockType,
 		int(htons(syscall.ETH_P_ALL)),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("create socket: %w", err)
 	}
 
-	return &Socket{fd: fd}, nil
+	s := &Socket{fd: fd, cooked: sockType == syscall.SOCK_DGRAM}
+	// The kernel strips VLAN tags from received frames and only reports
+	// them in auxdata
+	if err := s.enableAuxdata(); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("enable auxdata: %w", err)
+	}
+	return s, nil
+}
+
+// Cooked reports whether the socket delivers SLL2-framed packets.
+func (s *Socket) Cooked() bool {
+	return s.cooked
 }
 
-// Close closes the socket.
+// Interface returns the name of the interface the socket is bound to, or
+// "any".
+func (s *Socket) Interface() string {
+	if s.ifindex == 0 {
+		return AnyInterface
+	}
+	return InterfaceName(s.ifindex)
+}
+
+// Close leaves promiscuous mode, if enabled, and closes the socket.
 func (s *Socket) Close() error {
-	return syscall.Close(s.fd)
+	err := s.dropPromiscuous()
+	if cerr := syscall.Close(s.fd); cerr != nil {
+		return cerr
+	}
+	return err
 }
 
 // Bind binds the socket to a specific network interface.
+// The name "any" binds to all interfaces.
 func (s *Socket) Bind(interfaceName string) error {
-	netInterface, err := net.InterfaceByName(interfaceName)
-	if err != nil {
-		return fmt.Errorf("get interface %s: %w", interfaceName, err)
+	ifindex := 0
+	if interfaceName != AnyInterface {
+		netInterface, err := net.InterfaceByName(interfaceName)
+		if err != nil {
+			return fmt.Errorf("get interface %s: %w", interfaceName, err)
+		}
+		ifindex = netInterface.Index
 	}
 
 	addr := syscall.SockaddrLinklayer{
 		Protocol: htons(syscall.ETH_P_ALL),
-		Ifindex:  netInterface.Index,
+		Ifindex:  ifindex,
 	}
 
 	if err := syscall.Bind(s.fd, &addr); err != nil {
 		return fmt.Errorf("bind to %s: %w", interfaceName, err)
 	}
 
+	s.ifindex = ifindex
 	return nil
 }
 
-// ReadPacket reads a single raw packet from the socket.
-// Returns the packet data and the number of bytes read.
-func (s *Socket) ReadPacket(buf []byte) (int, error) {
-	n, _, err := syscall.Recvfrom(s.fd, buf, 0)
+// ReadPacket reads a single packet from the socket into buf.
+// Returns the number of bytes written to buf and the kernel's packet info.
+// For cooked sockets, buf starts with an SLL2 header. Packets longer than
+// buf or the snaplen are truncated; PacketInfo.Length has the full length.
+func (s *Socket) ReadPacket(buf []byte) (int, PacketInfo, error) {
+	offset := 0
+	if s.cooked {
+		offset = SLL2HeaderSize
+	}
+
+	// MSG_TRUNC makes the kernel return the real packet length even if
+	// only part of it fit into buf
+	var oob [oobBufferSize]byte
+	n, oobn, _, from, err := syscall.Recvmsg(s.fd, buf[offset:], oob[:], syscall.MSG_TRUNC)
+	if err == syscall.EAGAIN || err == syscall.EINTR {
+		return 0, PacketInfo{}, ErrTimeout
+	}
 	if err != nil {
-		return 0, fmt.Errorf("read packet: %w", err)
+		return 0, PacketInfo{}, fmt.Errorf("read packet: %w", err)
+	}
+	captured := min(n, len(buf)-offset)
+
+	var info PacketInfo
+	var addr []byte
+	if sll, ok := from.(*syscall.SockaddrLinklayer); ok {
+		info = PacketInfo{
+			Ifindex:  sll.Ifindex,
+			Protocol: htons(sll.Protocol), // Kernel reports network byte order
+			Hatype:   sll.Hatype,
+			PktType:  sll.Pkttype,
+		}
+		addr = sll.Addr[:min(int(sll.Halen), len(sll.Addr))]
+	}
+
+	// Fall back to the read time if the kernel attached no timestamp
+	ctrl := parseControlMessages(oob[:oobn])
+	info.Timestamp, info.HWStamp = ctrl.timestamp, ctrl.hardware
+	info.VLANTCI, info.VLANTPID = ctrl.vlanTCI, ctrl.vlanTPID
+	if info.Timestamp.IsZero() {
+		info.Timestamp = time.Now()
 	}
-	return n, nil
+
+	if s.cooked {
+		putSLL2Header(buf[:SLL2HeaderSize], info, addr)
+	}
+
+	// A snaplen filter trims the packet in the kernel, so only auxdata
+	// still knows how long it was
+	info.Length = offset + max(n, ctrl.origLen)
+	return offset + captured, info, nil
+}
+
+// putSLL2Header writes a LINKTYPE_LINUX_SLL2 header into b.
+//
+// Layout (all fields big-endian):
+//
+//	protocol (2) | reserved (2) | ifindex (4) | hatype (2) | pkttype (1) | halen (1) | addr (8)
+func putSLL2Header(b []byte, info PacketInfo, addr []byte) {
+	binary.BigEndian.PutUint16(b[0:2], info.Protocol)
+	binary.BigEndian.PutUint16(b[2:4], 0)
+	binary.BigEndian.PutUint32(b[4:8], uint32(info.Ifindex))
+	binary.BigEndian.PutUint16(b[8:10], info.Hatype)
+	b[10] = info.PktType
+	b[11] = uint8(len(addr))
+	clear(b[12:20])
+	copy(b[12:20], addr)
 }
 
 // htons converts a short (uint16) from host to network byte order.
@@ -0,0 +1,47 @@
+package capture
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWireFrame(t *testing.T) {
+	macs := []byte{
+		0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff, // Dest MAC
+		0x11, 0x22, 0x33, 0x44, 0x55, 0x66, // Src MAC
+	}
+	payload := []byte{0x45, 0x00, 0x00, 0x14}
+	frame := append(append(append([]byte{}, macs...), 0x08, 0x00), payload...)
+	tag := PacketInfo{Hatype: ARPHRDEther, Length: 60, VLANTCI: 100, VLANTPID: 0x8100}
+
+	// The tag goes back between the MAC addresses and the EtherType
+	data, origLen := Packet{Data: frame, Info: tag}.WireFrame()
+	want := append(append(append([]byte{}, macs...), 0x81, 0x00, 0x00, 0x64, 0x08, 0x00), payload...)
+	if !bytes.Equal(data, want) || origLen != 64 {
+		t.Errorf("Ethernet: got % x, length %d; want % x, length 64", data, origLen, want)
+	}
+
+	// Untagged frames and L3 packets stay as they are
+	data, origLen = Packet{Data: frame, Info: PacketInfo{Hatype: ARPHRDEther, Length: 60}}.WireFrame()
+	if !bytes.Equal(data, frame) || origLen != 60 {
+		t.Errorf("untagged: got % x, length %d", data, origLen)
+	}
+	l3 := tag
+	l3.Hatype = 65534 // ARPHRD_NONE, e.g. tun
+	data, _ = Packet{Data: payload, Info: l3}.WireFrame()
+	if !bytes.Equal(data, payload) {
+		t.Errorf("L3: got % x", data)
+	}
+
+	// Cooked packets: the SLL2 protocol becomes the TPID and moves behind
+	// the tag
+	sll := make([]byte, SLL2HeaderSize)
+	putSLL2Header(sll, PacketInfo{Protocol: 0x0800, Ifindex: 2, Hatype: ARPHRDEther}, macs[6:])
+	cooked := append(append([]byte{}, sll...), payload...)
+	data, origLen = Packet{Data: cooked, Info: PacketInfo{Length: 44, VLANTCI: 100, VLANTPID: 0x8100}, Cooked: true}.WireFrame()
+	want = append(append([]byte{0x81, 0x00}, sll[2:]...), 0x00, 0x64, 0x08, 0x00)
+	want = append(want, payload...)
+	if !bytes.Equal(data, want) || origLen != 48 {
+		t.Errorf("cooked: got % x, length %d; want % x, length 48", data, origLen, want)
+	}
+}
@@ -0,0 +1,163 @@
+package capture
+
+import (
+	"encoding/binary"
+	"fmt"
+	"syscall"
+
+	"github.com/hwang-fu/portlens/internal/parser"
+)
+
+// PACKET_FANOUT socket option and modes (from <linux/if_packet.h>).
+const (
+	packetFanout = 18
+
+	fanoutFlagDefrag = 0x8000 // Reassemble fragments before hashing
+
+	maxTunnelDepth = 4 // Tunnel layers FlowHash looks through
+)
+
+// FanoutMode selects how the kernel spreads packets across a fanout group.
+type FanoutMode uint16
+
+const (
+	FanoutHash FanoutMode = 0 // By flow hash; both directions of a flow go to one socket
+	FanoutLB   FanoutMode = 1 // Round-robin
+	FanoutCPU  FanoutMode = 2 // By the CPU the packet arrived on
+)
+
+// ParseFanoutMode parses "hash", "lb", or "cpu".
+func ParseFanoutMode(s string) (FanoutMode, error) {
+	switch s {
+	case "hash":
+		return FanoutHash, nil
+	case "lb":
+		return FanoutLB, nil
+	case "cpu":
+		return FanoutCPU, nil
+	default:
+		return 0, fmt.Errorf("invalid fanout mode %q (want hash, lb, or cpu)", s)
+	}
+}
+
+// JoinFanout adds the socket to fanout group id. All sockets in a group
+// must be bound to the same interface and use the same mode; the kernel
+// then delivers each packet to exactly one of them.
+func (s *Socket) JoinFanout(id uint16, mode FanoutMode) error {
+	arg := uint32(id) | uint32(mode)<<16
+	if mode == FanoutHash {
+		arg |= fanoutFlagDefrag << 16
+	}
+
+	if err := syscall.SetsockoptInt(s.fd, syscall.SOL_PACKET, packetFanout, int(arg)); err != nil {
+		return fmt.Errorf("join fanout group %d: %w", id, err)
+	}
+	return nil
+}
+
+// FlowHash returns a symmetric hash of the packet's IPv4 addresses and
+// TCP/UDP ports, so both directions of a connection hash the same.
+// Tunneled packets (IPIP, GRE, VXLAN, Geneve) hash on their innermost IPv4
+// packet, so the tunnel's own ports don't split a connection inside it.
+// Non-IPv4 packets hash on their first network-layer bytes.
+func FlowHash(pkt Packet) uint32 {
+	data := innermostIPv4(networkLayer(pkt))
+	if len(data) < 20 || data[0]>>4 != 4 {
+		var h uint32
+		for _, b := range data[:min(len(data), 16)] {
+			h = h*31 + uint32(b)
+		}
+		return h
+	}
+
+	src := binary.BigEndian.Uint32(data[12:16])
+	dst := binary.BigEndian.Uint32(data[16:20])
+	h := src ^ dst
+
+	proto := data[9]
+	headerLen := int(data[0]&0x0F) * 4
+	fragmented := binary.BigEndian.Uint16(data[6:8])&0x3FFF != 0
+	if (proto == 6 || proto == 17) && !fragmented && len(data) >= headerLen+4 {
+		srcPort := binary.BigEndian.Uint16(data[headerLen : headerLen+2])
+		dstPort := binary.BigEndian.Uint16(data[headerLen+2 : headerLen+4])
+		h ^= uint32(srcPort^dstPort) << 8
+		h ^= uint32(proto)
+	}
+
+	// Final avalanche (murmur3 fmix32) so nearby addresses spread evenly
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
+
+// innermostIPv4 returns the IPv4 packet carried by the tunnels data is
+// encapsulated in, or data itself if it isn't a tunneled IPv4 packet.
+func innermostIPv4(data []byte) []byte {
+	for range maxTunnelDepth {
+		ip, err := parser.ParseIPv4(data)
+		if err != nil || binary.BigEndian.Uint16(data[6:8])&0x3FFF != 0 {
+			// Only the first fragment holds the tunnel header
+			return data
+		}
+		inner := tunnelPayload(ip)
+		if inner == nil {
+			return data
+		}
+		data = inner
+	}
+	return data
+}
+
+// tunnelPayload returns the IPv4 packet ip carries as a tunnel, or nil.
+func tunnelPayload(ip *parser.IPv4Packet) []byte {
+	var etherType uint16
+	var payload []byte
+	switch ip.Protocol {
+	case parser.ProtocolIPIP:
+		return ip.Payload
+	case parser.ProtocolGRE:
+		gre, err := parser.ParseGRE(ip.Payload)
+		if err != nil {
+			return nil
+		}
+		etherType, payload = gre.ProtocolType, gre.Payload
+	case parser.ProtocolUDP:
+		udp, err := parser.ParseUDP(ip.Payload)
+		if err != nil {
+			return nil
+		}
+		switch udp.DstPort {
+		case parser.VXLANPort:
+			vxlan, err := parser.ParseVXLAN(udp.Payload)
+			if err != nil {
+				return nil
+			}
+			etherType, payload = parser.EtherTypeTEB, vxlan.Payload
+		case parser.GenevePort:
+			geneve, err := parser.ParseGeneve(udp.Payload)
+			if err != nil {
+				return nil
+			}
+			etherType, payload = geneve.ProtocolType, geneve.Payload
+		default:
+			return nil
+		}
+	default:
+		return nil
+	}
+
+	if etherType == parser.EtherTypeTEB {
+		frame, err := parser.ParseEthernet(payload)
+		if err != nil {
+			return nil
+		}
+		etherType, payload = frame.EtherType, frame.Payload
+	}
+	if etherType != parser.EtherTypeIPv4 {
+		return nil
+	}
+	return payload
+}
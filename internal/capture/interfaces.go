@@ -0,0 +1,87 @@
+package capture
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	ifnameMu    sync.RWMutex
+	ifnameCache = make(map[int]string)
+)
+
+// InterfaceName returns the name of the interface with the given index.
+// Results are cached; interfaces created after startup are looked up on
+// first use. Returns the index as a string if the interface is gone.
+func InterfaceName(ifindex int) string {
+	ifnameMu.RLock()
+	name, ok := ifnameCache[ifindex]
+	ifnameMu.RUnlock()
+	if ok {
+		return name
+	}
+
+	netInterface, err := net.InterfaceByIndex(ifindex)
+	if err != nil {
+		return strconv.Itoa(ifindex)
+	}
+
+	ifnameMu.Lock()
+	ifnameCache[ifindex] = netInterface.Name
+	ifnameMu.Unlock()
+	return netInterface.Name
+}
+
+// ResolveInterfaces expands a comma-separated list of interface names or
+// glob patterns (e.g. "lo,eth0,veth*") into concrete interface names.
+// "any" is returned as is and can't be combined with other names.
+func ResolveInterfaces(spec string) ([]string, error) {
+	var patterns []string
+	for _, p := range strings.Split(spec, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			patterns = append(patterns, p)
+		}
+	}
+	if len(patterns) == 0 {
+		return nil, fmt.Errorf("no interface given")
+	}
+
+	if slices.Contains(patterns, AnyInterface) {
+		if len(patterns) > 1 {
+			return nil, fmt.Errorf("%q can't be combined with other interfaces", AnyInterface)
+		}
+		return patterns, nil
+	}
+
+	all, err := net.Interfaces()
+	if err != nil {
+		return nil, fmt.Errorf("list interfaces: %w", err)
+	}
+
+	var names []string
+	for _, pattern := range patterns {
+		matched := false
+		for _, iface := range all {
+			ok, err := path.Match(pattern, iface.Name)
+			if err != nil {
+				return nil, fmt.Errorf("invalid interface pattern %q: %w", pattern, err)
+			}
+			if ok {
+				matched = true
+				if !slices.Contains(names, iface.Name) {
+					names = append(names, iface.Name)
+				}
+			}
+		}
+		if !matched {
+			return nil, fmt.Errorf("no interface matches %q", pattern)
+		}
+	}
+
+	return names, nil
+}
@@ -0,0 +1,150 @@
+package capture
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// MergeOrdered reads packets from several capture goroutines and re-emits
+// them in timestamp order. Packets are held back for up to window so that
+// a packet read slightly later on another interface can be placed first.
+// The returned channel is closed after in is closed and drained.
+func MergeOrdered(in <-chan Packet, window time.Duration) <-chan Packet {
+	out := make(chan Packet, cap(in))
+
+	go func() {
+		defer close(out)
+
+		var pending packetHeap
+		ticker := time.NewTicker(window / 2)
+		defer ticker.Stop()
+
+		// release emits every pending packet older than cutoff
+		release := func(cutoff time.Time) {
+			for len(pending) > 0 && !pending[0].Info.Timestamp.After(cutoff) {
+				out <- heap.Pop(&pending).(Packet)
+			}
+		}
+
+		for {
+			select {
+			case pkt, ok := <-in:
+				if !ok {
+					for len(pending) > 0 {
+						out <- heap.Pop(&pending).(Packet)
+					}
+					return
+				}
+				heap.Push(&pending, pkt)
+			case now := <-ticker.C:
+				release(now.Add(-window))
+			}
+		}
+	}()
+
+	return out
+}
+
+// packetHeap is a min-heap of packets ordered by timestamp.
+type packetHeap []Packet
+
+func (h packetHeap) Len() int           { return len(h) }
+func (h packetHeap) Less(i, j int) bool { return h[i].Info.Timestamp.Before(h[j].Info.Timestamp) }
+func (h packetHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *packetHeap) Push(x any)        { *h = append(*h, x.(Packet)) }
+func (h *packetHeap) Pop() any {
+	old := *h
+	n := len(old)
+	pkt := old[n-1]
+	old[n-1] = Packet{}
+	*h = old[:n-1]
+	return pkt
+}
+
+// Deduplicator drops copies of the same packet seen on different interfaces,
+// such as a frame crossing both a bridge and one of its veth ports.
+// It is safe for concurrent use by several capture workers.
+type Deduplicator struct {
+	mu        sync.Mutex
+	window    time.Duration
+	seen      map[uint64]dedupEntry
+	lastPrune time.Time
+}
+
+type dedupEntry struct {
+	ifindex   int
+	timestamp time.Time
+}
+
+// NewDeduplicator creates a deduplicator that treats identical packets on
+// different interfaces within window of each other as one.
+func NewDeduplicator(window time.Duration) *Deduplicator {
+	return &Deduplicator{
+		window: window,
+		seen:   make(map[uint64]dedupEntry),
+	}
+}
+
+// Duplicate reports whether pkt is a copy of a packet already seen on a
+// different interface within the window. Repeats on the same interface
+// (e.g. TCP retransmissions) are never treated as duplicates.
+func (d *Deduplicator) Duplicate(pkt Packet) bool {
+	h := fnv.New64a()
+	h.Write(networkLayer(pkt))
+	sum := h.Sum64()
+
+	ts := pkt.Info.Timestamp
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.prune(ts)
+
+	if prev, ok := d.seen[sum]; ok && prev.ifindex != pkt.Info.Ifindex && ts.Sub(prev.timestamp) < d.window {
+		return true
+	}
+
+	d.seen[sum] = dedupEntry{ifindex: pkt.Info.Ifindex, timestamp: ts}
+	return false
+}
+
+// prune forgets entries older than the window, at most once per window.
+// Caller must hold the lock.
+func (d *Deduplicator) prune(now time.Time) {
+	if now.Sub(d.lastPrune) < d.window {
+		return
+	}
+	for sum, entry := range d.seen {
+		if now.Sub(entry.timestamp) >= d.window {
+			delete(d.seen, sum)
+		}
+	}
+	d.lastPrune = now
+}
+
+// networkLayer returns the bytes after the link-layer header (and any VLAN
+// tags), which stay identical when a packet crosses a bridge.
+func networkLayer(pkt Packet) []byte {
+	data := pkt.Data
+	switch {
+	case pkt.Cooked:
+		if len(data) < SLL2HeaderSize {
+			return data
+		}
+		return data[SLL2HeaderSize:]
+	case pkt.Info.HasEthernetHeader():
+		offset := 12
+		for len(data) >= offset+2 {
+			switch binary.BigEndian.Uint16(data[offset : offset+2]) {
+			case 0x8100, 0x88A8, 0x9100:
+				offset += 4
+				continue
+			}
+			return data[offset+2:]
+		}
+	}
+	return data
+}
@@ -0,0 +1,168 @@
+package capture
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMergeOrdered(t *testing.T) {
+	in := make(chan Packet, 10)
+	base := time.Now()
+
+	// Arrive out of order, as they would from two capture goroutines
+	for _, offset := range []int{3, 1, 2} {
+		in <- Packet{Info: PacketInfo{Ifindex: offset, Timestamp: base.Add(time.Duration(offset) * time.Millisecond)}}
+	}
+	close(in)
+
+	var got []int
+	for pkt := range MergeOrdered(in, 10*time.Millisecond) {
+		got = append(got, pkt.Info.Ifindex)
+	}
+
+	if len(got) != 3 || got[0] != 1 || got[1] != 2 || got[2] != 3 {
+		t.Errorf("order = %v, want [1 2 3]", got)
+	}
+}
+
+func TestDeduplicator(t *testing.T) {
+	d := NewDeduplicator(50 * time.Millisecond)
+	now := time.Now()
+
+	frame := []byte{
+		0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff, // Dest MAC
+		0x11, 0x22, 0x33, 0x44, 0x55, 0x66, // Src MAC
+		0x08, 0x00, // EtherType: IPv4
+		0x45, 0x00, 0x00, 0x14, // IPv4 header start (dummy)
+	}
+	info := PacketInfo{Ifindex: 1, Hatype: ARPHRDEther, Timestamp: now}
+
+	if d.Duplicate(Packet{Data: frame, Info: info}) {
+		t.Error("first packet reported as duplicate")
+	}
+
+	// Same packet on the same interface (retransmission) is kept
+	info.Timestamp = now.Add(time.Millisecond)
+	if d.Duplicate(Packet{Data: frame, Info: info}) {
+		t.Error("repeat on the same interface reported as duplicate")
+	}
+
+	// Same packet on another interface (bridge + veth) is dropped
+	info.Ifindex = 2
+	info.Timestamp = now.Add(2 * time.Millisecond)
+	if !d.Duplicate(Packet{Data: frame, Info: info}) {
+		t.Error("copy on another interface not reported as duplicate")
+	}
+
+	// Outside the window it's a new packet again
+	info.Ifindex = 3
+	info.Timestamp = now.Add(time.Second)
+	if d.Duplicate(Packet{Data: frame, Info: info}) {
+		t.Error("packet outside the window reported as duplicate")
+	}
+}
+
+func TestDeduplicatorIgnoresVLANTag(t *testing.T) {
+	d := NewDeduplicator(50 * time.Millisecond)
+	now := time.Now()
+
+	untagged := []byte{
+		0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff,
+		0x11, 0x22, 0x33, 0x44, 0x55, 0x66,
+		0x08, 0x00,
+		0x45, 0x00, 0x00, 0x14,
+	}
+	tagged := []byte{
+		0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff,
+		0x11, 0x22, 0x33, 0x44, 0x55, 0x66,
+		0x81, 0x00, 0x00, 0x64, // 802.1Q tag, VID 100
+		0x08, 0x00,
+		0x45, 0x00, 0x00, 0x14,
+	}
+
+	d.Duplicate(Packet{Data: tagged, Info: PacketInfo{Ifindex: 1, Hatype: ARPHRDEther, Timestamp: now}})
+	if !d.Duplicate(Packet{Data: untagged, Info: PacketInfo{Ifindex: 2, Hatype: ARPHRDEther, Timestamp: now}}) {
+		t.Error("untagged copy of a tagged frame not reported as duplicate")
+	}
+}
+
+func TestFlowHashSymmetric(t *testing.T) {
+	forward := []byte{
+		0x45, 0x00, 0x00, 0x18, 0x00, 0x00, 0x40, 0x00, 0x40, 0x06, 0x00, 0x00,
+		0xc0, 0xa8, 0x01, 0x01, // Src IP: 192.168.1.1
+		0xc0, 0xa8, 0x01, 0x02, // Dst IP: 192.168.1.2
+		0x1f, 0x90, 0x00, 0x50, // Ports: 8080 -> 80
+	}
+	reverse := []byte{
+		0x45, 0x00, 0x00, 0x18, 0x00, 0x00, 0x40, 0x00, 0x40, 0x06, 0x00, 0x00,
+		0xc0, 0xa8, 0x01, 0x02, // Src IP: 192.168.1.2
+		0xc0, 0xa8, 0x01, 0x01, // Dst IP: 192.168.1.1
+		0x00, 0x50, 0x1f, 0x90, // Ports: 80 -> 8080
+	}
+	other := []byte{
+		0x45, 0x00, 0x00, 0x18, 0x00, 0x00, 0x40, 0x00, 0x40, 0x06, 0x00, 0x00,
+		0xc0, 0xa8, 0x01, 0x01,
+		0xc0, 0xa8, 0x01, 0x02,
+		0x1f, 0x91, 0x00, 0x50, // Ports: 8081 -> 80
+	}
+
+	// L3 interface: no link-layer header
+	hash := func(data []byte) uint32 { return FlowHash(Packet{Data: data}) }
+
+	if hash(forward) != hash(reverse) {
+		t.Error("FlowHash differs between directions of the same flow")
+	}
+
+	if hash(forward) == hash(other) {
+		t.Error("FlowHash equal for different flows")
+	}
+}
+
+func TestFlowHashVXLAN(t *testing.T) {
+	// Inner TCP packets of one connection, and of another
+	forward := []byte{
+		0x45, 0x00, 0x00, 0x18, 0x00, 0x00, 0x40, 0x00, 0x40, 0x06, 0x00, 0x00,
+		0xc0, 0xa8, 0x01, 0x01, // Src IP: 192.168.1.1
+		0xc0, 0xa8, 0x01, 0x02, // Dst IP: 192.168.1.2
+		0x1f, 0x90, 0x00, 0x50, // Ports: 8080 -> 80
+	}
+	reverse := []byte{
+		0x45, 0x00, 0x00, 0x18, 0x00, 0x00, 0x40, 0x00, 0x40, 0x06, 0x00, 0x00,
+		0xc0, 0xa8, 0x01, 0x02, // Src IP: 192.168.1.2
+		0xc0, 0xa8, 0x01, 0x01, // Dst IP: 192.168.1.1
+		0x00, 0x50, 0x1f, 0x90, // Ports: 80 -> 8080
+	}
+	other := []byte{
+		0x45, 0x00, 0x00, 0x18, 0x00, 0x00, 0x40, 0x00, 0x40, 0x06, 0x00, 0x00,
+		0xc0, 0xa8, 0x01, 0x01,
+		0xc0, 0xa8, 0x01, 0x02,
+		0x1f, 0x91, 0x00, 0x50, // Ports: 8081 -> 80
+	}
+
+	// Each VTEP picks its own outer source port, as VXLAN does per flow
+	vxlan := func(src, dst byte, srcPort uint16, inner []byte) []byte {
+		pkt := []byte{
+			0x45, 0x00, 0x00, 0x00, 0x00, 0x00, 0x40, 0x00, 0x40, 0x11, 0x00, 0x00,
+			0x0a, 0x00, 0x00, src, // Src IP: 10.0.0.src
+			0x0a, 0x00, 0x00, dst, // Dst IP: 10.0.0.dst
+			byte(srcPort >> 8), byte(srcPort), 0x12, 0xb5, // Ports: srcPort -> 4789
+			0x00, 0x00, 0x00, 0x00,
+			0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x2a, 0x00, // VXLAN, VNI 42
+			0x02, 0x00, 0x00, 0x00, 0x00, 0x02, // Dst MAC
+			0x02, 0x00, 0x00, 0x00, 0x00, 0x01, // Src MAC
+			0x08, 0x00, // EtherType: IPv4
+		}
+		return append(pkt, inner...)
+	}
+	hash := func(data []byte) uint32 { return FlowHash(Packet{Data: data}) }
+
+	if hash(vxlan(1, 2, 50000, forward)) != hash(vxlan(2, 1, 61000, reverse)) {
+		t.Error("FlowHash differs between directions of a tunneled flow")
+	}
+	if hash(vxlan(1, 2, 50000, forward)) != hash(forward) {
+		t.Error("FlowHash of a tunneled packet differs from its inner packet")
+	}
+	if hash(vxlan(1, 2, 50000, forward)) == hash(vxlan(1, 2, 50000, other)) {
+		t.Error("FlowHash equal for different flows in the same tunnel")
+	}
+}
@@ -0,0 +1,108 @@
+package capture
+
+import (
+	"fmt"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// packetMreq mirrors struct packet_mreq from <linux/if_packet.h>.
+type packetMreq struct {
+	Ifindex int32
+	Type    uint16
+	Alen    uint16
+	Address [8]byte
+}
+
+// SetPromiscuous puts the bound interface into promiscuous mode for as long
+// as the socket is open. The membership is reference-counted by the kernel,
+// so other users of the interface are unaffected when it is dropped.
+func (s *Socket) SetPromiscuous() error {
+	if s.ifindex == 0 {
+		return fmt.Errorf("promiscuous mode needs a specific interface, not %q", AnyInterface)
+	}
+
+	if err := s.packetMembership(syscall.PACKET_ADD_MEMBERSHIP); err != nil {
+		return fmt.Errorf("enable promiscuous mode: %w", err)
+	}
+	s.promisc = true
+	return nil
+}
+
+// dropPromiscuous removes the promiscuous membership added by SetPromiscuous.
+func (s *Socket) dropPromiscuous() error {
+	if !s.promisc {
+		return nil
+	}
+	s.promisc = false
+
+	if err := s.packetMembership(syscall.PACKET_DROP_MEMBERSHIP); err != nil {
+		return fmt.Errorf("disable promiscuous mode: %w", err)
+	}
+	return nil
+}
+
+// packetMembership adds or drops a PACKET_MR_PROMISC membership.
+func (s *Socket) packetMembership(op int) error {
+	mreq := packetMreq{
+		Ifindex: int32(s.ifindex),
+		Type:    syscall.PACKET_MR_PROMISC,
+	}
+	return setsockopt(s.fd, syscall.SOL_PACKET, op, unsafe.Pointer(&mreq), unsafe.Sizeof(mreq))
+}
+
+// SetSnaplen limits how many bytes of each packet are captured. The kernel
+// truncates packets with a socket filter before they are queued, so the
+// rest is never copied; ReadPacket still reports the original length, from
+// PACKET_AUXDATA.
+// A snaplen of 0 captures whole packets.
+func (s *Socket) SetSnaplen(snaplen int) error {
+	if snaplen <= 0 {
+		return nil
+	}
+
+	// Single-instruction BPF program: "ret #snaplen" accepts every packet,
+	// truncated to snaplen bytes.
+	filter := []syscall.SockFilter{{
+		Code: syscall.BPF_RET | syscall.BPF_K,
+		K:    uint32(snaplen),
+	}}
+	prog := syscall.SockFprog{
+		Len:    uint16(len(filter)),
+		Filter: &filter[0],
+	}
+	if err := setsockopt(s.fd, syscall.SOL_SOCKET, syscall.SO_ATTACH_FILTER, unsafe.Pointer(&prog), unsafe.Sizeof(prog)); err != nil {
+		return fmt.Errorf("set snaplen %d: %w", snaplen, err)
+	}
+
+	s.snaplen = snaplen
+	return nil
+}
+
+// setsockopt sets a socket option with an arbitrary struct value.
+func setsockopt(fd, level, name int, val unsafe.Pointer, size uintptr) error {
+	_, _, errno := syscall.Syscall6(
+		syscall.SYS_SETSOCKOPT,
+		uintptr(fd),
+		uintptr(level),
+		uintptr(name),
+		uintptr(val),
+		size,
+		0,
+	)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// SetReadTimeout makes ReadPacket give up with ErrTimeout when no packet
+// arrives within d, so that a capture loop can notice cancellation.
+func (s *Socket) SetReadTimeout(d time.Duration) error {
+	tv := syscall.NsecToTimeval(d.Nanoseconds())
+	if err := syscall.SetsockoptTimeval(s.fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		return fmt.Errorf("set read timeout: %w", err)
+	}
+	return nil
+}
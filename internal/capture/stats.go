@@ -0,0 +1,49 @@
+package capture
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+// SocketStats are the kernel's counters for a capture socket.
+type SocketStats struct {
+	Packets          uint64 // Packets seen by the socket, including dropped ones
+	Drops            uint64 // Packets dropped because the receive queue was full
+	FreezeQueueDrops uint64 // Packets dropped while the ring was frozen (TPACKET_V3 only)
+}
+
+// tpacketStatsV3 mirrors struct tpacket_stats_v3 from <linux/if_packet.h>.
+// Sockets without a V3 ring only fill in the first two fields.
+type tpacketStatsV3 struct {
+	Packets     uint32
+	Drops       uint32
+	FreezeQueue uint32
+}
+
+// Stats returns the kernel counters accumulated since the socket was opened.
+// The kernel resets its counters on every read, so the socket keeps totals.
+func (s *Socket) Stats() (SocketStats, error) {
+	var st tpacketStatsV3
+	size := uint32(unsafe.Sizeof(st))
+
+	_, _, errno := syscall.Syscall6(
+		syscall.SYS_GETSOCKOPT,
+		uintptr(s.fd),
+		syscall.SOL_PACKET,
+		syscall.PACKET_STATISTICS,
+		uintptr(unsafe.Pointer(&st)),
+		uintptr(unsafe.Pointer(&size)),
+		0,
+	)
+	if errno != 0 {
+		return SocketStats{}, fmt.Errorf("get packet statistics: %w", errno)
+	}
+
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	s.stats.Packets += uint64(st.Packets)
+	s.stats.Drops += uint64(st.Drops)
+	s.stats.FreezeQueueDrops += uint64(st.FreezeQueue)
+	return s.stats, nil
+}
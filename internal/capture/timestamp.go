@@ -0,0 +1,133 @@
+package capture
+
+import (
+	"fmt"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// SO_TIMESTAMPING flags (from <linux/net_tstamp.h>).
+const (
+	sofTimestampingRxHardware  = 1 << 2
+	sofTimestampingRxSoftware  = 1 << 3
+	sofTimestampingSoftware    = 1 << 4
+	sofTimestampingRawHardware = 1 << 6
+)
+
+// PACKET_AUXDATA socket option and control message type.
+const packetAuxdata = 8
+
+// tpacket_auxdata status flags (from <linux/if_packet.h>).
+const (
+	tpStatusVLANValid     = 1 << 4 // tp_vlan_tci holds a stripped tag
+	tpStatusVLANTPIDValid = 1 << 6 // tp_vlan_tpid holds its TPID
+)
+
+// tpidDot1Q is the TPID of a plain 802.1Q tag, assumed by kernels that
+// don't report it.
+const tpidDot1Q = 0x8100
+
+// oobBufferSize fits an SCM_TIMESTAMPING (three timespecs) and a
+// PACKET_AUXDATA control message.
+const oobBufferSize = 128
+
+// tpacketAuxdata mirrors struct tpacket_auxdata from <linux/if_packet.h>.
+type tpacketAuxdata struct {
+	Status   uint32
+	Len      uint32 // Original packet length
+	Snaplen  uint32 // Captured length
+	Mac      uint16
+	Net      uint16
+	VLANTCI  uint16
+	VLANTPID uint16
+}
+
+// controlInfo is what parseControlMessages extracts from a packet's
+// ancillary data.
+type controlInfo struct {
+	timestamp time.Time // Zero if no timestamp was attached
+	hardware  bool      // timestamp comes from the NIC
+	origLen   int       // Original length from PACKET_AUXDATA (0 if absent)
+	vlanTCI   uint16    // VLAN tag the kernel stripped, from PACKET_AUXDATA
+	vlanTPID  uint16    // Its TPID, 0 if there was none
+}
+
+// EnableTimestamps asks the kernel to attach a receive timestamp to every
+// packet. With hardware set, NIC timestamps are requested as well; they are
+// only delivered if hardware timestamping is enabled on the interface
+// (e.g. with hwstamp_ctl), otherwise the software timestamp is used.
+func (s *Socket) EnableTimestamps(hardware bool) error {
+	if hardware {
+		flags := sofTimestampingRxHardware | sofTimestampingRawHardware |
+			sofTimestampingRxSoftware | sofTimestampingSoftware
+		if err := syscall.SetsockoptInt(s.fd, syscall.SOL_SOCKET, syscall.SO_TIMESTAMPING, flags); err != nil {
+			return fmt.Errorf("enable hardware timestamps: %w", err)
+		}
+		return nil
+	}
+
+	if err := syscall.SetsockoptInt(s.fd, syscall.SOL_SOCKET, syscall.SO_TIMESTAMPNS, 1); err != nil {
+		return fmt.Errorf("enable kernel timestamps: %w", err)
+	}
+	return nil
+}
+
+// enableAuxdata asks the kernel to attach a PACKET_AUXDATA control message,
+// which carries the original length of truncated packets and the VLAN tag
+// the kernel stripped from the frame.
+func (s *Socket) enableAuxdata() error {
+	return syscall.SetsockoptInt(s.fd, syscall.SOL_PACKET, packetAuxdata, 1)
+}
+
+// parseControlMessages extracts the receive timestamp, original length and
+// stripped VLAN tag from the control messages of a received packet.
+// Hardware timestamps win over software ones.
+func parseControlMessages(oob []byte) controlInfo {
+	var info controlInfo
+
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return info
+	}
+
+	const timespecSize = int(unsafe.Sizeof(syscall.Timespec{}))
+
+	for _, msg := range msgs {
+		switch {
+		case msg.Header.Level == syscall.SOL_PACKET && msg.Header.Type == packetAuxdata:
+			if len(msg.Data) >= int(unsafe.Sizeof(tpacketAuxdata{})) {
+				aux := (*tpacketAuxdata)(unsafe.Pointer(&msg.Data[0]))
+				info.origLen = int(aux.Len)
+				if aux.Status&tpStatusVLANValid != 0 {
+					info.vlanTCI, info.vlanTPID = aux.VLANTCI, tpidDot1Q
+					if aux.Status&tpStatusVLANTPIDValid != 0 {
+						info.vlanTPID = aux.VLANTPID
+					}
+				}
+			}
+
+		case msg.Header.Level != syscall.SOL_SOCKET:
+			continue
+
+		case msg.Header.Type == syscall.SO_TIMESTAMPNS: // SCM_TIMESTAMPNS
+			if len(msg.Data) >= timespecSize {
+				tsp := (*syscall.Timespec)(unsafe.Pointer(&msg.Data[0]))
+				info.timestamp = time.Unix(tsp.Unix())
+			}
+
+		case msg.Header.Type == syscall.SO_TIMESTAMPING: // SCM_TIMESTAMPING: [software, deprecated, raw hardware]
+			if len(msg.Data) >= 3*timespecSize {
+				sw := (*syscall.Timespec)(unsafe.Pointer(&msg.Data[0]))
+				hw := (*syscall.Timespec)(unsafe.Pointer(&msg.Data[2*timespecSize]))
+				if hw.Sec != 0 || hw.Nsec != 0 {
+					info.timestamp, info.hardware = time.Unix(hw.Unix()), true
+				} else if sw.Sec != 0 || sw.Nsec != 0 {
+					info.timestamp = time.Unix(sw.Unix())
+				}
+			}
+		}
+	}
+
+	return info
+}
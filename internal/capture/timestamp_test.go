@@ -0,0 +1,49 @@
+package capture
+
+import (
+	"syscall"
+	"testing"
+	"unsafe"
+)
+
+// auxdataMessage encodes aux as a PACKET_AUXDATA control message.
+func auxdataMessage(aux tpacketAuxdata) []byte {
+	size := int(unsafe.Sizeof(aux))
+	b := make([]byte, syscall.CmsgSpace(size))
+	h := (*syscall.Cmsghdr)(unsafe.Pointer(&b[0]))
+	h.Level = syscall.SOL_PACKET
+	h.Type = packetAuxdata
+	h.SetLen(syscall.CmsgLen(size))
+	copy(b[syscall.CmsgLen(0):], unsafe.Slice((*byte)(unsafe.Pointer(&aux)), size))
+	return b
+}
+
+func TestParseAuxdataVLAN(t *testing.T) {
+	tests := []struct {
+		name     string
+		aux      tpacketAuxdata
+		tci      uint16
+		tpid     uint16
+		hasVLAN  bool
+		wantVLAN uint16
+	}{
+		{"untagged", tpacketAuxdata{Len: 1514}, 0, 0, false, 0},
+		{"802.1Q", tpacketAuxdata{Status: tpStatusVLANValid | tpStatusVLANTPIDValid, Len: 1514, VLANTCI: 0x2064, VLANTPID: 0x8100}, 0x2064, 0x8100, true, 100},
+		{"802.1ad", tpacketAuxdata{Status: tpStatusVLANValid | tpStatusVLANTPIDValid, Len: 1514, VLANTCI: 200, VLANTPID: 0x88a8}, 200, 0x88a8, true, 200},
+		// Older kernels don't report the TPID
+		{"no TPID", tpacketAuxdata{Status: tpStatusVLANValid, Len: 1514, VLANTCI: 300}, 300, 0x8100, true, 300},
+		// A priority tag carries VLAN ID 0
+		{"priority tag", tpacketAuxdata{Status: tpStatusVLANValid | tpStatusVLANTPIDValid, Len: 1514, VLANTCI: 0xa000, VLANTPID: 0x8100}, 0xa000, 0x8100, true, 0},
+	}
+	for _, tt := range tests {
+		ctrl := parseControlMessages(auxdataMessage(tt.aux))
+		if ctrl.origLen != 1514 || ctrl.vlanTCI != tt.tci || ctrl.vlanTPID != tt.tpid {
+			t.Errorf("%s: got length %d, TCI %#x, TPID %#x; want 1514, %#x, %#x",
+				tt.name, ctrl.origLen, ctrl.vlanTCI, ctrl.vlanTPID, tt.tci, tt.tpid)
+		}
+		info := PacketInfo{VLANTCI: ctrl.vlanTCI, VLANTPID: ctrl.vlanTPID}
+		if vid, ok := info.VLANID(); ok != tt.hasVLAN || vid != tt.wantVLAN {
+			t.Errorf("%s: VLANID = %d, %v; want %d, %v", tt.name, vid, ok, tt.wantVLAN, tt.hasVLAN)
+		}
+	}
+}
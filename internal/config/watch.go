@@ -0,0 +1,111 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// settleTime is how long Watch waits for a burst of changes to end: an
+// editor saving a file writes, renames and changes modes in a row.
+const settleTime = 200 * time.Millisecond
+
+// Watch calls changed in the background whenever the file at path is
+// written, created or replaced, until ctx is cancelled. The directory of
+// the file is watched rather than the file itself, so a file replaced by
+// renaming, as editors and configuration management do, is still seen.
+// The directory must exist.
+func Watch(ctx context.Context, path string, changed func()) error {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return fmt.Errorf("watch config: %w", err)
+	}
+	dir, name := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	const mask = syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO | syscall.IN_CREATE | syscall.IN_ATTRIB
+	if _, err := syscall.InotifyAddWatch(fd, dir, mask); err != nil {
+		syscall.Close(fd)
+		return fmt.Errorf("watch config: %s: %w", dir, err)
+	}
+
+	// A non-blocking descriptor makes reads wait in the runtime's poller,
+	// which Close interrupts
+	f := os.NewFile(uintptr(fd), "inotify")
+	go func() {
+		<-ctx.Done()
+		f.Close()
+	}()
+
+	events := make(chan struct{}, 1)
+	go func() {
+		defer close(events)
+		buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+		for {
+			n, err := f.Read(buf)
+			if err != nil {
+				if !errors.Is(err, os.ErrClosed) {
+					fmt.Fprintf(os.Stderr, "watch config: %v\n", err)
+				}
+				return
+			}
+			if eventsName(buf[:n], name) {
+				select {
+				case events <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+
+	go func() {
+		for range events {
+			// Let the burst settle, then report it once
+			timer := time.NewTimer(settleTime)
+		settle:
+			for {
+				select {
+				case _, ok := <-events:
+					if !ok {
+						timer.Stop()
+						return
+					}
+					timer.Reset(settleTime)
+				case <-timer.C:
+					break settle
+				}
+			}
+			changed()
+		}
+	}()
+	return nil
+}
+
+// eventsName reports whether any of the inotify events in buf is about
+// the file called name.
+func eventsName(buf []byte, name string) bool {
+	found := false
+	for len(buf) >= syscall.SizeofInotifyEvent {
+		ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[0]))
+		end := syscall.SizeofInotifyEvent + int(ev.Len)
+		if end > len(buf) {
+			break
+		}
+		evName := buf[syscall.SizeofInotifyEvent:end]
+		// The name is padded with NULs
+		for len(evName) > 0 && evName[len(evName)-1] == 0 {
+			evName = evName[:len(evName)-1]
+		}
+		if string(evName) == name {
+			found = true
+		}
+		buf = buf[end:]
+	}
+	return found
+}
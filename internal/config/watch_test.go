@@ -0,0 +1,62 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	os.WriteFile(path, []byte("verbosity: 2\n"), 0o644)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes := make(chan struct{}, 10)
+	if err := Watch(ctx, path, func() { changes <- struct{}{} }); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := func(what string, want int) {
+		t.Helper()
+		timeout := time.After(3 * settleTime)
+		for got := 0; ; {
+			select {
+			case <-changes:
+				got++
+			case <-timeout:
+				if got != want {
+					t.Errorf("%s: %d changes reported, want %d", what, got, want)
+				}
+				return
+			}
+		}
+	}
+
+	// Written in place, twice in a row: one change
+	os.WriteFile(path, []byte("verbosity: 3\n"), 0o644)
+	os.WriteFile(path, []byte("verbosity: 1\n"), 0o644)
+	expect("write", 1)
+
+	// Replaced by a rename, as editors do
+	tmp := filepath.Join(dir, ".config.yaml.swp")
+	os.WriteFile(tmp, []byte("verbosity: 2\n"), 0o644)
+	os.Rename(tmp, path)
+	expect("rename", 1)
+
+	// Other files in the directory don't count
+	os.WriteFile(filepath.Join(dir, "other.yaml"), nil, 0o644)
+	expect("other file", 0)
+
+	cancel()
+	time.Sleep(50 * time.Millisecond)
+	os.WriteFile(path, []byte("verbosity: 3\n"), 0o644)
+	expect("after cancel", 0)
+
+	if err := Watch(context.Background(), filepath.Join(dir, "missing", "config.yaml"), func() {}); err == nil {
+		t.Error("expected error watching in a missing directory")
+	}
+}
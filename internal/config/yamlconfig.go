@@ -1,6 +1,9 @@
 package config
 
 import (
+	"bytes"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 
@@ -8,22 +11,62 @@ import (
 )
 
 // YamlConfig represents the YAML config file structure.
-// Field names match CLI flag names.
+// Field names match CLI flag names. Settings whose zero value differs from
+// their default are pointers, so setting them to zero isn't mistaken for
+// leaving them out.
 type YamlConfig struct {
-	Interface string `yaml:"interface"`
-	Protocol  string `yaml:"protocol"`
-	Port      int    `yaml:"port"`
-	IP        string `yaml:"ip"`
-	Direction string `yaml:"direction"`
-	Process   string `yaml:"process"`
-	PID       int    `yaml:"pid"`
-	Stateful  bool   `yaml:"stateful"`
-	Verbosity int    `yaml:"verbosity"`
-	Output    string `yaml:"output"`
-	Debug     bool   `yaml:"debug"`
-	LogFile   string `yaml:"log-file"`
-	Stats     bool   `yaml:"stats"`
-	Graceful  bool   `yaml:"graceful"`
+	Interface         string `yaml:"interface"`
+	Cooked            bool   `yaml:"cooked"`
+	Workers           *int   `yaml:"workers"`
+	Fanout            string `yaml:"fanout"`
+	Promisc           bool   `yaml:"promisc"`
+	Snaplen           int    `yaml:"snaplen"`
+	Duration          string `yaml:"duration"`
+	Count             uint64 `yaml:"count"`
+	MaxBytes          string `yaml:"max-bytes"`
+	StopOn            string `yaml:"stop-on"`
+	Protocol          string `yaml:"protocol"`
+	Port              int    `yaml:"port"`
+	IP                string `yaml:"ip"`
+	VLAN              int    `yaml:"vlan"`
+	Direction         string `yaml:"direction"`
+	Process           string `yaml:"process"`
+	PID               int    `yaml:"pid"`
+	Stateful          bool   `yaml:"stateful"`
+	Verbosity         *int   `yaml:"verbosity"`
+	Output            string `yaml:"output"`
+	Format            string `yaml:"format"`
+	Color             string `yaml:"color"`
+	Pcap              string `yaml:"pcap"`
+	RotateSize        string `yaml:"rotate-size"`
+	RotateInterval    string `yaml:"rotate-interval"`
+	MaxFiles          int    `yaml:"max-files"`
+	Compress          string `yaml:"compress"`
+	Debug             bool   `yaml:"debug"`
+	LogFile           string `yaml:"log-file"`
+	Stats             bool   `yaml:"stats"`
+	StatsInterval     string `yaml:"stats-interval"`
+	MetricsListen     string `yaml:"metrics-listen"`
+	FlowCollector     string `yaml:"flow-collector"`
+	FlowProtocol      string `yaml:"flow-protocol"`
+	FlowActiveTimeout string `yaml:"flow-active-timeout"`
+	OTLPEndpoint      string `yaml:"otlp-endpoint"`
+	OTLPHeaders       string `yaml:"otlp-headers"`
+	SQLite            string `yaml:"sqlite"`
+	StreamSocket      string `yaml:"stream-socket"`
+	StreamListen      string `yaml:"stream-listen"`
+	ControlSocket     string `yaml:"control-socket"`
+	SnapshotSize      string `yaml:"snapshot-size"`
+	Graceful          bool   `yaml:"graceful"`
+	TimeFormat        string `yaml:"time-format"`
+	HWTimestamps      bool   `yaml:"hw-timestamps"`
+
+	keys map[string]bool // Keys present in the file
+}
+
+// IsSet reports whether the file sets key, even to its zero value.
+func (c *YamlConfig) IsSet(key string) bool {
+	return c.keys[key]
 }
 
 // DefaultPath returns the default config file path.
@@ -42,7 +85,8 @@ func DefaultPath() string {
 }
 
 // Load reads and parses a YAML config file.
-// Returns an empty config (not error) if file doesn't exist.
+// Returns an empty config (not error) if file doesn't exist. Unknown keys
+// are an error, so a misspelled setting isn't silently ignored.
 func Load(path string) (*YamlConfig, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
@@ -53,8 +97,20 @@ func Load(path string) (*YamlConfig, error) {
 	}
 
 	var cfg YamlConfig
-	if err := yaml.Unmarshal(data, &cfg); err != nil {
-		return nil, err
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&cfg); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	// Decoded again for the keys alone
+	var keys map[string]any
+	if err := yaml.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	cfg.keys = make(map[string]bool, len(keys))
+	for key := range keys {
+		cfg.keys[key] = true
 	}
 
 	return &cfg, nil
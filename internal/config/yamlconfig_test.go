@@ -0,0 +1,56 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	write := func(content string) string {
+		path := filepath.Join(dir, "config.yaml")
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	cfg, err := Load(write("interface: eth0\nverbosity: 0\nport: 443\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Interface != "eth0" || cfg.Port != 443 {
+		t.Errorf("got interface %q, port %d", cfg.Interface, cfg.Port)
+	}
+	// An explicit zero is kept apart from a missing setting
+	if cfg.Verbosity == nil || *cfg.Verbosity != 0 {
+		t.Errorf("verbosity: got %v, want 0", cfg.Verbosity)
+	}
+	if cfg.Workers != nil {
+		t.Errorf("workers: got %d, want unset", *cfg.Workers)
+	}
+	for key, want := range map[string]bool{"interface": true, "verbosity": true, "workers": false} {
+		if cfg.IsSet(key) != want {
+			t.Errorf("IsSet(%q) = %v, want %v", key, !want, want)
+		}
+	}
+
+	// A misspelled key is an error naming it
+	_, err = Load(write("interface: eth0\nverbositty: 3\n"))
+	if err == nil || !strings.Contains(err.Error(), "verbositty") {
+		t.Errorf("unknown key: got error %v", err)
+	}
+
+	// An empty file and a missing one are an empty config
+	for _, path := range []string{write(""), filepath.Join(dir, "missing.yaml")} {
+		cfg, err := Load(path)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if cfg.IsSet("interface") || cfg.Verbosity != nil {
+			t.Errorf("%s: got %+v, want an empty config", path, cfg)
+		}
+	}
+}
@@ -0,0 +1,252 @@
+// Package control implements the control API of a running capture: JSON
+// requests and responses, one per line, over a Unix socket. A client sends
+// {"command":"status"} or {"command":"filter","args":{...}} and gets
+// {"ok":true,"result":...} or {"ok":false,"error":"..."} back. A
+// connection may carry any number of requests.
+package control
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// maxRequest bounds a request line.
+const maxRequest = 64 << 10
+
+// writeTimeout is how long a client may take to accept a response.
+const writeTimeout = 10 * time.Second
+
+// Request is a command sent to the server.
+type Request struct {
+	Command string          `json:"command"`
+	Args    json.RawMessage `json:"args,omitempty"`
+}
+
+// Response answers a Request.
+type Response struct {
+	OK     bool            `json:"ok"`
+	Error  string          `json:"error,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+}
+
+// Handler runs a command. args is the request's "args", nil if it had
+// none. The result is encoded as the response's "result".
+type Handler func(args json.RawMessage) (any, error)
+
+// Server answers requests on a Unix socket.
+type Server struct {
+	mu       sync.RWMutex
+	handlers map[string]Handler
+	ln       net.Listener
+	conns    map[net.Conn]bool
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+// NewServer creates a Server with the built-in "commands" command, which
+// lists the commands it knows.
+func NewServer() *Server {
+	s := &Server{handlers: make(map[string]Handler), conns: make(map[net.Conn]bool)}
+	s.Handle("commands", func(json.RawMessage) (any, error) {
+		return s.Commands(), nil
+	})
+	return s
+}
+
+// Handle registers the handler of a command, replacing any earlier one.
+func (s *Server) Handle(command string, h Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[command] = h
+}
+
+// Commands returns the registered commands, sorted.
+func (s *Server) Commands() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	names := make([]string, 0, len(s.handlers))
+	for name := range s.handlers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Serve listens on a Unix socket at path and answers requests in the
+// background until Close. A stale socket left at path is replaced. The
+// socket is only accessible to its owner and group.
+func (s *Server) Serve(path string) error {
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("control socket: %w", err)
+	}
+	if err := os.Chmod(path, 0o660); err != nil {
+		ln.Close()
+		return fmt.Errorf("control socket: %w", err)
+	}
+	s.mu.Lock()
+	s.ln = ln
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				if !errors.Is(err, net.ErrClosed) {
+					time.Sleep(100 * time.Millisecond)
+					continue
+				}
+				return
+			}
+			if !s.track(c) {
+				c.Close()
+				return
+			}
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				defer s.untrack(c)
+				s.serveConn(c)
+			}()
+		}
+	}()
+	return nil
+}
+
+// track registers an open connection, unless the server is closed.
+func (s *Server) track(c net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.conns[c] = true
+	return true
+}
+
+func (s *Server) untrack(c net.Conn) {
+	s.mu.Lock()
+	delete(s.conns, c)
+	s.mu.Unlock()
+	c.Close()
+}
+
+// serveConn answers the requests of one client until it hangs up.
+func (s *Server) serveConn(c net.Conn) {
+	sc := bufio.NewScanner(c)
+	sc.Buffer(make([]byte, 4096), maxRequest)
+	enc := json.NewEncoder(c)
+	for sc.Scan() {
+		resp := s.Do(sc.Bytes())
+		c.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if err := enc.Encode(resp); err != nil {
+			return
+		}
+	}
+}
+
+// Do answers one encoded request.
+func (s *Server) Do(line []byte) Response {
+	var req Request
+	if err := json.Unmarshal(line, &req); err != nil {
+		return Response{Error: "request: " + err.Error()}
+	}
+	s.mu.RLock()
+	h := s.handlers[req.Command]
+	s.mu.RUnlock()
+	if h == nil {
+		return Response{Error: fmt.Sprintf("unknown command %q", req.Command)}
+	}
+
+	result, err := h(req.Args)
+	if err != nil {
+		return Response{Error: err.Error()}
+	}
+	resp := Response{OK: true}
+	if result != nil {
+		if resp.Result, err = json.Marshal(result); err != nil {
+			return Response{Error: "encode result: " + err.Error()}
+		}
+	}
+	return resp
+}
+
+// Close stops listening, removes the socket and disconnects the clients
+// once their current request is answered.
+func (s *Server) Close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	if s.ln != nil {
+		s.ln.Close()
+	}
+	for c := range s.conns {
+		// Unblocks the read of the next request
+		c.SetReadDeadline(time.Now())
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// Call sends a command to the server at path and decodes its result into
+// result, which may be nil. A command the server rejects is an error.
+func Call(path, command string, args, result any) error {
+	req := Request{Command: command}
+	if args != nil {
+		data, err := json.Marshal(args)
+		if err != nil {
+			return err
+		}
+		req.Args = data
+	}
+
+	c, err := net.Dial("unix", path)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if err := json.NewEncoder(c).Encode(req); err != nil {
+		return err
+	}
+	var resp Response
+	if err := json.NewDecoder(c).Decode(&resp); err != nil {
+		return fmt.Errorf("read response: %w", err)
+	}
+	if !resp.OK {
+		return errors.New(resp.Error)
+	}
+	if result != nil && resp.Result != nil {
+		return json.Unmarshal(resp.Result, result)
+	}
+	return nil
+}
+
+// DecodeArgs decodes a request's args into v, leaving v alone if there
+// are none. Unknown fields are an error, so a typo doesn't go unnoticed.
+func DecodeArgs(args json.RawMessage, v any) error {
+	if len(args) == 0 {
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(args))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("args: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package control
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestServer(t *testing.T) {
+	s := NewServer()
+	var level int
+	s.Handle("level", func(args json.RawMessage) (any, error) {
+		var req struct {
+			Set *int `json:"set"`
+		}
+		if err := DecodeArgs(args, &req); err != nil {
+			return nil, err
+		}
+		if req.Set != nil {
+			if *req.Set < 0 {
+				return nil, errors.New("level must not be negative")
+			}
+			level = *req.Set
+		}
+		return map[string]int{"level": level}, nil
+	})
+	s.Handle("nothing", func(json.RawMessage) (any, error) { return nil, nil })
+
+	path := filepath.Join(t.TempDir(), "control.sock")
+	if err := s.Serve(path); err != nil {
+		t.Fatal(err)
+	}
+
+	var got map[string]int
+	if err := Call(path, "level", map[string]int{"set": 3}, &got); err != nil || got["level"] != 3 {
+		t.Errorf("set level: %v, %v", got, err)
+	}
+	if err := Call(path, "level", nil, &got); err != nil || got["level"] != 3 {
+		t.Errorf("get level: %v, %v", got, err)
+	}
+	if err := Call(path, "level", map[string]int{"set": -1}, nil); err == nil || !strings.Contains(err.Error(), "negative") {
+		t.Errorf("handler error: %v", err)
+	}
+	if err := Call(path, "level", map[string]int{"sett": 1}, nil); err == nil || !strings.Contains(err.Error(), "unknown field") {
+		t.Errorf("typo in args: %v", err)
+	}
+	if err := Call(path, "nope", nil, nil); err == nil || !strings.Contains(err.Error(), "unknown command") {
+		t.Errorf("unknown command: %v", err)
+	}
+	if err := Call(path, "nothing", nil, nil); err != nil {
+		t.Errorf("nothing: %v", err)
+	}
+	var commands []string
+	if err := Call(path, "commands", nil, &commands); err != nil || strings.Join(commands, ",") != "commands,level,nothing" {
+		t.Errorf("commands = %v, %v", commands, err)
+	}
+
+	// Several requests on one connection, and one that isn't JSON
+	c, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Write([]byte("{\"command\":\"level\"}\nnot json\n"))
+	r := bufio.NewReader(c)
+	for _, want := range []string{`{"ok":true,"result":{"level":3}}`, `{"ok":false,"error":"request: `} {
+		line, err := r.ReadString('\n')
+		if err != nil || !strings.HasPrefix(line, want) {
+			t.Errorf("got %q, %v, want %s...", line, err, want)
+		}
+	}
+
+	// Close disconnects the idle client and removes the socket
+	s.Close()
+	if _, err := r.ReadByte(); err == nil {
+		t.Error("client still connected after Close")
+	}
+	if err := Call(path, "level", nil, nil); err == nil {
+		t.Error("server still answers after Close")
+	}
+}
@@ -0,0 +1,286 @@
+// Package filter implements the small expression language used to select
+// records, for example by --stop-on:
+//
+//	tcp and port 443
+//	direction == in || vlan 100
+//	tcp.flags contains RST
+//	src_ip == 10.0.0.0/8 && !(dst_port < 1024)
+//
+// Field names are the JSON keys of the record, with nested objects joined
+// by dots ("tcp.flags"). "ip" and "port" match either endpoint, and the
+// bare words tcp, udp and arp test the protocol. A field followed by a value
+// without an operator compares for equality. A bare field name is true if
+// the field is present and not zero or empty.
+package filter
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Env gives a filter access to the fields of a record.
+type Env interface {
+	// Lookup returns the value of a field and whether the record has it.
+	// Values are strings, bools, integers, floats or slices of those.
+	Lookup(field string) (any, bool)
+}
+
+// Map is an Env over a decoded JSON object. Dotted field names descend
+// into nested objects.
+type Map map[string]any
+
+// Lookup implements Env.
+func (m Map) Lookup(field string) (any, bool) {
+	var cur any = map[string]any(m)
+	for part := range strings.SplitSeq(field, ".") {
+		obj, ok := cur.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if cur, ok = obj[part]; !ok {
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+// Filter is a compiled filter expression.
+type Filter struct {
+	expr string
+	root node
+}
+
+// Compile parses a filter expression.
+func Compile(expr string) (*Filter, error) {
+	tokens, err := lex(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, fmt.Errorf("filter: unexpected %q at position %d", tok.text, tok.pos)
+	}
+
+	return &Filter{expr: expr, root: root}, nil
+}
+
+// Match reports whether the record matches the filter.
+func (f *Filter) Match(env Env) bool {
+	return f.root.eval(env)
+}
+
+// String returns the source expression.
+func (f *Filter) String() string {
+	return f.expr
+}
+
+// node is an expression tree node.
+type node interface {
+	eval(env Env) bool
+}
+
+type andNode struct{ left, right node }
+type orNode struct{ left, right node }
+type notNode struct{ operand node }
+
+func (n andNode) eval(env Env) bool { return n.left.eval(env) && n.right.eval(env) }
+func (n orNode) eval(env Env) bool  { return n.left.eval(env) || n.right.eval(env) }
+func (n notNode) eval(env Env) bool { return !n.operand.eval(env) }
+
+// existsNode is a bare field name: true if the field is set.
+type existsNode struct{ field string }
+
+func (n existsNode) eval(env Env) bool {
+	for _, field := range expandField(n.field) {
+		if v, ok := env.Lookup(field); ok && truthy(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// compareNode compares a field against a literal. For list fields and the
+// "ip"/"port" aliases it matches if any value does; != matches if none equal.
+type compareNode struct {
+	field string
+	op    string
+	value string
+}
+
+func (n compareNode) eval(env Env) bool {
+	if n.op == "!=" {
+		return !compareNode{field: n.field, op: "==", value: n.value}.eval(env)
+	}
+
+	for _, field := range expandField(n.field) {
+		v, ok := env.Lookup(field)
+		if !ok {
+			continue
+		}
+		if list, ok := asList(v); ok && n.op != "contains" {
+			for _, elem := range list {
+				if compare(elem, n.op, n.value) {
+					return true
+				}
+			}
+			continue
+		}
+		if compare(v, n.op, n.value) {
+			return true
+		}
+	}
+	return false
+}
+
+// expandField maps the "ip" and "port" aliases to both endpoints.
+func expandField(field string) []string {
+	switch field {
+	case "ip":
+		return []string{"src_ip", "dst_ip"}
+	case "port":
+		return []string{"src_port", "dst_port"}
+	}
+	return []string{field}
+}
+
+// protocolWords are bare words that test the protocol field.
+var protocolWords = map[string]bool{"tcp": true, "udp": true, "arp": true}
+
+// compare applies op to a single field value and a literal.
+func compare(v any, op, lit string) bool {
+	if op == "contains" {
+		return contains(v, lit)
+	}
+
+	if num, ok := asNumber(v); ok {
+		want, err := strconv.ParseFloat(lit, 64)
+		if err != nil {
+			return false
+		}
+		return compareOrdered(num, want, op)
+	}
+
+	switch v := v.(type) {
+	case bool:
+		want, err := strconv.ParseBool(lit)
+		if err != nil || (op != "==") {
+			return false
+		}
+		return v == want
+	case string:
+		if op == "==" && strings.Contains(lit, "/") {
+			if _, network, err := net.ParseCIDR(lit); err == nil {
+				ip := net.ParseIP(v)
+				return ip != nil && network.Contains(ip)
+			}
+		}
+		if op == "==" {
+			return strings.EqualFold(v, lit)
+		}
+		return compareOrdered(strings.ToLower(v), strings.ToLower(lit), op)
+	}
+	return false
+}
+
+// contains matches a substring of a string (case-insensitive) or an
+// element of a list.
+func contains(v any, lit string) bool {
+	if list, ok := asList(v); ok {
+		for _, elem := range list {
+			if compare(elem, "==", lit) {
+				return true
+			}
+		}
+		return false
+	}
+	if s, ok := v.(string); ok {
+		return strings.Contains(strings.ToUpper(s), strings.ToUpper(lit))
+	}
+	return false
+}
+
+func compareOrdered[T float64 | string](a, b T, op string) bool {
+	switch op {
+	case "==":
+		return a == b
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	}
+	return false
+}
+
+// asNumber converts numeric field values to float64.
+func asNumber(v any) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint8:
+		return float64(n), true
+	case uint16:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// asList converts list field values to []any.
+func asList(v any) ([]any, bool) {
+	switch l := v.(type) {
+	case []any:
+		return l, true
+	case []string:
+		out := make([]any, len(l))
+		for i, s := range l {
+			out[i] = s
+		}
+		return out, true
+	case []uint16:
+		out := make([]any, len(l))
+		for i, n := range l {
+			out[i] = n
+		}
+		return out, true
+	}
+	return nil, false
+}
+
+// truthy reports whether a field value is set: not false, zero or empty.
+func truthy(v any) bool {
+	if num, ok := asNumber(v); ok {
+		return num != 0
+	}
+	if list, ok := asList(v); ok {
+		return len(list) > 0
+	}
+	switch v := v.(type) {
+	case bool:
+		return v
+	case string:
+		return v != ""
+	case nil:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,96 @@
+package filter
+
+import "testing"
+
+func testRecord() Map {
+	return Map{
+		"protocol":  "TCP",
+		"src_ip":    "10.1.2.3",
+		"src_port":  float64(40000),
+		"dst_ip":    "93.184.216.34",
+		"dst_port":  float64(443),
+		"direction": "out",
+		"vlan":      []any{float64(100), float64(200)},
+		"tcp": map[string]any{
+			"flags": "PSH,ACK",
+			"seq":   float64(1000),
+		},
+	}
+}
+
+func TestFilterMatch(t *testing.T) {
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{"tcp", true},
+		{"udp", false},
+		{"tcp and port 443", true},
+		{"tcp and port 80", false},
+		{"tcp and port == 443", true},
+		{"port == 40000", true},
+		{"port == 80", false},
+		{"dst_port < 1024", true},
+		{"dst_port >= 1024", false},
+		{"ip == 10.0.0.0/8", true},
+		{"src_ip == 192.168.0.0/16", false},
+		{"dst_ip = 93.184.216.34", true},
+		{"direction == OUT", true},
+		{"direction != out", false},
+		{"tcp.flags contains ack", true},
+		{"tcp.flags contains RST", false},
+		{"tcp.seq > 999", true},
+		{"vlan == 200", true},
+		{"vlan contains 300", false},
+		{"vlan != 300", true},
+		{"pid", false},
+		{"tcp.flags", true},
+		{"!udp && (dst_port == 80 || dst_port == 443)", true},
+		{"not (tcp or udp)", false},
+		{"process == 'curl'", false},
+	}
+
+	rec := testRecord()
+	for _, tt := range tests {
+		f, err := Compile(tt.expr)
+		if err != nil {
+			t.Errorf("Compile(%q): %v", tt.expr, err)
+			continue
+		}
+		if got := f.Match(rec); got != tt.want {
+			t.Errorf("Match(%q) = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestFilterCompileErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"tcp and",
+		"(tcp",
+		"tcp)",
+		"port ==",
+		"port == (",
+		"process == 'curl",
+		"port # 1",
+		"port 1 2",
+	} {
+		if _, err := Compile(expr); err == nil {
+			t.Errorf("Compile(%q) succeeded, want error", expr)
+		}
+	}
+}
+
+func TestMapLookup(t *testing.T) {
+	rec := testRecord()
+
+	if v, ok := rec.Lookup("tcp.flags"); !ok || v != "PSH,ACK" {
+		t.Errorf("Lookup(tcp.flags) = %v, %v", v, ok)
+	}
+	if _, ok := rec.Lookup("udp.length"); ok {
+		t.Error("Lookup(udp.length) found a missing field")
+	}
+	if _, ok := rec.Lookup("protocol.name"); ok {
+		t.Error("Lookup(protocol.name) descended into a string")
+	}
+}
@@ -0,0 +1,204 @@
+package filter
+
+import (
+	"fmt"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokEOF    tokenKind = iota
+	tokWord             // field name, keyword or literal
+	tokString           // quoted literal
+	tokOp               // comparison operator
+	tokAnd
+	tokOr
+	tokNot
+	tokLParen
+	tokRParen
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// lex splits an expression into tokens.
+func lex(expr string) ([]token, error) {
+	var tokens []token
+	i := 0
+	for i < len(expr) {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '(':
+			tokens = append(tokens, token{tokLParen, "(", i})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{tokRParen, ")", i})
+			i++
+		case strings.HasPrefix(expr[i:], "&&"):
+			tokens = append(tokens, token{tokAnd, "&&", i})
+			i += 2
+		case strings.HasPrefix(expr[i:], "||"):
+			tokens = append(tokens, token{tokOr, "||", i})
+			i += 2
+		case strings.HasPrefix(expr[i:], "==") || strings.HasPrefix(expr[i:], "!=") ||
+			strings.HasPrefix(expr[i:], "<=") || strings.HasPrefix(expr[i:], ">="):
+			tokens = append(tokens, token{tokOp, expr[i : i+2], i})
+			i += 2
+		case c == '<' || c == '>':
+			tokens = append(tokens, token{tokOp, expr[i : i+1], i})
+			i++
+		case c == '=':
+			tokens = append(tokens, token{tokOp, "==", i})
+			i++
+		case c == '!':
+			tokens = append(tokens, token{tokNot, "!", i})
+			i++
+		case c == '"' || c == '\'':
+			end := strings.IndexByte(expr[i+1:], c)
+			if end < 0 {
+				return nil, fmt.Errorf("filter: unterminated string at position %d", i)
+			}
+			tokens = append(tokens, token{tokString, expr[i+1 : i+1+end], i})
+			i += end + 2
+		case isWordChar(c):
+			start := i
+			for i < len(expr) && isWordChar(expr[i]) {
+				i++
+			}
+			word := expr[start:i]
+			kind := tokWord
+			switch strings.ToLower(word) {
+			case "and":
+				kind = tokAnd
+			case "or":
+				kind = tokOr
+			case "not":
+				kind = tokNot
+			case "contains":
+				kind = tokOp
+				word = "contains"
+			}
+			tokens = append(tokens, token{kind, word, start})
+		default:
+			return nil, fmt.Errorf("filter: unexpected %q at position %d", c, i)
+		}
+	}
+	return append(tokens, token{tokEOF, "end of expression", len(expr)}), nil
+}
+
+// isWordChar reports whether c can be part of a field name or bare literal
+// such as 10.0.0.0/8, fe80::1 or eth0.
+func isWordChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		c == '_' || c == '.' || c == ':' || c == '/' || c == '-' || c == '*'
+}
+
+// parser is a recursive descent parser over the token list:
+//
+//	or      = and { ("or" | "||") and }
+//	and     = unary { ("and" | "&&") unary }
+//	unary   = ("not" | "!") unary | primary
+//	primary = "(" or ")" | protocol | field [ [ op ] value ]
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokOr {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokAnd {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.peek().kind == tokNot {
+		p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokLParen:
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.kind != tokRParen {
+			return nil, fmt.Errorf("filter: expected ) at position %d, got %q", closing.pos, closing.text)
+		}
+		return inner, nil
+
+	case tokWord:
+		if protocolWords[strings.ToLower(tok.text)] {
+			return compareNode{field: "protocol", op: "==", value: tok.text}, nil
+		}
+
+		// "port 443" is short for "port == 443"
+		op := token{kind: tokOp, text: "==", pos: p.peek().pos}
+		switch p.peek().kind {
+		case tokOp:
+			op = p.next()
+		case tokWord, tokString:
+		default:
+			return existsNode{field: tok.text}, nil
+		}
+		value := p.next()
+		if value.kind != tokWord && value.kind != tokString {
+			return nil, fmt.Errorf("filter: expected value after %s at position %d, got %q", op.text, value.pos, value.text)
+		}
+		return compareNode{field: tok.text, op: op.text, value: value.text}, nil
+	}
+
+	return nil, fmt.Errorf("filter: unexpected %q at position %d", tok.text, tok.pos)
+}
@@ -0,0 +1,136 @@
+// Package flow exports connections as flow records to an IPFIX or NetFlow
+// collector over UDP.
+package flow
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// Export protocols.
+const (
+	ProtocolIPFIX     = "ipfix"
+	ProtocolNetFlowV9 = "netflow9"
+	ProtocolNetFlowV5 = "netflow5"
+)
+
+// ValidProtocol reports whether p is a supported export protocol.
+func ValidProtocol(p string) bool {
+	return p == ProtocolIPFIX || p == ProtocolNetFlowV9 || p == ProtocolNetFlowV5
+}
+
+// maxMessageSize keeps export packets below a typical path MTU.
+const maxMessageSize = 1400
+
+// templateRefresh is how often templates are resent, so a collector that
+// restarts or missed the first packet learns them again (RFC 7011 10.3.6).
+const templateRefresh = time.Minute
+
+// EndReason is why a flow record was exported (IPFIX flowEndReason).
+type EndReason uint8
+
+// Flow end reasons.
+const (
+	EndIdleTimeout   EndReason = 1
+	EndActiveTimeout EndReason = 2
+	EndOfFlow        EndReason = 3 // FIN or RST seen
+	EndForced        EndReason = 4 // Exporter shut down
+)
+
+// Record is a unidirectional flow: the packets sent from Src to Dst
+// between Start and End.
+type Record struct {
+	SrcIP     net.IP // IPv4
+	DstIP     net.IP // IPv4
+	SrcPort   uint16
+	DstPort   uint16
+	Protocol  uint8 // IP protocol number
+	Packets   uint64
+	Bytes     uint64 // Whole IP packets, headers included
+	Start     time.Time
+	End       time.Time
+	EndReason EndReason
+
+	// Owner of the local socket; IPFIX only
+	PID       int
+	Process   string
+	Container string
+}
+
+// encoder packs records into export messages of one protocol. Encoders
+// keep sequence numbers and template state and are not safe for
+// concurrent use.
+type encoder interface {
+	encode(records []Record, now time.Time) [][]byte
+}
+
+// Exporter sends flow records to a collector. It is safe for concurrent use.
+type Exporter struct {
+	mu   sync.Mutex
+	conn net.Conn
+	enc  encoder
+}
+
+// Dial creates an exporter sending to the collector at addr (host:port)
+// in the given protocol.
+func Dial(addr, protocol string) (*Exporter, error) {
+	start := time.Now()
+	var enc encoder
+	switch protocol {
+	case ProtocolIPFIX:
+		enc = &ipfixEncoder{}
+	case ProtocolNetFlowV9:
+		enc = &v9Encoder{start: start}
+	case ProtocolNetFlowV5:
+		enc = &v5Encoder{start: start}
+	default:
+		return nil, fmt.Errorf("unknown flow protocol %q", protocol)
+	}
+
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("dial collector: %w", err)
+	}
+	return &Exporter{conn: conn, enc: enc}, nil
+}
+
+// Export sends records to the collector. Records without IPv4 addresses
+// are skipped.
+func (e *Exporter) Export(records []Record) error {
+	valid := records[:0:0]
+	for _, r := range records {
+		if r.SrcIP.To4() != nil && r.DstIP.To4() != nil {
+			valid = append(valid, r)
+		}
+	}
+	if len(valid) == 0 {
+		return nil
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var firstErr error
+	for _, msg := range e.enc.encode(valid, time.Now()) {
+		// Keep sending: one lost message shouldn't take the rest with it
+		if _, err := e.conn.Write(msg); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("send flow records: %w", err)
+		}
+	}
+	return firstErr
+}
+
+// Close closes the connection to the collector.
+func (e *Exporter) Close() error {
+	return e.conn.Close()
+}
+
+// uptimeMillis returns the time from start to t in milliseconds, as the
+// 32-bit system uptime NetFlow timestamps are relative to.
+func uptimeMillis(start, t time.Time) uint32 {
+	if t.Before(start) {
+		return 0
+	}
+	return uint32(t.Sub(start).Milliseconds())
+}
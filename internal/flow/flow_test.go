@@ -0,0 +1,296 @@
+package flow
+
+import (
+	"encoding/binary"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/hwang-fu/portlens/internal/tracker"
+)
+
+// listen starts a UDP collector on a free local port.
+func listen(t *testing.T) *net.UDPConn {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// receive reads one export packet.
+func receive(t *testing.T, conn *net.UDPConn) []byte {
+	t.Helper()
+	buf := make([]byte, 65535)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf[:n]
+}
+
+func testRecord() Record {
+	return Record{
+		SrcIP: net.IPv4(10, 0, 0, 5), DstIP: net.IPv4(1, 1, 1, 1),
+		SrcPort: 40000, DstPort: 443, Protocol: 6,
+		Packets: 10, Bytes: 1234,
+		Start:     time.UnixMilli(1700000000000),
+		End:       time.UnixMilli(1700000060000),
+		EndReason: EndOfFlow,
+		PID:       42, Process: "curl", Container: "abc123",
+	}
+}
+
+func TestExportIPFIX(t *testing.T) {
+	collector := listen(t)
+	e, err := Dial(collector.LocalAddr().String(), ProtocolIPFIX)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+
+	v6 := testRecord()
+	v6.SrcIP = net.ParseIP("2001:db8::1")
+	if err := e.Export([]Record{testRecord(), v6}); err != nil {
+		t.Fatal(err)
+	}
+	msg := receive(t, collector)
+
+	be := binary.BigEndian
+	if v := be.Uint16(msg[0:]); v != ipfixVersion {
+		t.Fatalf("version = %d", v)
+	}
+	if l := be.Uint16(msg[2:]); int(l) != len(msg) {
+		t.Errorf("length = %d, packet is %d bytes", l, len(msg))
+	}
+	if seq := be.Uint32(msg[8:]); seq != 0 {
+		t.Errorf("first sequence = %d, want 0", seq)
+	}
+
+	// Template set with the enterprise elements
+	sets := msg[ipfixHeaderSize:]
+	if id := be.Uint16(sets[0:]); id != setIDTemplate {
+		t.Fatalf("first set ID = %d, want template set", id)
+	}
+	tmplLen := be.Uint16(sets[2:])
+	tmpl := sets[4:tmplLen]
+	if be.Uint16(tmpl[0:]) != ipfixTemplateID || int(be.Uint16(tmpl[2:])) != len(ipfixFields) {
+		t.Fatalf("template header = % x", tmpl[:4])
+	}
+	var enterprise []uint16
+	for spec := tmpl[4:]; len(spec) > 0; {
+		id := be.Uint16(spec[0:])
+		if id&enterpriseBit == 0 {
+			spec = spec[4:]
+			continue
+		}
+		if pen := be.Uint32(spec[4:]); pen != EnterpriseNumber {
+			t.Errorf("enterprise number = %d", pen)
+		}
+		enterprise = append(enterprise, id&^enterpriseBit)
+		spec = spec[8:]
+	}
+	if len(enterprise) != 3 || enterprise[0] != ElementPID || enterprise[1] != ElementProcessName || enterprise[2] != ElementContainerID {
+		t.Errorf("enterprise elements = %v", enterprise)
+	}
+
+	// One data record; the IPv6 one is skipped
+	data := sets[tmplLen:]
+	if id := be.Uint16(data[0:]); id != ipfixTemplateID {
+		t.Fatalf("data set ID = %d", id)
+	}
+	rec := data[4:be.Uint16(data[2:])]
+	if !net.IP(rec[0:4]).Equal(net.IPv4(10, 0, 0, 5)) || be.Uint16(rec[8:]) != 40000 || rec[12] != 6 {
+		t.Errorf("flow key = % x", rec[:13])
+	}
+	if be.Uint64(rec[13:]) != 1234 || be.Uint64(rec[21:]) != 10 {
+		t.Errorf("bytes/packets = %d/%d", be.Uint64(rec[13:]), be.Uint64(rec[21:]))
+	}
+	if be.Uint64(rec[29:]) != 1700000000000 || rec[45] != byte(EndOfFlow) {
+		t.Errorf("start = %d, end reason = %d", be.Uint64(rec[29:]), rec[45])
+	}
+	if pid := be.Uint32(rec[46:]); pid != 42 {
+		t.Errorf("pid = %d", pid)
+	}
+	rest := rec[50:]
+	process := string(rest[1 : 1+rest[0]])
+	rest = rest[1+rest[0]:]
+	container := string(rest[1 : 1+rest[0]])
+	if process != "curl" || container != "abc123" || len(rest) != 1+len(container) {
+		t.Errorf("process = %q, container = %q, %d trailing bytes", process, container, len(rest)-1-len(container))
+	}
+
+	// The next message has no template and counts the record sent before
+	if err := e.Export([]Record{testRecord()}); err != nil {
+		t.Fatal(err)
+	}
+	msg = receive(t, collector)
+	if seq := be.Uint32(msg[8:]); seq != 1 {
+		t.Errorf("second sequence = %d, want 1", seq)
+	}
+	if id := be.Uint16(msg[ipfixHeaderSize:]); id != ipfixTemplateID {
+		t.Errorf("second message starts with set %d, want data", id)
+	}
+}
+
+func TestIPFIXSplitsMessages(t *testing.T) {
+	records := make([]Record, 100)
+	for i := range records {
+		records[i] = testRecord()
+	}
+	e := &ipfixEncoder{}
+	msgs := e.encode(records, time.Now())
+	if len(msgs) < 2 {
+		t.Fatalf("got %d messages for 100 records", len(msgs))
+	}
+	for _, msg := range msgs {
+		if len(msg) > maxMessageSize {
+			t.Errorf("message of %d bytes", len(msg))
+		}
+	}
+	if e.sequence != 100 {
+		t.Errorf("sequence = %d, want 100", e.sequence)
+	}
+}
+
+func TestVarString(t *testing.T) {
+	long := string(make([]byte, 300))
+	b := appendVarString(nil, long)
+	if b[0] != 255 || binary.BigEndian.Uint16(b[1:]) != 300 || len(b) != 303 {
+		t.Errorf("long string header = % x, length %d", b[:3], len(b))
+	}
+}
+
+func TestExportNetFlowV9(t *testing.T) {
+	collector := listen(t)
+	e, err := Dial(collector.LocalAddr().String(), ProtocolNetFlowV9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+	if err := e.Export([]Record{testRecord(), testRecord()}); err != nil {
+		t.Fatal(err)
+	}
+	msg := receive(t, collector)
+
+	be := binary.BigEndian
+	if v, count := be.Uint16(msg[0:]), be.Uint16(msg[2:]); v != v9Version || count != 3 {
+		t.Errorf("version %d, count %d; want 9, 3 (template and two records)", v, count)
+	}
+	tmplLen := be.Uint16(msg[v9HeaderSize+2:])
+	data := msg[v9HeaderSize+int(tmplLen):]
+	if id, l := be.Uint16(data[0:]), be.Uint16(data[2:]); id != v9TemplateID || int(l) != len(data) || l%4 != 0 {
+		t.Errorf("data FlowSet id %d, length %d of %d", id, l, len(data))
+	}
+	if got := be.Uint64(data[4+13:]); got != 1234 {
+		t.Errorf("IN_BYTES = %d", got)
+	}
+}
+
+func TestExportNetFlowV5(t *testing.T) {
+	collector := listen(t)
+	e, err := Dial(collector.LocalAddr().String(), ProtocolNetFlowV5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+
+	big := testRecord()
+	big.Bytes = 1 << 40
+	if err := e.Export([]Record{testRecord(), big}); err != nil {
+		t.Fatal(err)
+	}
+	msg := receive(t, collector)
+
+	be := binary.BigEndian
+	if len(msg) != v5HeaderSize+2*v5RecordSize || be.Uint16(msg[0:]) != v5Version || be.Uint16(msg[2:]) != 2 {
+		t.Fatalf("header = % x, %d bytes", msg[:4], len(msg))
+	}
+	rec := msg[v5HeaderSize:]
+	if be.Uint32(rec[16:]) != 10 || be.Uint32(rec[20:]) != 1234 || be.Uint16(rec[34:]) != 443 || rec[38] != 6 {
+		t.Errorf("record = % x", rec[:v5RecordSize])
+	}
+	if got := be.Uint32(rec[v5RecordSize+20:]); got != 0xFFFFFFFF {
+		t.Errorf("capped octets = %d", got)
+	}
+}
+
+// exportedOctets returns the byte counts of the records in an export
+// packet that starts with a template.
+func exportedOctets(t *testing.T, protocol string, msg []byte) []uint64 {
+	t.Helper()
+	be := binary.BigEndian
+	var octets []uint64
+	switch protocol {
+	case ProtocolIPFIX:
+		sets := msg[ipfixHeaderSize:]
+		data := sets[be.Uint16(sets[2:]):]
+		// Records without an owner end in two empty strings
+		for rec := data[4:be.Uint16(data[2:])]; len(rec) >= 52; rec = rec[52:] {
+			octets = append(octets, be.Uint64(rec[13:]))
+		}
+	case ProtocolNetFlowV9:
+		data := msg[v9HeaderSize+int(be.Uint16(msg[v9HeaderSize+2:])):]
+		for rec := data[4:be.Uint16(data[2:])]; len(rec) >= v9RecordSize; rec = rec[v9RecordSize:] {
+			octets = append(octets, be.Uint64(rec[13:]))
+		}
+	case ProtocolNetFlowV5:
+		for i := range int(be.Uint16(msg[2:])) {
+			octets = append(octets, uint64(be.Uint32(msg[v5HeaderSize+i*v5RecordSize+20:])))
+		}
+	}
+	return octets
+}
+
+func TestExportIPBytes(t *testing.T) {
+	start := time.Unix(1000, 0)
+	for _, protocol := range []string{ProtocolIPFIX, ProtocolNetFlowV9, ProtocolNetFlowV5} {
+		collector := listen(t)
+		e, err := Dial(collector.LocalAddr().String(), protocol)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer e.Close()
+
+		// A handshake and 100 bytes of data: the responder only sends a
+		// SYN-ACK and a pure ACK, which carry no payload
+		conns := tracker.New(10)
+		packets := []struct {
+			out          bool
+			flags        uint8
+			payload, ipl int
+		}{
+			{true, 0x02, 0, 60},
+			{false, 0x12, 0, 60},
+			{true, 0x10, 0, 52},
+			{true, 0x18, 100, 152},
+			{false, 0x10, 0, 52},
+		}
+		for _, p := range packets {
+			src, srcPort, dst, dstPort := "10.0.0.5", uint16(40000), "1.1.1.1", uint16(443)
+			if !p.out {
+				src, srcPort, dst, dstPort = dst, dstPort, src, srcPort
+			}
+			conns.ProcessTCPPacket(src, srcPort, dst, dstPort, p.flags, p.payload, p.ipl, p.out, start)
+		}
+
+		m := NewMeter(e.Export, time.Minute, func(ip string) bool { return ip == "10.0.0.5" })
+		if err := m.Tick(conns.Connections(), start.Add(time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+		got := exportedOctets(t, protocol, receive(t, collector))
+		if !slices.Equal(got, []uint64{264, 112}) {
+			t.Errorf("%s: octets = %v, want [264 112], the IP packets' lengths", protocol, got)
+		}
+	}
+}
+
+func TestDialUnknownProtocol(t *testing.T) {
+	if _, err := Dial("127.0.0.1:2055", "sflow"); err == nil {
+		t.Error("Dial accepted sflow")
+	}
+}
@@ -0,0 +1,148 @@
+package flow
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// EnterpriseNumber is the private enterprise number of the pid, process
+// name and container elements. portlens has no PEN of its own and uses
+// 32473, which RFC 5612 reserves for documentation and examples.
+const EnterpriseNumber = 32473
+
+// Enterprise-specific information elements under EnterpriseNumber.
+const (
+	ElementPID         = 1 // unsigned32
+	ElementProcessName = 2 // string
+	ElementContainerID = 3 // string
+)
+
+const (
+	ipfixVersion    = 10
+	ipfixHeaderSize = 16
+	ipfixTemplateID = 256
+	setIDTemplate   = 2
+
+	enterpriseBit = 0x8000
+	varLength     = 0xFFFF
+)
+
+// field is a template field specifier.
+type field struct {
+	id         uint16
+	length     uint16
+	enterprise uint32 // 0 for IANA elements
+}
+
+// ipfixFields is the template of every IPFIX data record.
+var ipfixFields = []field{
+	{8, 4, 0},   // sourceIPv4Address
+	{12, 4, 0},  // destinationIPv4Address
+	{7, 2, 0},   // sourceTransportPort
+	{11, 2, 0},  // destinationTransportPort
+	{4, 1, 0},   // protocolIdentifier
+	{1, 8, 0},   // octetDeltaCount
+	{2, 8, 0},   // packetDeltaCount
+	{152, 8, 0}, // flowStartMilliseconds
+	{153, 8, 0}, // flowEndMilliseconds
+	{136, 1, 0}, // flowEndReason
+	{ElementPID, 4, EnterpriseNumber},
+	{ElementProcessName, varLength, EnterpriseNumber},
+	{ElementContainerID, varLength, EnterpriseNumber},
+}
+
+// ipfixEncoder encodes IPFIX messages (RFC 7011).
+type ipfixEncoder struct {
+	domain       uint32 // Observation domain ID
+	sequence     uint32 // Data records sent so far
+	lastTemplate time.Time
+}
+
+func (e *ipfixEncoder) encode(records []Record, now time.Time) [][]byte {
+	var msgs [][]byte
+	for len(records) > 0 {
+		msg := make([]byte, ipfixHeaderSize, maxMessageSize)
+		if e.lastTemplate.IsZero() || now.Sub(e.lastTemplate) >= templateRefresh {
+			msg = appendIPFIXTemplate(msg)
+			e.lastTemplate = now
+		}
+
+		setStart := len(msg)
+		msg = binary.BigEndian.AppendUint16(msg, ipfixTemplateID)
+		msg = append(msg, 0, 0) // Set length, filled in below
+		n := 0
+		for ; n < len(records); n++ {
+			data := appendIPFIXRecord(nil, records[n])
+			if n > 0 && len(msg)+len(data) > maxMessageSize {
+				break
+			}
+			msg = append(msg, data...)
+		}
+		binary.BigEndian.PutUint16(msg[setStart+2:], uint16(len(msg)-setStart))
+
+		binary.BigEndian.PutUint16(msg[0:], ipfixVersion)
+		binary.BigEndian.PutUint16(msg[2:], uint16(len(msg)))
+		binary.BigEndian.PutUint32(msg[4:], uint32(now.Unix()))
+		binary.BigEndian.PutUint32(msg[8:], e.sequence)
+		binary.BigEndian.PutUint32(msg[12:], e.domain)
+
+		e.sequence += uint32(n)
+		records = records[n:]
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
+
+// appendIPFIXTemplate appends a template set defining ipfixFields.
+func appendIPFIXTemplate(b []byte) []byte {
+	start := len(b)
+	b = binary.BigEndian.AppendUint16(b, setIDTemplate)
+	b = append(b, 0, 0) // Set length
+	b = binary.BigEndian.AppendUint16(b, ipfixTemplateID)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(ipfixFields)))
+	for _, f := range ipfixFields {
+		if f.enterprise != 0 {
+			b = binary.BigEndian.AppendUint16(b, f.id|enterpriseBit)
+			b = binary.BigEndian.AppendUint16(b, f.length)
+			b = binary.BigEndian.AppendUint32(b, f.enterprise)
+		} else {
+			b = binary.BigEndian.AppendUint16(b, f.id)
+			b = binary.BigEndian.AppendUint16(b, f.length)
+		}
+	}
+	binary.BigEndian.PutUint16(b[start+2:], uint16(len(b)-start))
+	return b
+}
+
+// appendIPFIXRecord appends r in the layout of ipfixFields.
+func appendIPFIXRecord(b []byte, r Record) []byte {
+	b = append(b, r.SrcIP.To4()...)
+	b = append(b, r.DstIP.To4()...)
+	b = binary.BigEndian.AppendUint16(b, r.SrcPort)
+	b = binary.BigEndian.AppendUint16(b, r.DstPort)
+	b = append(b, r.Protocol)
+	b = binary.BigEndian.AppendUint64(b, r.Bytes)
+	b = binary.BigEndian.AppendUint64(b, r.Packets)
+	b = binary.BigEndian.AppendUint64(b, uint64(r.Start.UnixMilli()))
+	b = binary.BigEndian.AppendUint64(b, uint64(r.End.UnixMilli()))
+	b = append(b, byte(r.EndReason))
+	b = binary.BigEndian.AppendUint32(b, uint32(r.PID))
+	b = appendVarString(b, r.Process)
+	b = appendVarString(b, r.Container)
+	return b
+}
+
+// appendVarString appends s as a variable-length field: a one-byte length,
+// or 255 and a two-byte length for long values (RFC 7011 7).
+func appendVarString(b []byte, s string) []byte {
+	if len(s) > 0xFFFF {
+		s = s[:0xFFFF]
+	}
+	if len(s) < 255 {
+		b = append(b, byte(len(s)))
+	} else {
+		b = append(b, 255)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	}
+	return append(b, s...)
+}
@@ -0,0 +1,179 @@
+package flow
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/hwang-fu/portlens/internal/procfs"
+	"github.com/hwang-fu/portlens/internal/tracker"
+)
+
+// maxContainerCache bounds the PID → container ID cache.
+const maxContainerCache = 4096
+
+// meterState is what has been exported of a tracked connection.
+type meterState struct {
+	exported   tracker.Connection // Counters as of the last export
+	lastExport time.Time
+	missing    int // Ticks the connection was absent from the tracker
+}
+
+// Meter turns tracker connections into flow records: when they close, and
+// every active timeout while they stay open. Each export carries the
+// packets and bytes since the previous one, one record per direction.
+// It is safe for concurrent use.
+type Meter struct {
+	send          func([]Record) error
+	activeTimeout time.Duration
+	isLocal       func(ip string) bool
+	container     func(pid int) (string, error)
+
+	mu         sync.Mutex
+	flows      map[tracker.ConnKey]*meterState
+	containers map[int]string
+}
+
+// NewMeter creates a meter that passes records to send. isLocal tells the
+// local end of a connection, whose counters are its sent packets.
+func NewMeter(send func([]Record) error, activeTimeout time.Duration, isLocal func(ip string) bool) *Meter {
+	return &Meter{
+		send:          send,
+		activeTimeout: activeTimeout,
+		isLocal:       isLocal,
+		container:     procfs.ContainerID,
+		flows:         make(map[tracker.ConnKey]*meterState),
+		containers:    make(map[int]string),
+	}
+}
+
+// Closed exports the rest of a connection that ended. reason is the
+// tracker's close reason; "shutdown" marks a forced end.
+func (m *Meter) Closed(conn *tracker.Connection, reason string) error {
+	endReason := EndOfFlow
+	if reason == "shutdown" {
+		endReason = EndForced
+	}
+
+	m.mu.Lock()
+	state := m.flows[conn.Key]
+	delete(m.flows, conn.Key)
+	if state == nil {
+		state = &meterState{lastExport: conn.StartTime}
+	}
+	records := m.records(*conn, state, conn.EndTime, endReason)
+	m.mu.Unlock()
+
+	return m.export(records)
+}
+
+// Tick exports the connections open for longer than the active timeout
+// since their last export. conns are all connections the tracker holds.
+func (m *Meter) Tick(conns []tracker.Connection, now time.Time) error {
+	m.mu.Lock()
+	var records []Record
+	seen := make(map[tracker.ConnKey]bool, len(conns))
+	for _, conn := range conns {
+		seen[conn.Key] = true
+		state := m.flows[conn.Key]
+		if state == nil {
+			state = &meterState{lastExport: conn.StartTime}
+			m.flows[conn.Key] = state
+		}
+		state.missing = 0
+		if now.Sub(state.lastExport) >= m.activeTimeout {
+			records = append(records, m.records(conn, state, now, EndActiveTimeout)...)
+		}
+	}
+
+	// A connection gone from the tracker without a Closed call lost its
+	// close event; its close may also still be queued, so wait a tick
+	for key, state := range m.flows {
+		if !seen[key] {
+			if state.missing++; state.missing > 1 {
+				delete(m.flows, key)
+			}
+		}
+	}
+	m.mu.Unlock()
+
+	return m.export(records)
+}
+
+func (m *Meter) export(records []Record) error {
+	if len(records) == 0 {
+		return nil
+	}
+	return m.send(records)
+}
+
+// records builds the records of conn since its last export up to end and
+// marks them exported. Caller must hold m.mu.
+func (m *Meter) records(conn tracker.Connection, state *meterState, end time.Time, reason EndReason) []Record {
+	local, remote := conn.Key.SrcIP, conn.Key.DstIP
+	localPort, remotePort := conn.Key.SrcPort, conn.Key.DstPort
+	if !m.isLocal(local) && m.isLocal(remote) {
+		local, remote = remote, local
+		localPort, remotePort = remotePort, localPort
+	}
+	localIP, remoteIP := net.ParseIP(local), net.ParseIP(remote)
+
+	base := Record{
+		Protocol:  protocolNumber(conn.Key.Protocol),
+		Start:     state.lastExport,
+		End:       end,
+		EndReason: reason,
+		PID:       conn.PID,
+		Process:   conn.Process,
+		Container: m.containerID(conn.PID),
+	}
+
+	var records []Record
+	if n := conn.PacketsSent - state.exported.PacketsSent; n > 0 {
+		r := base
+		r.SrcIP, r.SrcPort, r.DstIP, r.DstPort = localIP, localPort, remoteIP, remotePort
+		r.Packets, r.Bytes = n, conn.IPBytesSent-state.exported.IPBytesSent
+		records = append(records, r)
+	}
+	if n := conn.PacketsReceived - state.exported.PacketsReceived; n > 0 {
+		r := base
+		r.SrcIP, r.SrcPort, r.DstIP, r.DstPort = remoteIP, remotePort, localIP, localPort
+		r.Packets, r.Bytes = n, conn.IPBytesReceived-state.exported.IPBytesReceived
+		records = append(records, r)
+	}
+
+	state.exported = conn
+	state.lastExport = end
+	return records
+}
+
+// containerID returns the container of pid, cached. Caller must hold m.mu.
+func (m *Meter) containerID(pid int) string {
+	if pid == 0 {
+		return ""
+	}
+	if id, ok := m.containers[pid]; ok {
+		return id
+	}
+	id, err := m.container(pid)
+	if err != nil {
+		// The process is gone; don't cache, the PID may be reused
+		return ""
+	}
+	if len(m.containers) >= maxContainerCache {
+		clear(m.containers)
+	}
+	m.containers[pid] = id
+	return id
+}
+
+// protocolNumber returns the IP protocol number of a tracker protocol.
+func protocolNumber(protocol string) uint8 {
+	switch protocol {
+	case "TCP":
+		return 6
+	case "UDP":
+		return 17
+	}
+	return 0
+}
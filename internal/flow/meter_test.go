@@ -0,0 +1,91 @@
+package flow
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hwang-fu/portlens/internal/tracker"
+)
+
+func newTestMeter(sent *[]Record) *Meter {
+	m := NewMeter(func(records []Record) error {
+		*sent = append(*sent, records...)
+		return nil
+	}, time.Minute, func(ip string) bool { return ip == "10.0.0.5" })
+	m.container = func(pid int) (string, error) { return "c-" + string(rune('0'+pid)), nil }
+	return m
+}
+
+func TestMeterActiveTimeoutAndClose(t *testing.T) {
+	var sent []Record
+	m := newTestMeter(&sent)
+	start := time.Unix(1000, 0)
+
+	// The remote end is the "lower" endpoint of the key
+	conn := tracker.Connection{
+		Key:         tracker.NormalizeKey("10.0.0.5", 40000, "1.1.1.1", 443, "TCP"),
+		StartTime:   start,
+		PacketsSent: 3, IPBytesSent: 300,
+		PacketsReceived: 5, IPBytesReceived: 5000,
+		PID: 7, Process: "curl",
+	}
+
+	if err := m.Tick([]tracker.Connection{conn}, start.Add(30*time.Second)); err != nil || len(sent) != 0 {
+		t.Fatalf("exported %d records before the active timeout (err %v)", len(sent), err)
+	}
+	m.Tick([]tracker.Connection{conn}, start.Add(time.Minute))
+	if len(sent) != 2 {
+		t.Fatalf("active timeout exported %d records, want 2", len(sent))
+	}
+	out, in := sent[0], sent[1]
+	if out.SrcIP.String() != "10.0.0.5" || out.SrcPort != 40000 || out.Packets != 3 || out.Bytes != 300 {
+		t.Errorf("outbound record = %+v", out)
+	}
+	if in.SrcIP.String() != "1.1.1.1" || in.DstPort != 40000 || in.Packets != 5 || in.EndReason != EndActiveTimeout {
+		t.Errorf("inbound record = %+v", in)
+	}
+	if out.Process != "curl" || out.PID != 7 || out.Container != "c-7" || out.Protocol != 6 {
+		t.Errorf("owner = %s[%d] in %q, protocol %d", out.Process, out.PID, out.Container, out.Protocol)
+	}
+
+	// Only what happened since the last export is sent on close
+	sent = nil
+	conn.PacketsReceived, conn.IPBytesReceived = 7, 5500
+	conn.EndTime = start.Add(90 * time.Second)
+	if err := m.Closed(&conn, ""); err != nil {
+		t.Fatal(err)
+	}
+	if len(sent) != 1 || sent[0].Packets != 2 || sent[0].Bytes != 500 || sent[0].EndReason != EndOfFlow {
+		t.Fatalf("close exported %+v", sent)
+	}
+	if !sent[0].Start.Equal(start.Add(time.Minute)) || !sent[0].End.Equal(conn.EndTime) {
+		t.Errorf("close record spans %v to %v", sent[0].Start, sent[0].End)
+	}
+}
+
+func TestMeterShutdownAndLostClose(t *testing.T) {
+	var sent []Record
+	m := newTestMeter(&sent)
+	start := time.Unix(1000, 0)
+	conn := tracker.Connection{
+		Key:       tracker.NormalizeKey("10.0.0.5", 40000, "1.1.1.1", 443, "TCP"),
+		StartTime: start, EndTime: start.Add(time.Second),
+		PacketsSent: 1, IPBytesSent: 60,
+	}
+
+	m.Closed(&conn, "shutdown")
+	if len(sent) != 1 || sent[0].EndReason != EndForced || !sent[0].Start.Equal(start) {
+		t.Fatalf("shutdown exported %+v", sent)
+	}
+
+	// A connection whose close event was dropped is forgotten after two ticks
+	m.Tick([]tracker.Connection{conn}, start)
+	m.Tick(nil, start.Add(time.Second))
+	if len(m.flows) != 1 {
+		t.Fatalf("forgot the connection after one tick")
+	}
+	m.Tick(nil, start.Add(2*time.Second))
+	if len(m.flows) != 0 {
+		t.Errorf("still remembering %d connections", len(m.flows))
+	}
+}
@@ -0,0 +1,155 @@
+package flow
+
+import (
+	"encoding/binary"
+	"math"
+	"time"
+)
+
+const (
+	v9Version       = 9
+	v9HeaderSize    = 20
+	v9TemplateID    = 256
+	flowSetTemplate = 0
+)
+
+// v9Fields is the template of every NetFlow v9 data record. v9 has no
+// enterprise or variable-length fields, so the process elements are
+// IPFIX only.
+var v9Fields = []field{
+	{8, 4, 0},  // IPV4_SRC_ADDR
+	{12, 4, 0}, // IPV4_DST_ADDR
+	{7, 2, 0},  // L4_SRC_PORT
+	{11, 2, 0}, // L4_DST_PORT
+	{4, 1, 0},  // PROTOCOL
+	{1, 8, 0},  // IN_BYTES
+	{2, 8, 0},  // IN_PKTS
+	{22, 4, 0}, // FIRST_SWITCHED
+	{21, 4, 0}, // LAST_SWITCHED
+}
+
+// v9RecordSize is the length of a v9 data record.
+const v9RecordSize = 37
+
+// v9Encoder encodes NetFlow v9 export packets (RFC 3954).
+type v9Encoder struct {
+	start        time.Time // System uptime zero
+	sourceID     uint32
+	sequence     uint32 // Export packets sent so far
+	lastTemplate time.Time
+}
+
+func (e *v9Encoder) encode(records []Record, now time.Time) [][]byte {
+	var msgs [][]byte
+	for len(records) > 0 {
+		msg := make([]byte, v9HeaderSize, maxMessageSize)
+		count := 0
+		if e.lastTemplate.IsZero() || now.Sub(e.lastTemplate) >= templateRefresh {
+			msg = appendV9Template(msg)
+			e.lastTemplate = now
+			count++
+		}
+
+		setStart := len(msg)
+		msg = binary.BigEndian.AppendUint16(msg, v9TemplateID)
+		msg = append(msg, 0, 0) // FlowSet length
+		n := min(len(records), (maxMessageSize-len(msg)-3)/v9RecordSize)
+		for _, r := range records[:n] {
+			msg = append(msg, r.SrcIP.To4()...)
+			msg = append(msg, r.DstIP.To4()...)
+			msg = binary.BigEndian.AppendUint16(msg, r.SrcPort)
+			msg = binary.BigEndian.AppendUint16(msg, r.DstPort)
+			msg = append(msg, r.Protocol)
+			msg = binary.BigEndian.AppendUint64(msg, r.Bytes)
+			msg = binary.BigEndian.AppendUint64(msg, r.Packets)
+			msg = binary.BigEndian.AppendUint32(msg, uptimeMillis(e.start, r.Start))
+			msg = binary.BigEndian.AppendUint32(msg, uptimeMillis(e.start, r.End))
+		}
+		// FlowSets are padded to a 4-byte boundary
+		for (len(msg)-setStart)%4 != 0 {
+			msg = append(msg, 0)
+		}
+		binary.BigEndian.PutUint16(msg[setStart+2:], uint16(len(msg)-setStart))
+		count += n
+
+		binary.BigEndian.PutUint16(msg[0:], v9Version)
+		binary.BigEndian.PutUint16(msg[2:], uint16(count))
+		binary.BigEndian.PutUint32(msg[4:], uptimeMillis(e.start, now))
+		binary.BigEndian.PutUint32(msg[8:], uint32(now.Unix()))
+		binary.BigEndian.PutUint32(msg[12:], e.sequence)
+		binary.BigEndian.PutUint32(msg[16:], e.sourceID)
+
+		e.sequence++
+		records = records[n:]
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
+
+// appendV9Template appends a template FlowSet defining v9Fields.
+func appendV9Template(b []byte) []byte {
+	start := len(b)
+	b = binary.BigEndian.AppendUint16(b, flowSetTemplate)
+	b = append(b, 0, 0) // FlowSet length
+	b = binary.BigEndian.AppendUint16(b, v9TemplateID)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(v9Fields)))
+	for _, f := range v9Fields {
+		b = binary.BigEndian.AppendUint16(b, f.id)
+		b = binary.BigEndian.AppendUint16(b, f.length)
+	}
+	binary.BigEndian.PutUint16(b[start+2:], uint16(len(b)-start))
+	return b
+}
+
+const (
+	v5Version    = 5
+	v5HeaderSize = 24
+	v5RecordSize = 48
+	v5MaxRecords = 30
+)
+
+// v5Encoder encodes NetFlow v5 export packets. v5 has a fixed record
+// layout with 32-bit counters, which are capped rather than wrapped.
+type v5Encoder struct {
+	start    time.Time // System uptime zero
+	sequence uint32    // Flows sent so far
+}
+
+func (e *v5Encoder) encode(records []Record, now time.Time) [][]byte {
+	var msgs [][]byte
+	for len(records) > 0 {
+		n := min(len(records), v5MaxRecords)
+		msg := make([]byte, v5HeaderSize, v5HeaderSize+n*v5RecordSize)
+		binary.BigEndian.PutUint16(msg[0:], v5Version)
+		binary.BigEndian.PutUint16(msg[2:], uint16(n))
+		binary.BigEndian.PutUint32(msg[4:], uptimeMillis(e.start, now))
+		binary.BigEndian.PutUint32(msg[8:], uint32(now.Unix()))
+		binary.BigEndian.PutUint32(msg[12:], uint32(now.Nanosecond()))
+		binary.BigEndian.PutUint32(msg[16:], e.sequence)
+		// Engine type and ID, sampling interval: all zero
+
+		for _, r := range records[:n] {
+			rec := make([]byte, v5RecordSize)
+			copy(rec[0:], r.SrcIP.To4())
+			copy(rec[4:], r.DstIP.To4())
+			// Next hop, SNMP interfaces: unknown
+			binary.BigEndian.PutUint32(rec[16:], cap32(r.Packets))
+			binary.BigEndian.PutUint32(rec[20:], cap32(r.Bytes))
+			binary.BigEndian.PutUint32(rec[24:], uptimeMillis(e.start, r.Start))
+			binary.BigEndian.PutUint32(rec[28:], uptimeMillis(e.start, r.End))
+			binary.BigEndian.PutUint16(rec[32:], r.SrcPort)
+			binary.BigEndian.PutUint16(rec[34:], r.DstPort)
+			rec[38] = r.Protocol
+			msg = append(msg, rec...)
+		}
+
+		e.sequence += uint32(n)
+		records = records[n:]
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
+
+func cap32(v uint64) uint32 {
+	return uint32(min(v, math.MaxUint32))
+}
@@ -0,0 +1,248 @@
+package history
+
+import (
+	"bytes"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hwang-fu/portlens/internal/parser"
+	"github.com/hwang-fu/portlens/internal/procfs"
+	"github.com/hwang-fu/portlens/internal/stats"
+	"github.com/hwang-fu/portlens/internal/tracker"
+)
+
+var base = time.Date(2025, 12, 24, 14, 0, 0, 0, time.UTC)
+
+// fill writes a small capture: curl talking to 10.2.3.4 after resolving
+// it, and an unrelated connection of ssh.
+func fill(t *testing.T, path string) {
+	t.Helper()
+	var reports []error
+	s, err := Open(path, func(err error) { reports = append(reports, err) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.readDetails = func(pid int) procfs.Details {
+		return procfs.Details{Exe: "/usr/bin/curl", Cmdline: "curl https://example.com", UID: 1000}
+	}
+
+	s.Process(42, "curl", base)
+	s.Process(42, "curl", base.Add(time.Second)) // Known already
+	s.DNS(DNSResponse{
+		Time:       base.Add(time.Second),
+		ClientIP:   net.ParseIP("192.168.1.10"),
+		ClientPort: 40000,
+		ServerIP:   net.ParseIP("192.168.1.1"),
+		ServerPort: 53,
+		Message: &parser.DNSMessage{
+			ID:        7,
+			Response:  true,
+			Questions: []parser.DNSQuestion{{Name: "www.example.com", Type: parser.DNSTypeA, Class: 1}},
+			Answers: []parser.DNSRecord{
+				{Name: "www.example.com", Type: parser.DNSTypeCNAME, TTL: 60, Data: "example.com"},
+				{Name: "example.com", Type: parser.DNSTypeA, TTL: 60, Data: "10.2.3.4"},
+			},
+		},
+		PID:     42,
+		Process: "curl",
+	})
+	// The loopback copy of the same response
+	s.DNS(DNSResponse{
+		Time:       base.Add(time.Second + time.Millisecond),
+		ClientIP:   net.ParseIP("192.168.1.10"),
+		ClientPort: 40000,
+		ServerIP:   net.ParseIP("192.168.1.1"),
+		ServerPort: 53,
+		Message:    &parser.DNSMessage{ID: 7, Response: true},
+	})
+
+	s.Connection(&tracker.Connection{
+		Key:         tracker.ConnKey{SrcIP: "10.2.3.4", SrcPort: 443, DstIP: "192.168.1.10", DstPort: 50000, Protocol: "TCP"},
+		StartTime:   base.Add(2 * time.Second),
+		EndTime:     base.Add(12 * time.Second),
+		PID:         42,
+		Process:     "curl",
+		OrigIP:      "192.168.1.10",
+		OrigPort:    50000,
+		OrigPackets: 10,
+		OrigBytes:   500,
+		RespPackets: 12,
+		RespBytes:   9000,
+		History:     "ShADadFf",
+	}, "fin")
+	s.Connection(&tracker.Connection{
+		Key:       tracker.ConnKey{SrcIP: "192.168.1.10", SrcPort: 50001, DstIP: "10.9.9.9", DstPort: 22, Protocol: "TCP"},
+		StartTime: base.Add(-2 * time.Hour),
+		EndTime:   base.Add(-time.Hour),
+		PID:       99,
+		Process:   "ssh",
+		OrigIP:    "192.168.1.10",
+		OrigPort:  50001,
+		OrigBytes: 100,
+		History:   "ShADadFf",
+	}, "fin")
+	s.Stats(stats.Totals{Time: base.Add(10 * time.Second), Packets: 22, Bytes: 9500, PacketsPerSec: 2.2})
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) > 0 || s.Dropped() != 0 {
+		t.Fatalf("write errors %v, dropped %d", reports, s.Dropped())
+	}
+}
+
+func TestStoreAndQuery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+	fill(t, path)
+
+	r, err := OpenReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// Which processes talked to 10.2.3.4 between 14:00 and 15:00?
+	res, err := r.Canned("talkers", Params{IP: "10.2.3.4", Since: base, Until: base.Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Rows) != 1 {
+		t.Fatalf("talkers = %v, want curl only", res.Rows)
+	}
+	row := rowMap(res, 0)
+	if row["process"] != "curl" || row["pid"] != int64(42) || row["exe"] != "/usr/bin/curl" ||
+		row["uid"] != int64(1000) || row["bytes"] != int64(9500) || row["responders"] != "10.2.3.4:443" {
+		t.Errorf("talkers row = %v", row)
+	}
+
+	res, err = r.Canned("connections", Params{Since: base.Add(-90 * time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Rows) != 2 {
+		t.Fatalf("got %d connections, want 2", len(res.Rows))
+	}
+	row = rowMap(res, 0)
+	// The originator is stored first, whatever the key's order
+	if row["orig_ip"] != "192.168.1.10" || row["resp_ip"] != "10.2.3.4" || row["resp_port"] != int64(443) ||
+		row["duration"] != 10.0 || row["start_time"] != "2025-12-24T14:00:02.000Z" {
+		t.Errorf("connection row = %v", row)
+	}
+
+	res, err = r.Canned("dns", Params{Name: "example.com."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Rows) != 1 {
+		t.Fatalf("got %d DNS responses, want 1", len(res.Rows))
+	}
+	if row := rowMap(res, 0); row["answers"] != "example.com 10.2.3.4" || row["qtype"] != "A" || row["rcode"] != "NOERROR" {
+		t.Errorf("dns row = %v", row)
+	}
+	if res, _ := r.Canned("dns", Params{IP: "10.2.3.4"}); len(res.Rows) != 1 {
+		t.Errorf("DNS responses answering 10.2.3.4: %v", res.Rows)
+	}
+
+	res, err = r.Canned("processes", Params{Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Rows) != 1 || rowMap(res, 0)["process"] != "curl" {
+		t.Errorf("processes = %v", res.Rows)
+	}
+
+	res, err = r.SQL("SELECT packets, packets_per_sec FROM stats WHERE time >= :since", Params{Since: base})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Rows) != 1 || res.Rows[0][0] != int64(22) || res.Rows[0][1] != 2.2 {
+		t.Errorf("stats = %v", res.Rows)
+	}
+
+	if _, err := r.Canned("nope", Params{}); err == nil {
+		t.Error("expected error for an unknown query")
+	}
+	if _, err := r.SQL("DELETE FROM stats", Params{}); err == nil {
+		t.Error("expected error writing to a read-only database")
+	}
+}
+
+func TestReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+	fill(t, path)
+	fill(t, path)
+
+	r, err := OpenReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	res, err := r.SQL("SELECT (SELECT count(*) FROM processes), (SELECT count(*) FROM connections)", Params{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Processes are upserted, the rest is appended
+	if res.Rows[0][0] != int64(2) || res.Rows[0][1] != int64(4) {
+		t.Errorf("processes, connections = %v, want 2 and 4", res.Rows[0])
+	}
+}
+
+func TestOpenErrors(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := OpenReader(filepath.Join(dir, "missing.db")); err == nil {
+		t.Error("expected error reading a missing database")
+	}
+
+	// A database of something else is left alone
+	other := filepath.Join(dir, "other.db")
+	db, err := openDB(other, true)
+	if err == nil {
+		db.Close()
+		t.Fatal("expected error opening a missing database read-only")
+	}
+	s, err := Open(other, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.db.Exec("PRAGMA user_version = 99")
+	s.Close()
+	if _, err := Open(other, nil); err == nil || !strings.Contains(err.Error(), "newer") {
+		t.Errorf("Open of a newer schema: %v", err)
+	}
+}
+
+func TestResultWriters(t *testing.T) {
+	res := &Result{
+		Columns: []string{"process", "bytes", "rate", "exe"},
+		Rows:    [][]any{{"cu\trl", int64(9500), 2.5, nil}},
+	}
+
+	var buf bytes.Buffer
+	res.WriteTable(&buf)
+	if buf.String() != "PROCESS  BYTES  RATE  EXE\ncu rl    9500   2.5   -\n" {
+		t.Errorf("table = %q", buf.String())
+	}
+
+	buf.Reset()
+	res.WriteCSV(&buf)
+	if buf.String() != "process,bytes,rate,exe\ncu\trl,9500,2.5,\n" {
+		t.Errorf("csv = %q", buf.String())
+	}
+
+	buf.Reset()
+	res.WriteJSON(&buf)
+	if buf.String() != `{"process":"cu\trl","bytes":9500,"rate":2.5,"exe":null}`+"\n" {
+		t.Errorf("json = %q", buf.String())
+	}
+}
+
+func rowMap(res *Result, i int) map[string]any {
+	m := make(map[string]any)
+	for j, c := range res.Columns {
+		m[c] = res.Rows[i][j]
+	}
+	return m
+}
@@ -0,0 +1,290 @@
+package history
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"unicode"
+)
+
+// Params narrow down a canned query. Zero values don't filter.
+type Params struct {
+	Since   time.Time // Activity at or after
+	Until   time.Time // Activity before
+	IP      string    // Either endpoint, or a DNS answer
+	Port    int       // Either endpoint
+	Process string    // Process name
+	Name    string    // DNS name, matched as a suffix, e.g. "example.com"
+	Limit   int       // Most rows returned, 0 for all
+}
+
+// Canned is a query that answers a common question.
+type Canned struct {
+	Name        string
+	Description string
+	SQL         string
+}
+
+// CannedQueries are the queries of `portlens query <name>`. They take
+// named parameters from Params.
+var CannedQueries = []Canned{
+	{
+		Name:        "talkers",
+		Description: "processes with connections in the window, by bytes",
+		SQL: `
+SELECT p.name AS process, p.pid, p.exe, p.uid,
+	count(*) AS connections,
+	sum(c.orig_bytes + c.resp_bytes) AS bytes,
+	min(c.start_time) AS first_seen, max(c.end_time) AS last_seen,
+	group_concat(DISTINCT c.resp_ip || ':' || c.resp_port) AS responders
+FROM connections c JOIN processes p ON p.id = c.process_id
+WHERE c.start_time < :until AND c.end_time >= :since` + connFilter + `
+GROUP BY p.id
+ORDER BY bytes DESC`,
+	},
+	{
+		Name:        "connections",
+		Description: "connections active in the window, newest first",
+		SQL: `
+SELECT c.start_time, c.end_time, round(c.duration, 3) AS duration, c.protocol,
+	c.orig_ip, c.orig_port, c.resp_ip, c.resp_port, c.state, c.history,
+	c.orig_bytes, c.resp_bytes, p.pid, p.name AS process
+FROM connections c LEFT JOIN processes p ON p.id = c.process_id
+WHERE c.start_time < :until AND c.end_time >= :since` + connFilter + `
+ORDER BY c.start_time DESC`,
+	},
+	{
+		Name:        "dns",
+		Description: "DNS responses in the window, with their answers",
+		SQL: `
+SELECT r.time, r.client_ip, r.server_ip, r.query, r.qtype, r.rcode,
+	(SELECT group_concat(a.data, ' ') FROM dns_answers a WHERE a.response_id = r.id) AS answers,
+	p.pid, p.name AS process
+FROM dns_responses r LEFT JOIN processes p ON p.id = r.process_id
+WHERE r.time >= :since AND r.time < :until
+	AND (:ip = '' OR r.client_ip = :ip OR r.server_ip = :ip
+		OR EXISTS (SELECT 1 FROM dns_answers a WHERE a.response_id = r.id AND a.data = :ip))
+	AND (:port = 0 OR r.client_port = :port OR r.server_port = :port)
+	AND (:process = '' OR p.name = :process)
+	AND (:name = '' OR r.query = :name OR r.query LIKE '%.' || :name)
+ORDER BY r.time DESC`,
+	},
+	{
+		Name:        "processes",
+		Description: "processes seen in the window",
+		SQL: `
+SELECT p.pid, p.name AS process, p.exe, p.cmdline, p.uid, p.container_id, p.first_seen, p.last_seen
+FROM processes p
+WHERE p.first_seen < :until AND p.last_seen >= :since
+	AND (:process = '' OR p.name = :process)
+ORDER BY p.first_seen DESC`,
+	},
+	{
+		Name:        "stats",
+		Description: "capture stats in the window",
+		SQL: `
+SELECT time, packets, bytes, round(packets_per_sec, 1) AS packets_per_sec,
+	round(bytes_per_sec, 1) AS bytes_per_sec, parse_errors, filtered, duplicates,
+	kernel_drops, event_drops
+FROM stats
+WHERE time >= :since AND time < :until
+ORDER BY time DESC`,
+	},
+}
+
+// connFilter narrows connections down by endpoint and process.
+const connFilter = `
+	AND (:ip = '' OR c.orig_ip = :ip OR c.resp_ip = :ip)
+	AND (:port = 0 OR c.orig_port = :port OR c.resp_port = :port)
+	AND (:process = '' OR p.name = :process)`
+
+// Result is the outcome of a query.
+type Result struct {
+	Columns []string
+	Rows    [][]any
+}
+
+// Reader queries a history database.
+type Reader struct {
+	db *sql.DB
+}
+
+// OpenReader opens a history database for queries. It may be written to
+// by a running capture at the same time.
+func OpenReader(path string) (*Reader, error) {
+	// SQLite's own error for a missing file is unhelpful
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	db, err := openDB(path, true)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{db: db}, nil
+}
+
+// Close closes the database.
+func (r *Reader) Close() error {
+	return r.db.Close()
+}
+
+// Canned runs a canned query by name.
+func (r *Reader) Canned(name string, p Params) (*Result, error) {
+	for _, q := range CannedQueries {
+		if q.Name == name {
+			return r.query(q.SQL, p)
+		}
+	}
+	return nil, fmt.Errorf("unknown query %q", name)
+}
+
+// SQL runs an ad-hoc query. It may use the same named parameters as the
+// canned queries, e.g. WHERE start_time >= :since.
+func (r *Reader) SQL(query string, p Params) (*Result, error) {
+	return r.query(query, p)
+}
+
+func (r *Reader) query(query string, p Params) (*Result, error) {
+	since, until := "", "9999"
+	if !p.Since.IsZero() {
+		since = formatTime(p.Since)
+	}
+	if !p.Until.IsZero() {
+		until = formatTime(p.Until)
+	}
+	if p.Limit > 0 {
+		query = strings.TrimRight(strings.TrimSpace(query), ";")
+		query = fmt.Sprintf("SELECT * FROM (%s) LIMIT %d", query, p.Limit)
+	}
+
+	// Only the parameters the query uses may be passed
+	named := []sql.NamedArg{
+		sql.Named("since", since),
+		sql.Named("until", until),
+		sql.Named("ip", p.IP),
+		sql.Named("port", p.Port),
+		sql.Named("process", p.Process),
+		sql.Named("name", strings.TrimSuffix(p.Name, ".")),
+	}
+	var args []any
+	for _, a := range named {
+		if strings.Contains(query, ":"+a.Name) {
+			args = append(args, a)
+		}
+	}
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	defer rows.Close()
+
+	res := &Result{}
+	if res.Columns, err = rows.Columns(); err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	for rows.Next() {
+		row := make([]any, len(res.Columns))
+		ptrs := make([]any, len(row))
+		for i := range row {
+			ptrs[i] = &row[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, fmt.Errorf("query: %w", err)
+		}
+		for i, v := range row {
+			if b, ok := v.([]byte); ok {
+				row[i] = string(b)
+			}
+		}
+		res.Rows = append(res.Rows, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	return res, nil
+}
+
+// WriteTable writes a result as aligned columns.
+func (res *Result) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.ToUpper(strings.Join(res.Columns, "\t")))
+	for _, row := range res.Rows {
+		cells := make([]string, len(row))
+		for i, v := range row {
+			// Line breaks, e.g. in a command line, would break the columns
+			cells[i] = strings.Map(func(r rune) rune {
+				if unicode.IsControl(r) {
+					return ' '
+				}
+				return r
+			}, cell(v))
+			if cells[i] == "" {
+				cells[i] = "-"
+			}
+		}
+		fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	}
+	return tw.Flush()
+}
+
+// WriteCSV writes a result as CSV with a header row.
+func (res *Result) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write(res.Columns)
+	for _, row := range res.Rows {
+		cells := make([]string, len(row))
+		for i, v := range row {
+			cells[i] = cell(v)
+		}
+		cw.Write(cells)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteJSON writes a result as one JSON object per row.
+func (res *Result) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	for _, row := range res.Rows {
+		// Keep the column order of the query
+		var b strings.Builder
+		b.WriteByte('{')
+		for i, v := range row {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			key, _ := json.Marshal(res.Columns[i])
+			value, err := json.Marshal(v)
+			if err != nil {
+				return err
+			}
+			b.Write(key)
+			b.WriteByte(':')
+			b.Write(value)
+		}
+		b.WriteByte('}')
+		if err := enc.Encode(json.RawMessage(b.String())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cell renders a value for text output. NULL is empty.
+func cell(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
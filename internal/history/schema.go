@@ -0,0 +1,168 @@
+// Package history keeps a queryable history of a capture in an SQLite
+// database: connections, DNS answers, the processes behind them and
+// periodic stats.
+package history
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	_ "modernc.org/sqlite" // Registers the "sqlite" driver
+)
+
+// schemaVersion is stored as the database's user_version. It is raised
+// whenever the schema changes.
+const schemaVersion = 1
+
+// TimeLayout is how times are stored: UTC, so that they sort and compare
+// as text, e.g. start_time >= '2025-12-24T14:00'.
+const TimeLayout = "2006-01-02T15:04:05.000Z"
+
+// schema creates the tables of schemaVersion.
+const schema = `
+CREATE TABLE processes (
+	id           INTEGER PRIMARY KEY,
+	pid          INTEGER NOT NULL,
+	name         TEXT NOT NULL,
+	exe          TEXT,
+	cmdline      TEXT,
+	uid          INTEGER,
+	container_id TEXT,
+	first_seen   TEXT NOT NULL,
+	last_seen    TEXT NOT NULL,
+	UNIQUE (pid, name)
+);
+CREATE INDEX processes_name ON processes (name);
+
+CREATE TABLE connections (
+	id           INTEGER PRIMARY KEY,
+	start_time   TEXT NOT NULL,
+	end_time     TEXT NOT NULL,
+	duration     REAL NOT NULL, -- Seconds
+	protocol     TEXT NOT NULL,
+	orig_ip      TEXT NOT NULL, -- The side that opened the connection
+	orig_port    INTEGER NOT NULL,
+	resp_ip      TEXT NOT NULL,
+	resp_port    INTEGER NOT NULL,
+	state        TEXT NOT NULL, -- TCP state when it closed
+	history      TEXT NOT NULL,
+	close_reason TEXT,
+	orig_packets INTEGER NOT NULL,
+	orig_bytes   INTEGER NOT NULL, -- Payload bytes
+	resp_packets INTEGER NOT NULL,
+	resp_bytes   INTEGER NOT NULL,
+	process_id   INTEGER REFERENCES processes (id)
+);
+CREATE INDEX connections_start ON connections (start_time);
+CREATE INDEX connections_end ON connections (end_time);
+CREATE INDEX connections_orig ON connections (orig_ip, start_time);
+CREATE INDEX connections_resp ON connections (resp_ip, start_time);
+CREATE INDEX connections_process ON connections (process_id);
+
+CREATE TABLE dns_responses (
+	id          INTEGER PRIMARY KEY,
+	time        TEXT NOT NULL,
+	client_ip   TEXT NOT NULL,
+	client_port INTEGER NOT NULL,
+	server_ip   TEXT NOT NULL,
+	server_port INTEGER NOT NULL,
+	trans_id    INTEGER NOT NULL,
+	query       TEXT,
+	qtype       TEXT,
+	rcode       TEXT NOT NULL,
+	process_id  INTEGER REFERENCES processes (id)
+);
+CREATE INDEX dns_responses_time ON dns_responses (time);
+CREATE INDEX dns_responses_query ON dns_responses (query);
+
+CREATE TABLE dns_answers (
+	response_id INTEGER NOT NULL REFERENCES dns_responses (id),
+	name        TEXT NOT NULL,
+	type        TEXT NOT NULL,
+	ttl         INTEGER NOT NULL,
+	data        TEXT NOT NULL -- Address, name or text, as in dig
+);
+CREATE INDEX dns_answers_response ON dns_answers (response_id);
+CREATE INDEX dns_answers_data ON dns_answers (data);
+
+CREATE TABLE stats (
+	time            TEXT NOT NULL,
+	packets         INTEGER NOT NULL, -- Since the capture started
+	bytes           INTEGER NOT NULL,
+	packets_per_sec REAL NOT NULL,    -- Over the last 10 seconds
+	bytes_per_sec   REAL NOT NULL,
+	parse_errors    INTEGER NOT NULL,
+	filtered        INTEGER NOT NULL,
+	duplicates      INTEGER NOT NULL,
+	kernel_drops    INTEGER NOT NULL,
+	event_drops     INTEGER NOT NULL
+);
+CREATE INDEX stats_time ON stats (time);
+`
+
+// openDB opens a database and checks or creates its schema. A read-only
+// database must already have the current schema.
+func openDB(path string, readOnly bool) (*sql.DB, error) {
+	dsn := "file:" + path + "?_pragma=busy_timeout(5000)&_pragma=foreign_keys(1)"
+	if readOnly {
+		dsn += "&mode=ro"
+	} else {
+		dsn += "&_pragma=journal_mode(WAL)&_pragma=synchronous(NORMAL)"
+	}
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", path, err)
+	}
+	// One connection keeps the pragmas and serializes writes
+	db.SetMaxOpenConns(1)
+
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("open %s: %w", path, err)
+	}
+	switch {
+	case version == schemaVersion:
+		return db, nil
+	case version > schemaVersion:
+		db.Close()
+		return nil, fmt.Errorf("open %s: schema version %d is newer than this portlens (%d)", path, version, schemaVersion)
+	case version == 0 && !readOnly:
+		if err := createSchema(db); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("open %s: %w", path, err)
+		}
+		return db, nil
+	}
+	db.Close()
+	return nil, fmt.Errorf("open %s: not a portlens history database", path)
+}
+
+func createSchema(db *sql.DB) error {
+	var tables int
+	if err := db.QueryRow("SELECT count(*) FROM sqlite_master").Scan(&tables); err != nil {
+		return err
+	}
+	if tables > 0 {
+		return fmt.Errorf("not a portlens history database")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(schema); err != nil {
+		return fmt.Errorf("create schema: %w", err)
+	}
+	if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", schemaVersion)); err != nil {
+		return fmt.Errorf("create schema: %w", err)
+	}
+	return tx.Commit()
+}
+
+// formatTime renders a time as it is stored.
+func formatTime(t time.Time) string {
+	return t.UTC().Format(TimeLayout)
+}
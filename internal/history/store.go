@@ -0,0 +1,366 @@
+package history
+
+import (
+	"database/sql"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hwang-fu/portlens/internal/parser"
+	"github.com/hwang-fu/portlens/internal/procfs"
+	"github.com/hwang-fu/portlens/internal/stats"
+	"github.com/hwang-fu/portlens/internal/tracker"
+)
+
+const (
+	// queueSize bounds the writes waiting for the database; past it, new
+	// ones are dropped.
+	queueSize = 8192
+
+	// maxBatch is the most writes committed in one transaction.
+	maxBatch = 1000
+
+	// maxKnown bounds the processes and DNS responses remembered to skip
+	// repeated work; past it, they are forgotten.
+	maxKnown = 65536
+
+	// duplicateWindow is how close together two copies of a DNS response
+	// must be to be stored once, e.g. both copies seen on loopback.
+	duplicateWindow = time.Second
+)
+
+// op is a write, run in the writer's transaction.
+type op func(tx *sql.Tx) error
+
+// procKey identifies a process row.
+type procKey struct {
+	pid  int
+	name string
+}
+
+// dnsKey identifies a DNS response for duplicate detection.
+type dnsKey struct {
+	client, server string
+	clientPort     uint16
+	id             uint16
+}
+
+// Store writes a capture's history to an SQLite database in the
+// background. Writes never block the caller: those that don't fit in the
+// queue are dropped and counted.
+type Store struct {
+	db     *sql.DB
+	report func(error)
+
+	// readDetails reads what /proc knows of a new process.
+	readDetails func(pid int) procfs.Details
+
+	queue   chan op
+	done    chan struct{}
+	dropped atomic.Uint64
+
+	mu     sync.RWMutex // Guards sends on queue against Close
+	closed bool
+
+	knownMu sync.Mutex
+	known   map[procKey]bool     // Processes whose details were read
+	recent  map[dnsKey]time.Time // DNS responses stored lately
+
+	// ids caches process row IDs. Only the writer goroutine uses it.
+	ids map[procKey]int64
+}
+
+// Open opens or creates the history database at path and starts writing.
+// report, if not nil, is called with every failed write.
+func Open(path string, report func(error)) (*Store, error) {
+	db, err := openDB(path, false)
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{
+		db:          db,
+		report:      report,
+		readDetails: procfs.ReadDetails,
+		queue:       make(chan op, queueSize),
+		done:        make(chan struct{}),
+		known:       make(map[procKey]bool),
+		recent:      make(map[dnsKey]time.Time),
+		ids:         make(map[procKey]int64),
+	}
+	go s.run()
+	return s, nil
+}
+
+// Dropped returns how many writes were dropped because the queue was
+// full or their transaction failed.
+func (s *Store) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Close writes what is queued and closes the database.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.queue)
+	s.mu.Unlock()
+
+	<-s.done
+	return s.db.Close()
+}
+
+// enqueue queues a write, dropping it if the queue is full.
+func (s *Store) enqueue(o op) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.queue <- o:
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+// run commits queued writes in batches until the queue is closed.
+func (s *Store) run() {
+	defer close(s.done)
+	for o := range s.queue {
+		batch := []op{o}
+	fill:
+		for len(batch) < maxBatch {
+			select {
+			case o, ok := <-s.queue:
+				if !ok {
+					break fill
+				}
+				batch = append(batch, o)
+			default:
+				break fill
+			}
+		}
+		if err := s.commit(batch); err != nil {
+			s.dropped.Add(uint64(len(batch)))
+			// Row IDs cached in the rolled-back transaction are gone
+			clear(s.ids)
+			if s.report != nil {
+				s.report(fmt.Errorf("history: %w", err))
+			}
+		}
+	}
+}
+
+func (s *Store) commit(batch []op) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, o := range batch {
+		if err := o(tx); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Process records a process seen on the wire. Its details are read from
+// /proc the first time, while it is most likely still running.
+func (s *Store) Process(pid int, name string, ts time.Time) {
+	key := procKey{pid, name}
+	s.knownMu.Lock()
+	if s.known[key] {
+		s.knownMu.Unlock()
+		return
+	}
+	if len(s.known) >= maxKnown {
+		clear(s.known)
+	}
+	s.known[key] = true
+	s.knownMu.Unlock()
+
+	d := s.readDetails(pid)
+	s.enqueue(func(tx *sql.Tx) error {
+		var uid any
+		if d.UID >= 0 {
+			uid = d.UID
+		}
+		var id int64
+		err := tx.QueryRow(`
+			INSERT INTO processes (pid, name, exe, cmdline, uid, container_id, first_seen, last_seen)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (pid, name) DO UPDATE SET
+				exe = coalesce(excluded.exe, exe),
+				cmdline = coalesce(excluded.cmdline, cmdline),
+				uid = coalesce(excluded.uid, uid),
+				container_id = coalesce(excluded.container_id, container_id),
+				last_seen = max(last_seen, excluded.last_seen)
+			RETURNING id`,
+			pid, name, nullString(d.Exe), nullString(d.Cmdline), uid, nullString(d.ContainerID),
+			formatTime(ts), formatTime(ts)).Scan(&id)
+		if err != nil {
+			return fmt.Errorf("insert process: %w", err)
+		}
+		s.ids[key] = id
+		return nil
+	})
+}
+
+// processID returns the row of a process, creating it if needed, and
+// notes it was seen at ts. It returns nil for an unknown process.
+func (s *Store) processID(tx *sql.Tx, pid int, name string, ts string) (any, error) {
+	if pid == 0 {
+		return nil, nil
+	}
+	key := procKey{pid, name}
+	if id, ok := s.ids[key]; ok {
+		_, err := tx.Exec("UPDATE processes SET last_seen = max(last_seen, ?) WHERE id = ?", ts, id)
+		return id, err
+	}
+
+	var id int64
+	err := tx.QueryRow(`
+		INSERT INTO processes (pid, name, first_seen, last_seen) VALUES (?, ?, ?, ?)
+		ON CONFLICT (pid, name) DO UPDATE SET last_seen = max(last_seen, excluded.last_seen)
+		RETURNING id`, pid, name, ts, ts).Scan(&id)
+	if err != nil {
+		return nil, fmt.Errorf("insert process: %w", err)
+	}
+	if len(s.ids) >= maxKnown {
+		clear(s.ids)
+	}
+	s.ids[key] = id
+	return id, nil
+}
+
+// Connection records a closed connection and why it was closed.
+func (s *Store) Connection(c *tracker.Connection, reason string) {
+	respIP, respPort := c.Key.DstIP, c.Key.DstPort
+	if respIP == c.OrigIP && respPort == c.OrigPort {
+		respIP, respPort = c.Key.SrcIP, c.Key.SrcPort
+	}
+	end := c.EndTime
+	if end.IsZero() {
+		end = time.Now()
+	}
+	// Copy what is stored, the connection may change once this returns
+	conn := *c
+	start, endTime := formatTime(c.StartTime), formatTime(end)
+
+	s.enqueue(func(tx *sql.Tx) error {
+		processID, err := s.processID(tx, conn.PID, conn.Process, endTime)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`
+			INSERT INTO connections (start_time, end_time, duration, protocol,
+				orig_ip, orig_port, resp_ip, resp_port, state, history, close_reason,
+				orig_packets, orig_bytes, resp_packets, resp_bytes, process_id)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			start, endTime, end.Sub(conn.StartTime).Seconds(), strings.ToLower(conn.Key.Protocol),
+			conn.OrigIP, conn.OrigPort, respIP, respPort, conn.State.String(), conn.History, nullString(reason),
+			conn.OrigPackets, conn.OrigBytes, conn.RespPackets, conn.RespBytes, processID)
+		if err != nil {
+			return fmt.Errorf("insert connection: %w", err)
+		}
+		return nil
+	})
+}
+
+// DNSResponse is a DNS response seen on the wire.
+type DNSResponse struct {
+	Time       time.Time
+	ClientIP   net.IP
+	ClientPort uint16
+	ServerIP   net.IP
+	ServerPort uint16
+	Message    *parser.DNSMessage
+
+	// Process of the local client or server, if known
+	PID     int
+	Process string
+}
+
+// DNS records a DNS response with its answers. Queries are not stored:
+// every response repeats its question. A second copy of a response seen
+// shortly after the first is skipped.
+func (s *Store) DNS(r DNSResponse) {
+	if !r.Message.Response {
+		return
+	}
+	key := dnsKey{r.ClientIP.String(), r.ServerIP.String(), r.ClientPort, r.Message.ID}
+	s.knownMu.Lock()
+	if last, ok := s.recent[key]; ok && r.Time.Sub(last) < duplicateWindow && r.Time.Sub(last) > -duplicateWindow {
+		s.knownMu.Unlock()
+		return
+	}
+	if len(s.recent) >= maxKnown {
+		clear(s.recent)
+	}
+	s.recent[key] = r.Time
+	s.knownMu.Unlock()
+
+	msg := r.Message
+	var query, qtype any
+	if len(msg.Questions) > 0 {
+		query = msg.Questions[0].Name
+		qtype = parser.DNSTypeName(msg.Questions[0].Type)
+	}
+	ts := formatTime(r.Time)
+
+	s.enqueue(func(tx *sql.Tx) error {
+		processID, err := s.processID(tx, r.PID, r.Process, ts)
+		if err != nil {
+			return err
+		}
+		var id int64
+		err = tx.QueryRow(`
+			INSERT INTO dns_responses (time, client_ip, client_port, server_ip, server_port,
+				trans_id, query, qtype, rcode, process_id)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING id`,
+			ts, key.client, r.ClientPort, key.server, r.ServerPort,
+			msg.ID, query, qtype, parser.DNSRcodeName(msg.Rcode), processID).Scan(&id)
+		if err != nil {
+			return fmt.Errorf("insert DNS response: %w", err)
+		}
+		for _, a := range msg.Answers {
+			_, err := tx.Exec("INSERT INTO dns_answers (response_id, name, type, ttl, data) VALUES (?, ?, ?, ?, ?)",
+				id, a.Name, parser.DNSTypeName(a.Type), a.TTL, a.Data)
+			if err != nil {
+				return fmt.Errorf("insert DNS answer: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// Stats records a snapshot of the capture's counters.
+func (s *Store) Stats(t stats.Totals) {
+	s.enqueue(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			INSERT INTO stats (time, packets, bytes, packets_per_sec, bytes_per_sec,
+				parse_errors, filtered, duplicates, kernel_drops, event_drops)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			formatTime(t.Time), t.Packets, t.Bytes, t.PacketsPerSec, t.BytesPerSec,
+			t.ParseErrors, t.Filtered, t.Duplicates, t.KernelDrops, t.EventDrops)
+		if err != nil {
+			return fmt.Errorf("insert stats: %w", err)
+		}
+		return nil
+	})
+}
+
+// nullString stores an empty string as NULL.
+func nullString(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
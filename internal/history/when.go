@@ -0,0 +1,72 @@
+package history
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ParseWhen parses a point in time for --since and --until, in now's
+// location:
+//
+//	2h, 30m             that long before now
+//	now
+//	today, yesterday    midnight, or a time of day: "yesterday 14:00"
+//	14:00, 14:00:30     a time today
+//	2025-12-24          midnight, or a time of day: "2025-12-24 14:00"
+//	2025-12-24T14:00:00Z  RFC 3339
+func ParseWhen(s string, now time.Time) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+
+	day, clock, _ := strings.Cut(s, " ")
+	if clock == "" {
+		if _, ok := parseClock(day); ok {
+			day, clock = "today", day
+		}
+	}
+
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	var date time.Time
+	switch day {
+	case "now":
+		if clock == "" {
+			return now, nil
+		}
+		return time.Time{}, fmt.Errorf("invalid time %q", s)
+	case "today":
+		date = midnight
+	case "yesterday":
+		date = midnight.AddDate(0, 0, -1)
+	default:
+		d, err := time.ParseInLocation(time.DateOnly, day, now.Location())
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid time %q: want e.g. 2h, yesterday 14:00, 2025-12-24 14:00 or RFC 3339", s)
+		}
+		date = d
+	}
+
+	if clock == "" {
+		return date, nil
+	}
+	t, ok := parseClock(strings.TrimSpace(clock))
+	if !ok {
+		return time.Time{}, fmt.Errorf("invalid time of day in %q: want HH:MM or HH:MM:SS", s)
+	}
+	return time.Date(date.Year(), date.Month(), date.Day(), t.Hour(), t.Minute(), t.Second(), 0, date.Location()), nil
+}
+
+// parseClock parses a time of day, HH:MM or HH:MM:SS.
+func parseClock(s string) (time.Time, bool) {
+	for _, layout := range []string{"15:04", "15:04:05"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
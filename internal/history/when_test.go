@@ -0,0 +1,36 @@
+package history
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseWhen(t *testing.T) {
+	loc := time.FixedZone("CET", 3600)
+	now := time.Date(2025, 12, 24, 16, 30, 0, 0, loc)
+
+	tests := map[string]time.Time{
+		"2h":                   now.Add(-2 * time.Hour),
+		"now":                  now,
+		"today":                time.Date(2025, 12, 24, 0, 0, 0, 0, loc),
+		"yesterday":            time.Date(2025, 12, 23, 0, 0, 0, 0, loc),
+		"yesterday 14:00":      time.Date(2025, 12, 23, 14, 0, 0, 0, loc),
+		"today 09:15:30":       time.Date(2025, 12, 24, 9, 15, 30, 0, loc),
+		"14:00":                time.Date(2025, 12, 24, 14, 0, 0, 0, loc),
+		"2025-12-01":           time.Date(2025, 12, 1, 0, 0, 0, 0, loc),
+		"2025-12-01 08:00":     time.Date(2025, 12, 1, 8, 0, 0, 0, loc),
+		"2025-12-01T08:00:00Z": time.Date(2025, 12, 1, 8, 0, 0, 0, time.UTC),
+	}
+	for s, want := range tests {
+		got, err := ParseWhen(s, now)
+		if err != nil || !got.Equal(want) {
+			t.Errorf("ParseWhen(%q) = %v, %v; want %v", s, got, err, want)
+		}
+	}
+
+	for _, bad := range []string{"", "tomorrow", "yesterday 25:00", "now 14:00", "2025-13-01"} {
+		if _, err := ParseWhen(bad, now); err == nil {
+			t.Errorf("ParseWhen(%q): expected error", bad)
+		}
+	}
+}
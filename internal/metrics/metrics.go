@@ -0,0 +1,280 @@
+// Package metrics implements a small metrics registry served in the
+// Prometheus text exposition format, or OpenMetrics when the scraper asks
+// for it.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Metric types.
+const (
+	typeCounter = "counter"
+	typeGauge   = "gauge"
+)
+
+// Content types of the two exposition formats.
+const (
+	contentTypeText        = "text/plain; version=0.0.4; charset=utf-8"
+	contentTypeOpenMetrics = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+)
+
+// OtherValue replaces label values beyond a vector's cardinality limit.
+const OtherValue = "other"
+
+// family is a named metric with a set of labelled series.
+type family interface {
+	desc() *descriptor
+	// collect calls emit for every series, in any order.
+	collect(emit func(labelValues []string, value float64))
+}
+
+// descriptor describes a metric family. Counter names are given without the
+// _total suffix, which is added on output.
+type descriptor struct {
+	Name   string
+	Help   string
+	Type   string
+	Labels []string
+}
+
+// Registry holds metric families in registration order. It is safe for
+// concurrent use.
+type Registry struct {
+	mu       sync.Mutex
+	families []family
+}
+
+// NewRegistry creates an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(f family) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.families = append(r.families, f)
+}
+
+// series is one labelled value of a vector.
+type series struct {
+	labelValues []string
+	value       float64
+}
+
+// CounterVec is a counter with labels.
+type CounterVec struct {
+	d descriptor
+
+	mu     sync.Mutex
+	series map[string]*series
+
+	// Cardinality bound: past maxSeries series, new series get OtherValue
+	// for the label at limitLabel
+	maxSeries  int
+	limitLabel int
+}
+
+// NewCounterVec registers a counter with the given label names.
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{
+		d:      descriptor{Name: name, Help: help, Type: typeCounter, Labels: labels},
+		series: make(map[string]*series),
+	}
+	r.register(c)
+	return c
+}
+
+// Limit bounds the number of series. Once max series exist, values of
+// label that would start a new series are replaced with OtherValue, so
+// high-cardinality labels such as process names or ports stay bounded.
+func (c *CounterVec) Limit(max int, label string) *CounterVec {
+	for i, l := range c.d.Labels {
+		if l == label {
+			c.maxSeries, c.limitLabel = max, i
+			return c
+		}
+	}
+	panic("metrics: Limit on unknown label " + label)
+}
+
+// Add adds v to the series with the given label values, which must match
+// the vector's labels in number and order.
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	if len(labelValues) != len(c.d.Labels) {
+		panic(fmt.Sprintf("metrics: %s wants %d label values, got %d", c.d.Name, len(c.d.Labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s := c.series[key]
+	if s == nil && c.maxSeries > 0 && len(c.series) >= c.maxSeries {
+		folded := append([]string(nil), labelValues...)
+		folded[c.limitLabel] = OtherValue
+		labelValues = folded
+		key = strings.Join(labelValues, "\xff")
+		s = c.series[key]
+	}
+	if s == nil {
+		s = &series{labelValues: append([]string(nil), labelValues...)}
+		c.series[key] = s
+	}
+	s.value += v
+}
+
+// Inc adds one to the series with the given label values.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *CounterVec) desc() *descriptor {
+	return &c.d
+}
+
+func (c *CounterVec) collect(emit func([]string, float64)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, s := range c.series {
+		emit(s.labelValues, s.value)
+	}
+}
+
+// funcFamily computes its series at scrape time.
+type funcFamily struct {
+	d  descriptor
+	fn func(emit func(value float64, labelValues ...string))
+}
+
+// NewGaugeFunc registers a gauge whose series are reported by fn at
+// every scrape.
+func (r *Registry) NewGaugeFunc(name, help string, labels []string, fn func(emit func(value float64, labelValues ...string))) {
+	r.register(&funcFamily{descriptor{Name: name, Help: help, Type: typeGauge, Labels: labels}, fn})
+}
+
+// NewCounterFunc registers a counter whose series are reported by fn at
+// every scrape, for totals kept elsewhere such as kernel socket counters.
+func (r *Registry) NewCounterFunc(name, help string, labels []string, fn func(emit func(value float64, labelValues ...string))) {
+	r.register(&funcFamily{descriptor{Name: name, Help: help, Type: typeCounter, Labels: labels}, fn})
+}
+
+func (f *funcFamily) desc() *descriptor {
+	return &f.d
+}
+
+func (f *funcFamily) collect(emit func([]string, float64)) {
+	f.fn(func(value float64, labelValues ...string) {
+		emit(labelValues, value)
+	})
+}
+
+// WriteText writes every family in the Prometheus text format, or in
+// OpenMetrics if openMetrics is set. Series are sorted by label values.
+func (r *Registry) WriteText(w io.Writer, openMetrics bool) error {
+	r.mu.Lock()
+	families := append([]family(nil), r.families...)
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		d := f.desc()
+		sample := d.Name
+		if d.Type == typeCounter {
+			sample += "_total"
+		}
+		// OpenMetrics names the counter family without the _total suffix
+		name := sample
+		if openMetrics {
+			name = d.Name
+		}
+		fmt.Fprintf(bw, "# HELP %s %s\n", name, escapeHelp(d.Help))
+		fmt.Fprintf(bw, "# TYPE %s %s\n", name, d.Type)
+
+		var all []series
+		f.collect(func(labelValues []string, value float64) {
+			all = append(all, series{labelValues, value})
+		})
+		sort.Slice(all, func(i, j int) bool {
+			a, b := all[i].labelValues, all[j].labelValues
+			for k := range a {
+				if a[k] != b[k] {
+					return a[k] < b[k]
+				}
+			}
+			return false
+		})
+
+		for _, s := range all {
+			bw.WriteString(sample)
+			if len(d.Labels) > 0 {
+				bw.WriteByte('{')
+				for i, label := range d.Labels {
+					if i > 0 {
+						bw.WriteByte(',')
+					}
+					fmt.Fprintf(bw, "%s=\"%s\"", label, escapeLabel(s.labelValues[i]))
+				}
+				bw.WriteByte('}')
+			}
+			bw.WriteByte(' ')
+			bw.WriteString(formatValue(s.value))
+			bw.WriteByte('\n')
+		}
+	}
+	if openMetrics {
+		bw.WriteString("# EOF\n")
+	}
+	return bw.Flush()
+}
+
+// Handler serves the registry. Scrapers that accept OpenMetrics get it;
+// everything else gets the Prometheus text format.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		openMetrics := strings.Contains(req.Header.Get("Accept"), "application/openmetrics-text")
+		if openMetrics {
+			w.Header().Set("Content-Type", contentTypeOpenMetrics)
+		} else {
+			w.Header().Set("Content-Type", contentTypeText)
+		}
+		r.WriteText(w, openMetrics)
+	})
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	if v == math.Trunc(v) && math.Abs(v) < 1e15 {
+		// Counters are usually integral; avoid 1e+06
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
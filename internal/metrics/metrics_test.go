@@ -0,0 +1,82 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWriteText(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("test_packets", "Packets seen.", "protocol", "direction")
+	c.Inc("UDP", "in")
+	c.Add(2, "TCP", "out")
+	c.Inc("TCP", "out")
+	r.NewGaugeFunc("test_ratio", "A \"ratio\"\nsecond line.", nil, func(emit func(float64, ...string)) {
+		emit(0.5)
+	})
+	r.NewCounterVec("test_labels", "Escaping.", "name").Inc("a\"b\\c")
+
+	var b strings.Builder
+	r.WriteText(&b, false)
+	want := `# HELP test_packets_total Packets seen.
+# TYPE test_packets_total counter
+test_packets_total{protocol="TCP",direction="out"} 3
+test_packets_total{protocol="UDP",direction="in"} 1
+# HELP test_ratio A "ratio"\nsecond line.
+# TYPE test_ratio gauge
+test_ratio 0.5
+# HELP test_labels_total Escaping.
+# TYPE test_labels_total counter
+test_labels_total{name="a\"b\\c"} 1
+`
+	if b.String() != want {
+		t.Errorf("WriteText =\n%s\nwant\n%s", b.String(), want)
+	}
+
+	b.Reset()
+	r.WriteText(&b, true)
+	got := b.String()
+	if !strings.Contains(got, "# TYPE test_packets counter\ntest_packets_total{") || !strings.HasSuffix(got, "# EOF\n") {
+		t.Errorf("OpenMetrics output:\n%s", got)
+	}
+}
+
+func TestCounterVecLimit(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("test_port", "By port.", "protocol", "port").Limit(2, "port")
+	for _, port := range []string{"22", "443", "8080", "9090", "22"} {
+		c.Inc("TCP", port)
+	}
+
+	var b strings.Builder
+	r.WriteText(&b, false)
+	for _, line := range []string{
+		`test_port_total{protocol="TCP",port="22"} 2`,
+		`test_port_total{protocol="TCP",port="443"} 1`,
+		`test_port_total{protocol="TCP",port="other"} 2`,
+	} {
+		if !strings.Contains(b.String(), line+"\n") {
+			t.Errorf("output lacks %q:\n%s", line, b.String())
+		}
+	}
+}
+
+func TestHandlerNegotiatesFormat(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounterVec("test_events", "Events.").Inc()
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != contentTypeText {
+		t.Errorf("Content-Type = %q", ct)
+	}
+
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	req.Header.Set("Accept", "application/openmetrics-text;version=1.0.0,text/plain;q=0.5")
+	r.Handler().ServeHTTP(rec, req)
+	if ct := rec.Header().Get("Content-Type"); ct != contentTypeOpenMetrics || !strings.HasSuffix(rec.Body.String(), "# EOF\n") {
+		t.Errorf("OpenMetrics response: %q\n%s", ct, rec.Body.String())
+	}
+}
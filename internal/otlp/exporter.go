@@ -0,0 +1,318 @@
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LogsPath is where OTLP/HTTP collectors receive logs.
+const LogsPath = "/v1/logs"
+
+// Defaults for the zero values of Options.
+const (
+	defaultBatchSize       = 512
+	defaultFlushInterval   = time.Second
+	defaultQueueSize       = 4096
+	defaultTimeout         = 10 * time.Second
+	defaultInitialBackoff  = 500 * time.Millisecond
+	defaultMaxElapsed      = time.Minute
+	defaultShutdownTimeout = 5 * time.Second
+
+	maxBackoff = 30 * time.Second
+)
+
+// Options configure an Exporter.
+type Options struct {
+	Endpoint string            // Collector URL; see LogsURL
+	Headers  map[string]string // Added to every request, e.g. for authorization
+	Resource Resource
+
+	BatchSize     int           // Records per request
+	FlushInterval time.Duration // Longest a record waits for its batch to fill
+	QueueSize     int           // Records waiting to be sent; past it, new ones are dropped
+
+	Timeout         time.Duration // Per request
+	InitialBackoff  time.Duration // Wait before the first retry, doubled for every further one
+	MaxElapsed      time.Duration // Give up on a batch after retrying it this long
+	ShutdownTimeout time.Duration // How long Close keeps sending what is queued
+
+	// Report, if set, is called with the outcome of every request.
+	Report func(error)
+}
+
+// Exporter batches log records and posts them to an OTLP/HTTP collector
+// in the background, retrying failed requests with exponential backoff.
+// Export never blocks: records that don't fit in the queue are dropped
+// and counted.
+type Exporter struct {
+	url    string
+	opts   Options
+	client *http.Client
+
+	queue   chan LogRecord
+	ctx     context.Context // Cancelled to abandon sending at shutdown
+	cancel  context.CancelFunc
+	done    chan struct{}
+	dropped atomic.Uint64
+
+	closeOnce sync.Once
+}
+
+// LogsURL returns the URL logs are posted to: the endpoint itself if its
+// path ends in /v1/logs, otherwise the endpoint with /v1/logs appended.
+func LogsURL(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", fmt.Errorf("otlp endpoint: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return "", fmt.Errorf("otlp endpoint %q: want http:// or https:// and a host", endpoint)
+	}
+	if !strings.HasSuffix(u.Path, LogsPath) {
+		u.Path = strings.TrimSuffix(u.Path, "/") + LogsPath
+	}
+	return u.String(), nil
+}
+
+// ParseHeaders parses headers written as comma-separated key=value pairs,
+// the format of OTEL_EXPORTER_OTLP_HEADERS.
+func ParseHeaders(s string) (map[string]string, error) {
+	headers := make(map[string]string)
+	for pair := range strings.SplitSeq(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("otlp header %q: want key=value", pair)
+		}
+		if v, err := url.QueryUnescape(strings.TrimSpace(value)); err == nil {
+			value = v
+		}
+		headers[key] = value
+	}
+	return headers, nil
+}
+
+// NewExporter creates an Exporter and starts sending.
+func NewExporter(opts Options) (*Exporter, error) {
+	logsURL, err := LogsURL(opts.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	setDefault(&opts.BatchSize, defaultBatchSize)
+	setDefault(&opts.FlushInterval, defaultFlushInterval)
+	setDefault(&opts.QueueSize, defaultQueueSize)
+	setDefault(&opts.Timeout, defaultTimeout)
+	setDefault(&opts.InitialBackoff, defaultInitialBackoff)
+	setDefault(&opts.MaxElapsed, defaultMaxElapsed)
+	setDefault(&opts.ShutdownTimeout, defaultShutdownTimeout)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	e := &Exporter{
+		url:    logsURL,
+		opts:   opts,
+		client: &http.Client{Timeout: opts.Timeout},
+		queue:  make(chan LogRecord, opts.QueueSize),
+		ctx:    ctx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go e.run()
+	return e, nil
+}
+
+func setDefault[T int | time.Duration](v *T, def T) {
+	if *v <= 0 {
+		*v = def
+	}
+}
+
+// Export queues a record. It returns false if the queue is full and the
+// record was dropped.
+func (e *Exporter) Export(r LogRecord) bool {
+	select {
+	case e.queue <- r:
+		return true
+	default:
+		e.dropped.Add(1)
+		return false
+	}
+}
+
+// Dropped returns how many records were dropped: because the queue was
+// full, the collector rejected them, or sending them failed for good.
+func (e *Exporter) Dropped() uint64 {
+	return e.dropped.Load()
+}
+
+// Close sends the queued records, giving up after the shutdown timeout,
+// and stops the exporter. Export must not be called after Close.
+func (e *Exporter) Close() {
+	e.closeOnce.Do(func() {
+		close(e.queue)
+		timer := time.AfterFunc(e.opts.ShutdownTimeout, e.cancel)
+		<-e.done
+		timer.Stop()
+		e.cancel()
+	})
+}
+
+// run batches queued records until the queue is closed.
+func (e *Exporter) run() {
+	defer close(e.done)
+
+	ticker := time.NewTicker(e.opts.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]LogRecord, 0, e.opts.BatchSize)
+	flush := func() {
+		if len(batch) > 0 {
+			e.send(batch)
+			batch = batch[:0]
+		}
+	}
+	for {
+		select {
+		case r, ok := <-e.queue:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, r)
+			if len(batch) >= e.opts.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// send posts a batch, retrying while the collector may accept it later.
+func (e *Exporter) send(batch []LogRecord) {
+	body, err := Marshal(e.opts.Resource, batch)
+	if err != nil {
+		e.fail(len(batch), fmt.Errorf("otlp: encode logs: %w", err))
+		return
+	}
+
+	deadline := time.Now().Add(e.opts.MaxElapsed)
+	backoff := e.opts.InitialBackoff
+	for {
+		rejected, retryAfter, err := e.post(body)
+		if err == nil {
+			if rejected > 0 {
+				e.fail(rejected, fmt.Errorf("otlp: collector rejected %d of %d log records", rejected, len(batch)))
+				return
+			}
+			e.report(nil)
+			return
+		}
+
+		var permanent *permanentError
+		if errors.As(err, &permanent) {
+			e.fail(len(batch), err)
+			return
+		}
+
+		wait := max(backoff, retryAfter)
+		if time.Now().Add(wait).After(deadline) {
+			e.fail(len(batch), fmt.Errorf("%w (giving up after %s)", err, e.opts.MaxElapsed))
+			return
+		}
+		select {
+		case <-time.After(wait):
+		case <-e.ctx.Done():
+			e.fail(len(batch), fmt.Errorf("%w (giving up at shutdown)", err))
+			return
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// permanentError is a failed request that retrying won't fix.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// post sends one request. It returns how many records a partial success
+// rejected, and how long the collector asked to wait before a retry.
+func (e *Exporter) post(body []byte) (rejected int, retryAfter time.Duration, err error) {
+	req, err := http.NewRequestWithContext(e.ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return 0, 0, &permanentError{fmt.Errorf("otlp: %w", err)}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.opts.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return 0, 0, fmt.Errorf("otlp: %w", err)
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return partialRejected(respBody), 0, nil
+	case resp.StatusCode == http.StatusTooManyRequests,
+		resp.StatusCode == http.StatusBadGateway,
+		resp.StatusCode == http.StatusServiceUnavailable,
+		resp.StatusCode == http.StatusGatewayTimeout:
+		return 0, parseRetryAfter(resp.Header.Get("Retry-After")), fmt.Errorf("otlp: collector returned %s", resp.Status)
+	default:
+		return 0, 0, &permanentError{fmt.Errorf("otlp: collector returned %s: %s", resp.Status, bytes.TrimSpace(respBody))}
+	}
+}
+
+// partialRejected returns the rejected record count of a partial success.
+func partialRejected(body []byte) int {
+	var resp struct {
+		PartialSuccess struct {
+			RejectedLogRecords json.Number `json:"rejectedLogRecords"`
+		} `json:"partialSuccess"`
+	}
+	if json.Unmarshal(body, &resp) != nil {
+		return 0
+	}
+	n, _ := resp.PartialSuccess.RejectedLogRecords.Int64()
+	return int(n)
+}
+
+// parseRetryAfter parses a Retry-After header given in seconds.
+func parseRetryAfter(s string) time.Duration {
+	secs, err := strconv.Atoi(s)
+	if err != nil || secs < 0 {
+		return 0
+	}
+	return time.Duration(secs) * time.Second
+}
+
+func (e *Exporter) fail(records int, err error) {
+	e.dropped.Add(uint64(records))
+	e.report(err)
+}
+
+func (e *Exporter) report(err error) {
+	if e.opts.Report != nil {
+		e.opts.Report(err)
+	}
+}
@@ -0,0 +1,327 @@
+package otlp
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// collector is a stand-in OTLP/HTTP collector. respond picks the status of
+// each request, numbered from 0; it accepts everything if nil.
+type collector struct {
+	t       *testing.T
+	respond func(n int, w http.ResponseWriter) bool
+
+	mu       sync.Mutex
+	requests int
+	batches  [][]map[string]any // Log records of every accepted request
+	headers  http.Header
+	resource []any
+}
+
+func newCollector(t *testing.T, respond func(n int, w http.ResponseWriter) bool) (*collector, *httptest.Server) {
+	c := &collector{t: t, respond: respond}
+	srv := httptest.NewServer(c)
+	t.Cleanup(srv.Close)
+	return c, srv
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := c.requests
+	c.requests++
+
+	if r.URL.Path != LogsPath || r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+		c.t.Errorf("got %s %s (%s)", r.Method, r.URL.Path, r.Header.Get("Content-Type"))
+	}
+	if c.respond != nil && !c.respond(n, w) {
+		return
+	}
+
+	var req struct {
+		ResourceLogs []struct {
+			Resource struct {
+				Attributes []any `json:"attributes"`
+			} `json:"resource"`
+			ScopeLogs []struct {
+				LogRecords []map[string]any `json:"logRecords"`
+			} `json:"scopeLogs"`
+		} `json:"resourceLogs"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		c.t.Errorf("decode request: %v", err)
+		return
+	}
+	c.headers = r.Header
+	c.resource = req.ResourceLogs[0].Resource.Attributes
+	c.batches = append(c.batches, req.ResourceLogs[0].ScopeLogs[0].LogRecords)
+}
+
+func (c *collector) accepted() (batches [][]map[string]any, requests int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.batches, c.requests
+}
+
+func testRecord(i int) LogRecord {
+	return LogRecord{
+		Time:           time.Unix(1700000000, int64(i)),
+		SeverityNumber: SeverityInfo,
+		SeverityText:   "INFO",
+		EventName:      "test",
+		Body:           String("record"),
+		Attributes: []KeyValue{
+			Attr("index", Int(int64(i))),
+			Attr("ok", Bool(true)),
+		},
+	}
+}
+
+// fastOptions returns options with short timers for tests.
+func fastOptions(endpoint string) Options {
+	return Options{
+		Endpoint:       endpoint,
+		Resource:       Resource{ServiceName: "portlens", ServiceVersion: "test"},
+		FlushInterval:  time.Hour, // Batches are sent when full or at Close
+		InitialBackoff: time.Millisecond,
+		MaxElapsed:     time.Second,
+	}
+}
+
+func TestExporterBatches(t *testing.T) {
+	c, srv := newCollector(t, nil)
+	opts := fastOptions(srv.URL)
+	opts.BatchSize = 4
+	opts.Headers = map[string]string{"Authorization": "Bearer secret"}
+	e, err := NewExporter(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 10 {
+		e.Export(testRecord(i))
+	}
+	e.Close()
+
+	batches, _ := c.accepted()
+	if len(batches) != 3 || len(batches[0]) != 4 || len(batches[2]) != 2 {
+		t.Fatalf("got batches of %v records, want 4, 4 and 2", batchSizes(batches))
+	}
+	if c.headers.Get("Authorization") != "Bearer secret" {
+		t.Errorf("Authorization = %q", c.headers.Get("Authorization"))
+	}
+	if e.Dropped() != 0 {
+		t.Errorf("dropped %d records", e.Dropped())
+	}
+
+	rec := batches[0][1]
+	if rec["timeUnixNano"] != "1700000000000000001" || rec["severityNumber"] != 9.0 || rec["eventName"] != "test" {
+		t.Errorf("record = %v", rec)
+	}
+	attrs, _ := json.Marshal(rec["attributes"])
+	if string(attrs) != `[{"key":"index","value":{"intValue":"1"}},{"key":"ok","value":{"boolValue":true}}]` {
+		t.Errorf("attributes = %s", attrs)
+	}
+	resource, _ := json.Marshal(c.resource)
+	if !strings.Contains(string(resource), `{"key":"service.name","value":{"stringValue":"portlens"}}`) {
+		t.Errorf("resource attributes = %s", resource)
+	}
+}
+
+func TestExporterFlushInterval(t *testing.T) {
+	c, srv := newCollector(t, nil)
+	opts := fastOptions(srv.URL)
+	opts.FlushInterval = 10 * time.Millisecond
+	e, _ := NewExporter(opts)
+	defer e.Close()
+
+	e.Export(testRecord(0))
+	for range 100 {
+		if batches, _ := c.accepted(); len(batches) == 1 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("record not sent within the flush interval")
+}
+
+func TestExporterRetries(t *testing.T) {
+	c, srv := newCollector(t, func(n int, w http.ResponseWriter) bool {
+		switch n {
+		case 0:
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return false
+		case 1:
+			w.WriteHeader(http.StatusTooManyRequests)
+			return false
+		}
+		return true
+	})
+	var reports []error
+	opts := fastOptions(srv.URL)
+	opts.Report = func(err error) { reports = append(reports, err) }
+	e, _ := NewExporter(opts)
+	e.Export(testRecord(0))
+	e.Close()
+
+	batches, requests := c.accepted()
+	if requests != 3 || len(batches) != 1 {
+		t.Errorf("got %d requests and %d accepted batches, want 3 and 1", requests, len(batches))
+	}
+	if len(reports) != 1 || reports[0] != nil {
+		t.Errorf("reports = %v, want one success", reports)
+	}
+	if e.Dropped() != 0 {
+		t.Errorf("dropped %d records", e.Dropped())
+	}
+}
+
+func TestExporterPermanentFailure(t *testing.T) {
+	_, srv := newCollector(t, func(n int, w http.ResponseWriter) bool {
+		http.Error(w, "bad payload", http.StatusBadRequest)
+		return false
+	})
+	var reported error
+	opts := fastOptions(srv.URL)
+	opts.Report = func(err error) { reported = err }
+	e, _ := NewExporter(opts)
+	e.Export(testRecord(0))
+	e.Export(testRecord(1))
+	e.Close()
+
+	var permanent *permanentError
+	if !errors.As(reported, &permanent) || !strings.Contains(reported.Error(), "bad payload") {
+		t.Errorf("reported %v, want a permanent error", reported)
+	}
+	if e.Dropped() != 2 {
+		t.Errorf("dropped %d records, want 2", e.Dropped())
+	}
+}
+
+func TestExporterGivesUp(t *testing.T) {
+	_, srv := newCollector(t, func(n int, w http.ResponseWriter) bool {
+		w.WriteHeader(http.StatusBadGateway)
+		return false
+	})
+	opts := fastOptions(srv.URL)
+	opts.MaxElapsed = 20 * time.Millisecond
+	e, _ := NewExporter(opts)
+	e.Export(testRecord(0))
+	e.Close()
+
+	if e.Dropped() != 1 {
+		t.Errorf("dropped %d records, want 1", e.Dropped())
+	}
+}
+
+func TestExporterPartialSuccess(t *testing.T) {
+	_, srv := newCollector(t, func(n int, w http.ResponseWriter) bool {
+		w.Write([]byte(`{"partialSuccess":{"rejectedLogRecords":"1","errorMessage":"too old"}}`))
+		return false
+	})
+	var reported error
+	opts := fastOptions(srv.URL)
+	opts.Report = func(err error) { reported = err }
+	e, _ := NewExporter(opts)
+	e.Export(testRecord(0))
+	e.Export(testRecord(1))
+	e.Close()
+
+	if reported == nil || e.Dropped() != 1 {
+		t.Errorf("reported %v and dropped %d, want an error and 1", reported, e.Dropped())
+	}
+}
+
+func TestExporterQueueFull(t *testing.T) {
+	block := make(chan struct{})
+	_, srv := newCollector(t, func(n int, w http.ResponseWriter) bool {
+		<-block
+		return true
+	})
+	opts := fastOptions(srv.URL)
+	opts.BatchSize = 1
+	opts.QueueSize = 2
+	e, _ := NewExporter(opts)
+
+	// The first record is being sent, two wait in the queue
+	e.Export(testRecord(0))
+	for len(e.queue) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	for i := 1; i <= 5; i++ {
+		e.Export(testRecord(i))
+	}
+	close(block)
+	e.Close()
+
+	if e.Dropped() != 3 {
+		t.Errorf("dropped %d records, want 3", e.Dropped())
+	}
+}
+
+func TestExporterShutdownTimeout(t *testing.T) {
+	_, srv := newCollector(t, func(n int, w http.ResponseWriter) bool {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return false
+	})
+	opts := fastOptions(srv.URL)
+	opts.InitialBackoff = time.Hour
+	opts.MaxElapsed = 2 * time.Hour
+	opts.ShutdownTimeout = 10 * time.Millisecond
+	e, _ := NewExporter(opts)
+	e.Export(testRecord(0))
+
+	start := time.Now()
+	e.Close()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Close took %s", elapsed)
+	}
+	if e.Dropped() != 1 {
+		t.Errorf("dropped %d records, want 1", e.Dropped())
+	}
+}
+
+func TestLogsURL(t *testing.T) {
+	tests := map[string]string{
+		"http://localhost:4318":         "http://localhost:4318/v1/logs",
+		"https://otel.example.com/":     "https://otel.example.com/v1/logs",
+		"http://gw:8080/otlp":           "http://gw:8080/otlp/v1/logs",
+		"http://localhost:4318/v1/logs": "http://localhost:4318/v1/logs",
+	}
+	for endpoint, want := range tests {
+		if got, err := LogsURL(endpoint); err != nil || got != want {
+			t.Errorf("LogsURL(%q) = %q, %v; want %q", endpoint, got, err, want)
+		}
+	}
+	for _, bad := range []string{"localhost:4318", "ftp://host", "http://"} {
+		if _, err := LogsURL(bad); err == nil {
+			t.Errorf("LogsURL(%q): expected error", bad)
+		}
+	}
+}
+
+func TestParseHeaders(t *testing.T) {
+	h, err := ParseHeaders("Authorization=Basic%20abc, X-Tenant = a=b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h["Authorization"] != "Basic abc" || h["X-Tenant"] != "a=b" || len(h) != 2 {
+		t.Errorf("headers = %v", h)
+	}
+	if _, err := ParseHeaders("novalue"); err == nil {
+		t.Error("expected error for a header without value")
+	}
+}
+
+func batchSizes(batches [][]map[string]any) []int {
+	var sizes []int
+	for _, b := range batches {
+		sizes = append(sizes, len(b))
+	}
+	return sizes
+}
@@ -0,0 +1,148 @@
+// Package otlp implements the OpenTelemetry logs data model in its OTLP
+// JSON encoding, and an exporter sending it to a collector over OTLP/HTTP.
+package otlp
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// Severity numbers of the logs data model.
+const (
+	SeverityInfo = 9
+	SeverityWarn = 13
+)
+
+// LogRecord is one log record of the OpenTelemetry logs data model.
+type LogRecord struct {
+	Time           time.Time
+	ObservedTime   time.Time
+	SeverityNumber int
+	SeverityText   string
+	EventName      string
+	Body           AnyValue
+	Attributes     []KeyValue
+}
+
+// KeyValue is an attribute.
+type KeyValue struct {
+	Key   string   `json:"key"`
+	Value AnyValue `json:"value"`
+}
+
+// AnyValue is an attribute or body value: a string, an integer or a bool.
+type AnyValue struct {
+	v any
+}
+
+// String returns a string value.
+func String(s string) AnyValue { return AnyValue{s} }
+
+// Int returns an integer value.
+func Int(i int64) AnyValue { return AnyValue{i} }
+
+// Bool returns a boolean value.
+func Bool(b bool) AnyValue { return AnyValue{b} }
+
+// Attr returns an attribute.
+func Attr(key string, value AnyValue) KeyValue {
+	return KeyValue{Key: key, Value: value}
+}
+
+// Value returns the Go value: a string, int64, bool, or nil if unset.
+func (v AnyValue) Value() any { return v.v }
+
+// MarshalJSON encodes the value as in OTLP JSON, where 64-bit integers
+// are strings.
+func (v AnyValue) MarshalJSON() ([]byte, error) {
+	switch x := v.v.(type) {
+	case string:
+		return json.Marshal(map[string]string{"stringValue": x})
+	case int64:
+		return json.Marshal(map[string]string{"intValue": strconv.FormatInt(x, 10)})
+	case bool:
+		return json.Marshal(map[string]bool{"boolValue": x})
+	}
+	return []byte("{}"), nil
+}
+
+// MarshalJSON encodes the record as in OTLP JSON.
+func (r LogRecord) MarshalJSON() ([]byte, error) {
+	type logRecord struct {
+		TimeUnixNano         string     `json:"timeUnixNano"`
+		ObservedTimeUnixNano string     `json:"observedTimeUnixNano"`
+		SeverityNumber       int        `json:"severityNumber,omitempty"`
+		SeverityText         string     `json:"severityText,omitempty"`
+		EventName            string     `json:"eventName,omitempty"`
+		Body                 *AnyValue  `json:"body,omitempty"`
+		Attributes           []KeyValue `json:"attributes,omitempty"`
+	}
+	out := logRecord{
+		TimeUnixNano:         unixNano(r.Time),
+		ObservedTimeUnixNano: unixNano(r.ObservedTime),
+		SeverityNumber:       r.SeverityNumber,
+		SeverityText:         r.SeverityText,
+		EventName:            r.EventName,
+		Attributes:           r.Attributes,
+	}
+	if r.Body.v != nil {
+		out.Body = &r.Body
+	}
+	return json.Marshal(out)
+}
+
+func unixNano(t time.Time) string {
+	if t.IsZero() {
+		return "0"
+	}
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// Resource describes the service producing the logs. It becomes the
+// resource attributes and the instrumentation scope of every request.
+type Resource struct {
+	ServiceName    string
+	ServiceVersion string
+	HostName       string
+}
+
+// Marshal encodes records as an ExportLogsServiceRequest, the body of an
+// OTLP/HTTP request and the line format of OTLP JSON files.
+func Marshal(res Resource, records []LogRecord) ([]byte, error) {
+	type scope struct {
+		Name    string `json:"name"`
+		Version string `json:"version,omitempty"`
+	}
+	type scopeLogs struct {
+		Scope      scope       `json:"scope"`
+		LogRecords []LogRecord `json:"logRecords"`
+	}
+	type resource struct {
+		Attributes []KeyValue `json:"attributes"`
+	}
+	type resourceLogs struct {
+		Resource  resource    `json:"resource"`
+		ScopeLogs []scopeLogs `json:"scopeLogs"`
+	}
+	type request struct {
+		ResourceLogs []resourceLogs `json:"resourceLogs"`
+	}
+
+	attrs := []KeyValue{Attr("service.name", String(res.ServiceName))}
+	if res.ServiceVersion != "" {
+		attrs = append(attrs, Attr("service.version", String(res.ServiceVersion)))
+	}
+	if res.HostName != "" {
+		attrs = append(attrs, Attr("host.name", String(res.HostName)))
+	}
+	return json.Marshal(request{
+		ResourceLogs: []resourceLogs{{
+			Resource: resource{Attributes: attrs},
+			ScopeLogs: []scopeLogs{{
+				Scope:      scope{Name: res.ServiceName, Version: res.ServiceVersion},
+				LogRecords: records,
+			}},
+		}},
+	})
+}
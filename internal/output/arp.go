@@ -0,0 +1,26 @@
+package output
+
+import "time"
+
+// ARPRecord represents a captured ARP packet in JSON-serializable format.
+// Like PacketRecord it has type "packet"; protocol tells them apart.
+type ARPRecord struct {
+	Type          string      `json:"type"` // Always "packet"
+	SchemaVersion int         `json:"schema_version"`
+	Timestamp     string      `json:"timestamp"`
+	Time          time.Time   `json:"-"` // The event time, at full precision
+	Interface     string      `json:"interface,omitempty"`
+	Protocol      string      `json:"protocol"`  // Always "ARP"
+	Operation     string      `json:"operation"` // "request", "reply", or "unknown"
+	SenderMAC     string      `json:"sender_mac"`
+	SenderIP      string      `json:"sender_ip"`
+	TargetMAC     string      `json:"target_mac"`
+	TargetIP      string      `json:"target_ip"`
+	Direction     string      `json:"direction"` // "in", "out", or "unknown"
+	Length        int         `json:"length"`
+	CapLen        int         `json:"caplen,omitempty"`
+	VLANs         []uint16    `json:"vlan,omitempty"`
+	Encap         []EncapInfo `json:"encap,omitempty"`
+	Gratuitous    bool        `json:"gratuitous,omitempty"`
+	Probe         bool        `json:"probe,omitempty"`
+}
@@ -0,0 +1,268 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ECSVersion is the Elastic Common Schema version of ECS documents.
+const ECSVersion = "8.11.0"
+
+// ECSEncoder renders records as Elastic Common Schema documents, one JSON
+// object per line. Fields ECS has no place for go under "portlens".
+type ECSEncoder struct{}
+
+// Encode renders one record, including the trailing newline. Records ECS
+// has no mapping for are written as plain JSON.
+func (ECSEncoder) Encode(v any) ([]byte, error) {
+	var data []byte
+	var err error
+	if doc := ECSDocument(v); doc != nil {
+		data, err = json.Marshal(doc)
+	} else {
+		data, err = json.Marshal(v)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// ECSDocument maps a record onto ECS fields, as nested objects. It returns
+// nil for records without a mapping.
+func ECSDocument(v any) map[string]any {
+	d := make(ecsDocument)
+	switch r := v.(type) {
+	case PacketRecord:
+		d.packet(&r)
+	case *PacketRecord:
+		d.packet(r)
+	case ARPRecord:
+		d.arp(&r)
+	case *ARPRecord:
+		d.arp(r)
+	case ConnectionRecord:
+		d.connection(&r)
+	case *ConnectionRecord:
+		d.connection(r)
+	case NeighborRecord:
+		d.neighbor(&r)
+	case *NeighborRecord:
+		d.neighbor(r)
+	default:
+		return nil
+	}
+	return d
+}
+
+// ecsDocument is an ECS document under construction.
+type ecsDocument map[string]any
+
+// set sets a dotted field such as "source.ip", creating the objects on the
+// way. Empty strings are left out.
+func (d ecsDocument) set(field string, value any) {
+	switch v := value.(type) {
+	case nil:
+		return
+	case string:
+		if v == "" {
+			return
+		}
+	}
+	m := map[string]any(d)
+	path := strings.Split(field, ".")
+	for _, key := range path[:len(path)-1] {
+		next, ok := m[key].(map[string]any)
+		if !ok {
+			next = make(map[string]any)
+			m[key] = next
+		}
+		m = next
+	}
+	m[path[len(path)-1]] = value
+}
+
+// base sets the fields every document has.
+func (d ecsDocument) base(t time.Time, dataset, message string) {
+	d.set("@timestamp", t.UTC().Format(time.RFC3339Nano))
+	d.set("ecs.version", ECSVersion)
+	d.set("event.kind", "event")
+	d.set("event.category", []string{"network"})
+	d.set("event.module", "portlens")
+	d.set("event.dataset", dataset)
+	d.set("message", message)
+}
+
+func (d ecsDocument) packet(r *PacketRecord) {
+	d.base(r.Time, "portlens.packet", summary(r))
+	d.set("event.type", []string{"connection"})
+	d.capture(r.Interface, r.Direction, r.Length, r.CapLen, r.VLANs, r.Encap)
+
+	transport := strings.ToLower(r.Protocol)
+	d.set("network.transport", transport)
+	d.set("network.iana_number", ianaNumber(transport))
+	d.set("network.type", ipVersion(r.SrcIP))
+	d.set("source.ip", r.SrcIP)
+	d.set("source.port", r.SrcPort)
+	d.set("destination.ip", r.DstIP)
+	d.set("destination.port", r.DstPort)
+	if r.PID != 0 {
+		d.set("process.pid", r.PID)
+		d.set("process.name", r.ProcessName)
+	}
+
+	if r.TCP != nil {
+		d.set("portlens.tcp.flags", r.TCP.Flags)
+		d.set("portlens.tcp.seq", r.TCP.Seq)
+		d.set("portlens.tcp.ack", r.TCP.Ack)
+	}
+	if r.UDP != nil {
+		d.set("portlens.udp.length", r.UDP.Length)
+	}
+	if r.Payload != nil {
+		d.set("portlens.payload.size", r.Payload.Size)
+		d.set("portlens.payload.head", r.Payload.Head)
+		d.set("portlens.payload.tail", r.Payload.Tail)
+	}
+}
+
+func (d ecsDocument) arp(r *ARPRecord) {
+	d.base(r.Time, "portlens.packet", summary(r))
+	d.set("event.type", []string{"protocol"})
+	d.capture(r.Interface, r.Direction, r.Length, r.CapLen, r.VLANs, r.Encap)
+
+	d.set("network.protocol", "arp")
+	d.set("source.ip", r.SenderIP)
+	d.set("source.mac", ecsMAC(r.SenderMAC))
+	d.set("destination.ip", r.TargetIP)
+	d.set("destination.mac", ecsMAC(r.TargetMAC))
+	d.set("portlens.arp.operation", r.Operation)
+	if r.Gratuitous {
+		d.set("portlens.arp.gratuitous", true)
+	}
+	if r.Probe {
+		d.set("portlens.arp.probe", true)
+	}
+}
+
+// capture sets the fields describing where and how a packet was captured.
+func (d ecsDocument) capture(iface, dir string, length, caplen int, vlans []uint16, encap []EncapInfo) {
+	switch dir {
+	case "in":
+		d.set("network.direction", "ingress")
+		d.set("observer.ingress.interface.name", iface)
+	case "out":
+		d.set("network.direction", "egress")
+		d.set("observer.egress.interface.name", iface)
+	default:
+		d.set("network.direction", "unknown")
+		d.set("observer.ingress.interface.name", iface)
+	}
+	d.set("network.bytes", length)
+	d.set("network.packets", 1)
+	if caplen != 0 {
+		d.set("portlens.caplen", caplen)
+	}
+	if len(vlans) > 0 {
+		d.set("network.vlan.id", fmt.Sprint(vlans[0]))
+	}
+	if len(vlans) > 1 {
+		d.set("network.inner.vlan.id", fmt.Sprint(vlans[1]))
+	}
+	if len(encap) > 0 {
+		d.set("portlens.encap", encap)
+	}
+}
+
+func (d ecsDocument) connection(r *ConnectionRecord) {
+	d.base(r.Time, "portlens.connection", summary(r))
+	c := &r.Connection
+	switch r.EventType {
+	case "opened":
+		d.set("event.type", []string{"connection", "start"})
+	case "closed":
+		d.set("event.type", []string{"connection", "end"})
+	default:
+		d.set("event.type", []string{"connection", "change"})
+	}
+	d.set("event.action", "connection-"+strings.ReplaceAll(r.EventType, "_", "-"))
+	d.set("event.reason", r.Reason)
+	if duration, err := time.ParseDuration(c.Duration); err == nil {
+		d.set("event.duration", duration.Nanoseconds())
+	}
+
+	transport := strings.ToLower(c.Protocol)
+	d.set("network.transport", transport)
+	d.set("network.iana_number", ianaNumber(transport))
+	d.set("network.type", ipVersion(c.SrcIP))
+	d.set("network.bytes", c.BytesSent+c.BytesRecv)
+	d.set("network.packets", c.PacketsSent+c.PacketsRecv)
+	d.set("source.ip", c.SrcIP)
+	d.set("source.port", c.SrcPort)
+	d.set("destination.ip", c.DstIP)
+	d.set("destination.port", c.DstPort)
+
+	d.set("portlens.connection.state", c.State)
+	d.set("portlens.connection.packets_sent", c.PacketsSent)
+	d.set("portlens.connection.packets_recv", c.PacketsRecv)
+	d.set("portlens.connection.bytes_sent", c.BytesSent)
+	d.set("portlens.connection.bytes_recv", c.BytesRecv)
+}
+
+func (d ecsDocument) neighbor(r *NeighborRecord) {
+	d.base(r.Time, "portlens.neighbor", summary(r))
+	n := &r.Neighbor
+	if neighborWarning(r.EventType) {
+		d.set("event.type", []string{"change"})
+	} else {
+		d.set("event.type", []string{"info"})
+	}
+	d.set("event.action", "neighbor-"+strings.ReplaceAll(r.EventType, "_", "-"))
+	d.set("network.protocol", "arp")
+	d.set("source.ip", n.IP)
+	d.set("source.mac", ecsMAC(n.MAC))
+	d.set("portlens.neighbor.old_mac", ecsMAC(n.OldMAC))
+}
+
+// summary returns the text format's line for a record, without payload
+// hexdump or trailing newline.
+func summary(v any) string {
+	line, err := (&TextEncoder{}).Encode(v)
+	if err != nil {
+		return ""
+	}
+	first, _, _ := strings.Cut(string(line), "\n")
+	return first
+}
+
+// neighborWarning reports whether a neighbor event may be spoofing.
+func neighborWarning(eventType string) bool {
+	return eventType == "mac_change" || eventType == "ip_conflict"
+}
+
+// ecsMAC writes a MAC address the way ECS wants it: uppercase, with
+// hyphens.
+func ecsMAC(mac string) string {
+	return strings.ToUpper(strings.ReplaceAll(mac, ":", "-"))
+}
+
+// ipVersion returns "ipv4" or "ipv6" for an address.
+func ipVersion(ip string) string {
+	if strings.Contains(ip, ":") {
+		return "ipv6"
+	}
+	return "ipv4"
+}
+
+// ianaNumber returns the IANA protocol number of a transport protocol.
+func ianaNumber(transport string) string {
+	switch transport {
+	case "tcp":
+		return "6"
+	case "udp":
+		return "17"
+	}
+	return ""
+}
@@ -0,0 +1,113 @@
+package output
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// field returns a dotted field of a decoded document.
+func field(doc map[string]any, path ...string) any {
+	var v any = doc
+	for _, key := range path {
+		m, ok := v.(map[string]any)
+		if !ok {
+			return nil
+		}
+		v = m[key]
+	}
+	return v
+}
+
+func decodeECS(t *testing.T, v any) map[string]any {
+	t.Helper()
+	line, err := ECSEncoder{}.Encode(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(line, &doc); err != nil {
+		t.Fatalf("decode %s: %v", line, err)
+	}
+	return doc
+}
+
+func TestECSPacket(t *testing.T) {
+	doc := decodeECS(t, PacketRecord{
+		Time:      time.Date(2025, 12, 24, 10, 30, 46, 500000042, time.UTC),
+		Interface: "eth0",
+		Protocol:  "TCP",
+		SrcIP:     "10.0.0.5", SrcPort: 51234,
+		DstIP: "93.184.216.34", DstPort: 443,
+		Direction: "out",
+		Length:    74,
+		VLANs:     []uint16{100, 200},
+		PID:       4242, ProcessName: "curl",
+		TCP: &TCPInfo{Seq: 1, Flags: "SYN"},
+	})
+
+	want := map[string]any{
+		"@timestamp":                     "2025-12-24T10:30:46.500000042Z",
+		"ecs.version":                    ECSVersion,
+		"event.dataset":                  "portlens.packet",
+		"network.transport":              "tcp",
+		"network.iana_number":            "6",
+		"network.type":                   "ipv4",
+		"network.direction":              "egress",
+		"network.bytes":                  74.0,
+		"network.vlan.id":                "100",
+		"network.inner.vlan.id":          "200",
+		"observer.egress.interface.name": "eth0",
+		"source.ip":                      "10.0.0.5",
+		"source.port":                    51234.0,
+		"destination.ip":                 "93.184.216.34",
+		"destination.port":               443.0,
+		"process.pid":                    4242.0,
+		"process.name":                   "curl",
+		"portlens.tcp.flags":             "SYN",
+	}
+	for name, v := range want {
+		if got := field(doc, strings.Split(name, ".")...); got != v {
+			t.Errorf("%s = %#v, want %#v", name, got, v)
+		}
+	}
+	if msg, _ := doc["message"].(string); msg == "" {
+		t.Error("message is empty")
+	}
+}
+
+func TestECSEvents(t *testing.T) {
+	conn := decodeECS(t, &ConnectionRecord{
+		EventType: "closed",
+		Time:      time.Date(2025, 12, 24, 10, 30, 46, 500000042, time.UTC),
+		Reason:    "shutdown",
+		Connection: ConnectionInfo{
+			SrcIP: "10.0.0.5", SrcPort: 51234, DstIP: "10.0.0.9", DstPort: 22,
+			Protocol: "TCP", State: "ESTABLISHED", Duration: "1.5s",
+			PacketsSent: 3, PacketsRecv: 2, BytesSent: 100, BytesRecv: 50,
+		},
+	})
+	if types, _ := json.Marshal(field(conn, "event", "type")); string(types) != `["connection","end"]` {
+		t.Errorf("event.type = %s", types)
+	}
+	if field(conn, "event", "duration") != 1.5e9 || field(conn, "network", "bytes") != 150.0 || field(conn, "event", "reason") != "shutdown" {
+		t.Errorf("connection document = %v", conn)
+	}
+
+	neighbor := decodeECS(t, NeighborRecord{
+		EventType: "mac_change",
+		Time:      time.Date(2025, 12, 24, 10, 30, 46, 500000042, time.UTC),
+		Neighbor:  NeighborInfo{IP: "10.0.0.1", MAC: "aa:bb:cc:dd:ee:ff", OldMAC: "00:11:22:33:44:55"},
+	})
+	if field(neighbor, "source", "mac") != "AA-BB-CC-DD-EE-FF" || field(neighbor, "event", "action") != "neighbor-mac-change" {
+		t.Errorf("neighbor document = %v", neighbor)
+	}
+}
+
+func TestECSUnmapped(t *testing.T) {
+	line, err := ECSEncoder{}.Encode(map[string]int{"a": 1})
+	if err != nil || string(line) != "{\"a\":1}\n" {
+		t.Errorf("Encode = %q, %v", line, err)
+	}
+}
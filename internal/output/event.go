@@ -0,0 +1,60 @@
+package output
+
+import "time"
+
+// SchemaVersion is the version of the record schemas printed by
+// "portlens schema". It is raised whenever a field is renamed, removed or
+// changes meaning; adding a field does not change it.
+const SchemaVersion = 1
+
+// Record types, the value of every record's "type" field.
+const (
+	TypePacket     = "packet"
+	TypeConnection = "connection"
+	TypeNeighbor   = "neighbor"
+	TypeStats      = "stats"
+)
+
+// ConnectionRecord is a connection state change from the tracker (--stateful).
+type ConnectionRecord struct {
+	Type          string         `json:"type"` // Always "connection"
+	SchemaVersion int            `json:"schema_version"`
+	EventType     string         `json:"event_type"` // "opened", "state_change", or "closed"
+	Timestamp     string         `json:"timestamp"`
+	Time          time.Time      `json:"-"`                // The event time, at full precision
+	Reason        string         `json:"reason,omitempty"` // Why a connection closed without FIN/RST ("shutdown")
+	Connection    ConnectionInfo `json:"connection"`
+}
+
+// ConnectionInfo describes a tracked connection. Src is the "lower"
+// endpoint of the normalized key, not necessarily the initiator.
+type ConnectionInfo struct {
+	SrcIP       string `json:"src_ip"`
+	SrcPort     uint16 `json:"src_port"`
+	DstIP       string `json:"dst_ip"`
+	DstPort     uint16 `json:"dst_port"`
+	Protocol    string `json:"protocol"`
+	State       string `json:"state"`
+	Duration    string `json:"duration"`
+	PacketsSent uint64 `json:"packets_sent"`
+	PacketsRecv uint64 `json:"packets_recv"`
+	BytesSent   uint64 `json:"bytes_sent"`
+	BytesRecv   uint64 `json:"bytes_recv"`
+}
+
+// NeighborRecord is a change in the ARP neighbor table.
+type NeighborRecord struct {
+	Type          string       `json:"type"` // Always "neighbor"
+	SchemaVersion int          `json:"schema_version"`
+	EventType     string       `json:"event_type"` // "new", "gratuitous", "mac_change", or "ip_conflict"
+	Timestamp     string       `json:"timestamp"`
+	Time          time.Time    `json:"-"` // The event time, at full precision
+	Neighbor      NeighborInfo `json:"neighbor"`
+}
+
+// NeighborInfo is an IP→MAC binding.
+type NeighborInfo struct {
+	IP     string `json:"ip"`
+	MAC    string `json:"mac"`
+	OldMAC string `json:"old_mac,omitempty"` // Only for mac_change and ip_conflict
+}
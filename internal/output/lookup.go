@@ -0,0 +1,108 @@
+package output
+
+// Lookup returns a field of the record by its JSON name, with nested
+// objects joined by dots ("tcp.flags"). It lets filter expressions run on
+// records without encoding them first. Fields omitted from the JSON
+// output are reported as missing.
+func (r *PacketRecord) Lookup(field string) (any, bool) {
+	switch field {
+	case "type":
+		return r.Type, true
+	case "timestamp":
+		return r.Timestamp, true
+	case "interface":
+		return r.Interface, r.Interface != ""
+	case "protocol":
+		return r.Protocol, true
+	case "src_ip":
+		return r.SrcIP, true
+	case "src_port":
+		return r.SrcPort, true
+	case "dst_ip":
+		return r.DstIP, true
+	case "dst_port":
+		return r.DstPort, true
+	case "direction":
+		return r.Direction, true
+	case "length":
+		return r.Length, true
+	case "caplen":
+		return r.CapLen, r.CapLen != 0
+	case "vlan":
+		return r.VLANs, len(r.VLANs) > 0
+	case "encap":
+		return encapTypes(r.Encap), len(r.Encap) > 0
+	case "pid":
+		return r.PID, r.PID != 0
+	case "process":
+		return r.ProcessName, r.ProcessName != ""
+	}
+
+	if r.TCP != nil {
+		switch field {
+		case "tcp.seq":
+			return r.TCP.Seq, true
+		case "tcp.ack":
+			return r.TCP.Ack, true
+		case "tcp.flags":
+			return r.TCP.Flags, true
+		}
+	}
+	if r.UDP != nil && field == "udp.length" {
+		return r.UDP.Length, true
+	}
+	if r.Payload != nil && field == "payload.size" {
+		return r.Payload.Size, true
+	}
+	return nil, false
+}
+
+// Lookup returns a field of the record by its JSON name. src_ip and dst_ip
+// are aliases for sender_ip and target_ip, so address filters work on ARP
+// records too.
+func (r *ARPRecord) Lookup(field string) (any, bool) {
+	switch field {
+	case "type":
+		return r.Type, true
+	case "timestamp":
+		return r.Timestamp, true
+	case "interface":
+		return r.Interface, r.Interface != ""
+	case "protocol":
+		return r.Protocol, true
+	case "operation":
+		return r.Operation, true
+	case "sender_mac":
+		return r.SenderMAC, true
+	case "sender_ip", "src_ip":
+		return r.SenderIP, true
+	case "target_mac":
+		return r.TargetMAC, true
+	case "target_ip", "dst_ip":
+		return r.TargetIP, true
+	case "direction":
+		return r.Direction, true
+	case "length":
+		return r.Length, true
+	case "caplen":
+		return r.CapLen, r.CapLen != 0
+	case "vlan":
+		return r.VLANs, len(r.VLANs) > 0
+	case "encap":
+		return encapTypes(r.Encap), len(r.Encap) > 0
+	case "gratuitous":
+		return r.Gratuitous, true
+	case "probe":
+		return r.Probe, true
+	}
+	return nil, false
+}
+
+// encapTypes lists the tunnel types of the encapsulation layers.
+func encapTypes(encap []EncapInfo) []string {
+	types := make([]string, len(encap))
+	for i, e := range encap {
+		types[i] = e.Type
+	}
+	return types
+}
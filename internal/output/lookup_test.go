@@ -0,0 +1,36 @@
+package output
+
+import "testing"
+
+func TestPacketRecordLookup(t *testing.T) {
+	rec := &PacketRecord{
+		Protocol: "TCP",
+		SrcIP:    "10.0.0.1",
+		DstPort:  443,
+		VLANs:    []uint16{100},
+		TCP:      &TCPInfo{Flags: "RST"},
+	}
+
+	if v, ok := rec.Lookup("dst_port"); !ok || v != uint16(443) {
+		t.Errorf("Lookup(dst_port) = %v, %v", v, ok)
+	}
+	if v, ok := rec.Lookup("tcp.flags"); !ok || v != "RST" {
+		t.Errorf("Lookup(tcp.flags) = %v, %v", v, ok)
+	}
+	for _, field := range []string{"udp.length", "pid", "process", "caplen", "no_such_field"} {
+		if v, ok := rec.Lookup(field); ok {
+			t.Errorf("Lookup(%s) = %v, want missing", field, v)
+		}
+	}
+}
+
+func TestARPRecordLookupAliases(t *testing.T) {
+	rec := &ARPRecord{SenderIP: "10.0.0.1", TargetIP: "10.0.0.2"}
+
+	if v, _ := rec.Lookup("src_ip"); v != "10.0.0.1" {
+		t.Errorf("Lookup(src_ip) = %v, want sender IP", v)
+	}
+	if v, _ := rec.Lookup("dst_ip"); v != "10.0.0.2" {
+		t.Errorf("Lookup(dst_ip) = %v, want target IP", v)
+	}
+}
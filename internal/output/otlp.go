@@ -0,0 +1,194 @@
+package output
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hwang-fu/portlens/internal/otlp"
+)
+
+// OTLPEncoder renders records in the OpenTelemetry logs data model, one
+// OTLP JSON export request per line, as read by collectors' OTLP JSON file
+// receivers.
+type OTLPEncoder struct {
+	Resource otlp.Resource
+}
+
+// Encode renders one record, including the trailing newline.
+func (e *OTLPEncoder) Encode(v any) ([]byte, error) {
+	rec, err := OTLPLogRecord(v)
+	if err != nil {
+		return nil, err
+	}
+	data, err := otlp.Marshal(e.Resource, []otlp.LogRecord{rec})
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// OTLPLogRecord maps a record onto an OpenTelemetry log record. The body
+// is the record's line in the text format; attributes follow the
+// OpenTelemetry semantic conventions where they have a name for a field,
+// and go under "portlens." otherwise. Records without a mapping are an
+// error.
+func OTLPLogRecord(v any) (otlp.LogRecord, error) {
+	var l logRecord
+	switch r := v.(type) {
+	case PacketRecord:
+		l.packet(&r)
+	case *PacketRecord:
+		l.packet(r)
+	case ARPRecord:
+		l.arp(&r)
+	case *ARPRecord:
+		l.arp(r)
+	case ConnectionRecord:
+		l.connection(&r)
+	case *ConnectionRecord:
+		l.connection(r)
+	case NeighborRecord:
+		l.neighbor(&r)
+	case *NeighborRecord:
+		l.neighbor(r)
+	default:
+		return otlp.LogRecord{}, fmt.Errorf("no OTLP mapping for %T", v)
+	}
+	return l.LogRecord, nil
+}
+
+// logRecord is an OpenTelemetry log record under construction.
+type logRecord struct {
+	otlp.LogRecord
+}
+
+// base sets the fields every record has.
+func (l *logRecord) base(t time.Time, eventName, body string) {
+	l.Time = t
+	l.ObservedTime = time.Now()
+	l.SeverityNumber = otlp.SeverityInfo
+	l.SeverityText = "INFO"
+	l.EventName = eventName
+	l.Body = otlp.String(body)
+}
+
+// str adds a string attribute, unless it is empty.
+func (l *logRecord) str(key, value string) {
+	if value != "" {
+		l.Attributes = append(l.Attributes, otlp.Attr(key, otlp.String(value)))
+	}
+}
+
+// num adds an integer attribute.
+func (l *logRecord) num(key string, value int64) {
+	l.Attributes = append(l.Attributes, otlp.Attr(key, otlp.Int(value)))
+}
+
+// flag adds a boolean attribute if it is set.
+func (l *logRecord) flag(key string, value bool) {
+	if value {
+		l.Attributes = append(l.Attributes, otlp.Attr(key, otlp.Bool(true)))
+	}
+}
+
+func (l *logRecord) packet(r *PacketRecord) {
+	l.base(r.Time, "portlens.packet", summary(r))
+	l.capture(r.Interface, r.Direction, r.Length, r.CapLen, r.VLANs, r.Encap)
+	l.str("network.transport", strings.ToLower(r.Protocol))
+	l.str("network.type", ipVersion(r.SrcIP))
+	l.str("source.address", r.SrcIP)
+	l.num("source.port", int64(r.SrcPort))
+	l.str("destination.address", r.DstIP)
+	l.num("destination.port", int64(r.DstPort))
+	if r.PID != 0 {
+		l.num("process.pid", int64(r.PID))
+		l.str("process.executable.name", r.ProcessName)
+	}
+
+	if r.TCP != nil {
+		l.str("portlens.tcp.flags", r.TCP.Flags)
+		l.num("portlens.tcp.seq", int64(r.TCP.Seq))
+		l.num("portlens.tcp.ack", int64(r.TCP.Ack))
+	}
+	if r.UDP != nil {
+		l.num("portlens.udp.length", int64(r.UDP.Length))
+	}
+	if r.Payload != nil {
+		l.num("portlens.payload.size", int64(r.Payload.Size))
+		l.str("portlens.payload.head", r.Payload.Head)
+		l.str("portlens.payload.tail", r.Payload.Tail)
+	}
+}
+
+func (l *logRecord) arp(r *ARPRecord) {
+	l.base(r.Time, "portlens.packet", summary(r))
+	l.capture(r.Interface, r.Direction, r.Length, r.CapLen, r.VLANs, r.Encap)
+	l.str("network.protocol.name", "arp")
+	l.str("source.address", r.SenderIP)
+	l.str("destination.address", r.TargetIP)
+	l.str("portlens.arp.operation", r.Operation)
+	l.str("portlens.arp.sender_mac", r.SenderMAC)
+	l.str("portlens.arp.target_mac", r.TargetMAC)
+	l.flag("portlens.arp.gratuitous", r.Gratuitous)
+	l.flag("portlens.arp.probe", r.Probe)
+}
+
+// capture adds the attributes describing where and how a packet was
+// captured.
+func (l *logRecord) capture(iface, dir string, length, caplen int, vlans []uint16, encap []EncapInfo) {
+	l.str("network.interface.name", iface)
+	switch dir {
+	case "in":
+		l.str("network.io.direction", "receive")
+	case "out":
+		l.str("network.io.direction", "transmit")
+	}
+	l.num("portlens.length", int64(length))
+	if caplen != 0 {
+		l.num("portlens.caplen", int64(caplen))
+	}
+	if len(vlans) > 0 {
+		l.num("portlens.vlan", int64(vlans[0]))
+	}
+	if len(encap) > 0 {
+		types := make([]string, len(encap))
+		for i, e := range encap {
+			types[i] = e.Type
+		}
+		l.str("portlens.encap", strings.Join(types, ","))
+	}
+}
+
+func (l *logRecord) connection(r *ConnectionRecord) {
+	l.base(r.Time, "portlens.connection."+r.EventType, summary(r))
+	c := &r.Connection
+	l.str("network.transport", strings.ToLower(c.Protocol))
+	l.str("network.type", ipVersion(c.SrcIP))
+	l.str("source.address", c.SrcIP)
+	l.num("source.port", int64(c.SrcPort))
+	l.str("destination.address", c.DstIP)
+	l.num("destination.port", int64(c.DstPort))
+	l.str("portlens.connection.state", c.State)
+	l.str("portlens.connection.reason", r.Reason)
+	if duration, err := time.ParseDuration(c.Duration); err == nil {
+		l.num("portlens.connection.duration_ns", duration.Nanoseconds())
+	}
+	l.num("portlens.connection.packets_sent", int64(c.PacketsSent))
+	l.num("portlens.connection.packets_recv", int64(c.PacketsRecv))
+	l.num("portlens.connection.bytes_sent", int64(c.BytesSent))
+	l.num("portlens.connection.bytes_recv", int64(c.BytesRecv))
+}
+
+func (l *logRecord) neighbor(r *NeighborRecord) {
+	l.base(r.Time, "portlens.neighbor."+r.EventType, summary(r))
+	if neighborWarning(r.EventType) {
+		l.SeverityNumber = otlp.SeverityWarn
+		l.SeverityText = "WARN"
+	}
+	n := &r.Neighbor
+	l.str("network.protocol.name", "arp")
+	l.str("source.address", n.IP)
+	l.str("portlens.neighbor.mac", n.MAC)
+	l.str("portlens.neighbor.old_mac", n.OldMAC)
+}
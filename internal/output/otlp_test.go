@@ -0,0 +1,118 @@
+package output
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hwang-fu/portlens/internal/otlp"
+)
+
+func attributes(rec otlp.LogRecord) map[string]any {
+	attrs := make(map[string]any)
+	for _, kv := range rec.Attributes {
+		attrs[kv.Key] = kv.Value.Value()
+	}
+	return attrs
+}
+
+func TestOTLPLogRecordPacket(t *testing.T) {
+	rec, err := OTLPLogRecord(&PacketRecord{
+		Time:      time.Date(2025, 12, 24, 10, 30, 46, 500000042, time.UTC),
+		Interface: "eth0",
+		Protocol:  "UDP",
+		SrcIP:     "2001:db8::1", SrcPort: 5353,
+		DstIP: "2001:db8::2", DstPort: 53,
+		Direction: "in",
+		Length:    90,
+		PID:       7, ProcessName: "resolved",
+		UDP: &UDPInfo{Length: 36},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !rec.Time.Equal(time.Date(2025, 12, 24, 10, 30, 46, 500000042, time.UTC)) {
+		t.Errorf("Time = %v", rec.Time)
+	}
+	if rec.EventName != "portlens.packet" || rec.SeverityNumber != otlp.SeverityInfo {
+		t.Errorf("record = %+v", rec)
+	}
+	if body, _ := rec.Body.Value().(string); !strings.Contains(body, "resolved[7]") {
+		t.Errorf("body = %q", body)
+	}
+
+	want := map[string]any{
+		"network.transport":       "udp",
+		"network.type":            "ipv6",
+		"network.interface.name":  "eth0",
+		"network.io.direction":    "receive",
+		"source.address":          "2001:db8::1",
+		"source.port":             int64(5353),
+		"destination.port":        int64(53),
+		"process.pid":             int64(7),
+		"process.executable.name": "resolved",
+		"portlens.udp.length":     int64(36),
+	}
+	attrs := attributes(rec)
+	for key, v := range want {
+		if attrs[key] != v {
+			t.Errorf("%s = %#v, want %#v", key, attrs[key], v)
+		}
+	}
+}
+
+func TestOTLPLogRecordNeighbor(t *testing.T) {
+	rec, err := OTLPLogRecord(NeighborRecord{
+		EventType: "ip_conflict",
+		Time:      time.Date(2025, 12, 24, 10, 30, 46, 500000042, time.UTC),
+		Neighbor:  NeighborInfo{IP: "10.0.0.1", MAC: "aa:bb:cc:dd:ee:ff", OldMAC: "00:11:22:33:44:55"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.SeverityNumber != otlp.SeverityWarn || rec.EventName != "portlens.neighbor.ip_conflict" {
+		t.Errorf("record = %+v", rec)
+	}
+	if attributes(rec)["portlens.neighbor.old_mac"] != "00:11:22:33:44:55" {
+		t.Errorf("attributes = %v", attributes(rec))
+	}
+
+	if _, err := OTLPLogRecord(struct{}{}); err == nil {
+		t.Error("expected error for a record without mapping")
+	}
+}
+
+func TestOTLPEncoder(t *testing.T) {
+	e := &OTLPEncoder{Resource: otlp.Resource{ServiceName: "portlens"}}
+	line, err := e.Encode(ConnectionRecord{
+		EventType:  "opened",
+		Time:       time.Date(2025, 12, 24, 10, 30, 46, 500000042, time.UTC),
+		Connection: ConnectionInfo{SrcIP: "10.0.0.5", DstIP: "10.0.0.9", Protocol: "TCP", Duration: "0s"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(string(line), "}\n") || strings.Count(string(line), "\n") != 1 {
+		t.Fatalf("line = %q", line)
+	}
+
+	var req struct {
+		ResourceLogs []struct {
+			ScopeLogs []struct {
+				LogRecords []struct {
+					TimeUnixNano string `json:"timeUnixNano"`
+					EventName    string `json:"eventName"`
+				} `json:"logRecords"`
+			} `json:"scopeLogs"`
+		} `json:"resourceLogs"`
+	}
+	if err := json.Unmarshal(line, &req); err != nil {
+		t.Fatal(err)
+	}
+	rec := req.ResourceLogs[0].ScopeLogs[0].LogRecords[0]
+	if rec.TimeUnixNano != "1766572246500000042" || rec.EventName != "portlens.connection.opened" {
+		t.Errorf("record = %+v", rec)
+	}
+}
@@ -7,13 +7,25 @@ import (
 
 // PacketRecord represents a captured packet in JSON-serializable format.
 type PacketRecord struct {
-	Timestamp string `json:"timestamp"`
-	Protocol  string `json:"protocol"`
-	SrcIP     string `json:"src_ip"`
-	SrcPort   uint16 `json:"src_port"`
-	DstIP     string `json:"dst_ip"`
-	DstPort   uint16 `json:"dst_port"`
-	Direction string `json:"direction"` // "in", "out", or "unknown"
+	Type          string    `json:"type"` // Always "packet"
+	SchemaVersion int       `json:"schema_version"`
+	Timestamp     string    `json:"timestamp"`
+	Time          time.Time `json:"-"`                   // The event time, at full precision
+	Interface     string    `json:"interface,omitempty"` // Interface the packet was captured on
+	Protocol      string    `json:"protocol"`
+	SrcIP         string    `json:"src_ip"`
+	SrcPort       uint16    `json:"src_port"`
+	DstIP         string    `json:"dst_ip"`
+	DstPort       uint16    `json:"dst_port"`
+	Direction     string    `json:"direction"`        // "in", "out", or "unknown"
+	Length        int       `json:"length"`           // Frame length on the wire
+	CapLen        int       `json:"caplen,omitempty"` // Captured length, only if truncated by --snaplen
+
+	// VLAN IDs from 802.1Q/802.1ad tags, outermost first (empty if untagged)
+	VLANs []uint16 `json:"vlan,omitempty"`
+
+	// Tunnel layers peeled to reach this packet, outermost first
+	Encap []EncapInfo `json:"encap,omitempty"`
 
 	// Process info (may be empty if not found)
 	PID         int    `json:"pid,omitempty"`
@@ -39,16 +51,31 @@ type UDPInfo struct {
 	Length uint16 `json:"length"`
 }
 
+// EncapInfo describes one tunnel encapsulation layer (outer headers).
+type EncapInfo struct {
+	Type    string  `json:"type"` // "vxlan", "geneve", "gre", or "ipip"
+	SrcIP   string  `json:"src_ip"`
+	DstIP   string  `json:"dst_ip"`
+	SrcPort uint16  `json:"src_port,omitempty"` // UDP-based tunnels only
+	DstPort uint16  `json:"dst_port,omitempty"` // UDP-based tunnels only
+	VNI     *uint32 `json:"vni,omitempty"`      // VXLAN/Geneve network identifier
+	Key     *uint32 `json:"key,omitempty"`      // GRE key, if present
+}
+
 // PayloadInfo contains payload preview for verbose output.
 type PayloadInfo struct {
 	Size int    `json:"size"`           // Total payload size in bytes
 	Head string `json:"head,omitempty"` // First 64 bytes as hex
 	Tail string `json:"tail,omitempty"` // Last 64 bytes as hex (if different from head)
+
+	// Data is the whole payload, for the text format's hexdump. It shares
+	// the capture buffer, so it is only valid until the record is encoded.
+	Data []byte `json:"-"`
 }
 
-// Now returns the current time formatted as ISO 8601 with milliseconds.
+// Now returns the current time formatted with the configured time format.
 func Now() string {
-	return time.Now().UTC().Format("2006-01-02T15:04:05.000Z")
+	return FormatTime(time.Now())
 }
 
 // NewPayloadInfo creates a PayloadInfo from raw payload bytes.
@@ -58,7 +85,7 @@ func NewPayloadInfo(data []byte) *PayloadInfo {
 		return nil
 	}
 
-	info := &PayloadInfo{Size: len(data)}
+	info := &PayloadInfo{Size: len(data), Data: data}
 
 	// First 64 bytes (or less if payload is smaller)
 	headLen := min(64, len(data))
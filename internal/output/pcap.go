@@ -0,0 +1,156 @@
+package output
+
+import (
+	"encoding/binary"
+	"io"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Link types for the pcap global header.
+const (
+	LinkTypeEthernet   = 1
+	LinkTypeLinuxSLL2  = 276
+	pcapMagicNanos     = 0xa1b23c4d // Timestamps in nanoseconds
+	pcapVersionMajor   = 2
+	pcapVersionMinor   = 4
+	pcapDefaultSnaplen = 262144
+)
+
+// PcapWriter writes packets in the classic libpcap file format with
+// nanosecond timestamps. It is safe for concurrent use.
+//
+// The global header and every packet are passed to the underlying writer
+// in a single Write call, so a rotating writer can start a new file
+// between any two packets. PcapWriter does no buffering of its own.
+type PcapWriter struct {
+	mu  sync.Mutex
+	w   io.Writer
+	buf []byte // Scratch space for one record
+}
+
+// flusher is implemented by buffered writers such as bufio.Writer.
+type flusher interface {
+	Flush() error
+}
+
+// NewPcapWriter writes the pcap global header to w and returns a writer
+// for packets of the given link type. snaplen 0 means unlimited.
+func NewPcapWriter(w io.Writer, linkType uint32, snaplen int) (*PcapWriter, error) {
+	if snaplen <= 0 {
+		snaplen = pcapDefaultSnaplen
+	}
+
+	var hdr [24]byte
+	binary.LittleEndian.PutUint32(hdr[0:4], pcapMagicNanos)
+	binary.LittleEndian.PutUint16(hdr[4:6], pcapVersionMajor)
+	binary.LittleEndian.PutUint16(hdr[6:8], pcapVersionMinor)
+	// hdr[8:16]: thiszone and sigfigs, always zero
+	binary.LittleEndian.PutUint32(hdr[16:20], uint32(snaplen))
+	binary.LittleEndian.PutUint32(hdr[20:24], linkType)
+
+	if _, err := w.Write(hdr[:]); err != nil {
+		return nil, err
+	}
+	return &PcapWriter{w: w}, nil
+}
+
+// WritePacket appends one packet. data is the captured (possibly truncated)
+// bytes and origLen the packet's length on the wire.
+func (pw *PcapWriter) WritePacket(ts time.Time, data []byte, origLen int) error {
+	var hdr [16]byte
+	binary.LittleEndian.PutUint32(hdr[0:4], uint32(ts.Unix()))
+	binary.LittleEndian.PutUint32(hdr[4:8], uint32(ts.Nanosecond()))
+	binary.LittleEndian.PutUint32(hdr[8:12], uint32(len(data)))
+	binary.LittleEndian.PutUint32(hdr[12:16], uint32(max(origLen, len(data))))
+
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+
+	rec := append(append(pw.buf[:0], hdr[:]...), data...)
+	pw.buf = rec
+
+	_, err := pw.w.Write(rec)
+	return err
+}
+
+// Flush flushes the underlying writer if it is buffered.
+func (pw *PcapWriter) Flush() error {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	if f, ok := pw.w.(flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// pcapRecordHeader is the size of a packet record's header.
+const pcapRecordHeader = 16
+
+// PacketRing keeps the most recent packets, up to a total size, so they
+// can be written as a pcap file on demand. It is safe for concurrent use.
+type PacketRing struct {
+	mu      sync.Mutex
+	max     int
+	size    int // Of the packets held, in pcap records
+	packets []ringPacket
+}
+
+type ringPacket struct {
+	ts      time.Time
+	data    []byte
+	origLen int
+}
+
+// NewPacketRing returns a ring holding up to maxBytes of pcap records.
+func NewPacketRing(maxBytes int) *PacketRing {
+	return &PacketRing{max: maxBytes}
+}
+
+// Add appends a packet, dropping the oldest ones to make room. The ring
+// keeps data, which must not be modified afterwards.
+func (r *PacketRing) Add(ts time.Time, data []byte, origLen int) {
+	n := pcapRecordHeader + len(data)
+	if n > r.max {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.packets = append(r.packets, ringPacket{ts, data, origLen})
+	r.size += n
+	drop := 0
+	for r.size > r.max {
+		r.size -= pcapRecordHeader + len(r.packets[drop].data)
+		r.packets[drop] = ringPacket{}
+		drop++
+	}
+	r.packets = r.packets[drop:]
+}
+
+// Len returns how many packets the ring holds and their size in pcap
+// records.
+func (r *PacketRing) Len() (packets, size int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.packets), r.size
+}
+
+// WritePcap writes the packets held as a pcap file and returns how many
+// it wrote. Packets added meanwhile are left out.
+func (r *PacketRing) WritePcap(w io.Writer, linkType uint32, snaplen int) (int, error) {
+	r.mu.Lock()
+	packets := slices.Clone(r.packets)
+	r.mu.Unlock()
+
+	pw, err := NewPcapWriter(w, linkType, snaplen)
+	if err != nil {
+		return 0, err
+	}
+	for i, p := range packets {
+		if err := pw.WritePacket(p.ts, p.data, p.origLen); err != nil {
+			return i, err
+		}
+	}
+	return len(packets), pw.Flush()
+}
@@ -0,0 +1,87 @@
+package output
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestPcapWriter(t *testing.T) {
+	var buf bytes.Buffer
+
+	pw, err := NewPcapWriter(&buf, LinkTypeEthernet, 96)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ts := time.Unix(1766572245, 123456789)
+	data := []byte{0xde, 0xad, 0xbe, 0xef}
+	if err := pw.WritePacket(ts, data, 1500); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := pw.Flush(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	out := buf.Bytes()
+	if len(out) != 24+16+4 {
+		t.Fatalf("output length = %d, want %d", len(out), 24+16+4)
+	}
+
+	if magic := binary.LittleEndian.Uint32(out[0:4]); magic != 0xa1b23c4d {
+		t.Errorf("magic = 0x%08x, want 0xa1b23c4d", magic)
+	}
+
+	if snaplen := binary.LittleEndian.Uint32(out[16:20]); snaplen != 96 {
+		t.Errorf("snaplen = %d, want 96", snaplen)
+	}
+
+	if linkType := binary.LittleEndian.Uint32(out[20:24]); linkType != LinkTypeEthernet {
+		t.Errorf("link type = %d, want %d", linkType, LinkTypeEthernet)
+	}
+
+	rec := out[24:]
+	if sec := binary.LittleEndian.Uint32(rec[0:4]); sec != 1766572245 {
+		t.Errorf("ts_sec = %d, want 1766572245", sec)
+	}
+
+	if nsec := binary.LittleEndian.Uint32(rec[4:8]); nsec != 123456789 {
+		t.Errorf("ts_nsec = %d, want 123456789", nsec)
+	}
+
+	if caplen := binary.LittleEndian.Uint32(rec[8:12]); caplen != 4 {
+		t.Errorf("caplen = %d, want 4", caplen)
+	}
+
+	if origLen := binary.LittleEndian.Uint32(rec[12:16]); origLen != 1500 {
+		t.Errorf("len = %d, want 1500", origLen)
+	}
+}
+
+func TestPacketRing(t *testing.T) {
+	// Room for two 4-byte packets
+	r := NewPacketRing(2 * (16 + 4))
+	ts := time.Unix(1766572245, 0)
+	for i := range byte(3) {
+		r.Add(ts.Add(time.Duration(i)*time.Second), []byte{i, i, i, i}, 60)
+	}
+	r.Add(ts, make([]byte, 100), 100) // Larger than the ring
+	if packets, size := r.Len(); packets != 2 || size != 40 {
+		t.Fatalf("Len = %d, %d, want 2, 40", packets, size)
+	}
+
+	var buf bytes.Buffer
+	n, err := r.WritePcap(&buf, LinkTypeLinuxSLL2, 0)
+	if err != nil || n != 2 {
+		t.Fatalf("WritePcap = %d, %v", n, err)
+	}
+	out := buf.Bytes()
+	if len(out) != 24+2*20 || binary.LittleEndian.Uint32(out[20:24]) != LinkTypeLinuxSLL2 {
+		t.Fatalf("pcap of %d bytes, link type %d", len(out), binary.LittleEndian.Uint32(out[20:24]))
+	}
+	// The oldest packet made room
+	if sec := binary.LittleEndian.Uint32(out[24:28]); sec != 1766572246 || out[40] != 1 || out[60] != 2 {
+		t.Errorf("first packet at %d holds %d, second holds %d", sec, out[40], out[60])
+	}
+}
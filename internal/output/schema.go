@@ -0,0 +1,21 @@
+package output
+
+import (
+	"embed"
+	"fmt"
+)
+
+//go:embed schema/*.json
+var schemas embed.FS
+
+// SchemaTypes lists the record types that have a JSON Schema.
+var SchemaTypes = []string{TypePacket, TypeConnection, TypeNeighbor, TypeStats}
+
+// Schema returns the JSON Schema (draft 2020-12) of a record type.
+func Schema(recordType string) ([]byte, error) {
+	data, err := schemas.ReadFile("schema/" + recordType + ".json")
+	if err != nil {
+		return nil, fmt.Errorf("no schema for record type %q (want one of %v)", recordType, SchemaTypes)
+	}
+	return data, nil
+}
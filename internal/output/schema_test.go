@@ -0,0 +1,114 @@
+package output
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// schemaProperties collects every property name declared anywhere in a
+// schema, keyed by the path of the object it belongs to ("" for the top
+// level, "tcp" for a nested object). Definitions under $defs count as top
+// level, since packet records are a oneOf over them.
+func schemaProperties(schema map[string]any, path string, props map[string]bool) {
+	if p, ok := schema["properties"].(map[string]any); ok {
+		for name, sub := range p {
+			key := name
+			if path != "" {
+				key = path + "." + name
+			}
+			props[key] = true
+			if subSchema, ok := sub.(map[string]any); ok {
+				schemaProperties(subSchema, key, props)
+				if items, ok := subSchema["items"].(map[string]any); ok {
+					schemaProperties(items, key, props)
+				}
+			}
+		}
+	}
+	if defs, ok := schema["$defs"].(map[string]any); ok {
+		for _, def := range defs {
+			schemaProperties(def.(map[string]any), path, props)
+		}
+	}
+}
+
+// recordKeys collects the keys of a marshaled record the same way.
+func recordKeys(v any, path string, keys map[string]bool) {
+	switch v := v.(type) {
+	case map[string]any:
+		for name, sub := range v {
+			key := name
+			if path != "" {
+				key = path + "." + name
+			}
+			keys[key] = true
+			recordKeys(sub, key, keys)
+		}
+	case []any:
+		for _, elem := range v {
+			recordKeys(elem, path, keys)
+		}
+	}
+}
+
+// TestSchemasCoverRecords fails if a record has a field its schema does
+// not document, so schema and code cannot drift apart unnoticed.
+func TestSchemasCoverRecords(t *testing.T) {
+	vni := uint32(42)
+	records := map[string][]any{
+		TypePacket: {
+			PacketRecord{
+				Type: TypePacket, Interface: "eth0", VLANs: []uint16{1}, PID: 1, ProcessName: "x", CapLen: 1,
+				Encap:   []EncapInfo{{Type: "vxlan", SrcPort: 1, DstPort: 2, VNI: &vni, Key: &vni}},
+				TCP:     &TCPInfo{},
+				UDP:     &UDPInfo{},
+				Payload: &PayloadInfo{Head: "00", Tail: "00"},
+			},
+			ARPRecord{Type: TypePacket, Interface: "eth0", Gratuitous: true, Probe: true},
+		},
+		TypeConnection: {ConnectionRecord{Type: TypeConnection, Reason: "shutdown"}},
+		TypeNeighbor:   {NeighborRecord{Type: TypeNeighbor, Neighbor: NeighborInfo{OldMAC: "x"}}},
+	}
+
+	for recordType, recs := range records {
+		data, err := Schema(recordType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var schema map[string]any
+		if err := json.Unmarshal(data, &schema); err != nil {
+			t.Fatalf("%s schema: %v", recordType, err)
+		}
+		props := map[string]bool{}
+		schemaProperties(schema, "", props)
+
+		for _, rec := range recs {
+			encoded, _ := json.Marshal(rec)
+			var decoded map[string]any
+			json.Unmarshal(encoded, &decoded)
+
+			keys := map[string]bool{}
+			recordKeys(decoded, "", keys)
+			for key := range keys {
+				if !props[key] {
+					t.Errorf("%s record field %q is missing from the schema", recordType, key)
+				}
+			}
+		}
+	}
+}
+
+func TestSchemaTypes(t *testing.T) {
+	for _, recordType := range SchemaTypes {
+		data, err := Schema(recordType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !json.Valid(data) {
+			t.Errorf("%s schema is not valid JSON", recordType)
+		}
+	}
+	if _, err := Schema("nope"); err == nil {
+		t.Error("Schema(nope) succeeded")
+	}
+}
@@ -0,0 +1,231 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ANSI escape sequences used by the text format.
+const (
+	ansiReset   = "\x1b[0m"
+	ansiBold    = "\x1b[1m"
+	ansiDim     = "\x1b[2m"
+	ansiRed     = "\x1b[31m"
+	ansiGreen   = "\x1b[32m"
+	ansiYellow  = "\x1b[33m"
+	ansiBlue    = "\x1b[34m"
+	ansiMagenta = "\x1b[35m"
+	ansiCyan    = "\x1b[36m"
+)
+
+// TextEncoder renders records as human-readable lines, one per packet or
+// event, in the spirit of tcpdump:
+//
+//	12:00:01.000Z eth0 out curl[4242] 10.0.0.5:51234 → 93.184.216.34:443 TCP [SYN] len 74
+//
+// Payloads that carry their bytes (verbosity 3) follow as a hexdump.
+type TextEncoder struct {
+	Color bool // Colorize with ANSI escapes
+}
+
+// Encode renders one record, including the trailing newline. Records the
+// text format has no layout for are written as JSON.
+func (e *TextEncoder) Encode(v any) ([]byte, error) {
+	var b strings.Builder
+	switch r := v.(type) {
+	case PacketRecord:
+		e.packet(&b, &r)
+	case *PacketRecord:
+		e.packet(&b, r)
+	case ARPRecord:
+		e.arp(&b, &r)
+	case *ARPRecord:
+		e.arp(&b, r)
+	case ConnectionRecord:
+		e.connection(&b, &r)
+	case *ConnectionRecord:
+		e.connection(&b, r)
+	case NeighborRecord:
+		e.neighbor(&b, &r)
+	case *NeighborRecord:
+		e.neighbor(&b, r)
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		return append(data, '\n'), nil
+	}
+	return []byte(b.String()), nil
+}
+
+// paint wraps s in an ANSI color if coloring is enabled.
+func (e *TextEncoder) paint(color, s string) string {
+	if !e.Color || s == "" {
+		return s
+	}
+	return color + s + ansiReset
+}
+
+// prefix writes the columns shared by all packet lines: time, interface
+// and direction.
+func (e *TextEncoder) prefix(b *strings.Builder, ts, iface, dir string) {
+	b.WriteString(e.paint(ansiDim, ts))
+	if iface != "" {
+		b.WriteString(" " + e.paint(ansiCyan, iface))
+	}
+	fmt.Fprintf(b, " %-3s", dir)
+}
+
+func (e *TextEncoder) packet(b *strings.Builder, r *PacketRecord) {
+	e.prefix(b, r.Timestamp, r.Interface, r.Direction)
+	if r.ProcessName != "" {
+		b.WriteString(" " + e.paint(ansiMagenta, fmt.Sprintf("%s[%d]", r.ProcessName, r.PID)))
+	}
+	fmt.Fprintf(b, " %s → %s %s",
+		hostPort(r.SrcIP, r.SrcPort), hostPort(r.DstIP, r.DstPort),
+		e.paint(protocolColor(r.Protocol), r.Protocol))
+
+	if r.TCP != nil && r.TCP.Flags != "" {
+		b.WriteString(" " + e.paint(flagsColor(r.TCP.Flags), "["+r.TCP.Flags+"]"))
+	}
+	if len(r.VLANs) > 0 {
+		fmt.Fprintf(b, " vlan %v", r.VLANs)
+	}
+	for _, encap := range r.Encap {
+		fmt.Fprintf(b, " via %s", encap.Type)
+	}
+	e.length(b, r.Length, r.CapLen)
+	b.WriteByte('\n')
+
+	if r.Payload != nil {
+		b.WriteString(Hexdump(r.Payload.Data))
+	}
+}
+
+func (e *TextEncoder) arp(b *strings.Builder, r *ARPRecord) {
+	e.prefix(b, r.Timestamp, r.Interface, r.Direction)
+	b.WriteString(" " + e.paint(protocolColor(r.Protocol), r.Protocol))
+	switch r.Operation {
+	case "request":
+		fmt.Fprintf(b, " who-has %s tell %s (%s)", r.TargetIP, r.SenderIP, r.SenderMAC)
+	case "reply":
+		fmt.Fprintf(b, " reply %s is-at %s", r.SenderIP, r.SenderMAC)
+	default:
+		fmt.Fprintf(b, " %s %s → %s", r.Operation, r.SenderIP, r.TargetIP)
+	}
+	if r.Gratuitous {
+		b.WriteString(" gratuitous")
+	}
+	if r.Probe {
+		b.WriteString(" probe")
+	}
+	e.length(b, r.Length, r.CapLen)
+	b.WriteByte('\n')
+}
+
+// length writes the wire length, and the captured length if truncated.
+func (e *TextEncoder) length(b *strings.Builder, length, caplen int) {
+	fmt.Fprintf(b, " len %d", length)
+	if caplen != 0 {
+		fmt.Fprintf(b, " (caplen %d)", caplen)
+	}
+}
+
+func (e *TextEncoder) connection(b *strings.Builder, r *ConnectionRecord) {
+	c := &r.Connection
+	color := ansiGreen
+	if r.EventType == "closed" {
+		color = ansiRed
+	}
+	fmt.Fprintf(b, "%s %s %s %s ↔ %s %s %s tx %d/%dB rx %d/%dB",
+		e.paint(ansiDim, r.Timestamp),
+		e.paint(ansiBold, "conn"),
+		e.paint(color, r.EventType),
+		hostPort(c.SrcIP, c.SrcPort), hostPort(c.DstIP, c.DstPort),
+		c.Protocol, c.State, c.PacketsSent, c.BytesSent, c.PacketsRecv, c.BytesRecv)
+	fmt.Fprintf(b, " %s", c.Duration)
+	if r.Reason != "" {
+		fmt.Fprintf(b, " (%s)", r.Reason)
+	}
+	b.WriteByte('\n')
+}
+
+func (e *TextEncoder) neighbor(b *strings.Builder, r *NeighborRecord) {
+	n := &r.Neighbor
+	color := ansiGreen
+	if r.EventType == "mac_change" || r.EventType == "ip_conflict" {
+		color = ansiRed
+	}
+	fmt.Fprintf(b, "%s %s %s %s is-at %s",
+		e.paint(ansiDim, r.Timestamp),
+		e.paint(ansiBold, "neighbor"),
+		e.paint(color, r.EventType),
+		n.IP, n.MAC)
+	if n.OldMAC != "" {
+		fmt.Fprintf(b, " (was %s)", n.OldMAC)
+	}
+	b.WriteByte('\n')
+}
+
+// hostPort joins an address and port, bracketing IPv6 addresses.
+func hostPort(ip string, port uint16) string {
+	if strings.Contains(ip, ":") {
+		return fmt.Sprintf("[%s]:%d", ip, port)
+	}
+	return fmt.Sprintf("%s:%d", ip, port)
+}
+
+func protocolColor(protocol string) string {
+	switch protocol {
+	case "TCP":
+		return ansiBlue
+	case "UDP":
+		return ansiGreen
+	}
+	return ansiYellow
+}
+
+// flagsColor highlights connection setup and teardown.
+func flagsColor(flags string) string {
+	switch {
+	case strings.Contains(flags, "RST"):
+		return ansiRed
+	case strings.Contains(flags, "SYN"):
+		return ansiGreen
+	case strings.Contains(flags, "FIN"):
+		return ansiYellow
+	}
+	return ansiDim
+}
+
+// Hexdump formats data as offset, 16 hex bytes and their printable ASCII,
+// one indented line per 16 bytes.
+func Hexdump(data []byte) string {
+	var b strings.Builder
+	for off := 0; off < len(data); off += 16 {
+		line := data[off:min(off+16, len(data))]
+		fmt.Fprintf(&b, "    %04x  ", off)
+		for i := range 16 {
+			switch {
+			case i < len(line):
+				fmt.Fprintf(&b, "%02x ", line[i])
+			default:
+				b.WriteString("   ")
+			}
+			if i == 7 {
+				b.WriteByte(' ')
+			}
+		}
+		b.WriteString(" |")
+		for _, c := range line {
+			if c < 0x20 || c > 0x7e {
+				c = '.'
+			}
+			b.WriteByte(c)
+		}
+		b.WriteString("|\n")
+	}
+	return b.String()
+}
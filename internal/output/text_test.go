@@ -0,0 +1,92 @@
+package output
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTextEncoderPacket(t *testing.T) {
+	rec := PacketRecord{
+		Timestamp:   "12:00:01.000Z",
+		Interface:   "eth0",
+		Protocol:    "TCP",
+		SrcIP:       "10.0.0.5",
+		SrcPort:     51234,
+		DstIP:       "2001:db8::1",
+		DstPort:     443,
+		Direction:   "out",
+		Length:      74,
+		PID:         4242,
+		ProcessName: "curl",
+		TCP:         &TCPInfo{Flags: "SYN"},
+	}
+
+	e := &TextEncoder{}
+	got, err := e.Encode(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "12:00:01.000Z eth0 out curl[4242] 10.0.0.5:51234 → [2001:db8::1]:443 TCP [SYN] len 74\n"
+	if string(got) != want {
+		t.Errorf("Encode =\n%q\nwant\n%q", got, want)
+	}
+
+	e.Color = true
+	colored, _ := e.Encode(&rec)
+	if !strings.Contains(string(colored), ansiGreen+"[SYN]"+ansiReset) {
+		t.Errorf("colored output %q lacks green SYN flags", colored)
+	}
+}
+
+func TestTextEncoderHexdump(t *testing.T) {
+	payload := []byte("GET / HTTP/1.1\r\nHost: x\r\n")
+	rec := &PacketRecord{Protocol: "TCP", Length: 90, Payload: NewPayloadInfo(payload)}
+
+	got, _ := (&TextEncoder{}).Encode(rec)
+	lines := strings.Split(strings.TrimSuffix(string(got), "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("got %d lines, want packet line and 2 hexdump lines:\n%s", len(lines), got)
+	}
+	want := "    0000  47 45 54 20 2f 20 48 54  54 50 2f 31 2e 31 0d 0a  |GET / HTTP/1.1..|"
+	if lines[1] != want {
+		t.Errorf("hexdump line = %q, want %q", lines[1], want)
+	}
+	if !strings.HasSuffix(lines[2], "|Host: x..|") {
+		t.Errorf("last hexdump line = %q", lines[2])
+	}
+}
+
+func TestTextEncoderEvents(t *testing.T) {
+	e := &TextEncoder{}
+	tests := []struct {
+		rec  any
+		want string
+	}{
+		{
+			ARPRecord{Timestamp: "t", Protocol: "ARP", Operation: "request", Direction: "in",
+				SenderIP: "10.0.0.1", SenderMAC: "aa:bb:cc:dd:ee:ff", TargetIP: "10.0.0.2", Length: 42},
+			"t in  ARP who-has 10.0.0.2 tell 10.0.0.1 (aa:bb:cc:dd:ee:ff) len 42\n",
+		},
+		{
+			ConnectionRecord{EventType: "closed", Timestamp: "t", Reason: "shutdown", Connection: ConnectionInfo{
+				SrcIP: "10.0.0.1", SrcPort: 1, DstIP: "10.0.0.2", DstPort: 2, Protocol: "TCP",
+				State: "ESTABLISHED", Duration: "1s", PacketsSent: 3, BytesSent: 120, PacketsRecv: 2, BytesRecv: 80}},
+			"t conn closed 10.0.0.1:1 ↔ 10.0.0.2:2 TCP ESTABLISHED tx 3/120B rx 2/80B 1s (shutdown)\n",
+		},
+		{
+			NeighborRecord{EventType: "mac_change", Timestamp: "t",
+				Neighbor: NeighborInfo{IP: "10.0.0.1", MAC: "aa:aa:aa:aa:aa:aa", OldMAC: "bb:bb:bb:bb:bb:bb"}},
+			"t neighbor mac_change 10.0.0.1 is-at aa:aa:aa:aa:aa:aa (was bb:bb:bb:bb:bb:bb)\n",
+		},
+		{
+			map[string]int{"n": 1},
+			"{\"n\":1}\n",
+		},
+	}
+	for _, tt := range tests {
+		got, err := e.Encode(tt.rec)
+		if err != nil || string(got) != tt.want {
+			t.Errorf("Encode(%T) = %q, %v, want %q", tt.rec, got, err, tt.want)
+		}
+	}
+}
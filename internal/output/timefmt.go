@@ -0,0 +1,64 @@
+package output
+
+import (
+	"fmt"
+	"time"
+)
+
+// TimeFormat selects how record timestamps are rendered.
+type TimeFormat int
+
+const (
+	TimeRFC3339     TimeFormat = iota // 2006-01-02T15:04:05.000Z (UTC, milliseconds)
+	TimeRFC3339Nano                   // 2006-01-02T15:04:05.000000000Z (UTC, nanoseconds)
+	TimeEpoch                         // Seconds since the Unix epoch: 1703413845.123456789
+	TimeRelative                      // Seconds since capture start: 12.000123456
+)
+
+var (
+	timeFormat = TimeRFC3339
+	timeBase   time.Time // Reference point for TimeRelative
+)
+
+// ParseTimeFormat parses "rfc3339", "rfc3339nano", "epoch", or "relative".
+func ParseTimeFormat(s string) (TimeFormat, error) {
+	switch s {
+	case "rfc3339":
+		return TimeRFC3339, nil
+	case "rfc3339nano":
+		return TimeRFC3339Nano, nil
+	case "epoch":
+		return TimeEpoch, nil
+	case "relative":
+		return TimeRelative, nil
+	default:
+		return 0, fmt.Errorf("invalid time format %q (want rfc3339, rfc3339nano, epoch, or relative)", s)
+	}
+}
+
+// SetTimeFormat sets the format used by FormatTime. base is the capture
+// start time that relative timestamps count from.
+// Must be called before any records are written.
+func SetTimeFormat(f TimeFormat, base time.Time) {
+	timeFormat = f
+	timeBase = base
+}
+
+// FormatTime formats t according to the configured time format.
+func FormatTime(t time.Time) string {
+	switch timeFormat {
+	case TimeRFC3339Nano:
+		return t.UTC().Format("2006-01-02T15:04:05.000000000Z")
+	case TimeEpoch:
+		return fmt.Sprintf("%d.%09d", t.Unix(), t.Nanosecond())
+	case TimeRelative:
+		d := t.Sub(timeBase)
+		sign := ""
+		if d < 0 {
+			sign, d = "-", -d
+		}
+		return fmt.Sprintf("%s%d.%09d", sign, d/time.Second, d%time.Second)
+	default:
+		return t.UTC().Format("2006-01-02T15:04:05.000Z")
+	}
+}
@@ -0,0 +1,41 @@
+package output
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFormatTime(t *testing.T) {
+	base := time.Date(2025, 12, 24, 10, 30, 45, 0, time.UTC)
+	ts := base.Add(1500*time.Millisecond + 42*time.Nanosecond)
+	defer SetTimeFormat(TimeRFC3339, time.Time{})
+
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"rfc3339", "2025-12-24T10:30:46.500Z"},
+		{"rfc3339nano", "2025-12-24T10:30:46.500000042Z"},
+		{"epoch", "1766572246.500000042"},
+		{"relative", "1.500000042"},
+	}
+
+	for _, tt := range tests {
+		f, err := ParseTimeFormat(tt.format)
+		if err != nil {
+			t.Fatalf("ParseTimeFormat(%q): unexpected error: %v", tt.format, err)
+		}
+		SetTimeFormat(f, base)
+
+		if got := FormatTime(ts); got != tt.want {
+			t.Errorf("FormatTime(%s) = %s, want %s", tt.format, got, tt.want)
+		}
+
+	}
+}
+
+func TestParseTimeFormatInvalid(t *testing.T) {
+	if _, err := ParseTimeFormat("iso"); err == nil {
+		t.Error("expected error for invalid time format, got nil")
+	}
+}
@@ -0,0 +1,92 @@
+package parser
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+const (
+	ARPFixedHeaderSize = 8 // in bytes, before the variable-length addresses
+
+	ARPHardwareEthernet = 1
+
+	ARPOpRequest = 1
+	ARPOpReply   = 2
+)
+
+// ARPPacket represents a parsed ARP message.
+// Only IPv4 over Ethernet (hardware length 6, protocol length 4) is supported.
+type ARPPacket struct {
+	HardwareType uint16
+	ProtocolType uint16 // Same value space as EtherType (0x0800 for IPv4)
+	HardwareLen  uint8
+	ProtocolLen  uint8
+	Operation    uint16 // ARPOpRequest or ARPOpReply
+	SenderMAC    net.HardwareAddr
+	SenderIP     net.IP
+	TargetMAC    net.HardwareAddr
+	TargetIP     net.IP
+}
+
+// ParseARP parses raw bytes into an ARPPacket.
+// Returns an error if the data is too short or not IPv4 over Ethernet.
+func ParseARP(data []byte) (*ARPPacket, error) {
+	if len(data) < ARPFixedHeaderSize {
+		return nil, fmt.Errorf("ARP packet too short: %d bytes", len(data))
+	}
+
+	hwType := binary.BigEndian.Uint16(data[0:2])
+	protoType := binary.BigEndian.Uint16(data[2:4])
+	hwLen := data[4]
+	protoLen := data[5]
+
+	if hwType != ARPHardwareEthernet || hwLen != 6 {
+		return nil, fmt.Errorf("unsupported ARP hardware: type %d, length %d", hwType, hwLen)
+	}
+	if protoType != EtherTypeIPv4 || protoLen != 4 {
+		return nil, fmt.Errorf("unsupported ARP protocol: type 0x%04x, length %d", protoType, protoLen)
+	}
+
+	// Sender MAC, sender IP, target MAC, target IP
+	size := ARPFixedHeaderSize + 2*(int(hwLen)+int(protoLen))
+	if len(data) < size {
+		return nil, fmt.Errorf("ARP packet too short for addresses: %d < %d", len(data), size)
+	}
+
+	return &ARPPacket{
+		HardwareType: hwType,
+		ProtocolType: protoType,
+		HardwareLen:  hwLen,
+		ProtocolLen:  protoLen,
+		Operation:    binary.BigEndian.Uint16(data[6:8]),
+		SenderMAC:    net.HardwareAddr(data[8:14]),
+		SenderIP:     net.IP(data[14:18]),
+		TargetMAC:    net.HardwareAddr(data[18:24]),
+		TargetIP:     net.IP(data[24:28]),
+	}, nil
+}
+
+// IsGratuitous reports whether the packet announces the sender's own address,
+// i.e. sender and target IP are the same.
+func (p *ARPPacket) IsGratuitous() bool {
+	return p.SenderIP.Equal(p.TargetIP)
+}
+
+// IsProbe reports whether the packet is an ARP probe (RFC 5227):
+// a request with an all-zero sender IP, used for duplicate address detection.
+func (p *ARPPacket) IsProbe() bool {
+	return p.Operation == ARPOpRequest && p.SenderIP.Equal(net.IPv4zero)
+}
+
+// OperationName returns "request", "reply", or "unknown".
+func (p *ARPPacket) OperationName() string {
+	switch p.Operation {
+	case ARPOpRequest:
+		return "request"
+	case ARPOpReply:
+		return "reply"
+	default:
+		return "unknown"
+	}
+}
@@ -0,0 +1,93 @@
+package parser
+
+import "testing"
+
+func TestParseARP(t *testing.T) {
+	// ARP request: who has 192.168.1.2? tell 192.168.1.1
+	data := []byte{
+		0x00, 0x01, // Hardware type: Ethernet
+		0x08, 0x00, // Protocol type: IPv4
+		0x06,       // Hardware length
+		0x04,       // Protocol length
+		0x00, 0x01, // Operation: request
+		0x11, 0x22, 0x33, 0x44, 0x55, 0x66, // Sender MAC
+		0xc0, 0xa8, 0x01, 0x01, // Sender IP: 192.168.1.1
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // Target MAC (unknown)
+		0xc0, 0xa8, 0x01, 0x02, // Target IP: 192.168.1.2
+	}
+
+	pkt, err := ParseARP(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if pkt.Operation != ARPOpRequest {
+		t.Errorf("Operation = %d, want %d", pkt.Operation, ARPOpRequest)
+	}
+
+	if pkt.OperationName() != "request" {
+		t.Errorf("OperationName = %s, want request", pkt.OperationName())
+	}
+
+	if pkt.SenderMAC.String() != "11:22:33:44:55:66" {
+		t.Errorf("SenderMAC = %s, want 11:22:33:44:55:66", pkt.SenderMAC)
+	}
+
+	if pkt.SenderIP.String() != "192.168.1.1" {
+		t.Errorf("SenderIP = %s, want 192.168.1.1", pkt.SenderIP)
+	}
+
+	if pkt.TargetIP.String() != "192.168.1.2" {
+		t.Errorf("TargetIP = %s, want 192.168.1.2", pkt.TargetIP)
+	}
+
+	if pkt.IsGratuitous() {
+		t.Error("IsGratuitous = true, want false")
+	}
+
+	if pkt.IsProbe() {
+		t.Error("IsProbe = true, want false")
+	}
+}
+
+func TestParseARPGratuitous(t *testing.T) {
+	data := []byte{
+		0x00, 0x01, 0x08, 0x00, 0x06, 0x04,
+		0x00, 0x02, // Operation: reply
+		0x11, 0x22, 0x33, 0x44, 0x55, 0x66,
+		0xc0, 0xa8, 0x01, 0x01, // Sender IP: 192.168.1.1
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+		0xc0, 0xa8, 0x01, 0x01, // Target IP: 192.168.1.1
+	}
+
+	pkt, err := ParseARP(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !pkt.IsGratuitous() {
+		t.Error("IsGratuitous = false, want true")
+	}
+}
+
+func TestParseARPTooShort(t *testing.T) {
+	data := []byte{0x00, 0x01, 0x08} // Only 3 bytes
+
+	_, err := ParseARP(data)
+	if err == nil {
+		t.Error("expected error for short packet, got nil")
+	}
+}
+
+func TestParseARPUnsupportedHardware(t *testing.T) {
+	data := make([]byte, 28)
+	data[1] = 0x06 // Hardware type: IEEE 802
+	data[2] = 0x08
+	data[4] = 6
+	data[5] = 4
+
+	_, err := ParseARP(data)
+	if err == nil {
+		t.Error("expected error for unsupported hardware type, got nil")
+	}
+}
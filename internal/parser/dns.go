@@ -0,0 +1,270 @@
+package parser
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const (
+	DNSPort       = 53
+	DNSHeaderSize = 12 // in bytes
+
+	// maxDNSPointers bounds name decompression, so a pointer loop in a
+	// malformed message can't spin forever
+	maxDNSPointers = 32
+)
+
+// DNS record types rendered by DNSRecord.Data.
+const (
+	DNSTypeA     = 1
+	DNSTypeNS    = 2
+	DNSTypeCNAME = 5
+	DNSTypeSOA   = 6
+	DNSTypePTR   = 12
+	DNSTypeMX    = 15
+	DNSTypeTXT   = 16
+	DNSTypeAAAA  = 28
+	DNSTypeSRV   = 33
+)
+
+// DNSMessage represents a parsed DNS query or response. Authority and
+// additional records are not decoded.
+type DNSMessage struct {
+	ID       uint16
+	Response bool  // QR bit
+	Opcode   uint8 // 0 = standard query
+	AA       bool  // Authoritative answer
+	TC       bool  // Truncated
+	RD       bool  // Recursion desired
+	RA       bool  // Recursion available
+	Z        uint8 // Reserved bits (including AD and CD)
+	Rcode    uint8 // 0 = NOERROR, 3 = NXDOMAIN, ...
+
+	Questions []DNSQuestion
+	Answers   []DNSRecord
+}
+
+// DNSQuestion is an entry of the question section.
+type DNSQuestion struct {
+	Name  string
+	Type  uint16
+	Class uint16
+}
+
+// DNSRecord is a resource record of the answer section.
+type DNSRecord struct {
+	Name  string
+	Type  uint16
+	Class uint16
+	TTL   uint32
+	Data  string // Rendered RDATA: an address, a name, or text
+}
+
+// ParseDNS parses a DNS message as carried in a UDP payload.
+func ParseDNS(data []byte) (*DNSMessage, error) {
+	if len(data) < DNSHeaderSize {
+		return nil, fmt.Errorf("DNS message too short: %d bytes", len(data))
+	}
+
+	flags := binary.BigEndian.Uint16(data[2:4])
+	msg := &DNSMessage{
+		ID:       binary.BigEndian.Uint16(data[0:2]),
+		Response: flags&0x8000 != 0,
+		Opcode:   uint8(flags>>11) & 0x0F,
+		AA:       flags&0x0400 != 0,
+		TC:       flags&0x0200 != 0,
+		RD:       flags&0x0100 != 0,
+		RA:       flags&0x0080 != 0,
+		Z:        uint8(flags>>4) & 0x07,
+		Rcode:    uint8(flags) & 0x0F,
+	}
+	qdcount := int(binary.BigEndian.Uint16(data[4:6]))
+	ancount := int(binary.BigEndian.Uint16(data[6:8]))
+
+	off := DNSHeaderSize
+	for i := 0; i < qdcount; i++ {
+		name, next, err := readDNSName(data, off)
+		if err != nil {
+			return nil, fmt.Errorf("question %d: %w", i, err)
+		}
+		if next+4 > len(data) {
+			return nil, fmt.Errorf("question %d truncated", i)
+		}
+		msg.Questions = append(msg.Questions, DNSQuestion{
+			Name:  name,
+			Type:  binary.BigEndian.Uint16(data[next : next+2]),
+			Class: binary.BigEndian.Uint16(data[next+2 : next+4]),
+		})
+		off = next + 4
+	}
+
+	for i := 0; i < ancount; i++ {
+		name, next, err := readDNSName(data, off)
+		if err != nil {
+			return nil, fmt.Errorf("answer %d: %w", i, err)
+		}
+		if next+10 > len(data) {
+			return nil, fmt.Errorf("answer %d truncated", i)
+		}
+		rr := DNSRecord{
+			Name:  name,
+			Type:  binary.BigEndian.Uint16(data[next : next+2]),
+			Class: binary.BigEndian.Uint16(data[next+2 : next+4]),
+			TTL:   binary.BigEndian.Uint32(data[next+4 : next+8]),
+		}
+		rdlen := int(binary.BigEndian.Uint16(data[next+8 : next+10]))
+		rdata := next + 10
+		if rdata+rdlen > len(data) {
+			return nil, fmt.Errorf("answer %d data truncated", i)
+		}
+		rr.Data = renderRData(data, rr.Type, rdata, rdlen)
+		msg.Answers = append(msg.Answers, rr)
+		off = rdata + rdlen
+	}
+
+	return msg, nil
+}
+
+// readDNSName reads a possibly compressed domain name at off. Returns the
+// name without the trailing dot ("" for the root) and the offset after it.
+func readDNSName(data []byte, off int) (string, int, error) {
+	var labels []string
+	end := -1 // Offset after the name where it started, before any pointer
+	for pointers := 0; ; {
+		if off >= len(data) {
+			return "", 0, fmt.Errorf("name truncated")
+		}
+		n := int(data[off])
+		switch {
+		case n == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			return strings.Join(labels, "."), end, nil
+		case n&0xC0 == 0xC0:
+			if off+2 > len(data) {
+				return "", 0, fmt.Errorf("name pointer truncated")
+			}
+			if pointers++; pointers > maxDNSPointers {
+				return "", 0, fmt.Errorf("too many name pointers")
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(data[off:off+2]) & 0x3FFF)
+		case n&0xC0 != 0:
+			return "", 0, fmt.Errorf("unsupported label type 0x%02x", n&0xC0)
+		default:
+			if off+1+n > len(data) {
+				return "", 0, fmt.Errorf("label truncated")
+			}
+			labels = append(labels, string(data[off+1:off+1+n]))
+			off += 1 + n
+		}
+	}
+}
+
+// renderRData renders the RDATA of a record at off as text.
+func renderRData(data []byte, rrType uint16, off, length int) string {
+	rdata := data[off : off+length]
+	switch rrType {
+	case DNSTypeA:
+		if length == net.IPv4len {
+			return net.IP(rdata).String()
+		}
+	case DNSTypeAAAA:
+		if length == net.IPv6len {
+			return net.IP(rdata).String()
+		}
+	case DNSTypeNS, DNSTypeCNAME, DNSTypePTR:
+		if name, _, err := readDNSName(data, off); err == nil {
+			return name
+		}
+	case DNSTypeMX:
+		if length > 2 {
+			if name, _, err := readDNSName(data, off+2); err == nil {
+				return name
+			}
+		}
+	case DNSTypeSRV:
+		if length > 6 {
+			if name, _, err := readDNSName(data, off+6); err == nil {
+				return name
+			}
+		}
+	case DNSTypeSOA:
+		if name, _, err := readDNSName(data, off); err == nil {
+			return name
+		}
+	case DNSTypeTXT:
+		var parts []string
+		for i := 0; i < length; {
+			n := int(rdata[i])
+			if i+1+n > length {
+				break
+			}
+			parts = append(parts, string(rdata[i+1:i+1+n]))
+			i += 1 + n
+		}
+		return "TXT " + strings.Join(parts, " ")
+	}
+	return "<unknown type=" + strconv.Itoa(int(rrType)) + ">"
+}
+
+// DNSTypeName returns the mnemonic of a record type, e.g. "AAAA".
+func DNSTypeName(t uint16) string {
+	if name, ok := dnsTypeNames[t]; ok {
+		return name
+	}
+	return "TYPE" + strconv.Itoa(int(t))
+}
+
+// DNSClassName returns the mnemonic of a record class, e.g. "C_INTERNET".
+func DNSClassName(c uint16) string {
+	switch c {
+	case 1:
+		return "C_INTERNET"
+	case 3:
+		return "C_CHAOS"
+	case 4:
+		return "C_HESIOD"
+	case 254:
+		return "C_NONE"
+	case 255:
+		return "C_ANY"
+	}
+	return "CLASS" + strconv.Itoa(int(c))
+}
+
+// DNSRcodeName returns the mnemonic of a response code, e.g. "NXDOMAIN".
+func DNSRcodeName(rcode uint8) string {
+	names := []string{"NOERROR", "FORMERR", "SERVFAIL", "NXDOMAIN", "NOTIMP", "REFUSED",
+		"YXDOMAIN", "YXRRSET", "NXRRSET", "NOTAUTH", "NOTZONE"}
+	if int(rcode) < len(names) {
+		return names[rcode]
+	}
+	return "RCODE" + strconv.Itoa(int(rcode))
+}
+
+var dnsTypeNames = map[uint16]string{
+	DNSTypeA:     "A",
+	DNSTypeNS:    "NS",
+	DNSTypeCNAME: "CNAME",
+	DNSTypeSOA:   "SOA",
+	DNSTypePTR:   "PTR",
+	DNSTypeMX:    "MX",
+	DNSTypeTXT:   "TXT",
+	DNSTypeAAAA:  "AAAA",
+	DNSTypeSRV:   "SRV",
+	41:           "OPT",
+	43:           "DS",
+	46:           "RRSIG",
+	47:           "NSEC",
+	48:           "DNSKEY",
+	64:           "SVCB",
+	65:           "HTTPS",
+	255:          "*",
+}
@@ -0,0 +1,74 @@
+package parser
+
+import "testing"
+
+// dnsResponse is a response for example.com A with a CNAME and an A record
+// whose names are compressed.
+var dnsResponse = []byte{
+	0x12, 0x34, // ID
+	0x81, 0x80, // QR, RD, RA, NOERROR
+	0x00, 0x01, // QDCOUNT
+	0x00, 0x02, // ANCOUNT
+	0x00, 0x00, 0x00, 0x00,
+	// Question: www.example.com A IN
+	0x03, 'w', 'w', 'w', 0x07, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 0x03, 'c', 'o', 'm', 0x00,
+	0x00, 0x01, 0x00, 0x01,
+	// Answer: www.example.com CNAME example.com
+	0xC0, 0x0C, 0x00, 0x05, 0x00, 0x01, 0x00, 0x00, 0x0E, 0x10, 0x00, 0x02, 0xC0, 0x10,
+	// Answer: example.com A 93.184.216.34
+	0xC0, 0x10, 0x00, 0x01, 0x00, 0x01, 0x00, 0x00, 0x00, 0x3C, 0x00, 0x04, 93, 184, 216, 34,
+}
+
+func TestParseDNS(t *testing.T) {
+	msg, err := ParseDNS(dnsResponse)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if msg.ID != 0x1234 || !msg.Response || !msg.RD || !msg.RA || msg.AA || msg.Rcode != 0 {
+		t.Errorf("header = %+v", msg)
+	}
+	if len(msg.Questions) != 1 || msg.Questions[0] != (DNSQuestion{"www.example.com", DNSTypeA, 1}) {
+		t.Errorf("questions = %+v", msg.Questions)
+	}
+	if len(msg.Answers) != 2 {
+		t.Fatalf("got %d answers, want 2", len(msg.Answers))
+	}
+	if a := msg.Answers[0]; a.Name != "www.example.com" || a.Type != DNSTypeCNAME || a.TTL != 3600 || a.Data != "example.com" {
+		t.Errorf("CNAME answer = %+v", a)
+	}
+	if a := msg.Answers[1]; a.Name != "example.com" || a.Data != "93.184.216.34" || a.TTL != 60 {
+		t.Errorf("A answer = %+v", a)
+	}
+}
+
+func TestParseDNSMalformed(t *testing.T) {
+	// A name pointer to itself
+	loop := append([]byte{0, 1, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0}, 0xC0, 0x0C, 0, 1, 0, 1)
+	tests := map[string][]byte{
+		"too short":       {0x12, 0x34, 0x01},
+		"truncated label": dnsResponse[:20],
+		"truncated rdata": dnsResponse[:len(dnsResponse)-2],
+		"pointer loop":    loop,
+	}
+	for name, data := range tests {
+		if _, err := ParseDNS(data); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestDNSNames(t *testing.T) {
+	if got := DNSTypeName(DNSTypeAAAA); got != "AAAA" {
+		t.Errorf("DNSTypeName(28) = %q", got)
+	}
+	if got := DNSTypeName(999); got != "TYPE999" {
+		t.Errorf("DNSTypeName(999) = %q", got)
+	}
+	if got := DNSRcodeName(3); got != "NXDOMAIN" {
+		t.Errorf("DNSRcodeName(3) = %q", got)
+	}
+	if got := DNSClassName(1); got != "C_INTERNET" {
+		t.Errorf("DNSClassName(1) = %q", got)
+	}
+}
@@ -8,30 +8,90 @@ import (
 
 const (
 	EthernetHeaderSize = 14 // in bytes
+	VLANTagSize        = 4  // in bytes (TPID + TCI)
 
 	EtherTypeIPv4 = uint16(0x0800)
 	EtherTypeARP  = uint16(0x0806)
 	EtherTypeIPv6 = uint16(0x86DD)
+
+	EtherTypeVLAN     = uint16(0x8100) // 802.1Q customer tag
+	EtherTypeQinQ     = uint16(0x88A8) // 802.1ad service tag
+	EtherTypeQinQOld  = uint16(0x9100) // Pre-standard QinQ, still seen on some switches
+	MaxVLANTagsParsed = 4              // Stop descending after this many tags
 )
 
+// VLANTag represents a single 802.1Q or 802.1ad tag.
+type VLANTag struct {
+	TPID uint16 // Tag protocol identifier (0x8100, 0x88A8, ...)
+	PCP  uint8  // Priority code point (3 bits)
+	DEI  bool   // Drop eligible indicator
+	VID  uint16 // VLAN identifier (12 bits)
+}
+
 // EthernetFrame represents a parsed Ethernet frame header.
+// For tagged frames, EtherType is the inner EtherType after all tags.
 type EthernetFrame struct {
 	DestMAC   net.HardwareAddr
 	SrcMAC    net.HardwareAddr
+	VLANs     []VLANTag // Outermost first; nil for untagged frames
 	EtherType uint16
 	Payload   []byte
 }
 
 // ParseEthernet parses raw bytes into an EthernetFrame.
+// Any 802.1Q/802.1ad tags are decoded and skipped.
 func ParseEthernet(data []byte) (*EthernetFrame, error) {
 	if len(data) < EthernetHeaderSize {
 		return nil, fmt.Errorf("packet too short: %d bytes", len(data))
 	}
 
-	return &EthernetFrame{
+	frame := &EthernetFrame{
 		DestMAC:   net.HardwareAddr(data[0:6]),
 		SrcMAC:    net.HardwareAddr(data[6:12]),
 		EtherType: binary.BigEndian.Uint16(data[12:14]),
-		Payload:   data[EthernetHeaderSize:],
-	}, nil
+	}
+
+	// Each tag sits where the EtherType would be: TPID (2 bytes) then
+	// TCI (2 bytes), followed by the next EtherType.
+	offset := 12
+	for isVLANTPID(frame.EtherType) {
+		if len(frame.VLANs) == MaxVLANTagsParsed {
+			return nil, fmt.Errorf("too many VLAN tags: more than %d", MaxVLANTagsParsed)
+		}
+		if len(data) < offset+VLANTagSize+2 {
+			return nil, fmt.Errorf("packet too short for VLAN tag: %d bytes", len(data))
+		}
+
+		tci := binary.BigEndian.Uint16(data[offset+2 : offset+4])
+		frame.VLANs = append(frame.VLANs, VLANTag{
+			TPID: frame.EtherType,
+			PCP:  uint8(tci >> 13),
+			DEI:  tci&0x1000 != 0,
+			VID:  tci & 0x0FFF,
+		})
+
+		offset += VLANTagSize
+		frame.EtherType = binary.BigEndian.Uint16(data[offset : offset+2])
+	}
+
+	frame.Payload = data[offset+2:]
+	return frame, nil
+}
+
+// VLANIDs returns the VLAN IDs of the frame, outermost first.
+// Returns nil for untagged frames.
+func (f *EthernetFrame) VLANIDs() []uint16 {
+	if len(f.VLANs) == 0 {
+		return nil
+	}
+	ids := make([]uint16, len(f.VLANs))
+	for i, tag := range f.VLANs {
+		ids[i] = tag.VID
+	}
+	return ids
+}
+
+// isVLANTPID reports whether an EtherType value introduces a VLAN tag.
+func isVLANTPID(etherType uint16) bool {
+	return etherType == EtherTypeVLAN || etherType == EtherTypeQinQ || etherType == EtherTypeQinQOld
 }
@@ -41,3 +41,81 @@ func TestParseEthernetTooShort(t *testing.T) {
 		t.Error("expected error for short packet, got nil")
 	}
 }
+
+func TestParseEthernetVLAN(t *testing.T) {
+	data := []byte{
+		0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff, // Dest MAC
+		0x11, 0x22, 0x33, 0x44, 0x55, 0x66, // Src MAC
+		0x81, 0x00, // TPID: 802.1Q
+		0xb0, 0x64, // TCI: PCP 5, DEI 1, VID 100
+		0x08, 0x00, // EtherType: IPv4
+		0xde, 0xad, 0xbe, 0xef, // Payload (dummy)
+	}
+
+	frame, err := ParseEthernet(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if frame.EtherType != EtherTypeIPv4 {
+		t.Errorf("EtherType = 0x%04x, want 0x0800", frame.EtherType)
+	}
+
+	if len(frame.VLANs) != 1 {
+		t.Fatalf("VLANs = %d tags, want 1", len(frame.VLANs))
+	}
+
+	tag := frame.VLANs[0]
+	if tag.PCP != 5 || !tag.DEI || tag.VID != 100 {
+		t.Errorf("tag = %+v, want PCP 5, DEI true, VID 100", tag)
+	}
+
+	if len(frame.Payload) != 4 {
+		t.Errorf("Payload length = %d, want 4", len(frame.Payload))
+	}
+}
+
+func TestParseEthernetQinQ(t *testing.T) {
+	data := []byte{
+		0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff, // Dest MAC
+		0x11, 0x22, 0x33, 0x44, 0x55, 0x66, // Src MAC
+		0x88, 0xa8, // TPID: 802.1ad (service tag)
+		0x00, 0x0a, // VID 10
+		0x81, 0x00, // TPID: 802.1Q (customer tag)
+		0x00, 0x14, // VID 20
+		0x08, 0x06, // EtherType: ARP
+		0xde, 0xad, // Payload (dummy)
+	}
+
+	frame, err := ParseEthernet(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if frame.EtherType != EtherTypeARP {
+		t.Errorf("EtherType = 0x%04x, want 0x0806", frame.EtherType)
+	}
+
+	ids := frame.VLANIDs()
+	if len(ids) != 2 || ids[0] != 10 || ids[1] != 20 {
+		t.Errorf("VLANIDs = %v, want [10 20]", ids)
+	}
+
+	if len(frame.Payload) != 2 {
+		t.Errorf("Payload length = %d, want 2", len(frame.Payload))
+	}
+}
+
+func TestParseEthernetVLANTruncated(t *testing.T) {
+	data := []byte{
+		0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff,
+		0x11, 0x22, 0x33, 0x44, 0x55, 0x66,
+		0x81, 0x00, // TPID: 802.1Q
+		0x00, // Truncated TCI
+	}
+
+	_, err := ParseEthernet(data)
+	if err == nil {
+		t.Error("expected error for truncated VLAN tag, got nil")
+	}
+}
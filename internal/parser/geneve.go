@@ -0,0 +1,55 @@
+package parser
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	GeneveMinHeaderSize = 8    // in bytes, without options
+	GenevePort          = 6081 // IANA-assigned UDP destination port
+
+	// EtherTypeTEB (transparent Ethernet bridging) marks an inner Ethernet
+	// frame in Geneve and GRE.
+	EtherTypeTEB = uint16(0x6558)
+)
+
+// GeneveHeader represents a parsed Geneve header (RFC 8926).
+type GeneveHeader struct {
+	Version      uint8
+	OptionsLen   uint8  // Options length in 4-byte words
+	OAM          bool   // Control (OAM) packet
+	Critical     bool   // Critical options present
+	ProtocolType uint16 // EtherType of the payload (EtherTypeTEB, EtherTypeIPv4, ...)
+	VNI          uint32 // Virtual network identifier (24 bits)
+	Payload      []byte
+}
+
+// ParseGeneve parses raw bytes (the UDP payload) into a GeneveHeader.
+// Options are skipped, not decoded.
+func ParseGeneve(data []byte) (*GeneveHeader, error) {
+	if len(data) < GeneveMinHeaderSize {
+		return nil, fmt.Errorf("Geneve header too short: %d bytes", len(data))
+	}
+
+	version := data[0] >> 6
+	if version != 0 {
+		return nil, fmt.Errorf("unsupported Geneve version: %d", version)
+	}
+
+	optLen := data[0] & 0x3F
+	headerLen := GeneveMinHeaderSize + int(optLen)*4
+	if len(data) < headerLen {
+		return nil, fmt.Errorf("Geneve header too short for options: %d < %d", len(data), headerLen)
+	}
+
+	return &GeneveHeader{
+		Version:      version,
+		OptionsLen:   optLen,
+		OAM:          data[1]&0x80 != 0,
+		Critical:     data[1]&0x40 != 0,
+		ProtocolType: binary.BigEndian.Uint16(data[2:4]),
+		VNI:          binary.BigEndian.Uint32(data[4:8]) >> 8,
+		Payload:      data[headerLen:],
+	}, nil
+}
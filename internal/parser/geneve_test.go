@@ -0,0 +1,45 @@
+package parser
+
+import "testing"
+
+func TestParseGeneve(t *testing.T) {
+	data := []byte{
+		0x01,       // Version 0, options length 1 (4 bytes)
+		0x00,       // Flags
+		0x65, 0x58, // Protocol type: transparent Ethernet bridging
+		0x00, 0x00, 0x64, // VNI: 100
+		0x00,                   // Reserved
+		0x01, 0x02, 0x03, 0x04, // Option (skipped)
+		0xde, 0xad, // Inner frame (dummy)
+	}
+
+	hdr, err := ParseGeneve(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if hdr.ProtocolType != EtherTypeTEB {
+		t.Errorf("ProtocolType = 0x%04x, want 0x6558", hdr.ProtocolType)
+	}
+
+	if hdr.VNI != 100 {
+		t.Errorf("VNI = %d, want 100", hdr.VNI)
+	}
+
+	if len(hdr.Payload) != 2 {
+		t.Errorf("Payload length = %d, want 2", len(hdr.Payload))
+	}
+}
+
+func TestParseGeneveOptionsTruncated(t *testing.T) {
+	data := []byte{
+		0x02, 0x00, 0x65, 0x58, // Options length 2 (8 bytes)
+		0x00, 0x00, 0x64, 0x00,
+		0x01, 0x02, // Only 2 bytes of options
+	}
+
+	_, err := ParseGeneve(data)
+	if err == nil {
+		t.Error("expected error for truncated options, got nil")
+	}
+}
@@ -0,0 +1,77 @@
+package parser
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	GREMinHeaderSize = 4 // in bytes, without optional fields
+
+	// GRE flag bits (first 16 bits of the header)
+	GREFlagChecksum = 0x8000
+	GREFlagRouting  = 0x4000
+	GREFlagKey      = 0x2000
+	GREFlagSequence = 0x1000
+)
+
+// GREPacket represents a parsed GRE header (RFC 2784, RFC 2890).
+type GREPacket struct {
+	Flags        uint16
+	Version      uint8
+	ProtocolType uint16 // EtherType of the payload (EtherTypeIPv4, EtherTypeTEB, ...)
+	HasKey       bool
+	Key          uint32 // Only valid if HasKey (NVGRE puts the VSID here)
+	HasSequence  bool
+	Sequence     uint32 // Only valid if HasSequence
+	Payload      []byte
+}
+
+// ParseGRE parses raw bytes (the IP payload) into a GREPacket.
+// Only version 0 is supported; version 1 (PPTP) carries PPP, not IP.
+func ParseGRE(data []byte) (*GREPacket, error) {
+	if len(data) < GREMinHeaderSize {
+		return nil, fmt.Errorf("GRE header too short: %d bytes", len(data))
+	}
+
+	flagsVersion := binary.BigEndian.Uint16(data[0:2])
+	version := uint8(flagsVersion & 0x0007)
+	if version != 0 {
+		return nil, fmt.Errorf("unsupported GRE version: %d", version)
+	}
+
+	pkt := &GREPacket{
+		Flags:        flagsVersion &^ 0x0007,
+		Version:      version,
+		ProtocolType: binary.BigEndian.Uint16(data[2:4]),
+	}
+
+	// Optional fields follow in a fixed order, 4 bytes each:
+	// checksum+offset (if C or R), key (if K), sequence (if S)
+	headerLen := GREMinHeaderSize
+	if flagsVersion&(GREFlagChecksum|GREFlagRouting) != 0 {
+		headerLen += 4
+	}
+	if flagsVersion&GREFlagKey != 0 {
+		if len(data) < headerLen+4 {
+			return nil, fmt.Errorf("GRE header too short for key: %d bytes", len(data))
+		}
+		pkt.HasKey = true
+		pkt.Key = binary.BigEndian.Uint32(data[headerLen : headerLen+4])
+		headerLen += 4
+	}
+	if flagsVersion&GREFlagSequence != 0 {
+		if len(data) < headerLen+4 {
+			return nil, fmt.Errorf("GRE header too short for sequence: %d bytes", len(data))
+		}
+		pkt.HasSequence = true
+		pkt.Sequence = binary.BigEndian.Uint32(data[headerLen : headerLen+4])
+		headerLen += 4
+	}
+	if len(data) < headerLen {
+		return nil, fmt.Errorf("GRE header too short: %d < %d", len(data), headerLen)
+	}
+
+	pkt.Payload = data[headerLen:]
+	return pkt, nil
+}
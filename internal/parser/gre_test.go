@@ -0,0 +1,74 @@
+package parser
+
+import "testing"
+
+func TestParseGRE(t *testing.T) {
+	data := []byte{
+		0x00, 0x00, // No flags, version 0
+		0x08, 0x00, // Protocol type: IPv4
+		0xde, 0xad, 0xbe, 0xef, // Payload (dummy)
+	}
+
+	pkt, err := ParseGRE(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if pkt.ProtocolType != EtherTypeIPv4 {
+		t.Errorf("ProtocolType = 0x%04x, want 0x0800", pkt.ProtocolType)
+	}
+
+	if pkt.HasKey {
+		t.Error("HasKey = true, want false")
+	}
+
+	if len(pkt.Payload) != 4 {
+		t.Errorf("Payload length = %d, want 4", len(pkt.Payload))
+	}
+}
+
+func TestParseGREWithKeyAndSequence(t *testing.T) {
+	data := []byte{
+		0xb0, 0x00, // Flags: C, K, S
+		0x65, 0x58, // Protocol type: transparent Ethernet bridging
+		0x12, 0x34, 0x00, 0x00, // Checksum + reserved
+		0x00, 0x00, 0x00, 0x2a, // Key: 42
+		0x00, 0x00, 0x00, 0x07, // Sequence: 7
+		0xde, 0xad, // Payload (dummy)
+	}
+
+	pkt, err := ParseGRE(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !pkt.HasKey || pkt.Key != 42 {
+		t.Errorf("Key = %d (HasKey %v), want 42", pkt.Key, pkt.HasKey)
+	}
+
+	if !pkt.HasSequence || pkt.Sequence != 7 {
+		t.Errorf("Sequence = %d (HasSequence %v), want 7", pkt.Sequence, pkt.HasSequence)
+	}
+
+	if len(pkt.Payload) != 2 {
+		t.Errorf("Payload length = %d, want 2", len(pkt.Payload))
+	}
+}
+
+func TestParseGREUnsupportedVersion(t *testing.T) {
+	data := []byte{0x30, 0x81, 0x88, 0x0b, 0x00, 0x00, 0x00, 0x00} // PPTP (version 1)
+
+	_, err := ParseGRE(data)
+	if err == nil {
+		t.Error("expected error for GRE version 1, got nil")
+	}
+}
+
+func TestParseGRETooShort(t *testing.T) {
+	data := []byte{0x20, 0x00, 0x08, 0x00, 0x00} // Key flag set but key missing
+
+	_, err := ParseGRE(data)
+	if err == nil {
+		t.Error("expected error for short header, got nil")
+	}
+}
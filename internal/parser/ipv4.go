@@ -10,8 +10,10 @@ const (
 	IPv4MinHeaderSize = 20 // in bytes
 	IPv4MaxHeaderSize = 60 // in bytes
 
-	ProtocolTCP = 6
-	ProtocolUDP = 17
+	ProtocolIPIP = 4 // IPv4-in-IPv4 encapsulation
+	ProtocolTCP  = 6
+	ProtocolUDP  = 17
+	ProtocolGRE  = 47
 )
 
 // IPv4Packet represents a parsed IPv4 header.
@@ -0,0 +1,48 @@
+package parser
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+const (
+	SLL2HeaderSize = 20 // in bytes
+
+	// Packet types (from <linux/if_packet.h>)
+	PacketHost      = 0 // To us
+	PacketBroadcast = 1 // To all
+	PacketMulticast = 2 // To group
+	PacketOtherHost = 3 // To someone else
+	PacketOutgoing  = 4 // From us
+)
+
+// SLL2Header represents a Linux cooked-mode capture header (LINKTYPE_LINUX_SLL2).
+// It replaces the link-layer header for packets captured on "any" or on
+// interfaces without an Ethernet header.
+type SLL2Header struct {
+	Protocol   uint16 // EtherType of the payload
+	Ifindex    uint32 // Interface the packet was captured on
+	ARPHRDType uint16 // Link type of that interface
+	PacketType uint8  // PacketHost, PacketOutgoing, ...
+	Addr       net.HardwareAddr
+	Payload    []byte // Network-layer packet
+}
+
+// ParseSLL2 parses raw bytes into an SLL2Header.
+func ParseSLL2(data []byte) (*SLL2Header, error) {
+	if len(data) < SLL2HeaderSize {
+		return nil, fmt.Errorf("SLL2 header too short: %d bytes", len(data))
+	}
+
+	addrLen := min(int(data[11]), 8)
+
+	return &SLL2Header{
+		Protocol:   binary.BigEndian.Uint16(data[0:2]),
+		Ifindex:    binary.BigEndian.Uint32(data[4:8]),
+		ARPHRDType: binary.BigEndian.Uint16(data[8:10]),
+		PacketType: data[10],
+		Addr:       net.HardwareAddr(data[12 : 12+addrLen]),
+		Payload:    data[SLL2HeaderSize:],
+	}, nil
+}
@@ -0,0 +1,70 @@
+package parser
+
+import "testing"
+
+func TestParseSLL2(t *testing.T) {
+	data := []byte{
+		0x08, 0x00, // Protocol: IPv4
+		0x00, 0x00, // Reserved
+		0x00, 0x00, 0x00, 0x03, // Ifindex: 3
+		0xff, 0xfe, // ARPHRD type: NONE (tun/wireguard)
+		0x04,                                           // Packet type: outgoing
+		0x00,                                           // Address length: 0
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // Address (padding)
+		0x45, 0x00, // Payload (dummy)
+	}
+
+	hdr, err := ParseSLL2(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if hdr.Protocol != EtherTypeIPv4 {
+		t.Errorf("Protocol = 0x%04x, want 0x0800", hdr.Protocol)
+	}
+
+	if hdr.Ifindex != 3 {
+		t.Errorf("Ifindex = %d, want 3", hdr.Ifindex)
+	}
+
+	if hdr.PacketType != PacketOutgoing {
+		t.Errorf("PacketType = %d, want %d", hdr.PacketType, PacketOutgoing)
+	}
+
+	if len(hdr.Addr) != 0 {
+		t.Errorf("Addr length = %d, want 0", len(hdr.Addr))
+	}
+
+	if len(hdr.Payload) != 2 {
+		t.Errorf("Payload length = %d, want 2", len(hdr.Payload))
+	}
+}
+
+func TestParseSLL2WithAddress(t *testing.T) {
+	data := []byte{
+		0x08, 0x06, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x02,
+		0x00, 0x01, // ARPHRD type: Ethernet
+		0x00,                                           // Packet type: host
+		0x06,                                           // Address length: 6
+		0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x00, 0x00, // Address
+	}
+
+	hdr, err := ParseSLL2(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if hdr.Addr.String() != "11:22:33:44:55:66" {
+		t.Errorf("Addr = %s, want 11:22:33:44:55:66", hdr.Addr)
+	}
+}
+
+func TestParseSLL2TooShort(t *testing.T) {
+	data := []byte{0x08, 0x00, 0x00} // Only 3 bytes
+
+	_, err := ParseSLL2(data)
+	if err == nil {
+		t.Error("expected error for short header, got nil")
+	}
+}
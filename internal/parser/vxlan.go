@@ -0,0 +1,43 @@
+package parser
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	VXLANHeaderSize = 8    // in bytes
+	VXLANPort       = 4789 // IANA-assigned UDP destination port
+
+	VXLANFlagVNI = 0x08 // "I" flag: VNI field is valid
+)
+
+// VXLANHeader represents a parsed VXLAN header (RFC 7348).
+// The payload is always a full inner Ethernet frame.
+type VXLANHeader struct {
+	Flags   uint8
+	VNI     uint32 // VXLAN network identifier (24 bits)
+	Payload []byte
+}
+
+// ParseVXLAN parses raw bytes (the UDP payload) into a VXLANHeader.
+// Returns an error if the data is too short or the VNI flag is not set.
+func ParseVXLAN(data []byte) (*VXLANHeader, error) {
+	if len(data) < VXLANHeaderSize {
+		return nil, fmt.Errorf("VXLAN header too short: %d bytes", len(data))
+	}
+
+	flags := data[0]
+	if flags&VXLANFlagVNI == 0 {
+		return nil, fmt.Errorf("VXLAN VNI flag not set: flags 0x%02x", flags)
+	}
+
+	// VNI occupies bytes 4-6; byte 7 is reserved
+	vni := binary.BigEndian.Uint32(data[4:8]) >> 8
+
+	return &VXLANHeader{
+		Flags:   flags,
+		VNI:     vni,
+		Payload: data[VXLANHeaderSize:],
+	}, nil
+}
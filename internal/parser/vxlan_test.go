@@ -0,0 +1,44 @@
+package parser
+
+import "testing"
+
+func TestParseVXLAN(t *testing.T) {
+	data := []byte{
+		0x08,             // Flags: I (VNI valid)
+		0x00, 0x00, 0x00, // Reserved
+		0x00, 0x30, 0x39, // VNI: 12345
+		0x00,                   // Reserved
+		0xde, 0xad, 0xbe, 0xef, // Inner frame (dummy)
+	}
+
+	hdr, err := ParseVXLAN(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if hdr.VNI != 12345 {
+		t.Errorf("VNI = %d, want 12345", hdr.VNI)
+	}
+
+	if len(hdr.Payload) != 4 {
+		t.Errorf("Payload length = %d, want 4", len(hdr.Payload))
+	}
+}
+
+func TestParseVXLANNoVNIFlag(t *testing.T) {
+	data := make([]byte, 8)
+
+	_, err := ParseVXLAN(data)
+	if err == nil {
+		t.Error("expected error for missing VNI flag, got nil")
+	}
+}
+
+func TestParseVXLANTooShort(t *testing.T) {
+	data := []byte{0x08, 0x00, 0x00} // Only 3 bytes
+
+	_, err := ParseVXLAN(data)
+	if err == nil {
+		t.Error("expected error for short header, got nil")
+	}
+}
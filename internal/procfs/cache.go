@@ -0,0 +1,106 @@
+package procfs
+
+import (
+	"bytes"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxCacheEntries bounds the cache; when full, expired entries are swept
+// and, failing that, the cache starts over.
+const maxCacheEntries = 65536
+
+// FindProcess returns the process owning the socket of a packet's 5-tuple,
+// or nil if none is found.
+func FindProcess(protocol string, srcIP net.IP, srcPort uint16, dstIP net.IP, dstPort uint16) *ProcessInfo {
+	inode, err := FindSocketInode(protocol, srcIP, srcPort, dstIP, dstPort)
+	if err != nil || inode == 0 {
+		return nil
+	}
+	proc, err := FindProcessBySocket(inode)
+	if err != nil {
+		return nil
+	}
+	return proc
+}
+
+type cacheKey struct {
+	protocol         string
+	srcIP, dstIP     [16]byte
+	srcPort, dstPort uint16
+}
+
+type cacheEntry struct {
+	proc    *ProcessInfo // nil if no owner was found
+	expires time.Time
+}
+
+// Cache remembers socket owners for a short time, so that the packets of
+// one flow don't each scan /proc. Misses are cached too. It is safe for
+// concurrent use.
+type Cache struct {
+	ttl    time.Duration
+	lookup func(protocol string, srcIP net.IP, srcPort uint16, dstIP net.IP, dstPort uint16) *ProcessInfo
+
+	mu      sync.Mutex
+	entries map[cacheKey]cacheEntry
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+// NewCache creates a cache whose entries are valid for ttl.
+func NewCache(ttl time.Duration) *Cache {
+	return &Cache{
+		ttl:     ttl,
+		lookup:  FindProcess,
+		entries: make(map[cacheKey]cacheEntry),
+	}
+}
+
+// Lookup is FindProcess through the cache.
+func (c *Cache) Lookup(protocol string, srcIP net.IP, srcPort uint16, dstIP net.IP, dstPort uint16) *ProcessInfo {
+	// Both directions of a flow belong to the same socket
+	key := cacheKey{protocol: protocol, srcPort: srcPort, dstPort: dstPort}
+	copy(key.srcIP[:], srcIP.To16())
+	copy(key.dstIP[:], dstIP.To16())
+	if cmp := bytes.Compare(key.srcIP[:], key.dstIP[:]); cmp > 0 || cmp == 0 && srcPort > dstPort {
+		key.srcIP, key.dstIP = key.dstIP, key.srcIP
+		key.srcPort, key.dstPort = key.dstPort, key.srcPort
+	}
+	now := time.Now()
+
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		c.hits.Add(1)
+		return entry.proc
+	}
+
+	c.misses.Add(1)
+	proc := c.lookup(protocol, srcIP, srcPort, dstIP, dstPort)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= maxCacheEntries {
+		for k, e := range c.entries {
+			if !now.Before(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= maxCacheEntries {
+			clear(c.entries)
+		}
+	}
+	c.entries[key] = cacheEntry{proc: proc, expires: now.Add(c.ttl)}
+	return proc
+}
+
+// Stats returns how many lookups were answered from the cache and how
+// many had to scan /proc.
+func (c *Cache) Stats() (hits, misses uint64) {
+	return c.hits.Load(), c.misses.Load()
+}
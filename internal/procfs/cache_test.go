@@ -0,0 +1,46 @@
+package procfs
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCacheSharesFlowDirections(t *testing.T) {
+	c := NewCache(time.Minute)
+	calls := 0
+	c.lookup = func(string, net.IP, uint16, net.IP, uint16) *ProcessInfo {
+		calls++
+		return &ProcessInfo{PID: 42, Name: "curl"}
+	}
+
+	a, b := net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2")
+	if p := c.Lookup("tcp", a, 5000, b, 443); p == nil || p.PID != 42 {
+		t.Fatalf("Lookup = %+v", p)
+	}
+	c.Lookup("tcp", b, 443, a, 5000)
+	c.Lookup("udp", a, 5000, b, 443)
+
+	if calls != 2 {
+		t.Errorf("lookups = %d, want 2 (reply direction cached, udp separate)", calls)
+	}
+	if hits, misses := c.Stats(); hits != 1 || misses != 2 {
+		t.Errorf("Stats = %d hits, %d misses, want 1, 2", hits, misses)
+	}
+}
+
+func TestCacheExpires(t *testing.T) {
+	c := NewCache(0)
+	calls := 0
+	c.lookup = func(string, net.IP, uint16, net.IP, uint16) *ProcessInfo {
+		calls++
+		return nil
+	}
+
+	ip := net.ParseIP("10.0.0.1")
+	c.Lookup("tcp", ip, 1, ip, 2)
+	c.Lookup("tcp", ip, 1, ip, 2)
+	if calls != 2 {
+		t.Errorf("lookups = %d, want 2 with a zero TTL", calls)
+	}
+}
@@ -0,0 +1,41 @@
+package procfs
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// containerIDPattern matches the 64-hex-digit container ID that Docker,
+// containerd, CRI-O and Podman put in a container's cgroup path, e.g.
+// /system.slice/docker-<id>.scope or /kubepods/.../cri-containerd-<id>.scope.
+var containerIDPattern = regexp.MustCompile(`^(?:[a-z-]+-)?([0-9a-f]{64})(?:\.scope)?$`)
+
+// ContainerID returns the ID of the container a process runs in, or ""
+// if it doesn't run in one.
+func ContainerID(pid int) (string, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/cgroup", pid))
+	if err != nil {
+		return "", fmt.Errorf("read cgroup: %w", err)
+	}
+	return parseContainerID(string(data)), nil
+}
+
+// parseContainerID finds a container ID in the contents of /proc/[pid]/cgroup.
+func parseContainerID(cgroup string) string {
+	for _, line := range strings.Split(cgroup, "\n") {
+		// hierarchy-ID:controllers:path
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		// Podman nests the process one level below the container's cgroup
+		for _, segment := range strings.Split(parts[2], "/") {
+			if m := containerIDPattern.FindStringSubmatch(segment); m != nil {
+				return m[1]
+			}
+		}
+	}
+	return ""
+}
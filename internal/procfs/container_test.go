@@ -0,0 +1,26 @@
+package procfs
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseContainerID(t *testing.T) {
+	id := strings.Repeat("0123456789abcdef", 4)
+	tests := []struct {
+		cgroup string
+		want   string
+	}{
+		{"0::/system.slice/docker-" + id + ".scope\n", id},
+		{"12:memory:/docker/" + id + "\n11:cpu:/docker/" + id + "\n", id},
+		{"0::/kubepods.slice/kubepods-pod1.slice/cri-containerd-" + id + ".scope\n", id},
+		{"0::/machine.slice/libpod-" + id + ".scope/container\n", id},
+		{"0::/user.slice/user-1000.slice/session-2.scope\n", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := parseContainerID(tt.cgroup); got != tt.want {
+			t.Errorf("parseContainerID(%q) = %q, want %q", tt.cgroup, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package procfs
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Details is what /proc tells about a process beyond its name. Fields
+// that can't be read, e.g. because the process has exited, are left
+// empty.
+type Details struct {
+	Exe         string // Path of the executable
+	Cmdline     string // Arguments, separated by spaces
+	UID         int    // Real user ID, -1 if unknown
+	ContainerID string
+}
+
+// ReadDetails reads the details of a process.
+func ReadDetails(pid int) Details {
+	d := Details{UID: -1}
+	d.Exe, _ = os.Readlink(fmt.Sprintf("/proc/%d/exe", pid))
+	if data, err := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid)); err == nil {
+		d.Cmdline = parseCmdline(data)
+	}
+	if data, err := os.ReadFile(fmt.Sprintf("/proc/%d/status", pid)); err == nil {
+		d.UID = parseStatusUID(string(data))
+	}
+	d.ContainerID, _ = ContainerID(pid)
+	return d
+}
+
+// parseCmdline joins the NUL-separated arguments of /proc/[pid]/cmdline.
+func parseCmdline(data []byte) string {
+	return strings.Join(strings.FieldsFunc(string(data), func(r rune) bool { return r == 0 }), " ")
+}
+
+// parseStatusUID returns the real user ID from /proc/[pid]/status, or -1.
+func parseStatusUID(status string) int {
+	for _, line := range strings.Split(status, "\n") {
+		// Uid: real, effective, saved set, filesystem
+		if rest, ok := strings.CutPrefix(line, "Uid:"); ok {
+			fields := strings.Fields(rest)
+			if len(fields) > 0 {
+				if uid, err := strconv.Atoi(fields[0]); err == nil {
+					return uid
+				}
+			}
+		}
+	}
+	return -1
+}
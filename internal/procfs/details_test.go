@@ -0,0 +1,37 @@
+package procfs
+
+import (
+	"os"
+	"testing"
+)
+
+func TestParseCmdline(t *testing.T) {
+	if got := parseCmdline([]byte("curl\x00-s\x00http://example.com\x00")); got != "curl -s http://example.com" {
+		t.Errorf("parseCmdline = %q", got)
+	}
+	if got := parseCmdline(nil); got != "" {
+		t.Errorf("parseCmdline(nil) = %q", got)
+	}
+}
+
+func TestParseStatusUID(t *testing.T) {
+	status := "Name:\tcurl\nUmask:\t0022\nUid:\t1000\t1000\t1000\t1000\nGid:\t1000\t1000\t1000\t1000\n"
+	if got := parseStatusUID(status); got != 1000 {
+		t.Errorf("parseStatusUID = %d, want 1000", got)
+	}
+	if got := parseStatusUID("Name:\tcurl\n"); got != -1 {
+		t.Errorf("parseStatusUID without Uid = %d, want -1", got)
+	}
+}
+
+func TestReadDetailsSelf(t *testing.T) {
+	d := ReadDetails(os.Getpid())
+	if d.Exe == "" || d.Cmdline == "" || d.UID != os.Getuid() {
+		t.Errorf("ReadDetails(self) = %+v", d)
+	}
+
+	gone := ReadDetails(-1)
+	if gone != (Details{UID: -1}) {
+		t.Errorf("ReadDetails(-1) = %+v", gone)
+	}
+}
@@ -53,6 +53,49 @@ func FindProcessBySocket(inode uint64) (*ProcessInfo, error) {
 	return nil, nil // Not found (not an error)
 }
 
+// SocketOwners maps the inode of every socket open in any process to that
+// process. It scans /proc once, which is cheaper than FindProcessBySocket
+// for each of many sockets.
+func SocketOwners() (map[uint64]*ProcessInfo, error) {
+	procs, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, fmt.Errorf("read /proc: %w", err)
+	}
+
+	owners := make(map[uint64]*ProcessInfo)
+	for _, proc := range procs {
+		pid, err := strconv.Atoi(proc.Name())
+		if err != nil {
+			continue
+		}
+
+		fdPath := filepath.Join("/proc", proc.Name(), "fd")
+		fds, err := os.ReadDir(fdPath)
+		if err != nil {
+			continue // Permission denied or process exited
+		}
+
+		var info *ProcessInfo
+		for _, fd := range fds {
+			link, err := os.Readlink(filepath.Join(fdPath, fd.Name()))
+			if err != nil || !strings.HasPrefix(link, "socket:[") {
+				continue
+			}
+			inode, err := strconv.ParseUint(strings.TrimSuffix(link[len("socket:["):], "]"), 10, 64)
+			if err != nil {
+				continue
+			}
+			if info == nil {
+				info = &ProcessInfo{PID: pid, Name: readProcessName(pid)}
+			}
+			if _, seen := owners[inode]; !seen {
+				owners[inode] = info
+			}
+		}
+	}
+	return owners, nil
+}
+
 // readProcessName reads the process name from /proc/[pid]/comm.
 func readProcessName(pid int) string {
 	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/comm", pid))
@@ -15,9 +15,44 @@ type SocketEntry struct {
 	LocalPort  uint16
 	RemoteIP   net.IP
 	RemotePort uint16
+	State      uint8 // Kernel socket state (TCP_LISTEN = 0x0A, ...)
 	Inode      uint64
 }
 
+// Socket states from /proc/net/{tcp,udp}.
+const (
+	stateTCPListen  = 0x0A
+	stateUDPUnbound = 0x07 // TCP_CLOSE, which unconnected UDP sockets report
+)
+
+// ListeningSockets returns the TCP sockets in the LISTEN state, or the
+// unconnected UDP sockets, for protocol "tcp" or "udp".
+func ListeningSockets(protocol string) ([]SocketEntry, error) {
+	var path string
+	var state uint8
+	switch protocol {
+	case "tcp", "TCP":
+		path, state = "/proc/net/tcp", stateTCPListen
+	case "udp", "UDP":
+		path, state = "/proc/net/udp", stateUDPUnbound
+	default:
+		return nil, fmt.Errorf("unsupported protocol: %s", protocol)
+	}
+
+	entries, err := parseNetFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var listening []SocketEntry
+	for _, e := range entries {
+		if e.State == state && e.RemotePort == 0 {
+			listening = append(listening, e)
+		}
+	}
+	return listening, nil
+}
+
 // FindSocketInode finds the inode for a socket matching the given 5-tuple.
 // We need the inode to later find which process owns this socket.
 func FindSocketInode(
@@ -149,6 +184,10 @@ func parseLine(line string) (SocketEntry, error) {
 		return SocketEntry{}, err
 	}
 
+	// fields[3] = state
+	var state uint8
+	fmt.Sscanf(fields[3], "%X", &state)
+
 	// fields[9] = inode
 	var inode uint64
 	fmt.Sscanf(fields[9], "%d", &inode)
@@ -158,6 +197,7 @@ func parseLine(line string) (SocketEntry, error) {
 		LocalPort:  localPort,
 		RemoteIP:   remoteIP,
 		RemotePort: remotePort,
+		State:      state,
 		Inode:      inode,
 	}, nil
 }
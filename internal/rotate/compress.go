@@ -0,0 +1,16 @@
+package rotate
+
+import (
+	"compress/gzip"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// newCompressor returns a writer compressing into w in the given format.
+func newCompressor(w io.Writer, format string) (io.WriteCloser, error) {
+	if format == CompressZstd {
+		return zstd.NewWriter(w)
+	}
+	return gzip.NewWriter(w), nil
+}
@@ -0,0 +1,392 @@
+// Package rotate implements an output file that is split into segments by
+// size or time, with a cap on the number of retained segments and optional
+// compression of finished ones.
+package rotate
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Compression formats for finished segments.
+const (
+	CompressNone = "none"
+	CompressGzip = "gzip"
+	CompressZstd = "zstd"
+)
+
+// flushInterval is how often buffered output is written to disk.
+const flushInterval = time.Second
+
+// Options configures a Writer. The zero value writes a single file.
+type Options struct {
+	MaxSize  uint64        // Start a new segment before one grows past this (0 = no limit)
+	Interval time.Duration // Start a new segment at multiples of this, in UTC (0 = never)
+	MaxFiles int           // Delete the oldest segments beyond this many (0 = keep all)
+	Compress string        // Compress finished segments: none, gzip or zstd
+
+	// Header treats the first Write as a file header (such as the pcap
+	// global header) and repeats it at the start of every segment.
+	Header bool
+}
+
+// rotating reports whether the options split the output at all.
+func (o Options) rotating() bool {
+	return o.MaxSize > 0 || o.Interval > 0
+}
+
+// ValidCompression reports whether name is a supported compression format.
+func ValidCompression(name string) bool {
+	switch name {
+	case "", CompressNone, CompressGzip, CompressZstd:
+		return true
+	}
+	return false
+}
+
+// segment is a closed segment file. last is set for the segment closed by
+// Close, which no newer segment follows.
+type segment struct {
+	name string
+	last bool
+}
+
+// Writer writes records to a series of segment files named by a strftime
+// template. Each Write is treated as one record and never split across
+// segments. It is safe for concurrent use.
+type Writer struct {
+	template string
+	opts     Options
+
+	mu       sync.Mutex
+	file     *os.File
+	buf      *bufio.Writer
+	name     string    // Current segment
+	size     uint64    // Bytes written to the current segment
+	header   []byte    // Repeated at the start of each segment (Options.Header)
+	deadline time.Time // When the current segment ends (Options.Interval)
+	err      error     // Sticky error from a failed rotation
+
+	finished chan segment // Closed segments for the finisher goroutine
+	done     chan struct{}
+	stop     chan struct{}
+}
+
+// Open creates the first segment and returns a Writer for it.
+//
+// The template may contain strftime directives (%Y, %m, %d, %H, %M, %S, ...)
+// expanded when a segment is opened. If the output rotates and the template
+// has none, a timestamp is inserted before the extension so that segments
+// get distinct names.
+func Open(template string, opts Options) (*Writer, error) {
+	if !ValidCompression(opts.Compress) {
+		return nil, fmt.Errorf("unknown compression %q (want none, gzip or zstd)", opts.Compress)
+	}
+	if opts.rotating() && !strings.Contains(template, "%") {
+		ext := filepath.Ext(template)
+		template = strings.TrimSuffix(template, ext) + "-%Y%m%d-%H%M%S" + ext
+	}
+
+	w := &Writer{
+		template: template,
+		opts:     opts,
+		finished: make(chan segment, 16),
+		done:     make(chan struct{}),
+		stop:     make(chan struct{}),
+	}
+	if err := w.openSegment(time.Now()); err != nil {
+		return nil, err
+	}
+
+	go w.finisher()
+	go w.flushLoop()
+	return w, nil
+}
+
+// Name returns the path of the segment currently being written.
+func (w *Writer) Name() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.name
+}
+
+// Write appends one record, starting a new segment first if the current
+// one is full or its interval is over.
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	if w.opts.Header && w.header == nil {
+		w.header = append([]byte{}, p...)
+	} else if w.needsRotation(len(p), time.Now()) {
+		if err := w.rotate(time.Now()); err != nil {
+			w.err = err
+			return 0, err
+		}
+	}
+
+	n, err := w.buf.Write(p)
+	w.size += uint64(n)
+	return n, err
+}
+
+// needsRotation reports whether a record of n bytes must go into a new
+// segment. A segment holding only the header is never rotated for size.
+// Caller must hold the lock.
+func (w *Writer) needsRotation(n int, now time.Time) bool {
+	if !w.deadline.IsZero() && !now.Before(w.deadline) {
+		return true
+	}
+	return w.opts.MaxSize > 0 && w.size > uint64(len(w.header)) &&
+		w.size+uint64(n) > w.opts.MaxSize
+}
+
+// Flush writes buffered records to the current segment.
+func (w *Writer) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.Flush()
+}
+
+// Close flushes and closes the current segment and waits until every
+// finished segment has been compressed and old ones removed.
+func (w *Writer) Close() error {
+	close(w.stop)
+
+	w.mu.Lock()
+	err := w.closeSegment(true)
+	if w.err == nil {
+		w.err = os.ErrClosed
+	}
+	w.mu.Unlock()
+
+	close(w.finished)
+	<-w.done
+	return err
+}
+
+// Rotate starts a new segment now, unless the current one holds no
+// records yet. A Writer that doesn't split its output reopens its file
+// instead, appending, so a tool like logrotate can move the file away
+// first.
+func (w *Writer) Rotate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.err != nil {
+		return w.err
+	}
+	if w.opts.rotating() {
+		if w.size <= uint64(len(w.header)) {
+			return nil
+		}
+		if err := w.rotate(time.Now()); err != nil {
+			w.err = err
+			return err
+		}
+		return nil
+	}
+
+	if err := w.buf.Flush(); err != nil {
+		return fmt.Errorf("flush %s: %w", w.name, err)
+	}
+	f, err := os.OpenFile(w.name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("reopen %s: %w", w.name, err)
+	}
+	w.file.Close()
+	w.file = f
+	w.buf.Reset(f)
+
+	// A new file needs the header; one that wasn't moved has it already
+	if fi, err := f.Stat(); err == nil && fi.Size() == 0 && w.header != nil {
+		n, err := w.buf.Write(w.header)
+		w.size = uint64(n)
+		return err
+	}
+	return nil
+}
+
+// rotate closes the current segment and opens the next one.
+// Caller must hold the lock.
+func (w *Writer) rotate(now time.Time) error {
+	if err := w.closeSegment(false); err != nil {
+		return err
+	}
+	return w.openSegment(now)
+}
+
+// openSegment creates a segment file for now and writes the header to it.
+// Caller must hold the lock (or be Open).
+func (w *Writer) openSegment(now time.Time) error {
+	name := Strftime(w.template, now)
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if w.opts.rotating() {
+		// Never overwrite an earlier segment, ours or another run's
+		flags = os.O_CREATE | os.O_WRONLY | os.O_EXCL
+	}
+
+	var f *os.File
+	var err error
+	for i := 0; ; i++ {
+		candidate := name
+		if i > 0 {
+			ext := filepath.Ext(name)
+			candidate = fmt.Sprintf("%s.%d%s", strings.TrimSuffix(name, ext), i, ext)
+		}
+		f, err = os.OpenFile(candidate, flags, 0o644)
+		if errors.Is(err, os.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("open output segment: %w", err)
+		}
+		name = candidate
+		break
+	}
+
+	w.file = f
+	w.buf = bufio.NewWriterSize(f, 64*1024)
+	w.name = name
+	w.size = 0
+	if w.opts.Interval > 0 {
+		w.deadline = now.UTC().Truncate(w.opts.Interval).Add(w.opts.Interval)
+	}
+
+	if w.header != nil {
+		n, err := w.buf.Write(w.header)
+		w.size += uint64(n)
+		return err
+	}
+	return nil
+}
+
+// closeSegment flushes and closes the current segment and hands it to the
+// finisher. last is set when no new segment follows. Caller must hold
+// the lock.
+func (w *Writer) closeSegment(last bool) error {
+	if w.file == nil {
+		return nil
+	}
+
+	err := w.buf.Flush()
+	if cerr := w.file.Close(); err == nil {
+		err = cerr
+	}
+	w.file = nil
+
+	if err != nil {
+		return fmt.Errorf("close output segment %s: %w", w.name, err)
+	}
+	w.finished <- segment{name: w.name, last: last}
+	return nil
+}
+
+// flushLoop periodically flushes buffered records, so a quiet capture
+// still reaches the disk, and starts new segments on schedule.
+func (w *Writer) flushLoop() {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case now := <-ticker.C:
+			w.mu.Lock()
+			if w.file != nil && w.err == nil {
+				if !w.deadline.IsZero() && !now.Before(w.deadline) {
+					w.err = w.rotate(now)
+				} else {
+					w.buf.Flush()
+				}
+			}
+			w.mu.Unlock()
+		}
+	}
+}
+
+// finisher compresses finished segments and enforces MaxFiles, in the
+// order the segments were closed.
+func (w *Writer) finisher() {
+	defer close(w.done)
+
+	var kept []string
+	for seg := range w.finished {
+		name := seg.name
+		if w.opts.Compress != "" && w.opts.Compress != CompressNone {
+			compressed, err := compressFile(name, w.opts.Compress)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "compress %s: %v\n", name, err)
+			} else {
+				name = compressed
+			}
+		}
+		kept = append(kept, name)
+
+		// The segment being written next counts towards the limit
+		limit := w.opts.MaxFiles
+		if !seg.last {
+			limit--
+		}
+		for w.opts.MaxFiles > 0 && len(kept) > limit {
+			if err := os.Remove(kept[0]); err != nil && !errors.Is(err, os.ErrNotExist) {
+				fmt.Fprintf(os.Stderr, "remove old segment: %v\n", err)
+			}
+			kept = kept[1:]
+		}
+	}
+}
+
+// compressFile compresses name into name.gz or name.zst and removes the
+// original. Returns the compressed file's name.
+func compressFile(name, format string) (string, error) {
+	src, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	target := name + compressedExt(format)
+	dst, err := os.Create(target)
+	if err != nil {
+		return "", err
+	}
+
+	zw, err := newCompressor(dst, format)
+	if err == nil {
+		_, err = io.Copy(zw, src)
+		if cerr := zw.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(target)
+		return "", err
+	}
+
+	return target, os.Remove(name)
+}
+
+// compressedExt returns the file extension for a compression format.
+func compressedExt(format string) string {
+	if format == CompressZstd {
+		return ".zst"
+	}
+	return ".gz"
+}
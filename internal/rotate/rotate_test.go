@@ -0,0 +1,204 @@
+package rotate
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestStrftime(t *testing.T) {
+	ts := time.Date(2025, 12, 24, 9, 5, 7, 0, time.UTC)
+
+	tests := map[string]string{
+		"out-%Y%m%d-%H%M%S.json": "out-20251224-090507.json",
+		"%F/%T.pcap":             "2025-12-24/090507.pcap",
+		"day%j-%y":               "day358-25",
+		"%s":                     "1766567107",
+		"100%%-%q":               "100%-%q",
+		"trailing%":              "trailing%",
+	}
+	for format, want := range tests {
+		if got := Strftime(format, ts); got != want {
+			t.Errorf("Strftime(%q) = %q, want %q", format, got, want)
+		}
+	}
+}
+
+// segments returns the sorted names of the files in dir.
+func segments(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestWriterRotatesBySize(t *testing.T) {
+	dir := t.TempDir()
+	w, err := Open(filepath.Join(dir, "out-%Y.log"), Options{MaxSize: 10, Header: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w.Write([]byte("HDR\n"))
+	for _, rec := range []string{"aaaa\n", "bbbb\n", "cccc\n"} {
+		if _, err := w.Write([]byte(rec)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each segment fits the header and one 5-byte record. Names collide
+	// within the same year, so later segments get a counter.
+	want := map[string]string{
+		"out-%Y.log":   "HDR\naaaa\n",
+		"out-%Y.1.log": "HDR\nbbbb\n",
+		"out-%Y.2.log": "HDR\ncccc\n",
+	}
+	if names := segments(t, dir); len(names) != len(want) {
+		t.Fatalf("segments = %v, want %d", names, len(want))
+	}
+	for template, content := range want {
+		name := Strftime(template, time.Now())
+		got, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil || string(got) != content {
+			t.Errorf("segment %s = %q (%v), want %q", name, got, err, content)
+		}
+	}
+}
+
+func TestWriterMaxFilesAndGzip(t *testing.T) {
+	dir := t.TempDir()
+	w, err := Open(filepath.Join(dir, "out.log"), Options{MaxSize: 4, MaxFiles: 2, Compress: CompressGzip})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rec := range []string{"one\n", "two\n", "six\n", "ten\n"} {
+		w.Write([]byte(rec))
+	}
+	w.Close()
+
+	names := segments(t, dir)
+	if len(names) != 2 {
+		t.Fatalf("segments = %v, want the newest 2", names)
+	}
+
+	var all []byte
+	for _, name := range names {
+		if filepath.Ext(name) != ".gz" {
+			t.Errorf("segment %s is not gzip-compressed", name)
+			continue
+		}
+		f, _ := os.Open(filepath.Join(dir, name))
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(zr)
+		f.Close()
+		all = append(all, data...)
+	}
+	if !bytes.Equal(all, []byte("six\nten\n")) {
+		t.Errorf("retained content = %q, want %q", all, "six\nten\n")
+	}
+}
+
+func TestWriterZstd(t *testing.T) {
+	dir := t.TempDir()
+	w, err := Open(filepath.Join(dir, "out.log"), Options{Compress: CompressZstd})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("hello\n"))
+	w.Close()
+
+	names := segments(t, dir)
+	if len(names) != 1 || names[0] != "out.log.zst" {
+		t.Fatalf("segments = %v, want [out.log.zst]", names)
+	}
+
+	f, _ := os.Open(filepath.Join(dir, names[0]))
+	defer f.Close()
+	zr, err := zstd.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	if data, _ := io.ReadAll(zr); string(data) != "hello\n" {
+		t.Errorf("content = %q, want %q", data, "hello\n")
+	}
+}
+
+func TestWriterRotate(t *testing.T) {
+	dir := t.TempDir()
+	w, err := Open(filepath.Join(dir, "seg.log"), Options{MaxSize: 1 << 20, Header: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("HDR\n"))
+	w.Rotate() // Nothing to rotate yet
+	w.Write([]byte("one\n"))
+	if err := w.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("two\n"))
+	w.Close()
+
+	names := segments(t, dir)
+	if len(names) != 2 {
+		t.Fatalf("segments = %v, want 2", names)
+	}
+	var contents []string
+	for _, name := range names {
+		data, _ := os.ReadFile(filepath.Join(dir, name))
+		contents = append(contents, string(data))
+	}
+	sort.Strings(contents)
+	if contents[0] != "HDR\none\n" || contents[1] != "HDR\ntwo\n" {
+		t.Errorf("segments hold %q, want a record each after the header", contents)
+	}
+
+	// A single file is reopened, as after logrotate moved it
+	single := filepath.Join(dir, "single", "out.log")
+	os.Mkdir(filepath.Dir(single), 0o755)
+	w, err = Open(single, Options{Header: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("HDR\n"))
+	w.Write([]byte("one\n"))
+	os.Rename(single, single+".1")
+	if err := w.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("two\n"))
+	w.Rotate() // Not moved this time
+	w.Write([]byte("six\n"))
+	w.Close()
+	for name, want := range map[string]string{single + ".1": "HDR\none\n", single: "HDR\ntwo\nsix\n"} {
+		if data, _ := os.ReadFile(name); string(data) != want {
+			t.Errorf("%s = %q, want %q", filepath.Base(name), data, want)
+		}
+	}
+}
+
+func TestOpenRejectsUnknownCompression(t *testing.T) {
+	if _, err := Open(filepath.Join(t.TempDir(), "out"), Options{Compress: "lz4"}); err == nil {
+		t.Error("Open succeeded with unknown compression")
+	}
+}
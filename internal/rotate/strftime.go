@@ -0,0 +1,57 @@
+package rotate
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Strftime expands the strftime directives in format:
+//
+//	%Y year      %m month   %d day      %H hour    %M minute  %S second
+//	%y 2-digit year  %j day of year  %s Unix time  %F %Y-%m-%d  %T %H%M%S
+//	%% a literal %
+//
+// %T omits the colons so that names stay portable. Unknown directives
+// are kept as they are.
+func Strftime(format string, t time.Time) string {
+	var b strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i+1 == len(format) {
+			b.WriteByte(format[i])
+			continue
+		}
+
+		i++
+		switch format[i] {
+		case 'Y':
+			b.WriteString(t.Format("2006"))
+		case 'y':
+			b.WriteString(t.Format("06"))
+		case 'm':
+			b.WriteString(t.Format("01"))
+		case 'd':
+			b.WriteString(t.Format("02"))
+		case 'H':
+			b.WriteString(t.Format("15"))
+		case 'M':
+			b.WriteString(t.Format("04"))
+		case 'S':
+			b.WriteString(t.Format("05"))
+		case 'j':
+			b.WriteString(t.Format("002"))
+		case 's':
+			b.WriteString(strconv.FormatInt(t.Unix(), 10))
+		case 'F':
+			b.WriteString(t.Format("2006-01-02"))
+		case 'T':
+			b.WriteString(t.Format("150405"))
+		case '%':
+			b.WriteByte('%')
+		default:
+			b.WriteByte('%')
+			b.WriteByte(format[i])
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,70 @@
+// Package sdnotify tells systemd how a Type=notify service is doing: that
+// it is ready, what it is busy with, and, for the watchdog, that it is
+// still alive. See sd_notify(3).
+package sdnotify
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// States understood by systemd.
+const (
+	Ready    = "READY=1"
+	Stopping = "STOPPING=1"
+	Watchdog = "WATCHDOG=1"
+)
+
+// Status returns the state that shows msg in systemctl status.
+func Status(msg string) string {
+	return "STATUS=" + msg
+}
+
+// Notify sends states to systemd, joined by newlines. It reports false
+// without an error if the process wasn't started by systemd or not as a
+// notify service.
+func Notify(states ...string) (bool, error) {
+	path := os.Getenv("NOTIFY_SOCKET")
+	if path == "" {
+		return false, nil
+	}
+	// A leading @ names an abstract socket, which net understands as is
+	c, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return false, fmt.Errorf("sd_notify: %w", err)
+	}
+	defer c.Close()
+
+	var msg []byte
+	for i, s := range states {
+		if i > 0 {
+			msg = append(msg, '\n')
+		}
+		msg = append(msg, s...)
+	}
+	if _, err := c.Write(msg); err != nil {
+		return false, fmt.Errorf("sd_notify: %w", err)
+	}
+	return true, nil
+}
+
+// WatchdogInterval returns how often systemd wants to hear Watchdog, or 0
+// if the watchdog is off or meant for another process. Notifying at half
+// the interval is customary.
+func WatchdogInterval() (time.Duration, error) {
+	usec := os.Getenv("WATCHDOG_USEC")
+	if usec == "" {
+		return 0, nil
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0, nil
+	}
+	n, err := strconv.ParseUint(usec, 10, 63)
+	if err != nil || n == 0 {
+		return 0, fmt.Errorf("sd_notify: invalid WATCHDOG_USEC %q", usec)
+	}
+	return time.Duration(n) * time.Microsecond, nil
+}
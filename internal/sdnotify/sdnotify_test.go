@@ -0,0 +1,67 @@
+package sdnotify
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestNotify(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if sent, err := Notify(Ready); sent || err != nil {
+		t.Errorf("without systemd: %v, %v", sent, err)
+	}
+
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	c, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	t.Setenv("NOTIFY_SOCKET", path)
+
+	if sent, err := Notify(Ready, Status("capturing on eth0")); !sent || err != nil {
+		t.Fatalf("Notify: %v, %v", sent, err)
+	}
+	buf := make([]byte, 256)
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := c.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(buf[:n]); got != "READY=1\nSTATUS=capturing on eth0" {
+		t.Errorf("got %q", got)
+	}
+
+	os.Remove(path)
+	if _, err := Notify(Watchdog); err == nil {
+		t.Error("expected error without a listener")
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	pid := strconv.Itoa(os.Getpid())
+	tests := []struct {
+		usec, pid string
+		want      time.Duration
+		err       bool
+	}{
+		{"", "", 0, false},
+		{"30000000", "", 30 * time.Second, false},
+		{"30000000", pid, 30 * time.Second, false},
+		{"30000000", "1", 0, false}, // Someone else's
+		{"0", "", 0, true},
+		{"soon", "", 0, true},
+	}
+	for _, tt := range tests {
+		t.Setenv("WATCHDOG_USEC", tt.usec)
+		t.Setenv("WATCHDOG_PID", tt.pid)
+		got, err := WatchdogInterval()
+		if got != tt.want || (err != nil) != tt.err {
+			t.Errorf("WATCHDOG_USEC=%q WATCHDOG_PID=%q: %v, %v", tt.usec, tt.pid, got, err)
+		}
+	}
+}
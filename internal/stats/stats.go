@@ -3,41 +3,256 @@ package stats
 import (
 	"encoding/json"
 	"io"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/hwang-fu/portlens/internal/output"
+)
+
+// Cardinality bounds of the breakdowns; further keys are counted under
+// OtherKey.
+const (
+	maxBreakdownKeys = 1000
+	maxTalkers       = 10000
 )
 
+// TopTalkers is the number of talkers and processes listed per interval.
+const TopTalkers = 10
+
+// Traffic describes a packet that passed all filters.
+type Traffic struct {
+	Interface string
+	Protocol  string // TCP, UDP or ARP
+	Direction string // in, out or unknown
+	SrcIP     string
+	DstIP     string
+	Port      uint16 // Service port, 0 if none
+	Process   string // Owning process name, empty if unknown
+	Length    int    // Wire length
+}
+
 // StatsRecorder tracks packet capture statistics.
 type StatsRecorder struct {
 	mu        sync.Mutex
 	startTime time.Time
+	now       func() time.Time
+
+	// Per-second counts of captured packets for the windowed rates
+	rates window
+
+	// Lifetime totals of matching packets by dimension
+	byProtocol  *breakdown
+	byDirection *breakdown
+	byInterface *breakdown
+	byPort      *breakdown
+	byProcess   *breakdown
+
+	// Matching traffic since the current interval started
+	intervalStart     time.Time
+	intervalPackets   uint64
+	intervalBytes     uint64
+	intervalTalkers   *breakdown // Keyed by host pair
+	intervalProcesses *breakdown
 
 	// Counters
 	PacketsCaptured uint64
 	BytesProcessed  uint64
+
+	// Capture timestamps of the first and last packet
+	FirstPacket time.Time
+	LastPacket  time.Time
+
+	// Per-stage counters: where packets and events were lost or discarded
+	ParseErrors        uint64 // Packets that failed to decode
+	PacketsFiltered    uint64 // Packets rejected by a filter
+	Duplicates         uint64 // Copies of a packet already seen on another interface
+	TrackerEventDrops  uint64 // Connection events dropped because the queue was full
+	NeighborEventDrops uint64 // ARP neighbor events dropped because the queue was full
+
+	// Kernel capture socket counters (PACKET_STATISTICS), summed over sockets
+	Kernel KernelStats
+
+	// Why the capture stopped (empty while it is running)
+	StopReason string
+}
+
+// KernelStats holds the kernel's view of the capture sockets.
+type KernelStats struct {
+	Packets          uint64 // Packets seen by the kernel, including drops
+	Drops            uint64 // Dropped because the socket receive queue was full
+	FreezeQueueDrops uint64 // Dropped while the ring buffer was frozen
 }
 
 // NewRecorder creates a new stats recorder.
 func NewRecorder() *StatsRecorder {
-	return &StatsRecorder{
-		startTime: time.Now(),
+	s := &StatsRecorder{
+		now:         time.Now,
+		byProtocol:  newBreakdown(maxBreakdownKeys),
+		byDirection: newBreakdown(maxBreakdownKeys),
+		byInterface: newBreakdown(maxBreakdownKeys),
+		byPort:      newBreakdown(maxBreakdownKeys),
+		byProcess:   newBreakdown(maxBreakdownKeys),
 	}
+	s.startTime = s.now()
+	s.resetInterval(s.startTime)
+	return s
 }
 
-// RecordPacket records a captured packet.
-func (s *StatsRecorder) RecordPacket(size int) {
+// RecordPacket records a captured packet with its capture timestamp.
+func (s *StatsRecorder) RecordPacket(size int, ts time.Time) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	s.PacketsCaptured++
 	s.BytesProcessed += uint64(size)
+	s.rates.add(s.now(), size)
+
+	if s.FirstPacket.IsZero() || ts.Before(s.FirstPacket) {
+		s.FirstPacket = ts
+	}
+	if ts.After(s.LastPacket) {
+		s.LastPacket = ts
+	}
+}
+
+// RecordTraffic accounts a packet that passed all filters in the
+// breakdowns and the current interval.
+func (s *StatsRecorder) RecordTraffic(t Traffic) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	process := t.Process
+	if process == "" {
+		process = "unknown"
+	}
+	s.byProtocol.add(t.Protocol, t.Length)
+	s.byDirection.add(t.Direction, t.Length)
+	s.byInterface.add(t.Interface, t.Length)
+	if t.Port != 0 {
+		s.byPort.add(strings.ToLower(t.Protocol)+"/"+strconv.Itoa(int(t.Port)), t.Length)
+	}
+	s.byProcess.add(process, t.Length)
+
+	s.intervalPackets++
+	s.intervalBytes += uint64(t.Length)
+	s.intervalProcesses.add(process, t.Length)
+	if t.SrcIP != "" && t.DstIP != "" {
+		// Both directions of a conversation count for the same pair
+		a, b := t.SrcIP, t.DstIP
+		if b < a {
+			a, b = b, a
+		}
+		s.intervalTalkers.add(a+" "+b, t.Length)
+	}
+}
+
+// resetInterval starts a new interval at now.
+func (s *StatsRecorder) resetInterval(now time.Time) {
+	s.intervalStart = now
+	s.intervalPackets = 0
+	s.intervalBytes = 0
+	s.intervalTalkers = newBreakdown(maxTalkers)
+	s.intervalProcesses = newBreakdown(maxBreakdownKeys)
+}
+
+// RecordParseError records a packet that failed to decode.
+func (s *StatsRecorder) RecordParseError() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ParseErrors++
+}
+
+// RecordFiltered records a packet rejected by a filter.
+func (s *StatsRecorder) RecordFiltered() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.PacketsFiltered++
+}
+
+// RecordDuplicate records a packet dropped as a cross-interface duplicate.
+func (s *StatsRecorder) RecordDuplicate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Duplicates++
+}
+
+// SetEventDrops sets the cumulative number of dropped tracker and neighbor events.
+func (s *StatsRecorder) SetEventDrops(tracker, neighbor uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.TrackerEventDrops = tracker
+	s.NeighborEventDrops = neighbor
+}
+
+// SetKernelStats sets the cumulative kernel socket counters.
+func (s *StatsRecorder) SetKernelStats(k KernelStats) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Kernel = k
+}
+
+// SetStopReason records why the capture stopped.
+func (s *StatsRecorder) SetStopReason(reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.StopReason = reason
 }
 
 // Snapshot returns current stats as a JSON-serializable struct.
 func (s *StatsRecorder) Snapshot() map[string]any {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	return s.snapshot(s.now())
+}
+
+// EndInterval returns a snapshot and starts a new interval, so the next
+// snapshot's top talkers cover only what happened after this one.
+func (s *StatsRecorder) EndInterval() map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	snapshot := s.snapshot(now)
+	s.resetInterval(now)
+	return snapshot
+}
+
+// Totals are the cumulative counters of a capture at one point in time.
+type Totals struct {
+	Time          time.Time
+	Packets       uint64
+	Bytes         uint64
+	PacketsPerSec float64 // Over the last RateWindows[1] seconds
+	BytesPerSec   float64
+	ParseErrors   uint64
+	Filtered      uint64
+	Duplicates    uint64
+	KernelDrops   uint64 // Including freeze queue drops
+	EventDrops    uint64 // Tracker and neighbor events
+}
+
+// Totals returns the current counters.
+func (s *StatsRecorder) Totals() Totals {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	packetsPerSec, bytesPerSec := s.rates.rate(now, RateWindows[1], s.startTime)
+	return Totals{
+		Time:          now,
+		Packets:       s.PacketsCaptured,
+		Bytes:         s.BytesProcessed,
+		PacketsPerSec: packetsPerSec,
+		BytesPerSec:   bytesPerSec,
+		ParseErrors:   s.ParseErrors,
+		Filtered:      s.PacketsFiltered,
+		Duplicates:    s.Duplicates,
+		KernelDrops:   s.Kernel.Drops + s.Kernel.FreezeQueueDrops,
+		EventDrops:    s.TrackerEventDrops + s.NeighborEventDrops,
+	}
+}
 
-	elapsed := time.Since(s.startTime).Seconds()
+func (s *StatsRecorder) snapshot(now time.Time) map[string]any {
+	elapsed := now.Sub(s.startTime).Seconds()
 	packetsPerSec := float64(0)
 	bytesPerSec := float64(0)
 	if elapsed > 0 {
@@ -45,14 +260,100 @@ func (s *StatsRecorder) Snapshot() map[string]any {
 		bytesPerSec = float64(s.BytesProcessed) / elapsed
 	}
 
-	return map[string]any{
-		"type":             "stats",
-		"timestamp":        time.Now().UTC().Format("2006-01-02T15:04:05.000Z"),
+	kernelDropRate := float64(0)
+	if s.Kernel.Packets > 0 {
+		kernelDropRate = float64(s.Kernel.Drops+s.Kernel.FreezeQueueDrops) / float64(s.Kernel.Packets)
+	}
+
+	// A capture is complete if nothing was lost before reaching the output.
+	// Parse errors, filtered packets and duplicates are deliberate discards.
+	complete := s.Kernel.Drops == 0 && s.Kernel.FreezeQueueDrops == 0 &&
+		s.TrackerEventDrops == 0 && s.NeighborEventDrops == 0
+
+	snapshot := map[string]any{
+		"type":             output.TypeStats,
+		"schema_version":   output.SchemaVersion,
+		"timestamp":        output.FormatTime(now),
 		"elapsed_seconds":  elapsed,
 		"packets_captured": s.PacketsCaptured,
 		"bytes_processed":  s.BytesProcessed,
 		"packets_per_sec":  packetsPerSec,
 		"bytes_per_sec":    bytesPerSec,
+		"kernel": map[string]any{
+			"packets":            s.Kernel.Packets,
+			"drops":              s.Kernel.Drops,
+			"freeze_queue_drops": s.Kernel.FreezeQueueDrops,
+			"drop_rate":          kernelDropRate,
+		},
+		"pipeline": map[string]any{
+			"parse_errors":         s.ParseErrors,
+			"filtered":             s.PacketsFiltered,
+			"duplicates":           s.Duplicates,
+			"tracker_event_drops":  s.TrackerEventDrops,
+			"neighbor_event_drops": s.NeighborEventDrops,
+		},
+		"rates": s.windowRates(now),
+		"breakdown": map[string]any{
+			"protocol":  s.byProtocol.snapshot(),
+			"direction": s.byDirection.snapshot(),
+			"interface": s.byInterface.snapshot(),
+			"port":      s.byPort.snapshot(),
+			"process":   s.byProcess.snapshot(),
+		},
+		"interval": s.interval(now),
+		"complete": complete,
+	}
+	if !s.FirstPacket.IsZero() {
+		snapshot["first_packet"] = output.FormatTime(s.FirstPacket)
+		snapshot["last_packet"] = output.FormatTime(s.LastPacket)
+	}
+	if s.StopReason != "" {
+		snapshot["stop_reason"] = s.StopReason
+	}
+	return snapshot
+}
+
+// windowRates returns the packet and byte rates over each of RateWindows.
+func (s *StatsRecorder) windowRates(now time.Time) map[string]any {
+	rates := make(map[string]any, len(RateWindows))
+	for _, seconds := range RateWindows {
+		packets, bytes := s.rates.rate(now, seconds, s.startTime)
+		rates[strconv.Itoa(seconds)+"s"] = map[string]any{
+			"packets_per_sec": packets,
+			"bytes_per_sec":   bytes,
+		}
+	}
+	return rates
+}
+
+// interval returns the matching traffic of the current interval with its
+// top talkers and processes by bytes.
+func (s *StatsRecorder) interval(now time.Time) map[string]any {
+	talkers := []map[string]any{}
+	for _, key := range s.intervalTalkers.top(TopTalkers) {
+		c := s.intervalTalkers.counts[key]
+		talker := map[string]any{"packets": c.Packets, "bytes": c.Bytes}
+		if a, b, ok := strings.Cut(key, " "); ok {
+			talker["hosts"] = []string{a, b}
+		} else {
+			talker["hosts"] = []string{key}
+		}
+		talkers = append(talkers, talker)
+	}
+
+	processes := []map[string]any{}
+	for _, name := range s.intervalProcesses.top(TopTalkers) {
+		c := s.intervalProcesses.counts[name]
+		processes = append(processes, map[string]any{"process": name, "packets": c.Packets, "bytes": c.Bytes})
+	}
+
+	return map[string]any{
+		"start":         output.FormatTime(s.intervalStart),
+		"seconds":       now.Sub(s.intervalStart).Seconds(),
+		"packets":       s.intervalPackets,
+		"bytes":         s.intervalBytes,
+		"top_talkers":   talkers,
+		"top_processes": processes,
 	}
 }
 
@@ -60,3 +361,8 @@ func (s *StatsRecorder) Snapshot() map[string]any {
 func (s *StatsRecorder) WriteJSON(w io.Writer) {
 	json.NewEncoder(w).Encode(s.Snapshot())
 }
+
+// WriteInterval writes the current stats as JSON and starts a new interval.
+func (s *StatsRecorder) WriteInterval(w io.Writer) {
+	json.NewEncoder(w).Encode(s.EndInterval())
+}
@@ -0,0 +1,179 @@
+package stats
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/hwang-fu/portlens/internal/output"
+)
+
+// TestSnapshotMatchesSchema fails if a stats field is not documented in
+// the published stats schema.
+func TestSnapshotMatchesSchema(t *testing.T) {
+	s := NewRecorder()
+	s.RecordPacket(100, time.Now())
+	s.SetStopReason("count")
+
+	data, err := output.Schema(output.TypeStats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var schema struct {
+		Required   []string                   `json:"required"`
+		Properties map[string]json.RawMessage `json:"properties"`
+	}
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot := s.Snapshot()
+	for key := range snapshot {
+		if _, ok := schema.Properties[key]; !ok {
+			t.Errorf("stats field %q is missing from the schema", key)
+		}
+	}
+	for _, key := range schema.Required {
+		if _, ok := snapshot[key]; !ok {
+			t.Errorf("required field %q is missing from the snapshot", key)
+		}
+	}
+}
+
+// fakeClock is a settable time source for the recorder.
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func newTestRecorder() (*StatsRecorder, *fakeClock) {
+	clock := &fakeClock{time.Unix(1000, 0)}
+	s := NewRecorder()
+	s.now = clock.now
+	s.startTime = clock.t
+	s.resetInterval(clock.t)
+	return s, clock
+}
+
+func TestWindowedRates(t *testing.T) {
+	s, clock := newTestRecorder()
+
+	// A minute of quiet traffic, then a burst
+	for i := 0; i < 60; i++ {
+		s.RecordPacket(100, clock.t)
+		clock.t = clock.t.Add(time.Second)
+	}
+	for i := 0; i < 50; i++ {
+		s.RecordPacket(100, clock.t)
+	}
+	clock.t = clock.t.Add(999 * time.Millisecond)
+
+	rates := s.Snapshot()["rates"].(map[string]any)
+	rate := func(window string) float64 {
+		return rates[window].(map[string]any)["packets_per_sec"].(float64)
+	}
+	if got := rate("1s"); got < 50 || got > 50.1 {
+		t.Errorf("1s rate = %v, want ~50", got)
+	}
+	// The second a window starts in counts for its last millisecond
+	if got, want := rate("10s"), 59.001/10; got < want-0.01 || got > want+0.01 {
+		t.Errorf("10s rate = %v, want ~%v", got, want)
+	}
+	if got, want := rate("60s"), 109.001/60; got < want-0.01 || got > want+0.01 {
+		t.Errorf("60s rate = %v, want ~%v", got, want)
+	}
+
+	totals := s.Totals()
+	if totals.Packets != 110 || totals.Bytes != 11000 || totals.PacketsPerSec != rate("10s") {
+		t.Errorf("totals = %+v", totals)
+	}
+}
+
+func TestRatesAcrossSecondBoundary(t *testing.T) {
+	// Just after a boundary, on it, and just before the next one: the
+	// current second holds few packets, none, or nearly all of them
+	for _, offset := range []time.Duration{time.Millisecond, 0, 995 * time.Millisecond} {
+		s, clock := newTestRecorder()
+
+		// A steady 100 packets per second for a minute, up to now
+		end := clock.t.Add(60*time.Second + offset)
+		for ; !clock.t.After(end); clock.t = clock.t.Add(10 * time.Millisecond) {
+			s.RecordPacket(100, clock.t)
+		}
+		clock.t = end
+
+		rates := s.Snapshot()["rates"].(map[string]any)
+		for _, window := range []string{"1s", "10s", "60s"} {
+			got := rates[window].(map[string]any)["packets_per_sec"].(float64)
+			if got < 99 || got > 101 {
+				t.Errorf("%v after the boundary: %s rate = %v, want ~100", offset, window, got)
+			}
+		}
+	}
+}
+
+func TestRatesRightAfterStart(t *testing.T) {
+	s, clock := newTestRecorder()
+	clock.t = clock.t.Add(500 * time.Millisecond)
+	s.RecordPacket(1000, clock.t)
+
+	// Half a second has passed, not a whole minute
+	rates := s.Snapshot()["rates"].(map[string]any)
+	if got := rates["60s"].(map[string]any)["bytes_per_sec"].(float64); got != 2000 {
+		t.Errorf("60s byte rate = %v, want 2000", got)
+	}
+}
+
+func TestBreakdownsAndTopTalkers(t *testing.T) {
+	s, clock := newTestRecorder()
+	s.RecordTraffic(Traffic{Interface: "eth0", Protocol: "TCP", Direction: "out",
+		SrcIP: "10.0.0.5", DstIP: "1.1.1.1", Port: 443, Process: "curl", Length: 100})
+	s.RecordTraffic(Traffic{Interface: "eth0", Protocol: "TCP", Direction: "in",
+		SrcIP: "1.1.1.1", DstIP: "10.0.0.5", Port: 443, Process: "curl", Length: 1500})
+	s.RecordTraffic(Traffic{Interface: "eth1", Protocol: "UDP", Direction: "out",
+		SrcIP: "10.0.0.5", DstIP: "8.8.8.8", Port: 53, Length: 80})
+
+	breakdown := s.Snapshot()["breakdown"].(map[string]any)
+	if got := breakdown["protocol"].(map[string]counter)["TCP"]; got != (counter{Packets: 2, Bytes: 1600}) {
+		t.Errorf("TCP = %+v", got)
+	}
+	if got := breakdown["port"].(map[string]counter)["udp/53"]; got.Packets != 1 {
+		t.Errorf("udp/53 = %+v", got)
+	}
+	if got := breakdown["process"].(map[string]counter)["unknown"]; got.Bytes != 80 {
+		t.Errorf("unknown process = %+v", got)
+	}
+
+	interval := s.EndInterval()["interval"].(map[string]any)
+	talkers := interval["top_talkers"].([]map[string]any)
+	if len(talkers) != 2 || talkers[0]["bytes"] != uint64(1600) {
+		t.Fatalf("top talkers = %+v", talkers)
+	}
+	if hosts := talkers[0]["hosts"].([]string); hosts[0] != "1.1.1.1" || hosts[1] != "10.0.0.5" {
+		t.Errorf("first talker hosts = %v", hosts)
+	}
+	if procs := interval["top_processes"].([]map[string]any); procs[0]["process"] != "curl" {
+		t.Errorf("top processes = %+v", procs)
+	}
+
+	// A new interval starts empty; lifetime totals are kept
+	clock.t = clock.t.Add(5 * time.Second)
+	snap := s.Snapshot()
+	interval = snap["interval"].(map[string]any)
+	if interval["packets"] != uint64(0) || len(interval["top_talkers"].([]map[string]any)) != 0 || interval["seconds"] != 5.0 {
+		t.Errorf("new interval = %+v", interval)
+	}
+	if got := snap["breakdown"].(map[string]any)["interface"].(map[string]counter)["eth0"]; got.Packets != 2 {
+		t.Errorf("eth0 after interval = %+v", got)
+	}
+}
+
+func TestBreakdownLimit(t *testing.T) {
+	b := newBreakdown(2)
+	for _, key := range []string{"a", "b", "c", "d", "a"} {
+		b.add(key, 10)
+	}
+	counts := b.snapshot()
+	if len(counts) != 3 || counts["a"].Packets != 2 || counts[OtherKey].Packets != 2 {
+		t.Errorf("counts = %+v", counts)
+	}
+}
@@ -0,0 +1,134 @@
+package stats
+
+import (
+	"sort"
+	"time"
+)
+
+// RateWindows are the sliding windows, in seconds, of the rates in a
+// snapshot.
+var RateWindows = []int{1, 10, 60}
+
+// windowBuckets is the number of one-second buckets kept: the longest
+// rate window, and the second it starts in.
+const windowBuckets = 61
+
+// bucket counts the packets of one second.
+type bucket struct {
+	second  int64
+	packets uint64
+	bytes   uint64
+}
+
+// window keeps per-second packet counts over the last windowBuckets seconds.
+type window struct {
+	buckets [windowBuckets]bucket
+}
+
+// add counts a packet at now.
+func (w *window) add(now time.Time, size int) {
+	sec := now.Unix()
+	b := &w.buckets[sec%windowBuckets]
+	if b.second != sec {
+		*b = bucket{second: sec}
+	}
+	b.packets++
+	b.bytes += uint64(size)
+}
+
+// rate returns the packet and byte rates over the trailing seconds seconds
+// up to now. The current second is only partly over, so the second the
+// window starts in is counted in proportion to the part of it inside the
+// window. The window is cut short by start, so rates right after startup
+// aren't diluted by time before the capture.
+func (w *window) rate(now time.Time, seconds int, start time.Time) (packetsPerSec, bytesPerSec float64) {
+	from := now.Add(-time.Duration(seconds) * time.Second)
+	clipped := start.After(from)
+	if clipped {
+		from = start
+	}
+	span := now.Sub(from).Seconds()
+	if span <= 0 {
+		return 0, 0
+	}
+
+	cur, first := now.Unix(), from.Unix()
+	var packets, bytes float64
+	for _, b := range w.buckets {
+		if b.second < first || b.second > cur || b.packets == 0 {
+			continue
+		}
+		weight := 1.0
+		if b.second == first && b.second != cur && !clipped {
+			// Packets are taken to be spread evenly over the second
+			weight = 1 - float64(from.Nanosecond())/1e9
+		}
+		packets += weight * float64(b.packets)
+		bytes += weight * float64(b.bytes)
+	}
+	return packets / span, bytes / span
+}
+
+// counter counts the packets and bytes of one breakdown key.
+type counter struct {
+	Packets uint64 `json:"packets"`
+	Bytes   uint64 `json:"bytes"`
+}
+
+// breakdown counts traffic by key. Past maxKeys keys, new keys are counted
+// under OtherKey.
+type breakdown struct {
+	maxKeys int
+	counts  map[string]*counter
+}
+
+// OtherKey collects traffic of keys beyond a breakdown's cardinality limit.
+const OtherKey = "other"
+
+func newBreakdown(maxKeys int) *breakdown {
+	return &breakdown{maxKeys: maxKeys, counts: make(map[string]*counter)}
+}
+
+func (b *breakdown) add(key string, size int) {
+	c := b.counts[key]
+	if c == nil {
+		if b.maxKeys > 0 && len(b.counts) >= b.maxKeys {
+			key = OtherKey
+			c = b.counts[key]
+		}
+		if c == nil {
+			c = &counter{}
+			b.counts[key] = c
+		}
+	}
+	c.Packets++
+	c.Bytes += uint64(size)
+}
+
+// snapshot copies the counts for JSON output.
+func (b *breakdown) snapshot() map[string]counter {
+	out := make(map[string]counter, len(b.counts))
+	for k, c := range b.counts {
+		out[k] = *c
+	}
+	return out
+}
+
+// top returns the n keys with the most bytes, ties broken by key.
+func (b *breakdown) top(n int) []string {
+	keys := make([]string, 0, len(b.counts))
+	for k := range b.counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		ci, cj := b.counts[keys[i]], b.counts[keys[j]]
+		if ci.Bytes != cj.Bytes {
+			return ci.Bytes > cj.Bytes
+		}
+		return keys[i] < keys[j]
+	})
+	if len(keys) > n {
+		keys = keys[:n]
+	}
+	return keys
+}
@@ -0,0 +1,127 @@
+package stream
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Handler serves the feed over HTTP. The transport depends on the request:
+//
+//   - a WebSocket upgrade gets one record per text message, and every
+//     message the client sends is a Request replacing the current one
+//   - Accept: text/event-stream gets Server-Sent Events, one record per
+//     event
+//   - anything else gets NDJSON in a streamed response
+//
+// The query parameters types (comma-separated) and filter are the initial
+// Request, e.g. /stream?types=connection&filter=port+443.
+func (h *Hub) Handler() http.Handler {
+	return http.HandlerFunc(h.serveHTTP)
+}
+
+func (h *Hub) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	req := Request{
+		Types:  ParseTypes(r.URL.Query().Get("types")),
+		Filter: r.URL.Query().Get("filter"),
+	}
+	if _, err := req.compile(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	transport := "ndjson"
+	switch {
+	case isWebSocket(r):
+		transport = "websocket"
+	case strings.Contains(r.Header.Get("Accept"), "text/event-stream"):
+		transport = "sse"
+	}
+	s, err := h.add(transport, r.RemoteAddr)
+	if err != nil {
+		status := http.StatusServiceUnavailable
+		if errors.Is(err, errFull) {
+			status = http.StatusTooManyRequests
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	defer h.remove(s)
+
+	if transport == "websocket" {
+		h.serveWebSocket(w, r, s, req)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	if transport == "sse" {
+		w.Header().Set("Content-Type", "text/event-stream")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	s.subscribe(req)
+
+	s.run(func(lines [][]byte) error {
+		rc.SetWriteDeadline(time.Now().Add(writeTimeout))
+		for _, line := range lines {
+			var err error
+			if transport == "sse" {
+				_, err = w.Write([]byte("data: "))
+				if err == nil {
+					_, err = w.Write(line[:len(line)-1])
+				}
+				if err == nil {
+					_, err = w.Write([]byte("\n\n"))
+				}
+			} else {
+				_, err = w.Write(line)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return rc.Flush()
+	}, r.Context().Done())
+}
+
+func (h *Hub) serveWebSocket(w http.ResponseWriter, r *http.Request, s *subscriber, req Request) {
+	ws, err := upgrade(w, r)
+	if err != nil {
+		return
+	}
+	s.subscribe(req)
+
+	stop := make(chan struct{})
+	go func() {
+		defer close(stop)
+		for {
+			msg, err := ws.readMessage()
+			if err != nil {
+				return
+			}
+			var req Request
+			if err := json.Unmarshal(msg, &req); err != nil {
+				s.control(map[string]string{"type": TypeError, "error": "request: " + err.Error()})
+				continue
+			}
+			s.subscribe(req)
+		}
+	}()
+
+	err = s.run(ws.writeText, stop)
+	select {
+	case <-stop:
+		// The client closed the connection, or it broke
+		ws.c.Close()
+	default:
+		if err == nil {
+			ws.close(closeGoingAway)
+		} else {
+			ws.c.Close()
+		}
+	}
+}
@@ -0,0 +1,407 @@
+// Package stream serves the live record feed to several subscribers at
+// once, as NDJSON over a Unix socket or HTTP (plain, Server-Sent Events or
+// WebSocket). Every subscriber picks its own record types and filter
+// expression. A subscriber that can't keep up loses records rather than
+// slowing the capture down: they are dropped, counted, and reported to it
+// in the feed.
+package stream
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hwang-fu/portlens/internal/filter"
+	"github.com/hwang-fu/portlens/internal/output"
+)
+
+const (
+	// queueSize is how many records wait for a subscriber; past it, new
+	// ones are dropped.
+	queueSize = 4096
+
+	// maxBatch is the most records written to a subscriber at once.
+	maxBatch = 256
+
+	// writeTimeout is how long a subscriber may take to accept a batch
+	// before it is disconnected.
+	writeTimeout = 10 * time.Second
+
+	// closeTimeout bounds how long Close waits for subscribers to take
+	// what is queued for them.
+	closeTimeout = 2 * time.Second
+
+	// MaxSubscribers bounds the subscribers of a Hub.
+	MaxSubscribers = 64
+)
+
+// Messages of the feed that aren't records, as the value of "type".
+const (
+	TypeSubscribed = "subscribed" // A subscription took effect
+	TypeError      = "error"      // A subscription was rejected
+	TypeDropped    = "dropped"    // Records were dropped since the last one sent
+)
+
+// Types are the record types a subscriber can ask for.
+var Types = []string{output.TypePacket, output.TypeConnection, output.TypeNeighbor}
+
+// Request is what a subscriber asks for: record types and a filter
+// expression. Empty fields select everything.
+type Request struct {
+	Types  []string `json:"types,omitempty"`
+	Filter string   `json:"filter,omitempty"`
+}
+
+// subscription is a validated Request.
+type subscription struct {
+	req    Request
+	types  map[string]bool // nil = all
+	filter *filter.Filter  // nil = all
+}
+
+// compile validates a request.
+func (r Request) compile() (*subscription, error) {
+	s := &subscription{req: r}
+	for _, t := range r.Types {
+		if !slices.Contains(Types, t) {
+			return nil, fmt.Errorf("unknown record type %q, want one of %s", t, strings.Join(Types, ", "))
+		}
+		if s.types == nil {
+			s.types = make(map[string]bool)
+		}
+		s.types[t] = true
+	}
+	if r.Filter != "" {
+		f, err := filter.Compile(r.Filter)
+		if err != nil {
+			return nil, fmt.Errorf("filter: %w", err)
+		}
+		s.filter = f
+	}
+	return s, nil
+}
+
+// ParseTypes splits a comma-separated list of record types.
+func ParseTypes(s string) []string {
+	var types []string
+	for t := range strings.SplitSeq(s, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			types = append(types, t)
+		}
+	}
+	return types
+}
+
+// Hub hands published records to its subscribers.
+type Hub struct {
+	mu     sync.RWMutex
+	subs   []*subscriber
+	nextID uint64
+	closed bool
+
+	servers sync.WaitGroup // Subscribers being served
+	closers []func()       // Stop the listeners
+
+	dropped atomic.Uint64 // Over all subscribers, past and present
+}
+
+// NewHub creates a Hub without subscribers.
+func NewHub() *Hub {
+	return &Hub{}
+}
+
+// subscriber is one consumer of the feed.
+type subscriber struct {
+	id        uint64
+	transport string
+	remote    string
+	since     time.Time
+
+	sub     atomic.Pointer[subscription] // nil until the first request
+	queue   chan []byte
+	sent    atomic.Uint64
+	dropped atomic.Uint64
+	unsent  atomic.Uint64 // Dropped since the last notice
+	hub     *Hub
+}
+
+// SubscriberInfo describes a subscriber.
+type SubscriberInfo struct {
+	ID        uint64    `json:"id"`
+	Transport string    `json:"transport"` // "unix", "ndjson", "sse", or "websocket"
+	Remote    string    `json:"remote,omitempty"`
+	Since     time.Time `json:"since"`
+	Types     []string  `json:"types,omitempty"`
+	Filter    string    `json:"filter,omitempty"`
+	Sent      uint64    `json:"sent"`
+	Dropped   uint64    `json:"dropped"`
+}
+
+// Subscribers describes the current subscribers.
+func (h *Hub) Subscribers() []SubscriberInfo {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	infos := make([]SubscriberInfo, 0, len(h.subs))
+	for _, s := range h.subs {
+		info := SubscriberInfo{
+			ID:        s.id,
+			Transport: s.transport,
+			Remote:    s.remote,
+			Since:     s.since,
+			Sent:      s.sent.Load(),
+			Dropped:   s.dropped.Load(),
+		}
+		if sub := s.sub.Load(); sub != nil {
+			info.Types, info.Filter = sub.req.Types, sub.req.Filter
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// Dropped returns how many records were dropped for subscribers that
+// couldn't keep up.
+func (h *Hub) Dropped() uint64 {
+	return h.dropped.Load()
+}
+
+var errFull = errors.New("too many subscribers")
+
+// add registers a new subscriber.
+func (h *Hub) add(transport, remote string) (*subscriber, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return nil, errors.New("shutting down")
+	}
+	if len(h.subs) >= MaxSubscribers {
+		return nil, errFull
+	}
+	h.nextID++
+	s := &subscriber{
+		id:        h.nextID,
+		transport: transport,
+		remote:    remote,
+		since:     time.Now(),
+		queue:     make(chan []byte, queueSize),
+		hub:       h,
+	}
+	h.subs = append(h.subs, s)
+	h.servers.Add(1)
+	return s, nil
+}
+
+// remove unregisters a subscriber once it is done.
+func (h *Hub) remove(s *subscriber) {
+	h.mu.Lock()
+	if i := slices.Index(h.subs, s); i >= 0 {
+		h.subs = slices.Delete(h.subs, i, i+1)
+	}
+	h.mu.Unlock()
+	h.servers.Done()
+}
+
+// Publish hands a record to every subscriber that wants it. It never
+// blocks: a subscriber whose queue is full loses the record.
+func (h *Hub) Publish(v any) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if len(h.subs) == 0 {
+		return
+	}
+
+	rec := record{v: v}
+	for _, s := range h.subs {
+		sub := s.sub.Load()
+		if sub == nil || sub.types != nil && !sub.types[rec.recordType()] {
+			continue
+		}
+		if sub.filter != nil && !sub.filter.Match(rec.env()) {
+			continue
+		}
+		line := rec.line()
+		if line == nil {
+			return
+		}
+		s.send(line)
+	}
+}
+
+// send queues a line, dropping it if the queue is full.
+func (s *subscriber) send(line []byte) {
+	select {
+	case s.queue <- line:
+	default:
+		s.dropped.Add(1)
+		s.unsent.Add(1)
+		s.hub.dropped.Add(1)
+	}
+}
+
+// control queues a message about the feed itself.
+func (s *subscriber) control(msg any) {
+	line, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	// The queue is closed once the hub is
+	s.hub.mu.RLock()
+	defer s.hub.mu.RUnlock()
+	if !s.hub.closed {
+		s.send(append(line, '\n'))
+	}
+}
+
+// subscribe applies a request, or tells the subscriber why it can't.
+func (s *subscriber) subscribe(req Request) error {
+	sub, err := req.compile()
+	if err != nil {
+		s.control(map[string]string{"type": TypeError, "error": err.Error()})
+		return err
+	}
+	// The notice goes ahead of the first record it selects
+	s.control(struct {
+		Type string `json:"type"`
+		ID   uint64 `json:"id"`
+		Request
+	}{TypeSubscribed, s.id, req})
+	s.sub.Store(sub)
+	return nil
+}
+
+// batchWriter writes a batch of NDJSON lines to a subscriber in its
+// transport's framing.
+type batchWriter func(lines [][]byte) error
+
+// run writes queued lines until the hub closes, stop is closed or a write
+// fails. stop may be nil.
+func (s *subscriber) run(write batchWriter, stop <-chan struct{}) error {
+	batch := make([][]byte, 0, maxBatch)
+	for {
+		var line []byte
+		select {
+		case l, ok := <-s.queue:
+			if !ok {
+				return nil
+			}
+			line = l
+		case <-stop:
+			return nil
+		}
+		batch = append(batch[:0], line)
+	fill:
+		for len(batch) < maxBatch {
+			select {
+			case line, ok := <-s.queue:
+				if !ok {
+					break fill
+				}
+				batch = append(batch, line)
+			default:
+				break fill
+			}
+		}
+		if n := s.unsent.Swap(0); n > 0 {
+			notice, _ := json.Marshal(map[string]any{"type": TypeDropped, "dropped": n, "total": s.dropped.Load()})
+			batch = append(batch, append(notice, '\n'))
+		}
+		if err := write(batch); err != nil {
+			return err
+		}
+		s.sent.Add(uint64(len(batch)))
+	}
+}
+
+// Close stops the listeners, sends every subscriber what is queued for it
+// and disconnects them. Subscribers still busy after closeTimeout are left
+// behind.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		return
+	}
+	h.closed = true
+	for _, stop := range h.closers {
+		stop()
+	}
+	for _, s := range h.subs {
+		close(s.queue)
+	}
+	h.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		h.servers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(closeTimeout):
+	}
+}
+
+// record is a record being published, encoded at most once however many
+// subscribers want it.
+type record struct {
+	v       any
+	encoded []byte
+	failed  bool
+	decoded filter.Env
+}
+
+// recordType returns the record's "type".
+func (r *record) recordType() string {
+	switch r.v.(type) {
+	case output.PacketRecord, *output.PacketRecord, output.ARPRecord, *output.ARPRecord:
+		return output.TypePacket
+	case output.ConnectionRecord, *output.ConnectionRecord:
+		return output.TypeConnection
+	case output.NeighborRecord, *output.NeighborRecord:
+		return output.TypeNeighbor
+	}
+	t, _ := r.env().Lookup("type")
+	s, _ := t.(string)
+	return s
+}
+
+// env returns the record's fields for filters.
+func (r *record) env() filter.Env {
+	switch v := r.v.(type) {
+	case output.PacketRecord:
+		return &v
+	case *output.PacketRecord:
+		return v
+	case output.ARPRecord:
+		return &v
+	case *output.ARPRecord:
+		return v
+	}
+	if r.decoded == nil {
+		var m filter.Map
+		if line := r.line(); line != nil {
+			json.Unmarshal(line, &m)
+		}
+		r.decoded = m
+	}
+	return r.decoded
+}
+
+// line returns the record as an NDJSON line, or nil if it can't be
+// encoded.
+func (r *record) line() []byte {
+	if r.encoded == nil && !r.failed {
+		data, err := json.Marshal(r.v)
+		if err != nil {
+			r.failed = true
+			return nil
+		}
+		r.encoded = append(data, '\n')
+	}
+	return r.encoded
+}
@@ -0,0 +1,339 @@
+package stream
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hwang-fu/portlens/internal/output"
+)
+
+func packet(port uint16) output.PacketRecord {
+	return output.PacketRecord{
+		Type:     output.TypePacket,
+		Protocol: "TCP",
+		SrcIP:    "10.0.0.1",
+		SrcPort:  50000,
+		DstIP:    "10.0.0.2",
+		DstPort:  port,
+	}
+}
+
+func connection(port uint16) *output.ConnectionRecord {
+	return &output.ConnectionRecord{
+		Type:       output.TypeConnection,
+		EventType:  "opened",
+		Connection: output.ConnectionInfo{SrcIP: "10.0.0.1", DstIP: "10.0.0.2", DstPort: port, Protocol: "TCP"},
+	}
+}
+
+// readJSON reads the next NDJSON line.
+func readJSON(t *testing.T, r *bufio.Reader) map[string]any {
+	t.Helper()
+	line, err := r.ReadBytes('\n')
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	var m map[string]any
+	if err := json.Unmarshal(line, &m); err != nil {
+		t.Fatalf("decode %q: %v", line, err)
+	}
+	return m
+}
+
+// waitSubscribers waits until the hub has n subscribed subscribers.
+func waitSubscribers(t *testing.T, h *Hub, n int) {
+	t.Helper()
+	for range 200 {
+		subscribed := 0
+		h.mu.RLock()
+		for _, s := range h.subs {
+			if s.sub.Load() != nil {
+				subscribed++
+			}
+		}
+		h.mu.RUnlock()
+		if subscribed == n {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("want %d subscribers", n)
+}
+
+func TestUnixSocket(t *testing.T) {
+	h := NewHub()
+	path := filepath.Join(t.TempDir(), "stream.sock")
+	if err := h.ServeUnix(path); err != nil {
+		t.Fatal(err)
+	}
+
+	dial := func(req string) (net.Conn, *bufio.Reader) {
+		c, err := net.Dial("unix", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { c.Close() })
+		io.WriteString(c, req+"\n")
+		r := bufio.NewReader(c)
+		if m := readJSON(t, r); m["type"] != TypeSubscribed {
+			t.Fatalf("got %v, want a subscribed notice", m)
+		}
+		return c, r
+	}
+	_, conns := dial(`{"types":["connection"]}`)
+	https, httpsR := dial(`{"filter":"tcp and port 443"}`)
+	waitSubscribers(t, h, 2)
+
+	h.Publish(packet(80))
+	h.Publish(packet(443))
+	h.Publish(connection(443))
+
+	if m := readJSON(t, conns); m["type"] != "connection" {
+		t.Errorf("connections subscriber got %v", m)
+	}
+	// "port" matches packets on dst_port; connection records nest theirs
+	if m := readJSON(t, httpsR); m["type"] != "packet" || m["dst_port"] != 443.0 {
+		t.Errorf("port 443 subscriber got %v", m)
+	}
+
+	// A new request replaces the old one; a bad one is answered and ignored
+	io.WriteString(https, `{"filter":"tcp and"}`+"\n")
+	if m := readJSON(t, httpsR); m["type"] != TypeError || !strings.Contains(m["error"].(string), "filter") {
+		t.Errorf("got %v, want an error", m)
+	}
+	io.WriteString(https, `{"types":["neighbor"]}`+"\n")
+	if m := readJSON(t, httpsR); m["type"] != TypeSubscribed {
+		t.Errorf("got %v, want a subscribed notice", m)
+	}
+	h.Publish(packet(443))
+	h.Publish(&output.NeighborRecord{Type: output.TypeNeighbor, EventType: "new"})
+	if m := readJSON(t, httpsR); m["type"] != "neighbor" {
+		t.Errorf("got %v, want the neighbor record", m)
+	}
+
+	infos := h.Subscribers()
+	if len(infos) != 2 || infos[0].Transport != "unix" || infos[0].Types[0] != "connection" {
+		t.Errorf("subscribers = %+v", infos)
+	}
+
+	h.Close()
+	if _, err := conns.ReadByte(); err != io.EOF {
+		t.Errorf("after Close: %v, want EOF", err)
+	}
+	if _, err := net.Dial("unix", path); err == nil {
+		t.Error("socket still accepts after Close")
+	}
+}
+
+func TestDropAndCount(t *testing.T) {
+	h := NewHub()
+	s, err := h.add("test", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.sub.Store(&subscription{})
+
+	// Nobody reads: the queue fills and the rest is dropped
+	for i := range queueSize + 10 {
+		h.Publish(packet(uint16(i)))
+	}
+	if h.Dropped() != 10 || s.dropped.Load() != 10 {
+		t.Fatalf("dropped %d, want 10", h.Dropped())
+	}
+
+	var got [][]byte
+	stop := make(chan struct{})
+	s.run(func(lines [][]byte) error {
+		got = append(got, lines...)
+		if len(got) > queueSize {
+			close(stop)
+		}
+		return nil
+	}, stop)
+
+	// The notice follows the records that were queued when it was noticed
+	var notice map[string]any
+	json.Unmarshal(got[maxBatch], &notice)
+	if notice["type"] != TypeDropped || notice["dropped"] != 10.0 || notice["total"] != 10.0 {
+		t.Errorf("notice = %v", notice)
+	}
+	h.remove(s)
+}
+
+func TestHTTP(t *testing.T) {
+	h := NewHub()
+	srv := httptest.NewServer(h.Handler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/stream?filter=port+443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "application/x-ndjson" {
+		t.Errorf("Content-Type = %q", resp.Header.Get("Content-Type"))
+	}
+	ndjson := bufio.NewReader(resp.Body)
+	readJSON(t, ndjson)
+
+	req, _ := http.NewRequest("GET", srv.URL+"/stream?types=packet", nil)
+	req.Header.Set("Accept", "text/event-stream")
+	resp2, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp2.Body.Close()
+	sse := bufio.NewReader(resp2.Body)
+	readEvent := func() string {
+		line, _ := sse.ReadString('\n')
+		blank, _ := sse.ReadString('\n')
+		if blank != "\n" {
+			t.Errorf("event not ended by a blank line: %q", line+blank)
+		}
+		return line
+	}
+	if ev := readEvent(); !strings.HasPrefix(ev, `data: {"type":"subscribed"`) {
+		t.Errorf("first event = %q", ev)
+	}
+
+	waitSubscribers(t, h, 2)
+	h.Publish(connection(443))
+	h.Publish(packet(443))
+	if m := readJSON(t, ndjson); m["type"] != "packet" {
+		t.Errorf("ndjson got %v", m)
+	}
+	if ev := readEvent(); !strings.HasPrefix(ev, `data: {"type":"packet"`) {
+		t.Errorf("sse got %q", ev)
+	}
+
+	bad, err := http.Get(srv.URL + "/stream?types=bogus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad.Body.Close()
+	if bad.StatusCode != http.StatusBadRequest {
+		t.Errorf("unknown type: status %d", bad.StatusCode)
+	}
+	h.Close()
+}
+
+// wsClient is a bare WebSocket client for tests.
+type wsClient struct {
+	c net.Conn
+	r *bufio.Reader
+}
+
+func dialWebSocket(t *testing.T, url string) *wsClient {
+	t.Helper()
+	c, err := net.Dial("tcp", strings.TrimPrefix(url, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	key := make([]byte, 16)
+	rand.Read(key)
+	encoded := base64.StdEncoding.EncodeToString(key)
+	io.WriteString(c, "GET /stream?types=packet HTTP/1.1\r\nHost: test\r\nUpgrade: websocket\r\n"+
+		"Connection: keep-alive, Upgrade\r\nSec-WebSocket-Version: 13\r\nSec-WebSocket-Key: "+encoded+"\r\n\r\n")
+
+	r := bufio.NewReader(c)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != acceptKey(encoded) {
+		t.Fatalf("handshake: %s %v", resp.Status, resp.Header)
+	}
+	return &wsClient{c: c, r: r}
+}
+
+func (ws *wsClient) send(op byte, payload []byte) {
+	mask := [4]byte{1, 2, 3, 4}
+	frame := []byte{0x80 | op, 0x80 | byte(len(payload))}
+	frame = append(frame, mask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	ws.c.Write(frame)
+}
+
+func (ws *wsClient) read(t *testing.T) (byte, []byte) {
+	t.Helper()
+	var hdr [2]byte
+	if _, err := io.ReadFull(ws.r, hdr[:]); err != nil {
+		t.Fatalf("read frame: %v", err)
+	}
+	length := int(hdr[1] & 0x7F)
+	if length == 126 {
+		var ext [2]byte
+		io.ReadFull(ws.r, ext[:])
+		length = int(binary.BigEndian.Uint16(ext[:]))
+	}
+	payload := make([]byte, length)
+	io.ReadFull(ws.r, payload)
+	return hdr[0] & 0x0F, payload
+}
+
+func TestWebSocket(t *testing.T) {
+	// The example of RFC 6455
+	if got := acceptKey("dGhlIHNhbXBsZSBub25jZQ=="); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("acceptKey = %q", got)
+	}
+
+	h := NewHub()
+	srv := httptest.NewServer(h.Handler())
+	defer srv.Close()
+
+	ws := dialWebSocket(t, srv.URL)
+	if op, msg := ws.read(t); op != opText || !strings.Contains(string(msg), `"types":["packet"]`) {
+		t.Fatalf("got %d %s, want the subscribed notice", op, msg)
+	}
+	waitSubscribers(t, h, 1)
+	h.Publish(connection(22))
+	h.Publish(packet(22))
+	if _, msg := ws.read(t); !strings.HasPrefix(string(msg), `{"type":"packet"`) || strings.HasSuffix(string(msg), "\n") {
+		t.Errorf("got %q, want the packet record without newline", msg)
+	}
+
+	ws.send(opPing, []byte("hi"))
+	if op, msg := ws.read(t); op != opPong || string(msg) != "hi" {
+		t.Errorf("got %d %q, want a pong", op, msg)
+	}
+
+	ws.send(opText, []byte(`{"types":["connection"],"filter":"connection.dst_port == 22"}`))
+	if _, msg := ws.read(t); !strings.Contains(string(msg), TypeSubscribed) {
+		t.Fatalf("got %s, want the subscribed notice", msg)
+	}
+	h.Publish(packet(22))
+	h.Publish(connection(80))
+	h.Publish(connection(22))
+	if _, msg := ws.read(t); !strings.Contains(string(msg), `"dst_port":22`) {
+		t.Errorf("got %s, want the port 22 connection", msg)
+	}
+
+	ws.send(opClose, binary.BigEndian.AppendUint16(nil, closeNormal))
+	if op, _ := ws.read(t); op != opClose {
+		t.Errorf("got opcode %d, want close", op)
+	}
+	for range 200 {
+		if len(h.Subscribers()) == 0 {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := len(h.Subscribers()); n != 0 {
+		t.Errorf("%d subscribers left after close", n)
+	}
+	h.Close()
+}
@@ -0,0 +1,87 @@
+package stream
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+// maxRequest bounds a subscription request line or message.
+const maxRequest = 64 << 10
+
+// ServeUnix listens on a Unix socket at path and serves subscribers in
+// the background until the hub is closed. A stale socket left at path is
+// replaced.
+//
+// A subscriber sends a Request as a JSON line, e.g.
+// {"types":["connection"],"filter":"port 443"}, and receives records from
+// then on. Every further line replaces the request. The feed keeps going
+// after the subscriber shuts down its side of the connection.
+func (h *Hub) ServeUnix(path string) error {
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("stream socket: %w", err)
+	}
+	// Readable by the owner's group, e.g. a dashboard's service user
+	if err := os.Chmod(path, 0o660); err != nil {
+		ln.Close()
+		return fmt.Errorf("stream socket: %w", err)
+	}
+
+	h.mu.Lock()
+	h.closers = append(h.closers, func() { ln.Close() })
+	h.mu.Unlock()
+
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				if !errors.Is(err, net.ErrClosed) {
+					time.Sleep(100 * time.Millisecond)
+					continue
+				}
+				return
+			}
+			go h.serveUnixConn(c)
+		}
+	}()
+	return nil
+}
+
+func (h *Hub) serveUnixConn(c net.Conn) {
+	defer c.Close()
+	s, err := h.add("unix", "")
+	if err != nil {
+		msg, _ := json.Marshal(map[string]string{"type": TypeError, "error": err.Error()})
+		c.Write(append(msg, '\n'))
+		return
+	}
+	defer h.remove(s)
+
+	go func() {
+		sc := bufio.NewScanner(c)
+		sc.Buffer(make([]byte, 4096), maxRequest)
+		for sc.Scan() {
+			var req Request
+			if err := json.Unmarshal(sc.Bytes(), &req); err != nil {
+				s.control(map[string]string{"type": TypeError, "error": "request: " + err.Error()})
+				continue
+			}
+			s.subscribe(req)
+		}
+	}()
+
+	s.run(func(lines [][]byte) error {
+		c.SetWriteDeadline(time.Now().Add(writeTimeout))
+		bufs := net.Buffers(lines)
+		_, err := bufs.WriteTo(c)
+		return err
+	}, nil)
+}
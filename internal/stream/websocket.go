@@ -0,0 +1,232 @@
+package stream
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The server side of the WebSocket protocol (RFC 6455), as much of it as
+// the feed needs: text messages out, text and control messages in.
+
+// wsGUID is mixed into the handshake key to prove the server speaks
+// WebSocket.
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Frame opcodes.
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+// Close status codes.
+const (
+	closeNormal    = 1000
+	closeGoingAway = 1001
+	closeTooBig    = 1009
+)
+
+// isWebSocket reports whether a request asks to upgrade to WebSocket.
+func isWebSocket(r *http.Request) bool {
+	return headerContains(r.Header, "Connection", "upgrade") &&
+		strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+}
+
+// headerContains reports whether a comma-separated header lists token.
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for t := range strings.SplitSeq(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// acceptKey answers a handshake's Sec-WebSocket-Key.
+func acceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// wsConn is the server side of a WebSocket connection.
+type wsConn struct {
+	c  net.Conn
+	br *bufio.Reader
+
+	wmu sync.Mutex // Serializes frames of the feed and of replies
+}
+
+// upgrade completes the handshake of a WebSocket request and takes over
+// its connection. On failure it has answered the request.
+func upgrade(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "WebSocket handshake must be a GET", http.StatusMethodNotAllowed)
+		return nil, errors.New("websocket: not a GET")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported WebSocket version", http.StatusUpgradeRequired)
+		return nil, errors.New("websocket: unsupported version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "missing Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, errors.New("websocket: missing key")
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "WebSocket not supported", http.StatusInternalServerError)
+		return nil, errors.New("websocket: connection can't be hijacked")
+	}
+	c, brw, err := hj.Hijack()
+	if err != nil {
+		return nil, fmt.Errorf("websocket: %w", err)
+	}
+
+	c.SetWriteDeadline(time.Now().Add(writeTimeout))
+	_, err = fmt.Fprintf(c, "HTTP/1.1 101 Switching Protocols\r\n"+
+		"Upgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", acceptKey(key))
+	if err != nil {
+		c.Close()
+		return nil, fmt.Errorf("websocket: %w", err)
+	}
+	return &wsConn{c: c, br: brw.Reader}, nil
+}
+
+// frameHeader returns the header of an unmasked frame, as servers send.
+func frameHeader(op byte, length int) []byte {
+	hdr := []byte{0x80 | op} // FIN
+	switch {
+	case length < 126:
+		hdr = append(hdr, byte(length))
+	case length <= 0xFFFF:
+		hdr = append(hdr, 126)
+		hdr = binary.BigEndian.AppendUint16(hdr, uint16(length))
+	default:
+		hdr = append(hdr, 127)
+		hdr = binary.BigEndian.AppendUint64(hdr, uint64(length))
+	}
+	return hdr
+}
+
+// writeText sends every line as a text message, without its newline.
+func (ws *wsConn) writeText(lines [][]byte) error {
+	bufs := make(net.Buffers, 0, 2*len(lines))
+	for _, line := range lines {
+		line = line[:len(line)-1]
+		bufs = append(bufs, frameHeader(opText, len(line)), line)
+	}
+	ws.wmu.Lock()
+	defer ws.wmu.Unlock()
+	ws.c.SetWriteDeadline(time.Now().Add(writeTimeout))
+	_, err := bufs.WriteTo(ws.c)
+	return err
+}
+
+// writeControl sends a control frame.
+func (ws *wsConn) writeControl(op byte, payload []byte) error {
+	ws.wmu.Lock()
+	defer ws.wmu.Unlock()
+	ws.c.SetWriteDeadline(time.Now().Add(writeTimeout))
+	_, err := ws.c.Write(append(frameHeader(op, len(payload)), payload...))
+	return err
+}
+
+// close sends a close frame with a status code and closes the connection.
+func (ws *wsConn) close(code uint16) {
+	ws.writeControl(opClose, binary.BigEndian.AppendUint16(nil, code))
+	ws.c.Close()
+}
+
+var errClosed = errors.New("websocket: closed by peer")
+
+// readMessage returns the next text or binary message, answering pings on
+// the way. It returns errClosed once the peer closes the connection.
+func (ws *wsConn) readMessage() ([]byte, error) {
+	var msg []byte
+	for {
+		fin, op, payload, err := ws.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case opPing:
+			ws.writeControl(opPong, payload)
+		case opPong:
+		case opClose:
+			ws.writeControl(opClose, binary.BigEndian.AppendUint16(nil, closeNormal))
+			return nil, errClosed
+		case opText, opBinary, opContinuation:
+			msg = append(msg, payload...)
+			if len(msg) > maxRequest {
+				ws.close(closeTooBig)
+				return nil, errors.New("websocket: message too big")
+			}
+			if fin {
+				return msg, nil
+			}
+		default:
+			return nil, fmt.Errorf("websocket: unknown opcode %d", op)
+		}
+	}
+}
+
+// readFrame reads one frame and unmasks its payload.
+func (ws *wsConn) readFrame() (fin bool, op byte, payload []byte, err error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(ws.br, hdr[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin, op = hdr[0]&0x80 != 0, hdr[0]&0x0F
+	if hdr[1]&0x80 == 0 {
+		return false, 0, nil, errors.New("websocket: unmasked client frame")
+	}
+
+	length := uint64(hdr[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(ws.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(ws.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > maxRequest {
+		ws.close(closeTooBig)
+		return false, 0, nil, errors.New("websocket: frame too big")
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(ws.br, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(ws.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, op, payload, nil
+}
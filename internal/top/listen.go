@@ -0,0 +1,56 @@
+package top
+
+import (
+	"sort"
+
+	"github.com/hwang-fu/portlens/internal/procfs"
+)
+
+// Listener is a socket waiting for connections (TCP) or datagrams (UDP).
+type Listener struct {
+	Protocol string
+	Addr     string // host:port
+	Port     uint16
+	PID      int
+	Process  string
+}
+
+// Listeners returns the listening sockets of the host with their owning
+// processes, ordered by protocol and port.
+func Listeners() ([]Listener, error) {
+	owners, err := procfs.SocketOwners()
+	if err != nil {
+		return nil, err
+	}
+
+	var listeners []Listener
+	for _, proto := range []string{"TCP", "UDP"} {
+		entries, err := procfs.ListeningSockets(proto)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			l := Listener{
+				Protocol: proto,
+				Addr:     hostPort(e.LocalIP.String(), e.LocalPort),
+				Port:     e.LocalPort,
+			}
+			if p := owners[e.Inode]; p != nil {
+				l.PID, l.Process = p.PID, p.Name
+			}
+			listeners = append(listeners, l)
+		}
+	}
+
+	sort.Slice(listeners, func(i, j int) bool {
+		a, b := listeners[i], listeners[j]
+		if a.Protocol != b.Protocol {
+			return a.Protocol < b.Protocol
+		}
+		if a.Port != b.Port {
+			return a.Port < b.Port
+		}
+		return a.Addr < b.Addr
+	})
+	return listeners, nil
+}
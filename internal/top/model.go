@@ -0,0 +1,340 @@
+// Package top implements "portlens top", a live full-screen view of the
+// connections and processes using the network. A Model aggregates the
+// records the capture pipeline produces; a UI renders it in the terminal.
+package top
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hwang-fu/portlens/internal/output"
+	"github.com/hwang-fu/portlens/internal/tracker"
+)
+
+const (
+	rateWindow    = 3                // Seconds rates are averaged over
+	recentPackets = 50               // Packets kept per connection for drill-down
+	closedLinger  = 5 * time.Second  // How long closed connections stay listed
+	idleTimeout   = 60 * time.Second // Connections without traffic for this long are dropped
+)
+
+// Sort orders for connections and processes.
+const (
+	SortRate = iota
+	SortBytes
+	SortDuration
+	sortCount
+)
+
+// sortNames are the labels of the sort orders, indexed by SortRate etc.
+var sortNames = [sortCount]string{"rate", "bytes", "duration"}
+
+// bucket counts the bytes of one second.
+type bucket struct {
+	sec   int64
+	bytes uint64
+}
+
+// rateCounter averages bytes per second over the last rateWindow full
+// seconds.
+type rateCounter struct {
+	buckets [rateWindow + 1]bucket
+}
+
+func (r *rateCounter) add(now time.Time, n int) {
+	sec := now.Unix()
+	b := &r.buckets[sec%int64(len(r.buckets))]
+	if b.sec != sec {
+		*b = bucket{sec: sec}
+	}
+	b.bytes += uint64(n)
+}
+
+func (r *rateCounter) rate(now time.Time) float64 {
+	sec := now.Unix()
+	var total uint64
+	for _, b := range r.buckets {
+		if b.sec >= sec-rateWindow && b.sec < sec {
+			total += b.bytes
+		}
+	}
+	return float64(total) / rateWindow
+}
+
+// Conn is a connection as shown by the UI. Local and Remote are oriented
+// by packet direction; Sent and Recv are from the local side.
+type Conn struct {
+	Key        tracker.ConnKey
+	Protocol   string
+	Local      string // host:port
+	Remote     string
+	State      string // TCP state from the tracker, empty for UDP
+	PID        int
+	Process    string
+	BytesSent  uint64
+	BytesRecv  uint64
+	PacketsIn  uint64
+	PacketsOut uint64
+	Start      time.Time
+	Last       time.Time
+	Closed     time.Time // Zero while open
+	Rate       float64   // Bytes per second, both directions
+
+	rate   rateCounter
+	recent []string // Ring of the last recentPackets packets as text lines
+	next   int      // Next slot in recent once it is full
+}
+
+// Bytes returns the bytes transferred in both directions.
+func (c *Conn) Bytes() uint64 {
+	return c.BytesSent + c.BytesRecv
+}
+
+// Duration returns how long the connection has been (or was) open.
+func (c *Conn) Duration(now time.Time) time.Duration {
+	if !c.Closed.IsZero() {
+		return c.Closed.Sub(c.Start)
+	}
+	return now.Sub(c.Start)
+}
+
+// Matches reports whether query occurs in any of the connection's columns,
+// ignoring case. An empty query matches everything.
+func (c *Conn) Matches(query string) bool {
+	if query == "" {
+		return true
+	}
+	text := strings.ToLower(strings.Join([]string{
+		c.Protocol, c.Local, c.Remote, c.State, c.Process, fmt.Sprint(c.PID),
+	}, " "))
+	return strings.Contains(text, strings.ToLower(query))
+}
+
+// Process is the traffic of one process summed over its connections.
+// Packets whose owner could not be found are grouped under PID 0.
+type Process struct {
+	PID       int
+	Name      string
+	Conns     int
+	BytesSent uint64
+	BytesRecv uint64
+	Start     time.Time // First packet of any of its connections
+	Rate      float64
+}
+
+// Model aggregates packet and connection records into per-connection
+// traffic. It implements the record sink the capture pipeline writes to
+// and is safe for concurrent use.
+type Model struct {
+	mu    sync.Mutex
+	conns map[tracker.ConnKey]*Conn
+	text  output.TextEncoder
+}
+
+// NewModel creates an empty Model.
+func NewModel() *Model {
+	return &Model{conns: make(map[tracker.ConnKey]*Conn)}
+}
+
+// Encode records a packet or connection record. Other records are ignored.
+func (m *Model) Encode(v any) error {
+	m.observe(v, time.Now())
+	return nil
+}
+
+// Close is a no-op; the Model stays readable after the capture stops.
+func (m *Model) Close() {}
+
+func (m *Model) observe(v any, now time.Time) {
+	switch r := v.(type) {
+	case output.PacketRecord:
+		m.addPacket(&r, now)
+	case output.ConnectionRecord:
+		m.addConnEvent(&r, now)
+	}
+}
+
+// addPacket accounts a TCP or UDP packet to its connection.
+func (m *Model) addPacket(r *output.PacketRecord, now time.Time) {
+	key := tracker.NormalizeKey(r.SrcIP, r.SrcPort, r.DstIP, r.DstPort, r.Protocol)
+	src := hostPort(r.SrcIP, r.SrcPort)
+	dst := hostPort(r.DstIP, r.DstPort)
+	line, _ := m.text.Encode(r)
+	text := strings.TrimSuffix(string(line), "\n")
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c := m.conns[key]
+	if c == nil {
+		c = &Conn{Key: key, Protocol: r.Protocol, Local: src, Remote: dst, Start: now}
+		m.conns[key] = c
+	}
+	// Orient the connection once a packet's direction is known, turning
+	// around what was counted before
+	if r.Direction == "in" && c.Local != dst || r.Direction == "out" && c.Local != src {
+		c.Local, c.Remote = c.Remote, c.Local
+		c.BytesSent, c.BytesRecv = c.BytesRecv, c.BytesSent
+		c.PacketsOut, c.PacketsIn = c.PacketsIn, c.PacketsOut
+	}
+	if r.PID != 0 {
+		c.PID, c.Process = r.PID, r.ProcessName
+	}
+
+	if src == c.Local {
+		c.BytesSent += uint64(r.Length)
+		c.PacketsOut++
+	} else {
+		c.BytesRecv += uint64(r.Length)
+		c.PacketsIn++
+	}
+	c.Last = now
+	c.rate.add(now, r.Length)
+
+	if len(c.recent) < recentPackets {
+		c.recent = append(c.recent, text)
+	} else {
+		c.recent[c.next] = text
+		c.next = (c.next + 1) % recentPackets
+	}
+}
+
+// addConnEvent updates a connection's TCP state from the tracker.
+func (m *Model) addConnEvent(r *output.ConnectionRecord, now time.Time) {
+	info := &r.Connection
+	key := tracker.ConnKey{
+		SrcIP: info.SrcIP, SrcPort: info.SrcPort,
+		DstIP: info.DstIP, DstPort: info.DstPort,
+		Protocol: info.Protocol,
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c := m.conns[key]
+	if c == nil {
+		return
+	}
+	c.State = info.State
+	if r.EventType == "closed" {
+		c.State = "CLOSED"
+		c.Closed = now
+	}
+}
+
+// Connections returns a copy of the current connections with their rates,
+// after dropping those closed or idle for too long.
+func (m *Model) Connections(now time.Time) []Conn {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	conns := make([]Conn, 0, len(m.conns))
+	for key, c := range m.conns {
+		if !c.Closed.IsZero() && now.Sub(c.Closed) > closedLinger || now.Sub(c.Last) > idleTimeout {
+			delete(m.conns, key)
+			continue
+		}
+		c.Rate = c.rate.rate(now)
+		conn := *c
+		conn.recent = nil
+		conns = append(conns, conn)
+	}
+	return conns
+}
+
+// Recent returns the last packets of a connection as text lines, oldest
+// first.
+func (m *Model) Recent(key tracker.ConnKey) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c := m.conns[key]
+	if c == nil {
+		return nil
+	}
+	lines := make([]string, 0, len(c.recent))
+	lines = append(lines, c.recent[c.next:]...)
+	return append(lines, c.recent[:c.next]...)
+}
+
+// Processes sums connections by owning process.
+func Processes(conns []Conn) []Process {
+	byPID := make(map[int]*Process)
+	for i := range conns {
+		c := &conns[i]
+		p := byPID[c.PID]
+		if p == nil {
+			p = &Process{PID: c.PID, Name: c.Process, Start: c.Start}
+			byPID[c.PID] = p
+		}
+		p.Conns++
+		p.BytesSent += c.BytesSent
+		p.BytesRecv += c.BytesRecv
+		p.Rate += c.Rate
+		if c.Start.Before(p.Start) {
+			p.Start = c.Start
+		}
+	}
+
+	procs := make([]Process, 0, len(byPID))
+	for _, p := range byPID {
+		if p.PID == 0 {
+			p.Name = "(unknown)"
+		}
+		procs = append(procs, *p)
+	}
+	return procs
+}
+
+// SortConns orders connections by the given sort order, largest first.
+// Ties are broken by key so rows don't jump between frames.
+func SortConns(conns []Conn, by int) {
+	sort.Slice(conns, func(i, j int) bool {
+		a, b := &conns[i], &conns[j]
+		switch by {
+		case SortBytes:
+			if a.Bytes() != b.Bytes() {
+				return a.Bytes() > b.Bytes()
+			}
+		case SortDuration:
+			if !a.Start.Equal(b.Start) {
+				return a.Start.Before(b.Start)
+			}
+		default:
+			if a.Rate != b.Rate {
+				return a.Rate > b.Rate
+			}
+		}
+		return a.Key.String() < b.Key.String()
+	})
+}
+
+// SortProcesses orders processes like SortConns.
+func SortProcesses(procs []Process, by int) {
+	sort.Slice(procs, func(i, j int) bool {
+		a, b := &procs[i], &procs[j]
+		switch by {
+		case SortBytes:
+			if x, y := a.BytesSent+a.BytesRecv, b.BytesSent+b.BytesRecv; x != y {
+				return x > y
+			}
+		case SortDuration:
+			if !a.Start.Equal(b.Start) {
+				return a.Start.Before(b.Start)
+			}
+		default:
+			if a.Rate != b.Rate {
+				return a.Rate > b.Rate
+			}
+		}
+		return a.PID < b.PID
+	})
+}
+
+// hostPort joins an address and port.
+func hostPort(ip string, port uint16) string {
+	return fmt.Sprintf("%s:%d", ip, port)
+}
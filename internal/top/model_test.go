@@ -0,0 +1,101 @@
+package top
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hwang-fu/portlens/internal/output"
+)
+
+func packet(src string, sport uint16, dst string, dport uint16, dir string, length int) output.PacketRecord {
+	return output.PacketRecord{
+		Protocol: "TCP", SrcIP: src, SrcPort: sport, DstIP: dst, DstPort: dport,
+		Direction: dir, Length: length, TCP: &output.TCPInfo{Flags: "ACK"},
+	}
+}
+
+func TestModelAggregatesConnections(t *testing.T) {
+	m := NewModel()
+	start := time.Unix(1000, 0)
+
+	// The first packet has no direction; a later one orients the connection
+	m.observe(packet("1.1.1.1", 443, "10.0.0.5", 5000, "unknown", 1500), start)
+	out := packet("10.0.0.5", 5000, "1.1.1.1", 443, "out", 100)
+	out.PID, out.ProcessName = 42, "curl"
+	m.observe(out, start.Add(time.Second))
+	m.observe(packet("1.1.1.1", 443, "10.0.0.5", 5000, "in", 1500), start.Add(2*time.Second))
+
+	conns := m.Connections(start.Add(3 * time.Second))
+	if len(conns) != 1 {
+		t.Fatalf("got %d connections, want 1", len(conns))
+	}
+	c := conns[0]
+	if c.Local != "10.0.0.5:5000" || c.Remote != "1.1.1.1:443" {
+		t.Errorf("endpoints = %s → %s, want local 10.0.0.5:5000", c.Local, c.Remote)
+	}
+	if c.BytesSent != 100 || c.BytesRecv != 3000 || c.PacketsIn != 2 || c.PacketsOut != 1 {
+		t.Errorf("counters = sent %d recv %d in %d out %d", c.BytesSent, c.BytesRecv, c.PacketsIn, c.PacketsOut)
+	}
+	if c.PID != 42 || c.Process != "curl" {
+		t.Errorf("process = %s[%d], want curl[42]", c.Process, c.PID)
+	}
+	if want := float64(3100) / rateWindow; c.Rate != want {
+		t.Errorf("rate = %v, want %v", c.Rate, want)
+	}
+
+	if recent := m.Recent(c.Key); len(recent) != 3 {
+		t.Errorf("recent = %d lines, want 3", len(recent))
+	}
+}
+
+func TestModelClosesAndExpires(t *testing.T) {
+	m := NewModel()
+	now := time.Unix(1000, 0)
+	m.observe(packet("10.0.0.5", 5000, "1.1.1.1", 443, "out", 60), now)
+	m.observe(packet("10.0.0.5", 5001, "1.1.1.1", 443, "out", 60), now)
+
+	m.observe(output.ConnectionRecord{EventType: "closed", Connection: output.ConnectionInfo{
+		SrcIP: "1.1.1.1", SrcPort: 443, DstIP: "10.0.0.5", DstPort: 5000, Protocol: "TCP", State: "FIN_WAIT2",
+	}}, now)
+
+	conns := m.Connections(now.Add(time.Second))
+	SortConns(conns, SortDuration)
+	if len(conns) != 2 || conns[0].State != "CLOSED" {
+		t.Fatalf("connections = %+v, want the closed one still listed", conns)
+	}
+
+	if conns := m.Connections(now.Add(closedLinger + time.Second)); len(conns) != 1 {
+		t.Errorf("got %d connections after linger, want 1", len(conns))
+	}
+	if conns := m.Connections(now.Add(idleTimeout + time.Second)); len(conns) != 0 {
+		t.Errorf("got %d connections after idle timeout, want 0", len(conns))
+	}
+}
+
+func TestProcessesAndSorting(t *testing.T) {
+	now := time.Unix(1000, 0)
+	conns := []Conn{
+		{PID: 1, Process: "a", BytesSent: 10, Rate: 5, Start: now},
+		{PID: 2, Process: "b", BytesSent: 500, Rate: 1, Start: now.Add(-time.Minute)},
+		{PID: 1, Process: "a", BytesRecv: 20, Rate: 5, Start: now.Add(-time.Hour)},
+		{BytesRecv: 1, Start: now},
+	}
+
+	procs := Processes(conns)
+	SortProcesses(procs, SortRate)
+	if len(procs) != 3 || procs[0].PID != 1 || procs[0].Conns != 2 || procs[0].Rate != 10 {
+		t.Fatalf("by rate = %+v", procs)
+	}
+	if procs[2].Name != "(unknown)" {
+		t.Errorf("owner-less traffic = %q, want (unknown)", procs[2].Name)
+	}
+
+	SortProcesses(procs, SortBytes)
+	if procs[0].PID != 2 {
+		t.Errorf("by bytes, first = %d, want 2", procs[0].PID)
+	}
+	SortProcesses(procs, SortDuration)
+	if procs[0].PID != 1 {
+		t.Errorf("by duration, first = %d, want 1 (oldest connection)", procs[0].PID)
+	}
+}
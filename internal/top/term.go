@@ -0,0 +1,185 @@
+package top
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// frameInterval is how often the screen is redrawn without input.
+const frameInterval = time.Second
+
+// ErrNotTerminal is returned by Run when input or output is not a terminal.
+var ErrNotTerminal = errors.New("portlens top needs a terminal")
+
+// IsTerminal reports whether f is a terminal.
+func IsTerminal(f *os.File) bool {
+	_, err := getTermios(f.Fd())
+	return err == nil
+}
+
+func getTermios(fd uintptr) (*syscall.Termios, error) {
+	var t syscall.Termios
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TCGETS, uintptr(unsafe.Pointer(&t))); errno != 0 {
+		return nil, errno
+	}
+	return &t, nil
+}
+
+func setTermios(fd uintptr, t *syscall.Termios) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TCSETS, uintptr(unsafe.Pointer(t))); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// makeRaw puts the terminal in raw mode: keys are read one at a time
+// without echo, and Ctrl+C arrives as a key rather than SIGINT. Reads time
+// out after 100ms so the reader can notice shutdown. Returns the previous
+// settings.
+func makeRaw(fd uintptr) (*syscall.Termios, error) {
+	old, err := getTermios(fd)
+	if err != nil {
+		return nil, err
+	}
+	raw := *old
+	raw.Iflag &^= syscall.BRKINT | syscall.ICRNL | syscall.INPCK | syscall.ISTRIP | syscall.IXON
+	raw.Lflag &^= syscall.ECHO | syscall.ICANON | syscall.IEXTEN | syscall.ISIG
+	raw.Cc[syscall.VMIN] = 0
+	raw.Cc[syscall.VTIME] = 1
+	return old, setTermios(fd, &raw)
+}
+
+// windowSize returns the terminal's columns and rows, or 80x24.
+func windowSize(fd uintptr) (int, int) {
+	var ws struct{ Row, Col, X, Y uint16 }
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TIOCGWINSZ, uintptr(unsafe.Pointer(&ws)))
+	if errno != 0 || ws.Col == 0 || ws.Row == 0 {
+		return 80, 24
+	}
+	return int(ws.Col), int(ws.Row)
+}
+
+// Run shows the UI on the terminal until ctx is done or the user quits, in
+// which case quit is called. The terminal is restored before it returns.
+func (ui *UI) Run(ctx context.Context, in, out *os.File, quit func()) error {
+	if !IsTerminal(in) || !IsTerminal(out) {
+		return ErrNotTerminal
+	}
+	old, err := makeRaw(in.Fd())
+	if err != nil {
+		return err
+	}
+	defer setTermios(in.Fd(), old)
+
+	// Alternate screen, hidden cursor
+	out.WriteString("\x1b[?1049h\x1b[?25l")
+	defer out.WriteString("\x1b[?25h\x1b[?1049l")
+
+	// The reader must stop before the terminal leaves raw mode, or its
+	// pending read would wait for a whole line
+	keys := make(chan string, 16)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		readKeys(in, keys, done)
+		close(stopped)
+	}()
+	defer func() {
+		close(done)
+		<-stopped
+	}()
+
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, syscall.SIGWINCH)
+	defer signal.Stop(winch)
+
+	ticker := time.NewTicker(frameInterval)
+	defer ticker.Stop()
+
+	for {
+		if ui.opts.Refresh != nil {
+			ui.opts.Refresh()
+		}
+		ui.update(time.Now())
+		width, height := windowSize(out.Fd())
+		ui.draw(out, width, height)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case key := <-keys:
+			if ui.handleKey(key) {
+				quit()
+				return nil
+			}
+		case <-winch:
+		case <-ticker.C:
+		}
+	}
+}
+
+// draw writes a frame over the previous one.
+func (ui *UI) draw(out *os.File, width, height int) {
+	var b bytes.Buffer
+	b.WriteString("\x1b[H")
+	b.WriteString(strings.Join(ui.render(width, height), "\x1b[K\r\n"))
+	b.WriteString("\x1b[K\x1b[J")
+	out.Write(b.Bytes())
+}
+
+// readKeys sends key presses from in until done is closed. Escape
+// sequences such as arrow keys arrive in one read and are sent whole.
+func readKeys(in *os.File, keys chan<- string, done <-chan struct{}) {
+	buf := make([]byte, 64)
+	for {
+		n, err := in.Read(buf)
+		select {
+		case <-done:
+			return
+		default:
+		}
+		if n == 0 {
+			// A raw-mode read that times out returns no bytes and io.EOF
+			if err != nil && !errors.Is(err, io.EOF) {
+				return
+			}
+			continue
+		}
+		for _, key := range splitKeys(buf[:n]) {
+			select {
+			case keys <- key:
+			case <-done:
+				return
+			}
+		}
+	}
+}
+
+// splitKeys splits one read into keys: escape sequences stay together,
+// other bytes are one key each.
+func splitKeys(data []byte) []string {
+	var keys []string
+	for i := 0; i < len(data); {
+		if data[i] == 0x1b && i+2 < len(data) && data[i+1] == '[' {
+			end := i + 2
+			for end < len(data) && (data[end] < '@' || data[end] > '~') {
+				end++
+			}
+			end = min(end+1, len(data))
+			keys = append(keys, string(data[i:end]))
+			i = end
+			continue
+		}
+		keys = append(keys, string(data[i:i+1]))
+		i++
+	}
+	return keys
+}
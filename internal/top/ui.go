@@ -0,0 +1,466 @@
+package top
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hwang-fu/portlens/internal/stats"
+)
+
+// Views of the UI.
+const (
+	viewConns = iota
+	viewProcs
+	viewListen
+	viewDetail
+)
+
+// listenRefresh is how often the listening sockets are re-read while their
+// view is shown. Reading them scans every process's file descriptors.
+const listenRefresh = 2 * time.Second
+
+// Keys as read from a terminal in raw mode.
+const (
+	keyUp        = "\x1b[A"
+	keyDown      = "\x1b[B"
+	keyEsc       = "\x1b"
+	keyEnter     = "\r"
+	keyBackspace = "\x7f"
+	keyTab       = "\t"
+	keyCtrlC     = "\x03"
+)
+
+// ANSI sequences for the header and selection bars.
+const (
+	ansiReverse = "\x1b[7m"
+	ansiBold    = "\x1b[1m"
+	ansiReset   = "\x1b[0m"
+)
+
+// Options configures a UI.
+type Options struct {
+	Title     string                     // Shown in the header, such as the interfaces
+	Stats     *stats.StatsRecorder       // Capture counters for the header (optional)
+	Refresh   func()                     // Called before each frame, e.g. to update kernel counters
+	Listeners func() ([]Listener, error) // Source of the listening view (default Listeners)
+}
+
+// UI is the state of the top view: which view is shown, how it is sorted
+// and filtered, and the data of the current frame.
+type UI struct {
+	model *Model
+	opts  Options
+
+	view     int
+	sortBy   int
+	filter   string
+	typing   bool // Keys go to the filter
+	paused   bool // Frame data is frozen
+	selected int
+	detail   Conn // Connection shown in viewDetail
+
+	// Frame data, filtered and sorted
+	now        time.Time
+	conns      []Conn
+	procs      []Process
+	recent     []string
+	listeners  []Listener
+	listenErr  error
+	lastListen time.Time
+}
+
+// NewUI creates a UI showing model.
+func NewUI(model *Model, opts Options) *UI {
+	if opts.Listeners == nil {
+		opts.Listeners = Listeners
+	}
+	return &UI{model: model, opts: opts}
+}
+
+// update takes the frame data from the model, unless paused.
+func (ui *UI) update(now time.Time) {
+	if ui.paused {
+		return
+	}
+	ui.now = now
+
+	all := ui.model.Connections(now)
+	ui.conns = ui.conns[:0]
+	for _, c := range all {
+		if c.Matches(ui.filter) {
+			ui.conns = append(ui.conns, c)
+		}
+	}
+	SortConns(ui.conns, ui.sortBy)
+
+	ui.procs = Processes(ui.conns)
+	SortProcesses(ui.procs, ui.sortBy)
+
+	if ui.view == viewDetail {
+		for _, c := range all {
+			if c.Key == ui.detail.Key {
+				ui.detail = c
+			}
+		}
+		ui.recent = ui.model.Recent(ui.detail.Key)
+	}
+
+	if ui.view == viewListen && now.Sub(ui.lastListen) >= listenRefresh {
+		ui.lastListen = now
+		var all []Listener
+		all, ui.listenErr = ui.opts.Listeners()
+		ui.listeners = ui.listeners[:0]
+		for _, l := range all {
+			if listenerMatches(&l, ui.filter) {
+				ui.listeners = append(ui.listeners, l)
+			}
+		}
+	}
+	ui.clampSelection()
+}
+
+// listenerMatches is Conn.Matches for listeners.
+func listenerMatches(l *Listener, query string) bool {
+	text := strings.ToLower(fmt.Sprintf("%s %s %s %d", l.Protocol, l.Addr, l.Process, l.PID))
+	return strings.Contains(text, strings.ToLower(query))
+}
+
+// rows returns the number of selectable rows of the current view.
+func (ui *UI) rows() int {
+	switch ui.view {
+	case viewConns:
+		return len(ui.conns)
+	case viewProcs:
+		return len(ui.procs)
+	case viewListen:
+		return len(ui.listeners)
+	}
+	return 0
+}
+
+func (ui *UI) clampSelection() {
+	ui.selected = max(0, min(ui.selected, ui.rows()-1))
+}
+
+// setView switches views and shows fresh data.
+func (ui *UI) setView(view int) {
+	ui.view = view
+	ui.selected = 0
+	ui.lastListen = time.Time{}
+}
+
+// handleKey applies a key press. Returns true if the user quit.
+func (ui *UI) handleKey(key string) (quit bool) {
+	if key == keyCtrlC {
+		return true
+	}
+
+	if ui.typing {
+		switch key {
+		case keyEnter:
+			ui.typing = false
+		case keyEsc:
+			ui.typing = false
+			ui.filter = ""
+		case keyBackspace, "\b":
+			if ui.filter != "" {
+				ui.filter = ui.filter[:len(ui.filter)-1]
+			}
+		default:
+			if len(key) == 1 && key[0] >= ' ' && key[0] < 0x7f {
+				ui.filter += key
+			}
+		}
+		ui.selected = 0
+		ui.lastListen = time.Time{}
+		return false
+	}
+
+	switch key {
+	case "q":
+		return true
+	case "/":
+		ui.typing = true
+	case " ", "p":
+		ui.paused = !ui.paused
+	case "s":
+		ui.sortBy = (ui.sortBy + 1) % sortCount
+	case "r":
+		ui.sortBy = SortRate
+	case "b":
+		ui.sortBy = SortBytes
+	case "d":
+		ui.sortBy = SortDuration
+	case "1":
+		ui.setView(viewConns)
+	case "2":
+		ui.setView(viewProcs)
+	case "3":
+		ui.setView(viewListen)
+	case keyTab:
+		ui.setView((min(ui.view, viewListen) + 1) % viewDetail)
+	case keyUp, "k":
+		ui.selected--
+	case keyDown, "j":
+		ui.selected++
+	case keyEnter:
+		ui.drillDown()
+	case keyEsc, keyBackspace:
+		switch {
+		case ui.view == viewDetail:
+			ui.setView(viewConns)
+		case ui.filter != "":
+			ui.filter = ""
+		}
+	}
+	ui.clampSelection()
+	return false
+}
+
+// drillDown opens the selected connection, or shows the connections of
+// the selected process.
+func (ui *UI) drillDown() {
+	switch {
+	case ui.view == viewConns && ui.selected < len(ui.conns):
+		ui.detail = ui.conns[ui.selected]
+		ui.view = viewDetail
+		ui.recent = ui.model.Recent(ui.detail.Key)
+	case ui.view == viewProcs && ui.selected < len(ui.procs):
+		p := ui.procs[ui.selected]
+		ui.filter = p.Name
+		if p.PID != 0 {
+			ui.filter = strconv.Itoa(p.PID)
+		}
+		ui.setView(viewConns)
+	}
+}
+
+// render draws the current frame as lines of at most width columns,
+// filling height rows.
+func (ui *UI) render(width, height int) []string {
+	lines := []string{ui.statusLine(), ui.tabsLine(), ""}
+
+	var header string
+	var rows []string
+	switch ui.view {
+	case viewConns:
+		header, rows = ui.connRows()
+	case viewProcs:
+		header, rows = ui.procRows()
+	case viewListen:
+		header, rows = ui.listenRows()
+	case viewDetail:
+		lines = append(lines, ui.detailLines()...)
+		header, rows = "RECENT PACKETS", ui.recent
+	}
+	lines = append(lines, bar(header, width))
+
+	// Scroll so the selection stays visible above the help line
+	visible := max(0, height-len(lines)-1)
+	offset := 0
+	if ui.view == viewDetail {
+		offset = max(0, len(rows)-visible)
+	} else if ui.selected >= visible {
+		offset = ui.selected - visible + 1
+	}
+	for i := offset; i < len(rows) && i < offset+visible; i++ {
+		if i == ui.selected && ui.view != viewDetail {
+			lines = append(lines, bar(rows[i], width))
+		} else {
+			lines = append(lines, truncate(rows[i], width))
+		}
+	}
+
+	for len(lines) < height-1 {
+		lines = append(lines, "")
+	}
+	lines = append(lines, truncate(ui.helpLine(), width))
+	if len(lines) > height {
+		lines = lines[len(lines)-height:]
+	}
+	for i, line := range lines {
+		lines[i] = truncate(line, width)
+	}
+	return lines
+}
+
+func (ui *UI) statusLine() string {
+	var rate float64
+	for _, c := range ui.conns {
+		rate += c.Rate
+	}
+	line := fmt.Sprintf("%sportlens top%s  %s  %d connections  %s",
+		ansiBold, ansiReset, ui.opts.Title, len(ui.conns), formatRate(rate))
+
+	if ui.opts.Stats != nil {
+		snap := ui.opts.Stats.Snapshot()
+		packets, _ := snap["packets_captured"].(uint64)
+		line += fmt.Sprintf("  %d packets", packets)
+		if kernel, ok := snap["kernel"].(map[string]any); ok {
+			drops, _ := kernel["drops"].(uint64)
+			line += fmt.Sprintf("  %d dropped", drops)
+		}
+	}
+	if ui.paused {
+		line += "  " + ansiReverse + " PAUSED " + ansiReset
+	}
+	return line
+}
+
+func (ui *UI) tabsLine() string {
+	names := []string{"1 connections", "2 processes", "3 listening"}
+	for i := range names {
+		if i == ui.view || i == viewConns && ui.view == viewDetail {
+			names[i] = ansiReverse + names[i] + ansiReset
+		}
+	}
+	line := strings.Join(names, "  ") + "    sort: " + sortNames[ui.sortBy]
+	if ui.filter != "" || ui.typing {
+		line += "    filter: " + ui.filter
+		if ui.typing {
+			line += "_"
+		}
+	}
+	return line
+}
+
+func (ui *UI) helpLine() string {
+	if ui.typing {
+		return "type to filter  enter done  esc clear"
+	}
+	if ui.view == viewDetail {
+		return "esc back  space pause  q quit"
+	}
+	return "q quit  / filter  s sort  space pause  ↑↓ select  enter details  tab view"
+}
+
+const connFormat = "%-4s %-22s %-22s %-12s %-18s %10s %8s %8s %7s"
+
+func (ui *UI) connRows() (string, []string) {
+	header := fmt.Sprintf(connFormat, "PROTO", "LOCAL", "REMOTE", "STATE", "PROCESS", "RATE", "SENT", "RECV", "AGE")
+	rows := make([]string, len(ui.conns))
+	for i := range ui.conns {
+		c := &ui.conns[i]
+		rows[i] = fmt.Sprintf(connFormat, c.Protocol, c.Local, c.Remote, c.State,
+			processLabel(c.PID, c.Process), formatRate(c.Rate),
+			formatBytes(c.BytesSent), formatBytes(c.BytesRecv), formatDuration(c.Duration(ui.now)))
+	}
+	return header, rows
+}
+
+const procFormat = "%7s %-20s %6s %10s %8s %8s %7s"
+
+func (ui *UI) procRows() (string, []string) {
+	header := fmt.Sprintf(procFormat, "PID", "PROCESS", "CONNS", "RATE", "SENT", "RECV", "AGE")
+	rows := make([]string, len(ui.procs))
+	for i := range ui.procs {
+		p := &ui.procs[i]
+		pid := "-"
+		if p.PID != 0 {
+			pid = strconv.Itoa(p.PID)
+		}
+		rows[i] = fmt.Sprintf(procFormat, pid, p.Name, strconv.Itoa(p.Conns), formatRate(p.Rate),
+			formatBytes(p.BytesSent), formatBytes(p.BytesRecv), formatDuration(ui.now.Sub(p.Start)))
+	}
+	return header, rows
+}
+
+const listenFormat = "%-5s %-24s %s"
+
+func (ui *UI) listenRows() (string, []string) {
+	header := fmt.Sprintf(listenFormat, "PROTO", "ADDRESS", "PROCESS")
+	if ui.listenErr != nil {
+		return header, []string{"error: " + ui.listenErr.Error()}
+	}
+	rows := make([]string, len(ui.listeners))
+	for i, l := range ui.listeners {
+		rows[i] = fmt.Sprintf(listenFormat, l.Protocol, l.Addr, processLabel(l.PID, l.Process))
+	}
+	return header, rows
+}
+
+func (ui *UI) detailLines() []string {
+	c := &ui.detail
+	state := c.State
+	if state == "" {
+		state = "-"
+	}
+	return []string{
+		fmt.Sprintf("%s %s → %s  %s  %s", c.Protocol, c.Local, c.Remote, state, processLabel(c.PID, c.Process)),
+		fmt.Sprintf("sent %s in %d packets, received %s in %d packets, %s, open %s",
+			formatBytes(c.BytesSent), c.PacketsOut, formatBytes(c.BytesRecv), c.PacketsIn,
+			formatRate(c.Rate), formatDuration(c.Duration(ui.now))),
+		"",
+	}
+}
+
+// processLabel formats a process as name[pid], or "-" if unknown.
+func processLabel(pid int, name string) string {
+	if pid == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%s[%d]", name, pid)
+}
+
+// bar renders s in reverse video across the whole width.
+func bar(s string, width int) string {
+	s = truncate(s, width)
+	if n := len([]rune(s)); n < width {
+		s += strings.Repeat(" ", width-n)
+	}
+	return ansiReverse + s + ansiReset
+}
+
+// truncate shortens s to width columns, not counting ANSI sequences.
+func truncate(s string, width int) string {
+	var b strings.Builder
+	cols := 0
+	inEscape := false
+	for _, r := range s {
+		switch {
+		case inEscape:
+			inEscape = r < '@' || r > '~' || r == '['
+		case r == '\x1b':
+			inEscape = true
+		case cols == width:
+			continue
+		default:
+			cols++
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// formatBytes formats a byte count with a binary unit suffix.
+func formatBytes(n uint64) string {
+	const units = "KMGTPE"
+	if n < 1024 {
+		return fmt.Sprintf("%dB", n)
+	}
+	v := float64(n)
+	i := -1
+	for v >= 1024 && i < len(units)-1 {
+		v /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f%c", v, units[i])
+}
+
+func formatRate(bytesPerSec float64) string {
+	return formatBytes(uint64(bytesPerSec)) + "/s"
+}
+
+// formatDuration formats d compactly: 42s, 3m05s, 2h07m.
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Second)
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm%02ds", int(d.Minutes()), int(d.Seconds())%60)
+	}
+	return fmt.Sprintf("%dh%02dm", int(d.Hours()), int(d.Minutes())%60)
+}
@@ -0,0 +1,131 @@
+package top
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestUI returns a UI over a model with two connections of different
+// processes.
+func newTestUI() *UI {
+	m := NewModel()
+	now := time.Unix(1000, 0)
+	a := packet("10.0.0.5", 5000, "1.1.1.1", 443, "out", 100)
+	a.PID, a.ProcessName = 42, "curl"
+	b := packet("10.0.0.5", 6000, "8.8.8.8", 53, "out", 900)
+	b.PID, b.ProcessName = 7, "resolved"
+	m.observe(a, now)
+	m.observe(b, now)
+
+	ui := NewUI(m, Options{
+		Title: "eth0",
+		Listeners: func() ([]Listener, error) {
+			return []Listener{{Protocol: "TCP", Addr: "0.0.0.0:22", Port: 22, PID: 1, Process: "sshd"}}, nil
+		},
+	})
+	ui.update(now.Add(time.Second))
+	return ui
+}
+
+// screen renders the UI without ANSI sequences.
+func screen(ui *UI) string {
+	text := strings.Join(ui.render(120, 20), "\n")
+	for _, seq := range []string{ansiReverse, ansiBold, ansiReset} {
+		text = strings.ReplaceAll(text, seq, "")
+	}
+	return text
+}
+
+func TestUIFilterAndSort(t *testing.T) {
+	ui := newTestUI()
+	if ui.conns[0].Process != "resolved" {
+		t.Fatalf("by rate, first = %s, want resolved", ui.conns[0].Process)
+	}
+
+	for _, key := range []string{"/", "c", "u", "r", keyEnter} {
+		ui.handleKey(key)
+	}
+	ui.update(time.Unix(1001, 0))
+	if len(ui.conns) != 1 || ui.conns[0].Process != "curl" {
+		t.Fatalf("filter %q left %+v", ui.filter, ui.conns)
+	}
+	if s := screen(ui); !strings.Contains(s, "filter: cur") || strings.Contains(s, "resolved") {
+		t.Errorf("filtered screen:\n%s", s)
+	}
+
+	ui.handleKey(keyEsc)
+	ui.handleKey("s")
+	ui.update(time.Unix(1001, 0))
+	if ui.filter != "" || ui.sortBy != SortBytes || len(ui.conns) != 2 {
+		t.Errorf("after esc and s: filter %q, sort %d, %d conns", ui.filter, ui.sortBy, len(ui.conns))
+	}
+}
+
+func TestUIPauseAndDrillDown(t *testing.T) {
+	ui := newTestUI()
+
+	ui.handleKey(" ")
+	ui.model.observe(packet("10.0.0.5", 7000, "9.9.9.9", 80, "out", 60), time.Unix(1001, 0))
+	ui.update(time.Unix(1002, 0))
+	if len(ui.conns) != 2 {
+		t.Errorf("paused view changed to %d connections", len(ui.conns))
+	}
+	if !strings.Contains(screen(ui), "PAUSED") {
+		t.Error("paused screen lacks PAUSED")
+	}
+	ui.handleKey(" ")
+
+	ui.handleKey(keyDown)
+	ui.handleKey(keyEnter)
+	if ui.view != viewDetail || ui.detail.Process != "curl" {
+		t.Fatalf("enter on row 2 opened view %d for %s", ui.view, ui.detail.Process)
+	}
+	if s := screen(ui); !strings.Contains(s, "RECENT PACKETS") || !strings.Contains(s, "10.0.0.5:5000 → 1.1.1.1:443 TCP [ACK] len 100") {
+		t.Errorf("detail screen:\n%s", s)
+	}
+
+	ui.handleKey(keyEsc)
+	if ui.view != viewConns {
+		t.Errorf("esc from detail went to view %d", ui.view)
+	}
+}
+
+func TestUIProcessesAndListening(t *testing.T) {
+	ui := newTestUI()
+
+	ui.handleKey("2")
+	ui.update(time.Unix(1001, 0))
+	if s := screen(ui); !strings.Contains(s, "curl") || !strings.Contains(s, "resolved") {
+		t.Errorf("process screen:\n%s", s)
+	}
+
+	// Enter on a process lists its connections
+	ui.handleKey(keyEnter)
+	if ui.view != viewConns || ui.filter != "7" {
+		t.Errorf("enter on process: view %d, filter %q", ui.view, ui.filter)
+	}
+
+	ui.handleKey(keyEsc)
+	ui.handleKey("3")
+	ui.update(time.Unix(1001, 0))
+	if s := screen(ui); !strings.Contains(s, "0.0.0.0:22") || !strings.Contains(s, "sshd[1]") {
+		t.Errorf("listening screen:\n%s", s)
+	}
+
+	if !ui.handleKey("q") {
+		t.Error("q did not quit")
+	}
+}
+
+func TestSplitKeysAndTruncate(t *testing.T) {
+	keys := splitKeys([]byte("a\x1b[Bq\x1b"))
+	want := []string{"a", keyDown, "q", keyEsc}
+	if strings.Join(keys, ",") != strings.Join(want, ",") {
+		t.Errorf("splitKeys = %q, want %q", keys, want)
+	}
+
+	if got := truncate(ansiBold+"abcdef"+ansiReset, 3); got != ansiBold+"abc"+ansiReset {
+		t.Errorf("truncate = %q", got)
+	}
+}
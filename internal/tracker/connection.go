@@ -2,6 +2,7 @@ package tracker
 
 import (
 	"fmt"
+	"strings"
 	"time"
 )
 
@@ -68,11 +69,67 @@ type Connection struct {
 	StartTime time.Time
 	EndTime   time.Time
 
-	// Statistics
+	// Statistics. Bytes count payload, IP bytes whole IP packets.
 	PacketsSent     uint64
 	PacketsReceived uint64
 	BytesSent       uint64
 	BytesReceived   uint64
+	IPBytesSent     uint64
+	IPBytesReceived uint64
+
+	// Process owning the local socket, if known
+	PID     int
+	Process string
+
+	// Originator: the endpoint that opened the connection. The other
+	// endpoint is the responder.
+	OrigIP   string
+	OrigPort uint16
+
+	// Statistics by role. Bytes count payload, IP bytes whole IP packets.
+	OrigPackets uint64
+	OrigBytes   uint64
+	OrigIPBytes uint64
+	RespPackets uint64
+	RespBytes   uint64
+	RespIPBytes uint64
+
+	// History in Zeek notation: each kind of packet seen, in order of first
+	// appearance, uppercase from the originator and lowercase from the
+	// responder. S is a SYN, H a SYN-ACK, A a pure ACK, D data, F a FIN
+	// and R a reset, e.g. "ShADadFf" for a regular connection.
+	History string
+}
+
+// addHistory records the kinds of a packet in the history.
+func (c *Connection) addHistory(flags uint8, payloadLen int, fromOrig bool) {
+	var kinds []byte
+	switch {
+	case flags&flagSYN != 0 && flags&flagACK != 0:
+		kinds = append(kinds, 'H')
+	case flags&flagSYN != 0:
+		kinds = append(kinds, 'S')
+	}
+	if flags&flagFIN != 0 {
+		kinds = append(kinds, 'F')
+	}
+	if flags&flagRST != 0 {
+		kinds = append(kinds, 'R')
+	}
+	if payloadLen > 0 {
+		kinds = append(kinds, 'D')
+	} else if flags&(flagSYN|flagFIN|flagRST) == 0 && flags&flagACK != 0 {
+		kinds = append(kinds, 'A')
+	}
+
+	for _, k := range kinds {
+		if !fromOrig {
+			k += 'a' - 'A'
+		}
+		if strings.IndexByte(c.History, k) < 0 {
+			c.History += string(k)
+		}
+	}
 }
 
 // Duration returns how long the connection has been active.
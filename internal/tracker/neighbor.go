@@ -0,0 +1,220 @@
+package tracker
+
+import (
+	"cmp"
+	"maps"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ConflictWindow is how long a previous IP→MAC binding is remembered.
+// If an IP flips back to that MAC within the window, two hosts are
+// claiming the same address rather than one host changing its MAC.
+const ConflictWindow = 60 * time.Second
+
+const (
+	// DefaultMaxNeighbors bounds the table, enough for a /16. Past it, the
+	// least recently seen neighbors are forgotten, so a flood of spoofed
+	// senders can't grow it without limit.
+	DefaultMaxNeighbors = 65536
+
+	// DefaultNeighborTimeout is how long a neighbor is remembered after
+	// its last ARP packet. Hosts refresh their ARP caches every few
+	// minutes, so a live neighbor is seen again well within it.
+	DefaultNeighborTimeout = 30 * time.Minute
+
+	// neighborPruneInterval is how often expired neighbors are looked for.
+	neighborPruneInterval = time.Second
+)
+
+// Neighbor is a single IP→MAC binding learned from ARP traffic.
+type Neighbor struct {
+	IP        string
+	MAC       string
+	FirstSeen time.Time
+	LastSeen  time.Time
+	Packets   uint64
+
+	// Previous binding, kept for conflict detection
+	PrevMAC      string
+	PrevLastSeen time.Time
+}
+
+// NeighborEvent represents a notable change in the neighbor table.
+type NeighborEvent struct {
+	Type      string // "new", "gratuitous", "mac_change", "ip_conflict"
+	IP        string
+	MAC       string
+	OldMAC    string // Only for mac_change and ip_conflict events
+	Timestamp time.Time
+}
+
+// NeighborTable keeps a live IP→MAC table built from observed ARP packets.
+// Neighbors not seen for the timeout are forgotten, as are the least
+// recently seen ones once the table holds maxEntries; either is reported
+// as new when it shows up again.
+type NeighborTable struct {
+	mu         sync.RWMutex
+	entries    map[string]*Neighbor
+	maxEntries int
+	timeout    time.Duration
+	lastPrune  time.Time
+	events     chan NeighborEvent
+	dropped    atomic.Uint64 // Events dropped because the channel was full
+}
+
+// NewNeighborTable creates a new neighbor table holding at most maxEntries
+// neighbors, each for timeout after it was last seen.
+// eventBufferSize determines how many events can be buffered before dropping.
+func NewNeighborTable(eventBufferSize, maxEntries int, timeout time.Duration) *NeighborTable {
+	return &NeighborTable{
+		entries:    make(map[string]*Neighbor),
+		maxEntries: maxEntries,
+		timeout:    timeout,
+		events:     make(chan NeighborEvent, eventBufferSize),
+	}
+}
+
+// Events returns the channel for receiving neighbor events.
+func (t *NeighborTable) Events() <-chan NeighborEvent {
+	return t.events
+}
+
+// Lookup returns a copy of the entry for ip, or nil if unknown.
+func (t *NeighborTable) Lookup(ip string) *Neighbor {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if n, ok := t.entries[ip]; ok {
+		entry := *n
+		return &entry
+	}
+	return nil
+}
+
+// Len returns the number of known neighbors.
+func (t *NeighborTable) Len() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return len(t.entries)
+}
+
+// Close closes the events channel.
+func (t *NeighborTable) Close() {
+	close(t.events)
+}
+
+// emitEvent sends an event to the events channel (non-blocking).
+func (t *NeighborTable) emitEvent(event NeighborEvent) {
+	select {
+	case t.events <- event:
+	default:
+		// Channel full, drop event
+		t.dropped.Add(1)
+	}
+}
+
+// DroppedEvents returns how many events were dropped because the events
+// channel was full.
+func (t *NeighborTable) DroppedEvents() uint64 {
+	return t.dropped.Load()
+}
+
+// ProcessARP updates the table from an ARP packet.
+//
+// senderIP/senderMAC is the binding the packet announces. targetIP is only
+// used for probes (senderIP 0.0.0.0), which carry no binding of their own
+// but reveal a conflict if the probed address already belongs to another MAC.
+// now is the packet's capture timestamp.
+func (t *NeighborTable) ProcessARP(senderIP, senderMAC, targetIP string, gratuitous, probe bool, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.prune(now)
+
+	if probe {
+		// Someone is about to claim targetIP. If we already know it under
+		// a different MAC, the address is in use (RFC 5227 conflict).
+		if n, ok := t.entries[targetIP]; ok && n.MAC != senderMAC {
+			t.emitEvent(NeighborEvent{
+				Type:      "ip_conflict",
+				IP:        targetIP,
+				MAC:       senderMAC,
+				OldMAC:    n.MAC,
+				Timestamp: now,
+			})
+		}
+		return
+	}
+
+	n, ok := t.entries[senderIP]
+	if !ok {
+		if len(t.entries) >= t.maxEntries {
+			t.evictOldest()
+		}
+		t.entries[senderIP] = &Neighbor{
+			IP:        senderIP,
+			MAC:       senderMAC,
+			FirstSeen: now,
+			LastSeen:  now,
+			Packets:   1,
+		}
+		t.emitEvent(NeighborEvent{Type: "new", IP: senderIP, MAC: senderMAC, Timestamp: now})
+		if gratuitous {
+			t.emitEvent(NeighborEvent{Type: "gratuitous", IP: senderIP, MAC: senderMAC, Timestamp: now})
+		}
+		return
+	}
+
+	n.Packets++
+
+	if n.MAC != senderMAC {
+		eventType := "mac_change"
+		// Flapping back to the previous MAC means two hosts share the IP
+		if senderMAC == n.PrevMAC && now.Sub(n.PrevLastSeen) < ConflictWindow {
+			eventType = "ip_conflict"
+		}
+		t.emitEvent(NeighborEvent{
+			Type:      eventType,
+			IP:        senderIP,
+			MAC:       senderMAC,
+			OldMAC:    n.MAC,
+			Timestamp: now,
+		})
+		n.PrevMAC = n.MAC
+		n.PrevLastSeen = n.LastSeen
+		n.MAC = senderMAC
+	}
+
+	n.LastSeen = now
+
+	if gratuitous {
+		t.emitEvent(NeighborEvent{Type: "gratuitous", IP: senderIP, MAC: senderMAC, Timestamp: now})
+	}
+}
+
+// prune forgets neighbors not seen within the timeout, at most once per
+// neighborPruneInterval. Caller must hold the lock.
+func (t *NeighborTable) prune(now time.Time) {
+	if now.Sub(t.lastPrune) < neighborPruneInterval {
+		return
+	}
+	for ip, n := range t.entries {
+		if now.Sub(n.LastSeen) >= t.timeout {
+			delete(t.entries, ip)
+		}
+	}
+	t.lastPrune = now
+}
+
+// evictOldest forgets the least recently seen tenth of the neighbors, so a
+// full table isn't scanned for every new one. Caller must hold the lock.
+func (t *NeighborTable) evictOldest() {
+	oldest := slices.SortedFunc(maps.Values(t.entries), func(a, b *Neighbor) int {
+		return cmp.Or(a.LastSeen.Compare(b.LastSeen), cmp.Compare(a.IP, b.IP))
+	})
+	for _, n := range oldest[:len(oldest)/10+1] {
+		delete(t.entries, n.IP)
+	}
+}
@@ -0,0 +1,142 @@
+package tracker
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+// drainNeighborEvents returns the types of all currently buffered events.
+func drainNeighborEvents(t *NeighborTable) []string {
+	var types []string
+	for {
+		select {
+		case ev := <-t.Events():
+			types = append(types, ev.Type)
+		default:
+			return types
+		}
+	}
+}
+
+func TestNeighborTableNewAndGratuitous(t *testing.T) {
+	table := NewNeighborTable(10, DefaultMaxNeighbors, DefaultNeighborTimeout)
+
+	table.ProcessARP("10.0.0.1", "aa:aa:aa:aa:aa:aa", "10.0.0.1", true, false, time.Now())
+
+	got := drainNeighborEvents(table)
+	want := []string{"new", "gratuitous"}
+	if !slices.Equal(got, want) {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+
+	n := table.Lookup("10.0.0.1")
+	if n == nil || n.MAC != "aa:aa:aa:aa:aa:aa" {
+		t.Fatalf("Lookup = %+v, want MAC aa:aa:aa:aa:aa:aa", n)
+	}
+}
+
+func TestNeighborTableMACChange(t *testing.T) {
+	table := NewNeighborTable(10, DefaultMaxNeighbors, DefaultNeighborTimeout)
+
+	table.ProcessARP("10.0.0.1", "aa:aa:aa:aa:aa:aa", "10.0.0.2", false, false, time.Now())
+	table.ProcessARP("10.0.0.1", "bb:bb:bb:bb:bb:bb", "10.0.0.2", false, false, time.Now())
+
+	got := drainNeighborEvents(table)
+	want := []string{"new", "mac_change"}
+	if !slices.Equal(got, want) {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+
+	if n := table.Lookup("10.0.0.1"); n.MAC != "bb:bb:bb:bb:bb:bb" {
+		t.Errorf("MAC = %s, want bb:bb:bb:bb:bb:bb", n.MAC)
+	}
+}
+
+func TestNeighborTableConflict(t *testing.T) {
+	table := NewNeighborTable(10, DefaultMaxNeighbors, DefaultNeighborTimeout)
+
+	// Two hosts alternately claiming the same IP
+	table.ProcessARP("10.0.0.1", "aa:aa:aa:aa:aa:aa", "10.0.0.2", false, false, time.Now())
+	table.ProcessARP("10.0.0.1", "bb:bb:bb:bb:bb:bb", "10.0.0.2", false, false, time.Now())
+	table.ProcessARP("10.0.0.1", "aa:aa:aa:aa:aa:aa", "10.0.0.2", false, false, time.Now())
+
+	got := drainNeighborEvents(table)
+	want := []string{"new", "mac_change", "ip_conflict"}
+	if !slices.Equal(got, want) {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+}
+
+func TestNeighborTableProbeConflict(t *testing.T) {
+	table := NewNeighborTable(10, DefaultMaxNeighbors, DefaultNeighborTimeout)
+
+	table.ProcessARP("10.0.0.1", "aa:aa:aa:aa:aa:aa", "10.0.0.2", false, false, time.Now())
+	drainNeighborEvents(table)
+
+	// Another host probes for an address that is already in use
+	table.ProcessARP("0.0.0.0", "bb:bb:bb:bb:bb:bb", "10.0.0.1", false, true, time.Now())
+
+	got := drainNeighborEvents(table)
+	want := []string{"ip_conflict"}
+	if !slices.Equal(got, want) {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+
+	if table.Len() != 1 {
+		t.Errorf("Len = %d, want 1 (probes must not add entries)", table.Len())
+	}
+}
+
+func TestNeighborTableEvictsOldest(t *testing.T) {
+	table := NewNeighborTable(100, 10, DefaultNeighborTimeout)
+	start := time.Date(2025, 12, 24, 10, 0, 0, 0, time.UTC)
+
+	// A flood of senders, one a millisecond; 10.0.0.0 keeps refreshing
+	for i := range 20 {
+		now := start.Add(time.Duration(i) * time.Millisecond)
+		table.ProcessARP(fmt.Sprintf("10.0.1.%d", i), "aa:aa:aa:aa:aa:aa", "10.0.0.254", false, false, now)
+		table.ProcessARP("10.0.0.0", "bb:bb:bb:bb:bb:bb", "10.0.0.254", false, false, now)
+	}
+
+	if table.Len() > 10 {
+		t.Errorf("Len = %d, want at most 10", table.Len())
+	}
+	if table.Lookup("10.0.0.0") == nil {
+		t.Error("the most recently seen neighbor was evicted")
+	}
+	if table.Lookup("10.0.1.19") == nil {
+		t.Error("the newest neighbor was evicted")
+	}
+	if table.Lookup("10.0.1.0") != nil {
+		t.Error("the oldest neighbor was kept")
+	}
+}
+
+func TestNeighborTableExpires(t *testing.T) {
+	table := NewNeighborTable(10, DefaultMaxNeighbors, time.Minute)
+	start := time.Date(2025, 12, 24, 10, 0, 0, 0, time.UTC)
+
+	table.ProcessARP("10.0.0.1", "aa:aa:aa:aa:aa:aa", "10.0.0.254", false, false, start)
+	table.ProcessARP("10.0.0.2", "bb:bb:bb:bb:bb:bb", "10.0.0.254", false, false, start)
+	table.ProcessARP("10.0.0.2", "bb:bb:bb:bb:bb:bb", "10.0.0.254", false, false, start.Add(30*time.Second))
+	drainNeighborEvents(table)
+
+	// 10.0.0.1 was last seen a minute ago, 10.0.0.2 only 30s ago
+	table.ProcessARP("10.0.0.3", "cc:cc:cc:cc:cc:cc", "10.0.0.254", false, false, start.Add(time.Minute))
+	if table.Lookup("10.0.0.1") != nil {
+		t.Error("10.0.0.1 did not expire")
+	}
+	if table.Lookup("10.0.0.2") == nil {
+		t.Error("10.0.0.2 expired while refreshed")
+	}
+
+	// An expired neighbor is new again
+	table.ProcessARP("10.0.0.1", "aa:aa:aa:aa:aa:aa", "10.0.0.254", false, false, start.Add(time.Minute))
+	got := drainNeighborEvents(table)
+	want := []string{"new", "new"}
+	if !slices.Equal(got, want) {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+}
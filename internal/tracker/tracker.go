@@ -1,7 +1,9 @@
 package tracker
 
 import (
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -9,6 +11,7 @@ import (
 type Event struct {
 	Type       string   // "opened", "closed", "state_change"
 	OldState   TCPState // Only for state_change events
+	Reason     string   // Why a connection was closed without a FIN/RST ("shutdown")
 	Connection *Connection
 	Timestamp  time.Time
 }
@@ -18,6 +21,7 @@ type Tracker struct {
 	mu          sync.RWMutex
 	connections map[ConnKey]*Connection
 	events      chan Event
+	dropped     atomic.Uint64 // Events dropped because the channel was full
 }
 
 // New creates a new connection tracker.
@@ -41,9 +45,9 @@ func (t *Tracker) GetConnection(key ConnKey) *Connection {
 	return t.connections[key]
 }
 
-// getOrCreateConnection returns an existing connection or creates a new one.
-// Caller must hold the write lock.
-func (t *Tracker) getOrCreateConnection(key ConnKey) (*Connection, bool) {
+// getOrCreateConnection returns an existing connection or creates a new one
+// starting at ts. Caller must hold the write lock.
+func (t *Tracker) getOrCreateConnection(key ConnKey, ts time.Time) (*Connection, bool) {
 	if conn, exists := t.connections[key]; exists {
 		return conn, false
 	}
@@ -51,7 +55,7 @@ func (t *Tracker) getOrCreateConnection(key ConnKey) (*Connection, bool) {
 	conn := &Connection{
 		Key:       key,
 		State:     StateClosed,
-		StartTime: time.Now(),
+		StartTime: ts,
 	}
 	t.connections[key] = conn
 	return conn, true
@@ -68,10 +72,17 @@ func (t *Tracker) emitEvent(event Event) {
 	select {
 	case t.events <- event:
 	default:
-		// Channel full, drop event (could log warning here)
+		// Channel full, drop event
+		t.dropped.Add(1)
 	}
 }
 
+// DroppedEvents returns how many events were dropped because the events
+// channel was full.
+func (t *Tracker) DroppedEvents() uint64 {
+	return t.dropped.Load()
+}
+
 // ActiveConnections returns the number of currently tracked connections.
 func (t *Tracker) ActiveConnections() int {
 	t.mu.RLock()
@@ -79,28 +90,93 @@ func (t *Tracker) ActiveConnections() int {
 	return len(t.connections)
 }
 
+// StateCounts returns the number of tracked connections in each state.
+func (t *Tracker) StateCounts() map[TCPState]int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	counts := make(map[TCPState]int)
+	for _, conn := range t.connections {
+		counts[conn.State]++
+	}
+	return counts
+}
+
+// Connections returns a copy of every tracked connection.
+func (t *Tracker) Connections() []Connection {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	conns := make([]Connection, 0, len(t.connections))
+	for _, conn := range t.connections {
+		conns = append(conns, *conn)
+	}
+	return conns
+}
+
+// SetOwner records the process owning a tracked connection's local socket.
+// It does nothing if the connection isn't tracked.
+func (t *Tracker) SetOwner(key ConnKey, pid int, process string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if conn := t.connections[key]; conn != nil {
+		conn.PID = pid
+		conn.Process = process
+	}
+}
+
 // Close closes the events channel.
 func (t *Tracker) Close() {
 	close(t.events)
 }
 
+// CloseAll ends every tracked connection at now and emits a "closed" event
+// with the given reason for each, oldest first. Unlike regular events these
+// are not dropped when the channel is full, so the events channel must
+// still be drained.
+func (t *Tracker) CloseAll(now time.Time, reason string) {
+	t.mu.Lock()
+	open := make([]*Connection, 0, len(t.connections))
+	for key, conn := range t.connections {
+		open = append(open, conn)
+		delete(t.connections, key)
+	}
+	t.mu.Unlock()
+
+	sort.Slice(open, func(i, j int) bool {
+		return open[i].StartTime.Before(open[j].StartTime)
+	})
+
+	for _, conn := range open {
+		conn.EndTime = now
+		t.events <- Event{
+			Type:       "closed",
+			OldState:   conn.State,
+			Connection: conn,
+			Reason:     reason,
+			Timestamp:  now,
+		}
+	}
+}
+
+// TCP flag constants (should match parser package)
+const (
+	flagFIN = 0x01
+	flagSYN = 0x02
+	flagRST = 0x04
+	flagACK = 0x10
+)
+
 // ProcessTCPPacket processes a TCP packet and updates connection state.
+// payloadLen is the TCP payload length, ipLen the whole IP packet's.
+// ts is the packet's capture timestamp; connection times and events use it.
 // Returns the connection and any state change event.
 func (t *Tracker) ProcessTCPPacket(
 	srcIP string, srcPort uint16,
 	dstIP string, dstPort uint16,
 	flags uint8,
-	payloadLen int,
+	payloadLen, ipLen int,
 	isOutbound bool,
+	ts time.Time,
 ) *Connection {
-	// TCP flag constants (should match parser package)
-	const (
-		FlagFIN = 0x01
-		FlagSYN = 0x02
-		FlagRST = 0x04
-		FlagACK = 0x10
-	)
-
 	key := NormalizeKey(
 		srcIP,
 		srcPort,
@@ -112,27 +188,49 @@ func (t *Tracker) ProcessTCPPacket(
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
-	conn, isNew := t.getOrCreateConnection(key)
+	conn, isNew := t.getOrCreateConnection(key, ts)
 	oldState := conn.State
 
 	// Update statistics
 	if isOutbound {
 		conn.PacketsSent++
 		conn.BytesSent += uint64(payloadLen)
+		conn.IPBytesSent += uint64(ipLen)
 	} else {
 		conn.PacketsReceived++
 		conn.BytesReceived += uint64(payloadLen)
+		conn.IPBytesReceived += uint64(ipLen)
+	}
+
+	// The originator sent the SYN; a connection picked up at the SYN-ACK
+	// was opened by the receiver, one picked up later by the first sender
+	if isNew {
+		conn.OrigIP, conn.OrigPort = srcIP, srcPort
+		if flags&flagSYN != 0 && flags&flagACK != 0 {
+			conn.OrigIP, conn.OrigPort = dstIP, dstPort
+		}
+	}
+	fromOrig := srcIP == conn.OrigIP && srcPort == conn.OrigPort
+	if fromOrig {
+		conn.OrigPackets++
+		conn.OrigBytes += uint64(payloadLen)
+		conn.OrigIPBytes += uint64(ipLen)
+	} else {
+		conn.RespPackets++
+		conn.RespBytes += uint64(payloadLen)
+		conn.RespIPBytes += uint64(ipLen)
 	}
+	conn.addHistory(flags, payloadLen, fromOrig)
 
 	// Handle RST - immediate close
-	if flags&FlagRST != 0 {
+	if flags&flagRST != 0 {
 		conn.State = StateClosed
-		conn.EndTime = time.Now()
+		conn.EndTime = ts
 		t.emitEvent(Event{
 			Type:       "closed",
 			Connection: conn,
 			OldState:   oldState,
-			Timestamp:  time.Now(),
+			Timestamp:  ts,
 		})
 		t.removeConnection(key)
 		return conn
@@ -141,72 +239,72 @@ func (t *Tracker) ProcessTCPPacket(
 	// State machine transitions
 	switch conn.State {
 	case StateClosed:
-		if flags&FlagSYN != 0 && flags&FlagACK == 0 {
+		if flags&flagSYN != 0 && flags&flagACK == 0 {
 			// SYN only - connection initiation
 			conn.State = StateSynSent
 			if isNew {
 				t.emitEvent(Event{
 					Type:       "opened",
 					Connection: conn,
-					Timestamp:  time.Now(),
+					Timestamp:  ts,
 				})
 			}
 		}
 
 	case StateSynSent:
-		if flags&FlagSYN != 0 && flags&FlagACK != 0 {
+		if flags&flagSYN != 0 && flags&flagACK != 0 {
 			// SYN+ACK - server responding
 			conn.State = StateSynReceived
 		}
 
 	case StateSynReceived:
-		if flags&FlagACK != 0 && flags&FlagSYN == 0 {
+		if flags&flagACK != 0 && flags&flagSYN == 0 {
 			// ACK only - handshake complete
 			conn.State = StateEstablished
 			t.emitEvent(Event{
 				Type:       "state_change",
 				Connection: conn,
 				OldState:   oldState,
-				Timestamp:  time.Now(),
+				Timestamp:  ts,
 			})
 		}
 
 	case StateEstablished:
-		if flags&FlagFIN != 0 {
+		if flags&flagFIN != 0 {
 			// FIN received - start closing
 			conn.State = StateFinWait1
 		}
 
 	case StateFinWait1:
-		if flags&FlagACK != 0 && flags&FlagFIN == 0 {
+		if flags&flagACK != 0 && flags&flagFIN == 0 {
 			conn.State = StateFinWait2
-		} else if flags&FlagFIN != 0 {
+		} else if flags&flagFIN != 0 {
 			conn.State = StateLastAck
 		}
 
 	case StateFinWait2:
-		if flags&FlagFIN != 0 {
+		if flags&flagFIN != 0 {
 			conn.State = StateTimeWait
-			conn.EndTime = time.Now()
+			conn.EndTime = ts
 			t.emitEvent(Event{
 				Type:       "closed",
 				Connection: conn,
 				OldState:   oldState,
-				Timestamp:  time.Now(),
+				Timestamp:  ts,
 			})
 			// In real implementation, would wait for TIME_WAIT timeout
 			t.removeConnection(key)
 		}
 
 	case StateLastAck:
-		if flags&FlagACK != 0 {
+		if flags&flagACK != 0 {
 			conn.State = StateClosed
-			conn.EndTime = time.Now()
+			conn.EndTime = ts
 			t.emitEvent(Event{
 				Type:       "closed",
 				Connection: conn,
 				OldState:   oldState,
-				Timestamp:  time.Now(),
+				Timestamp:  ts,
 			})
 			t.removeConnection(key)
 		}
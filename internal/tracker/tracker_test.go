@@ -0,0 +1,90 @@
+package tracker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTrackerCloseAll(t *testing.T) {
+	tr := New(10)
+	start := time.Now()
+
+	// SYN opens a connection; a second flow starts later
+	tr.ProcessTCPPacket("10.0.0.1", 5000, "10.0.0.2", 80, 0x02, 0, 40, true, start)
+	tr.ProcessTCPPacket("10.0.0.1", 5001, "10.0.0.2", 80, 0x02, 0, 40, true, start.Add(time.Second))
+	<-tr.Events()
+	<-tr.Events()
+
+	end := start.Add(5 * time.Second)
+	tr.CloseAll(end, "shutdown")
+
+	for _, wantPort := range []uint16{5000, 5001} {
+		ev := <-tr.Events()
+		if ev.Type != "closed" || ev.Reason != "shutdown" {
+			t.Errorf("event = %s/%q, want closed/shutdown", ev.Type, ev.Reason)
+		}
+		if ev.Connection.Key.SrcPort != wantPort {
+			t.Errorf("src port = %d, want %d (oldest first)", ev.Connection.Key.SrcPort, wantPort)
+		}
+		if !ev.Connection.EndTime.Equal(end) {
+			t.Errorf("end time = %v, want %v", ev.Connection.EndTime, end)
+		}
+	}
+
+	if n := tr.ActiveConnections(); n != 0 {
+		t.Errorf("ActiveConnections = %d, want 0", n)
+	}
+}
+
+func TestTrackerOwnerAndConnections(t *testing.T) {
+	tr := New(10)
+	now := time.Now()
+	tr.ProcessTCPPacket("10.0.0.1", 5000, "10.0.0.2", 80, 0x02, 0, 40, true, now)
+	tr.ProcessTCPPacket("10.0.0.2", 80, "10.0.0.1", 5000, 0x12, 100, 140, false, now)
+
+	key := NormalizeKey("10.0.0.2", 80, "10.0.0.1", 5000, "TCP")
+	tr.SetOwner(key, 42, "curl")
+	tr.SetOwner(NormalizeKey("10.0.0.9", 1, "10.0.0.1", 2, "TCP"), 1, "untracked")
+
+	conns := tr.Connections()
+	if len(conns) != 1 {
+		t.Fatalf("got %d connections, want 1", len(conns))
+	}
+	c := conns[0]
+	if c.PID != 42 || c.Process != "curl" || c.PacketsSent != 1 || c.BytesReceived != 100 {
+		t.Errorf("connection = %+v", c)
+	}
+
+	// The copy doesn't change with the tracked connection
+	tr.ProcessTCPPacket("10.0.0.1", 5000, "10.0.0.2", 80, 0x10, 0, 40, true, now)
+	if conns[0].PacketsSent != 1 {
+		t.Errorf("copy changed to %d packets sent", conns[0].PacketsSent)
+	}
+}
+
+func TestTrackerOriginatorAndHistory(t *testing.T) {
+	tr := New(10)
+	now := time.Now()
+
+	// Picked up at the SYN-ACK: the receiver is the originator
+	tr.ProcessTCPPacket("10.0.0.2", 80, "10.0.0.1", 5000, 0x12, 0, 40, false, now)
+	tr.ProcessTCPPacket("10.0.0.1", 5000, "10.0.0.2", 80, 0x10, 0, 40, true, now)
+	tr.ProcessTCPPacket("10.0.0.1", 5000, "10.0.0.2", 80, 0x18, 100, 140, true, now)
+	tr.ProcessTCPPacket("10.0.0.2", 80, "10.0.0.1", 5000, 0x18, 500, 540, false, now)
+	tr.ProcessTCPPacket("10.0.0.1", 5000, "10.0.0.2", 80, 0x18, 100, 140, true, now)
+	tr.ProcessTCPPacket("10.0.0.2", 80, "10.0.0.1", 5000, 0x04, 0, 40, false, now)
+
+	ev := <-tr.Events()
+	c := ev.Connection
+	if c.OrigIP != "10.0.0.1" || c.OrigPort != 5000 {
+		t.Errorf("originator = %s:%d, want 10.0.0.1:5000", c.OrigIP, c.OrigPort)
+	}
+	if c.History != "hADdr" {
+		t.Errorf("history = %q, want hADdr", c.History)
+	}
+	if c.OrigPackets != 3 || c.OrigBytes != 200 || c.OrigIPBytes != 320 ||
+		c.RespPackets != 3 || c.RespBytes != 500 || c.RespIPBytes != 620 {
+		t.Errorf("orig %d/%d/%d resp %d/%d/%d", c.OrigPackets, c.OrigBytes, c.OrigIPBytes,
+			c.RespPackets, c.RespBytes, c.RespIPBytes)
+	}
+}
@@ -0,0 +1,118 @@
+package zeek
+
+import (
+	"strings"
+
+	"github.com/hwang-fu/portlens/internal/tracker"
+)
+
+// connFields are the columns of conn.log: Zeek's, then the owning process.
+var connFields = []Field{
+	{"ts", "time"},
+	{"uid", "string"},
+	{"id.orig_h", "addr"},
+	{"id.orig_p", "port"},
+	{"id.resp_h", "addr"},
+	{"id.resp_p", "port"},
+	{"proto", "enum"},
+	{"service", "string"},
+	{"duration", "interval"},
+	{"orig_bytes", "count"},
+	{"resp_bytes", "count"},
+	{"conn_state", "string"},
+	{"local_orig", "bool"},
+	{"local_resp", "bool"},
+	{"missed_bytes", "count"},
+	{"history", "string"},
+	{"orig_pkts", "count"},
+	{"orig_ip_bytes", "count"},
+	{"resp_pkts", "count"},
+	{"resp_ip_bytes", "count"},
+	{"tunnel_parents", "set[string]"},
+	{"pid", "count"},
+	{"process", "string"},
+}
+
+// ConnState derives Zeek's conn_state code from a connection's history.
+func ConnState(history string) string {
+	has := func(c byte) bool { return strings.IndexByte(history, c) >= 0 }
+	syn, synAck := has('S'), has('h')
+
+	switch {
+	case syn && synAck:
+		switch {
+		case has('R'):
+			return "RSTO" // Originator aborted
+		case has('r'):
+			return "RSTR" // Responder aborted
+		case has('F') && has('f'):
+			return "SF" // Normal establishment and termination
+		case has('F'):
+			return "S2" // Close attempt by the originator only
+		case has('f'):
+			return "S3" // Close attempt by the responder only
+		}
+		return "S1" // Established, not terminated
+	case syn:
+		switch {
+		case has('r'):
+			return "REJ" // Attempt rejected
+		case has('R'):
+			return "RSTOS0" // Originator sent SYN then RST
+		case has('F'):
+			return "SH" // Originator sent SYN then FIN
+		case strings.IndexFunc(history, isLower) < 0:
+			return "S0" // Attempt seen, no reply
+		}
+	case synAck:
+		switch {
+		case has('r'):
+			return "RSTRH" // Responder sent SYN-ACK then RST
+		case has('f'):
+			return "SHR" // Responder sent SYN-ACK then FIN
+		}
+	}
+	return "OTH" // Midstream traffic
+}
+
+func isLower(r rune) bool {
+	return r >= 'a' && r <= 'z'
+}
+
+// connRow returns the conn.log values of a closed connection.
+func connRow(c *tracker.Connection, uid string, isLocal func(string) bool) []any {
+	respIP, respPort := c.Key.DstIP, c.Key.DstPort
+	if respIP == c.OrigIP && respPort == c.OrigPort {
+		respIP, respPort = c.Key.SrcIP, c.Key.SrcPort
+	}
+
+	var pid, process any
+	if c.PID != 0 {
+		pid, process = c.PID, c.Process
+	}
+	return []any{
+		c.StartTime,
+		uid,
+		c.OrigIP,
+		c.OrigPort,
+		respIP,
+		respPort,
+		strings.ToLower(c.Key.Protocol),
+		nil, // No service detection
+		c.Duration(),
+		c.OrigBytes,
+		c.RespBytes,
+		ConnState(c.History),
+		isLocal(c.OrigIP),
+		isLocal(respIP),
+		0,
+		c.History,
+		c.OrigPackets,
+		c.OrigIPBytes,
+		c.RespPackets,
+		c.RespIPBytes,
+		Set{},
+		pid,
+		process,
+	}
+}
@@ -0,0 +1,140 @@
+package zeek
+
+import (
+	"net"
+	"time"
+
+	"github.com/hwang-fu/portlens/internal/parser"
+)
+
+// dnsFields are the columns of dns.log: Zeek's, then the owning process.
+var dnsFields = []Field{
+	{"ts", "time"},
+	{"uid", "string"},
+	{"id.orig_h", "addr"},
+	{"id.orig_p", "port"},
+	{"id.resp_h", "addr"},
+	{"id.resp_p", "port"},
+	{"proto", "enum"},
+	{"trans_id", "count"},
+	{"rtt", "interval"},
+	{"query", "string"},
+	{"qclass", "count"},
+	{"qclass_name", "string"},
+	{"qtype", "count"},
+	{"qtype_name", "string"},
+	{"rcode", "count"},
+	{"rcode_name", "string"},
+	{"AA", "bool"},
+	{"TC", "bool"},
+	{"RD", "bool"},
+	{"RA", "bool"},
+	{"Z", "count"},
+	{"answers", "vector[string]"},
+	{"TTLs", "vector[interval]"},
+	{"rejected", "bool"},
+	{"pid", "count"},
+	{"process", "string"},
+}
+
+// DNSPacket is a decoded DNS message with its capture context.
+type DNSPacket struct {
+	Timestamp time.Time
+	SrcIP     net.IP
+	SrcPort   uint16
+	DstIP     net.IP
+	DstPort   uint16
+	Message   *parser.DNSMessage
+	PID       int // Owner of the local socket, 0 if unknown
+	Process   string
+}
+
+// dnsKey identifies a DNS transaction, oriented from the client.
+type dnsKey struct {
+	client, server         string
+	clientPort, serverPort uint16
+	id                     uint16
+}
+
+// dnsQuery is a query waiting for its response.
+type dnsQuery struct {
+	DNSPacket
+	uid string
+}
+
+// dnsRow returns the dns.log values of a transaction. Either query or
+// response may be nil, but not both.
+func dnsRow(query *dnsQuery, response *DNSPacket, uid string) []any {
+	// The client is the query's sender, or the response's receiver
+	var first *DNSPacket
+	var client, server string
+	var clientPort, serverPort uint16
+	if query != nil {
+		first = &query.DNSPacket
+		client, clientPort, server, serverPort = query.srcEndpoint()
+	} else {
+		first = response
+		client, clientPort, server, serverPort = response.dstEndpoint()
+	}
+	msg := first.Message
+
+	row := []any{
+		first.Timestamp,
+		uid,
+		client, clientPort, server, serverPort,
+		"udp",
+		msg.ID,
+		nil,                     // rtt
+		nil, nil, nil, nil, nil, // query, qclass, qclass_name, qtype, qtype_name
+		nil, nil, // rcode, rcode_name
+		false, false, msg.RD, false,
+		msg.Z,
+		nil, nil, // answers, TTLs
+		false,
+		nil, nil, // pid, process
+	}
+	if len(msg.Questions) > 0 {
+		q := msg.Questions[0]
+		row[9] = q.Name
+		row[10], row[11] = q.Class, parser.DNSClassName(q.Class)
+		row[12], row[13] = q.Type, parser.DNSTypeName(q.Type)
+	}
+
+	if response != nil {
+		r := response.Message
+		if query != nil {
+			row[8] = response.Timestamp.Sub(query.Timestamp)
+		}
+		row[14], row[15] = r.Rcode, parser.DNSRcodeName(r.Rcode)
+		row[16], row[17], row[19] = r.AA, r.TC, r.RA
+		if len(r.Answers) > 0 {
+			answers := make(Set, len(r.Answers))
+			ttls := make(Intervals, len(r.Answers))
+			for i, a := range r.Answers {
+				answers[i] = a.Data
+				ttls[i] = time.Duration(a.TTL) * time.Second
+			}
+			row[21], row[22] = answers, ttls
+		}
+		row[23] = r.Rcode == dnsRcodeRefused
+	}
+
+	// Either side may be the local process
+	for _, p := range []*DNSPacket{first, response} {
+		if p != nil && p.PID != 0 {
+			row[24], row[25] = p.PID, p.Process
+			break
+		}
+	}
+	return row
+}
+
+const dnsRcodeRefused = 5
+
+func (p *DNSPacket) srcEndpoint() (client string, clientPort uint16, server string, serverPort uint16) {
+	return p.SrcIP.String(), p.SrcPort, p.DstIP.String(), p.DstPort
+}
+
+func (p *DNSPacket) dstEndpoint() (client string, clientPort uint16, server string, serverPort uint16) {
+	return p.DstIP.String(), p.DstPort, p.SrcIP.String(), p.SrcPort
+}
@@ -0,0 +1,239 @@
+// Package zeek writes connections and DNS transactions as Zeek logs
+// (conn.log, dns.log), in Zeek's tab-separated format or as JSON lines.
+package zeek
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Field is a column of a log.
+type Field struct {
+	Name string
+	Type string // Zeek type: time, string, addr, port, count, bool, ...
+}
+
+// Set is a value of a set or vector column.
+type Set []string
+
+// Intervals is a value of a vector[interval] column.
+type Intervals []time.Duration
+
+const (
+	separator    = "\t"
+	setSeparator = ","
+	emptyField   = "(empty)"
+	unsetField   = "-"
+)
+
+// Log writes one Zeek log. Every row is a single Write, and so is the TSV
+// header, so a rotating writer can repeat it in every file. It is safe
+// for concurrent use.
+type Log struct {
+	mu     sync.Mutex
+	w      io.Writer
+	fields []Field
+	json   bool
+}
+
+// NewLog starts a log named path (e.g. "conn") with the given columns.
+// TSV logs begin with Zeek's header, which is written right away.
+func NewLog(w io.Writer, path string, fields []Field, jsonLines bool, now time.Time) (*Log, error) {
+	l := &Log{w: w, fields: fields, json: jsonLines}
+	if jsonLines {
+		return l, nil
+	}
+
+	var hdr strings.Builder
+	names := make([]string, len(fields))
+	types := make([]string, len(fields))
+	for i, f := range fields {
+		names[i], types[i] = f.Name, f.Type
+	}
+	fmt.Fprintf(&hdr, "#separator \\x%02x\n", separator[0])
+	fmt.Fprintf(&hdr, "#set_separator%s%s\n", separator, setSeparator)
+	fmt.Fprintf(&hdr, "#empty_field%s%s\n", separator, emptyField)
+	fmt.Fprintf(&hdr, "#unset_field%s%s\n", separator, unsetField)
+	fmt.Fprintf(&hdr, "#path%s%s\n", separator, path)
+	fmt.Fprintf(&hdr, "#open%s%s\n", separator, formatOpenClose(now))
+	fmt.Fprintf(&hdr, "#fields%s%s\n", separator, strings.Join(names, separator))
+	fmt.Fprintf(&hdr, "#types%s%s\n", separator, strings.Join(types, separator))
+	if _, err := io.WriteString(w, hdr.String()); err != nil {
+		return nil, fmt.Errorf("write %s.log header: %w", path, err)
+	}
+	return l, nil
+}
+
+// Write appends a row. values match the fields in number and order; nil
+// is an unset value.
+func (l *Log) Write(values ...any) error {
+	if len(values) != len(l.fields) {
+		return fmt.Errorf("zeek: %d values for %d fields", len(values), len(l.fields))
+	}
+	var line []byte
+	if l.json {
+		line = l.jsonRow(values)
+	} else {
+		line = l.tsvRow(values)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err := l.w.Write(line)
+	return err
+}
+
+// Close ends a TSV log with its #close line. It doesn't close the writer.
+func (l *Log) Close(now time.Time) error {
+	if l.json {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err := fmt.Fprintf(l.w, "#close%s%s\n", separator, formatOpenClose(now))
+	return err
+}
+
+func (l *Log) tsvRow(values []any) []byte {
+	var b bytes.Buffer
+	for i, v := range values {
+		if i > 0 {
+			b.WriteString(separator)
+		}
+		switch v := v.(type) {
+		case nil:
+			b.WriteString(unsetField)
+		case Set:
+			if len(v) == 0 {
+				b.WriteString(emptyField)
+				continue
+			}
+			for j, s := range v {
+				if j > 0 {
+					b.WriteString(setSeparator)
+				}
+				b.WriteString(escape(s, true))
+			}
+		case Intervals:
+			if len(v) == 0 {
+				b.WriteString(emptyField)
+				continue
+			}
+			for j, d := range v {
+				if j > 0 {
+					b.WriteString(setSeparator)
+				}
+				b.WriteString(formatInterval(d))
+			}
+		case string:
+			b.WriteString(escape(v, false))
+		case time.Time:
+			b.WriteString(formatTime(v))
+		case time.Duration:
+			b.WriteString(formatInterval(v))
+		case bool:
+			if v {
+				b.WriteString("T")
+			} else {
+				b.WriteString("F")
+			}
+		default:
+			fmt.Fprint(&b, v)
+		}
+	}
+	b.WriteByte('\n')
+	return b.Bytes()
+}
+
+// jsonRow renders a row as Zeek's JSON writer does: fields in log order,
+// times as epoch seconds, unset fields left out.
+func (l *Log) jsonRow(values []any) []byte {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	first := true
+	for i, v := range values {
+		if v == nil {
+			continue
+		}
+		if !first {
+			b.WriteByte(',')
+		}
+		first = false
+		name, _ := json.Marshal(l.fields[i].Name)
+		b.Write(name)
+		b.WriteByte(':')
+
+		switch v := v.(type) {
+		case time.Time:
+			b.WriteString(formatTime(v))
+		case time.Duration:
+			b.WriteString(formatInterval(v))
+		case Intervals:
+			b.WriteByte('[')
+			for j, d := range v {
+				if j > 0 {
+					b.WriteByte(',')
+				}
+				b.WriteString(formatInterval(d))
+			}
+			b.WriteByte(']')
+		case Set:
+			if v == nil {
+				v = Set{}
+			}
+			data, _ := json.Marshal([]string(v))
+			b.Write(data)
+		default:
+			data, _ := json.Marshal(v)
+			b.Write(data)
+		}
+	}
+	b.WriteString("}\n")
+	return b.Bytes()
+}
+
+// escape makes s safe for a TSV column: separators and non-printable
+// bytes become \xNN, and values that would read as unset or empty are
+// escaped too. Like Zeek, it writes an empty string as the empty field.
+func escape(s string, inSet bool) string {
+	if s == "" {
+		return emptyField
+	}
+	if s == unsetField {
+		return `\x2d`
+	}
+	if s == emptyField {
+		return `\x28empty)`
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c < 0x20 || c >= 0x7f || c == '\\' || c == separator[0] || inSet && c == setSeparator[0]:
+			fmt.Fprintf(&b, `\x%02x`, c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// formatTime renders a time as epoch seconds with microseconds.
+func formatTime(t time.Time) string {
+	return strconv.FormatFloat(float64(t.UnixMicro())/1e6, 'f', 6, 64)
+}
+
+// formatInterval renders a duration as seconds with microseconds.
+func formatInterval(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 6, 64)
+}
+
+func formatOpenClose(t time.Time) string {
+	return t.Format("2006-01-02-15-04-05")
+}
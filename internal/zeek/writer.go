@@ -0,0 +1,203 @@
+package zeek
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hwang-fu/portlens/internal/tracker"
+)
+
+const (
+	// dnsTimeout is how long a query waits for its response before it is
+	// logged unanswered.
+	dnsTimeout = 10 * time.Second
+
+	// maxPendingDNS bounds the queries waiting for a response; past it,
+	// the oldest are logged unanswered.
+	maxPendingDNS = 10000
+)
+
+// Writer writes closed connections to conn.log and DNS transactions to
+// dns.log. It is safe for concurrent use.
+type Writer struct {
+	conn    *Log
+	dns     *Log
+	isLocal func(ip string) bool
+
+	mu       sync.Mutex
+	pending  map[dnsKey]*dnsQuery
+	answered map[dnsKey]time.Time // When recent transactions were answered
+}
+
+// NewWriter starts conn.log on connW and dns.log on dnsW, as TSV or as
+// JSON lines. isLocal tells local addresses for local_orig and local_resp.
+func NewWriter(connW, dnsW io.Writer, jsonLines bool, isLocal func(ip string) bool) (*Writer, error) {
+	now := time.Now()
+	conn, err := NewLog(connW, "conn", connFields, jsonLines, now)
+	if err != nil {
+		return nil, err
+	}
+	dns, err := NewLog(dnsW, "dns", dnsFields, jsonLines, now)
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{
+		conn:     conn,
+		dns:      dns,
+		isLocal:  isLocal,
+		pending:  make(map[dnsKey]*dnsQuery),
+		answered: make(map[dnsKey]time.Time),
+	}, nil
+}
+
+// Connection writes a closed connection to conn.log.
+func (w *Writer) Connection(c *tracker.Connection) error {
+	if err := w.conn.Write(connRow(c, newUID(), w.isLocal)...); err != nil {
+		return fmt.Errorf("write conn.log: %w", err)
+	}
+	return nil
+}
+
+// DNS records a DNS message. A query is held until its response arrives
+// or it times out; a response is logged with its query. Retransmitted
+// queries and repeated responses, such as the two copies of every packet
+// on a loopback interface, are not logged again.
+func (w *Writer) DNS(p DNSPacket) error {
+	w.mu.Lock()
+	var rows [][]any
+	rows = w.expire(p.Timestamp, rows)
+
+	msg := p.Message
+	if !msg.Response {
+		key := dnsKey{p.SrcIP.String(), p.DstIP.String(), p.SrcPort, p.DstPort, msg.ID}
+		// The RTT is measured from the first attempt
+		if w.pending[key] == nil {
+			w.pending[key] = &dnsQuery{DNSPacket: p, uid: newUID()}
+			if len(w.pending) > maxPendingDNS {
+				rows = w.evictOldest(rows)
+			}
+		}
+	} else {
+		key := dnsKey{p.DstIP.String(), p.SrcIP.String(), p.DstPort, p.SrcPort, msg.ID}
+		query := w.pending[key]
+		if _, dup := w.answered[key]; query != nil || !dup {
+			delete(w.pending, key)
+			if len(w.answered) >= maxPendingDNS {
+				clear(w.answered)
+			}
+			w.answered[key] = p.Timestamp
+			uid := newUID()
+			if query != nil {
+				uid = query.uid
+			}
+			rows = append(rows, dnsRow(query, &p, uid))
+		}
+	}
+	w.mu.Unlock()
+
+	return w.writeDNS(rows)
+}
+
+// Close logs the queries still waiting for a response and ends both logs.
+// It doesn't close the underlying writers.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	rows := w.drain(nil)
+	w.mu.Unlock()
+
+	now := time.Now()
+	return errors.Join(w.writeDNS(rows), w.conn.Close(now), w.dns.Close(now))
+}
+
+func (w *Writer) writeDNS(rows [][]any) error {
+	for _, row := range rows {
+		if err := w.dns.Write(row...); err != nil {
+			return fmt.Errorf("write dns.log: %w", err)
+		}
+	}
+	return nil
+}
+
+// expire appends the rows of queries unanswered for dnsTimeout before now
+// and forgets transactions answered that long ago. Caller must hold w.mu.
+func (w *Writer) expire(now time.Time, rows [][]any) [][]any {
+	for key, at := range w.answered {
+		if now.Sub(at) >= dnsTimeout {
+			delete(w.answered, key)
+		}
+	}
+
+	var expired []*dnsQuery
+	for key, q := range w.pending {
+		if now.Sub(q.Timestamp) >= dnsTimeout {
+			expired = append(expired, q)
+			delete(w.pending, key)
+		}
+	}
+	return appendUnanswered(rows, expired)
+}
+
+// evictOldest logs the oldest tenth of the pending queries unanswered.
+// Caller must hold w.mu.
+func (w *Writer) evictOldest(rows [][]any) [][]any {
+	queries := w.sortedPending()
+	queries = queries[:len(queries)/10+1]
+	for _, q := range queries {
+		delete(w.pending, q.key())
+	}
+	return appendUnanswered(rows, queries)
+}
+
+// drain logs every pending query unanswered. Caller must hold w.mu.
+func (w *Writer) drain(rows [][]any) [][]any {
+	queries := w.sortedPending()
+	clear(w.pending)
+	return appendUnanswered(rows, queries)
+}
+
+// sortedPending returns the pending queries, oldest first.
+func (w *Writer) sortedPending() []*dnsQuery {
+	queries := make([]*dnsQuery, 0, len(w.pending))
+	for _, q := range w.pending {
+		queries = append(queries, q)
+	}
+	sortQueries(queries)
+	return queries
+}
+
+func appendUnanswered(rows [][]any, queries []*dnsQuery) [][]any {
+	sortQueries(queries)
+	for _, q := range queries {
+		rows = append(rows, dnsRow(q, nil, q.uid))
+	}
+	return rows
+}
+
+func sortQueries(queries []*dnsQuery) {
+	sort.Slice(queries, func(i, j int) bool {
+		return queries[i].Timestamp.Before(queries[j].Timestamp)
+	})
+}
+
+func (q *dnsQuery) key() dnsKey {
+	return dnsKey{q.SrcIP.String(), q.DstIP.String(), q.SrcPort, q.DstPort, q.Message.ID}
+}
+
+const uidAlphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// newUID returns a Zeek-style connection UID: "C" and 17 base62 digits.
+func newUID() string {
+	var random [17]byte
+	rand.Read(random[:])
+	uid := make([]byte, 1, 18)
+	uid[0] = 'C'
+	for _, b := range random {
+		uid = append(uid, uidAlphabet[int(b)%len(uidAlphabet)])
+	}
+	return string(uid)
+}
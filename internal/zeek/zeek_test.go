@@ -0,0 +1,274 @@
+package zeek
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hwang-fu/portlens/internal/parser"
+	"github.com/hwang-fu/portlens/internal/tracker"
+)
+
+func TestConnState(t *testing.T) {
+	tests := map[string]string{
+		"ShADadFf": "SF",
+		"ShADad":   "S1",
+		"ShADadF":  "S2",
+		"ShADadf":  "S3",
+		"ShADR":    "RSTO",
+		"ShAr":     "RSTR",
+		"S":        "S0",
+		"Sr":       "REJ",
+		"SR":       "RSTOS0",
+		"SF":       "SH",
+		"hr":       "RSTRH",
+		"hf":       "SHR",
+		"Dd":       "OTH",
+		"Sd":       "OTH",
+	}
+	for history, want := range tests {
+		if got := ConnState(history); got != want {
+			t.Errorf("ConnState(%q) = %s, want %s", history, got, want)
+		}
+	}
+}
+
+func isLocal(ip string) bool { return ip == "10.0.0.5" }
+
+// testConn is a regular connection opened by 10.0.0.5:40000 to 1.1.1.1:443.
+func testConn() *tracker.Connection {
+	start := time.Unix(1700000000, 250000000)
+	return &tracker.Connection{
+		Key:         tracker.NormalizeKey("10.0.0.5", 40000, "1.1.1.1", 443, "TCP"),
+		StartTime:   start,
+		EndTime:     start.Add(1500 * time.Millisecond),
+		OrigIP:      "10.0.0.5",
+		OrigPort:    40000,
+		OrigPackets: 5, OrigBytes: 300, OrigIPBytes: 500,
+		RespPackets: 4, RespBytes: 4000, RespIPBytes: 4160,
+		History: "ShADadFf",
+		PID:     42, Process: "curl",
+	}
+}
+
+// rows returns the data rows of a TSV log.
+func rows(log string) [][]string {
+	var out [][]string
+	for _, line := range strings.Split(strings.TrimSpace(log), "\n") {
+		if !strings.HasPrefix(line, "#") {
+			out = append(out, strings.Split(line, "\t"))
+		}
+	}
+	return out
+}
+
+// column returns the value of a field in a TSV row.
+func column(fields []Field, row []string, name string) string {
+	for i, f := range fields {
+		if f.Name == name {
+			return row[i]
+		}
+	}
+	return "<no field " + name + ">"
+}
+
+func TestConnLogTSV(t *testing.T) {
+	var conn, dns bytes.Buffer
+	w, err := NewWriter(&conn, &dns, false, isLocal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Connection(testConn()); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	log := conn.String()
+	for _, want := range []string{"#separator \\x09\n", "#path\tconn\n", "#fields\tts\tuid\tid.orig_h\t", "\tpid\tprocess\n", "#types\ttime\tstring\taddr\t", "\n#close\t"} {
+		if !strings.Contains(log, want) {
+			t.Errorf("conn.log lacks %q:\n%s", want, log)
+		}
+	}
+
+	r := rows(log)
+	if len(r) != 1 || len(r[0]) != len(connFields) {
+		t.Fatalf("rows = %q", r)
+	}
+	want := map[string]string{
+		"ts": "1700000000.250000", "id.orig_h": "10.0.0.5", "id.orig_p": "40000",
+		"id.resp_h": "1.1.1.1", "id.resp_p": "443", "proto": "tcp", "service": "-",
+		"duration": "1.500000", "orig_bytes": "300", "resp_bytes": "4000",
+		"conn_state": "SF", "local_orig": "T", "local_resp": "F", "history": "ShADadFf",
+		"orig_pkts": "5", "resp_ip_bytes": "4160", "tunnel_parents": "(empty)",
+		"pid": "42", "process": "curl",
+	}
+	for name, v := range want {
+		if got := column(connFields, r[0], name); got != v {
+			t.Errorf("%s = %q, want %q", name, got, v)
+		}
+	}
+	if uid := column(connFields, r[0], "uid"); len(uid) != 18 || uid[0] != 'C' {
+		t.Errorf("uid = %q", uid)
+	}
+}
+
+func TestConnLogJSON(t *testing.T) {
+	var conn, dns bytes.Buffer
+	w, err := NewWriter(&conn, &dns, true, isLocal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := testConn()
+	c.PID, c.Process = 0, ""
+	w.Connection(c)
+	w.Close()
+
+	if strings.Contains(conn.String(), "#") {
+		t.Errorf("JSON log has a header:\n%s", conn.String())
+	}
+	var row map[string]any
+	if err := json.Unmarshal(conn.Bytes(), &row); err != nil {
+		t.Fatal(err)
+	}
+	if row["id.resp_h"] != "1.1.1.1" || row["ts"] != 1700000000.25 || row["local_orig"] != true || row["conn_state"] != "SF" {
+		t.Errorf("row = %v", row)
+	}
+	for _, unset := range []string{"service", "pid", "process"} {
+		if _, ok := row[unset]; ok {
+			t.Errorf("unset field %s present", unset)
+		}
+	}
+	if parents, ok := row["tunnel_parents"].([]any); !ok || len(parents) != 0 {
+		t.Errorf("tunnel_parents = %v", row["tunnel_parents"])
+	}
+}
+
+func dnsPacket(ts time.Time, response bool, id uint16) DNSPacket {
+	p := DNSPacket{
+		Timestamp: ts,
+		SrcIP:     net.ParseIP("10.0.0.5"), SrcPort: 5353,
+		DstIP: net.ParseIP("8.8.8.8"), DstPort: 53,
+		Message: &parser.DNSMessage{
+			ID: id, Response: response, RD: true,
+			Questions: []parser.DNSQuestion{{Name: "example.com", Type: parser.DNSTypeA, Class: 1}},
+		},
+	}
+	if response {
+		p.SrcIP, p.DstIP = p.DstIP, p.SrcIP
+		p.SrcPort, p.DstPort = p.DstPort, p.SrcPort
+		p.Message.RA = true
+		p.Message.Answers = []parser.DNSRecord{
+			{Name: "example.com", Type: parser.DNSTypeA, TTL: 60, Data: "93.184.216.34"},
+			{Name: "example.com", Type: parser.DNSTypeA, TTL: 60, Data: "93.184.216.35"},
+		}
+	} else {
+		p.PID, p.Process = 7, "resolved"
+	}
+	return p
+}
+
+func TestDNSLog(t *testing.T) {
+	var conn, dns bytes.Buffer
+	w, err := NewWriter(&conn, &dns, false, isLocal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Unix(1700000000, 0)
+
+	w.DNS(dnsPacket(start, false, 1))
+	w.DNS(dnsPacket(start.Add(20*time.Millisecond), true, 1))
+	w.DNS(dnsPacket(start.Add(time.Second), false, 2)) // Never answered
+	w.DNS(dnsPacket(start.Add(time.Second), true, 3))  // Query not seen
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r := rows(dns.String())
+	if len(r) != 3 {
+		t.Fatalf("got %d rows, want 3:\n%s", len(r), dns.String())
+	}
+
+	answered := r[0]
+	want := map[string]string{
+		"id.orig_h": "10.0.0.5", "id.resp_p": "53", "proto": "udp", "trans_id": "1",
+		"rtt": "0.020000", "query": "example.com", "qclass_name": "C_INTERNET",
+		"qtype_name": "A", "rcode_name": "NOERROR", "RD": "T", "RA": "T",
+		"answers": "93.184.216.34,93.184.216.35", "TTLs": "60.000000,60.000000",
+		"rejected": "F", "pid": "7", "process": "resolved",
+	}
+	for name, v := range want {
+		if got := column(dnsFields, answered, name); got != v {
+			t.Errorf("answered %s = %q, want %q", name, got, v)
+		}
+	}
+
+	// A response without its query is logged right away, without an RTT
+	orphan := r[1]
+	if column(dnsFields, orphan, "trans_id") != "3" || column(dnsFields, orphan, "rtt") != "-" || column(dnsFields, orphan, "id.orig_h") != "10.0.0.5" {
+		t.Errorf("orphan response = %q", orphan)
+	}
+
+	// The unanswered query is logged on close
+	unanswered := r[2]
+	if column(dnsFields, unanswered, "trans_id") != "2" || column(dnsFields, unanswered, "rcode") != "-" || column(dnsFields, unanswered, "answers") != "-" {
+		t.Errorf("unanswered query = %q", unanswered)
+	}
+}
+
+func TestDNSDuplicates(t *testing.T) {
+	var conn, dns bytes.Buffer
+	w, _ := NewWriter(&conn, &dns, true, isLocal)
+	start := time.Unix(1700000000, 0)
+
+	// Both copies of each packet, as on a loopback interface
+	w.DNS(dnsPacket(start, false, 1))
+	w.DNS(dnsPacket(start, false, 1))
+	w.DNS(dnsPacket(start.Add(time.Millisecond), true, 1))
+	w.DNS(dnsPacket(start.Add(time.Millisecond), true, 1))
+	w.Close()
+
+	if n := strings.Count(dns.String(), "\n"); n != 1 {
+		t.Fatalf("got %d rows, want 1:\n%s", n, dns.String())
+	}
+	if !strings.Contains(dns.String(), `"rtt":0.001000`) {
+		t.Errorf("row = %s", dns.String())
+	}
+}
+
+func TestDNSTimeout(t *testing.T) {
+	var conn, dns bytes.Buffer
+	w, _ := NewWriter(&conn, &dns, true, isLocal)
+	start := time.Unix(1700000000, 0)
+
+	w.DNS(dnsPacket(start, false, 1))
+	w.DNS(dnsPacket(start.Add(dnsTimeout), false, 2))
+	if n := strings.Count(dns.String(), "\n"); n != 1 {
+		t.Fatalf("got %d rows after the timeout, want 1", n)
+	}
+	if !strings.Contains(dns.String(), `"trans_id":1`) {
+		t.Errorf("expired row = %s", dns.String())
+	}
+}
+
+func TestEscape(t *testing.T) {
+	tests := map[string]string{
+		"plain":     "plain",
+		"tab\there": `tab\x09here`,
+		"-":         `\x2d`,
+		"":          "(empty)",
+		"a\\b":      `a\x5cb`,
+	}
+	for in, want := range tests {
+		if got := escape(in, false); got != want {
+			t.Errorf("escape(%q) = %q, want %q", in, got, want)
+		}
+	}
+	if got := escape("a,b", true); got != `a\x2cb` {
+		t.Errorf("escape in set = %q", got)
+	}
+}